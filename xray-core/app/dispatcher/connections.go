@@ -0,0 +1,70 @@
+package dispatcher
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/common/buf"
+)
+
+// connectionCounter counts the connections dispatched to each outbound, once
+// a balancer needs them.
+type connectionCounter struct {
+	enabled atomic.Bool
+	access  sync.Mutex
+	counts  map[string]int
+}
+
+func (c *connectionCounter) add(tag string, delta int) {
+	c.access.Lock()
+	defer c.access.Unlock()
+	if c.counts == nil {
+		c.counts = make(map[string]int)
+	}
+	if n := c.counts[tag] + delta; n > 0 {
+		c.counts[tag] = n
+	} else {
+		delete(c.counts, tag)
+	}
+}
+
+func (c *connectionCounter) get(tag string) int {
+	c.access.Lock()
+	defer c.access.Unlock()
+	return c.counts[tag]
+}
+
+// track counts a connection of the outbound of tag until writer, the downlink
+// of the connection, is closed or interrupted. This covers connections that
+// live on after Dispatch returns, as those of mux do. A user stats writer is
+// kept outermost, as splice copy looks it up. Nothing is counted unless the
+// counter is enabled.
+func (c *connectionCounter) track(tag string, writer buf.Writer) buf.Writer {
+	if !c.enabled.Load() {
+		return writer
+	}
+	c.add(tag, 1)
+	w := &connectionWriter{Writer: writer, done: func() { c.add(tag, -1) }}
+	if statWriter, ok := writer.(*SizeStatWriter); ok {
+		w.Writer = statWriter.Writer
+		return &SizeStatWriter{Counter: statWriter.Counter, Writer: w}
+	}
+	return w
+}
+
+type connectionWriter struct {
+	buf.Writer
+	once sync.Once
+	done func()
+}
+
+func (w *connectionWriter) Close() error {
+	w.once.Do(w.done)
+	return common.Close(w.Writer)
+}
+
+func (w *connectionWriter) Interrupt() {
+	w.once.Do(w.done)
+	common.Interrupt(w.Writer)
+}
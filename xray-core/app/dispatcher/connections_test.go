@@ -0,0 +1,86 @@
+package dispatcher_test
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/xtls/xray-core/app/dispatcher"
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/serial"
+	"github.com/xtls/xray-core/features/outbound"
+	"github.com/xtls/xray-core/features/policy"
+	"github.com/xtls/xray-core/features/stats"
+	"github.com/xtls/xray-core/transport"
+	"github.com/xtls/xray-core/transport/pipe"
+)
+
+// linkHandler is an outbound that keeps the links dispatched to it
+type linkHandler struct {
+	links []*transport.Link
+}
+
+func (*linkHandler) Start() error                         { return nil }
+func (*linkHandler) Close() error                         { return nil }
+func (*linkHandler) Tag() string                          { return "out" }
+func (*linkHandler) SenderSettings() *serial.TypedMessage { return nil }
+func (*linkHandler) ProxySettings() *serial.TypedMessage  { return nil }
+func (h *linkHandler) Dispatch(ctx context.Context, link *transport.Link) {
+	h.links = append(h.links, link)
+}
+
+// handlerManager is an outbound manager with one handler
+type handlerManager struct {
+	handler outbound.Handler
+}
+
+func (*handlerManager) Type() interface{}                                  { return outbound.ManagerType() }
+func (*handlerManager) Start() error                                       { return nil }
+func (*handlerManager) Close() error                                       { return nil }
+func (m *handlerManager) GetHandler(tag string) outbound.Handler           { return m.handler }
+func (m *handlerManager) GetDefaultHandler() outbound.Handler              { return m.handler }
+func (*handlerManager) AddHandler(context.Context, outbound.Handler) error { return nil }
+func (*handlerManager) RemoveHandler(context.Context, string) error        { return nil }
+func (m *handlerManager) ListHandlers(context.Context) []outbound.Handler {
+	return []outbound.Handler{m.handler}
+}
+
+func dispatchLink(d *DefaultDispatcher) {
+	reader, writer := pipe.New()
+	common.Must(d.DispatchLink(context.Background(), net.TCPDestination(net.DomainAddress("example.com"), 80), &transport.Link{
+		Reader: reader,
+		Writer: writer,
+	}))
+}
+
+func TestOutboundConnections(t *testing.T) {
+	handler := new(linkHandler)
+	d := new(DefaultDispatcher)
+	common.Must(d.Init(&Config{}, &handlerManager{handler: handler}, nil, policy.DefaultManager{}, stats.NoopManager{}))
+
+	// Nothing is counted until a balancer asks for it
+	dispatchLink(d)
+	if n := d.OutboundConnections("out"); n != 0 {
+		t.Fatalf("expected no connection counted, got %d", n)
+	}
+	handler.links = nil
+
+	d.CountConnections()
+	dispatchLink(d)
+	dispatchLink(d)
+	if n := d.OutboundConnections("out"); n != 2 {
+		t.Fatalf("expected 2 connections, got %d", n)
+	}
+
+	// Closing or interrupting the downlink ends a connection, once
+	common.Must(common.Close(handler.links[0].Writer))
+	common.Close(handler.links[0].Writer)
+	if n := d.OutboundConnections("out"); n != 1 {
+		t.Fatalf("expected 1 connection after close, got %d", n)
+	}
+	common.Interrupt(handler.links[1].Writer)
+	common.Interrupt(handler.links[1].Writer)
+	if n := d.OutboundConnections("out"); n != 0 {
+		t.Fatalf("expected no connection after interrupt, got %d", n)
+	}
+}
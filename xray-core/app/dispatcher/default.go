@@ -99,6 +99,8 @@ type DefaultDispatcher struct {
 	policy policy.Manager
 	stats  stats.Manager
 	fdns   dns.FakeDNSEngine
+
+	connections connectionCounter
 }
 
 func init() {
@@ -138,6 +140,16 @@ func (*DefaultDispatcher) Start() error {
 // Close implements common.Closable.
 func (*DefaultDispatcher) Close() error { return nil }
 
+// CountConnections implements routing.ConnectionCounter.
+func (d *DefaultDispatcher) CountConnections() {
+	d.connections.enabled.Store(true)
+}
+
+// OutboundConnections implements routing.ConnectionCounter.
+func (d *DefaultDispatcher) OutboundConnections(tag string) int {
+	return d.connections.get(tag)
+}
+
 func (d *DefaultDispatcher) getLink(ctx context.Context) (*transport.Link, *transport.Link) {
 	opt := pipe.OptionsFromContext(ctx)
 	uplinkReader, uplinkWriter := pipe.New(opt...)
@@ -520,5 +532,9 @@ func (d *DefaultDispatcher) routedDispatch(ctx context.Context, link *transport.
 		log.Record(accessMessage)
 	}
 
+	link = &transport.Link{
+		Reader: link.Reader,
+		Writer: d.connections.track(handler.Tag(), link.Writer),
+	}
 	handler.Dispatch(ctx, link)
 }
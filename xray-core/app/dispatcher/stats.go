@@ -1,6 +1,8 @@
 package dispatcher
 
 import (
+	"time"
+
 	"github.com/xtls/xray-core/common"
 	"github.com/xtls/xray-core/common/buf"
 	"github.com/xtls/xray-core/features/stats"
@@ -23,3 +25,11 @@ func (w *SizeStatWriter) Close() error {
 func (w *SizeStatWriter) Interrupt() {
 	common.Interrupt(w.Writer)
 }
+
+// WaitDrained waits for the pipe written to, if any, to be drained.
+func (w *SizeStatWriter) WaitDrained(timeout time.Duration) bool {
+	if drained, ok := w.Writer.(interface{ WaitDrained(time.Duration) bool }); ok {
+		return drained.WaitDrained(timeout)
+	}
+	return true
+}
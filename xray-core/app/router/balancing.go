@@ -10,16 +10,28 @@ import (
 	"github.com/xtls/xray-core/core"
 	"github.com/xtls/xray-core/features/extension"
 	"github.com/xtls/xray-core/features/outbound"
+	"github.com/xtls/xray-core/features/routing"
 )
 
 type BalancingStrategy interface {
 	PickOutbound([]string) string
 }
 
+// BalancingContextStrategy is a BalancingStrategy whose pick depends on the
+// connection being routed.
+type BalancingContextStrategy interface {
+	PickOutboundFor(routing.Context, []string) string
+}
+
 type BalancingPrincipleTarget interface {
 	GetPrincipleTarget([]string) []string
 }
 
+// BalancingWeights is a BalancingStrategy that weighs the outbounds.
+type BalancingWeights interface {
+	GetWeights([]string) map[string]float64
+}
+
 type RoundRobinStrategy struct {
 	FallbackTag string
 
@@ -91,8 +103,9 @@ type Balancer struct {
 	override override
 }
 
-// PickOutbound picks the tag of a outbound
-func (b *Balancer) PickOutbound() (string, error) {
+// PickOutbound picks the tag of a outbound for the connection of ctx, which
+// may be nil
+func (b *Balancer) PickOutbound(ctx routing.Context) (string, error) {
 	candidates, err := b.SelectOutbounds()
 	if err != nil {
 		if b.fallbackTag != "" {
@@ -104,6 +117,8 @@ func (b *Balancer) PickOutbound() (string, error) {
 	var tag string
 	if o := b.override.Get(); o != "" {
 		tag = o
+	} else if s, ok := b.strategy.(BalancingContextStrategy); ok && ctx != nil {
+		tag = s.PickOutboundFor(ctx, candidates)
 	} else {
 		tag = b.strategy.PickOutbound(candidates)
 	}
@@ -149,6 +164,21 @@ func (r *Router) GetPrincipleTarget(tag string) ([]string, error) {
 	return nil, errors.New("cannot find tag")
 }
 
+// GetBalancerWeights implements routing.BalancerWeights
+func (r *Router) GetBalancerWeights(tag string) (map[string]float64, error) {
+	if b, ok := r.balancers[tag]; ok {
+		if s, ok := b.strategy.(BalancingWeights); ok {
+			candidates, err := b.SelectOutbounds()
+			if err != nil {
+				return nil, errors.New("unable to select outbounds").Base(err)
+			}
+			return s.GetWeights(candidates), nil
+		}
+		return nil, errors.New("unsupported GetBalancerWeights")
+	}
+	return nil, errors.New("cannot find tag")
+}
+
 // SetOverrideTarget implements routing.BalancerOverrider
 func (r *Router) SetOverrideTarget(tag, target string) error {
 	if b, ok := r.balancers[tag]; ok {
@@ -43,6 +43,13 @@ func (s *routingServer) GetBalancerInfo(ctx context.Context, request *GetBalance
 			}
 		}
 	}
+
+	if bw, ok := s.router.(routing.BalancerWeights); ok {
+		res, err := bw.GetBalancerWeights(request.GetTag())
+		if err == nil {
+			ret.Balancer.Weights = res
+		}
+	}
 	return &ret, nil
 }
 
@@ -20,9 +20,9 @@ type Rule struct {
 	Condition Condition
 }
 
-func (r *Rule) GetTag() (string, error) {
+func (r *Rule) GetTag(ctx routing.Context) (string, error) {
 	if r.Balancer != nil {
-		return r.Balancer.PickOutbound()
+		return r.Balancer.PickOutbound(ctx)
 	}
 	return r.Tag, nil
 }
@@ -174,6 +174,48 @@ func (br *BalancingRule) Build(ohm outbound.Manager, dispatcher routing.Dispatch
 			fallbackTag: br.FallbackTag,
 			strategy:    leastLoadStrategy,
 		}, nil
+	case "consistenthash":
+		settings := &StrategyConsistentHashConfig{}
+		if br.StrategySettings != nil {
+			i, err := br.StrategySettings.GetInstance()
+			if err != nil {
+				return nil, err
+			}
+			s, ok := i.(*StrategyConsistentHashConfig)
+			if !ok {
+				return nil, errors.New("not a StrategyConsistentHashConfig").AtError()
+			}
+			settings = s
+		}
+		consistentHashStrategy, err := NewConsistentHashStrategy(settings, br.FallbackTag)
+		if err != nil {
+			return nil, err
+		}
+		return &Balancer{
+			selectors:   br.OutboundSelector,
+			ohm:         ohm,
+			fallbackTag: br.FallbackTag,
+			strategy:    consistentHashStrategy,
+		}, nil
+	case "weightedroundrobin":
+		settings := &StrategyWeightedRoundRobinConfig{}
+		if br.StrategySettings != nil {
+			i, err := br.StrategySettings.GetInstance()
+			if err != nil {
+				return nil, err
+			}
+			s, ok := i.(*StrategyWeightedRoundRobinConfig)
+			if !ok {
+				return nil, errors.New("not a StrategyWeightedRoundRobinConfig").AtError()
+			}
+			settings = s
+		}
+		return &Balancer{
+			selectors:   br.OutboundSelector,
+			ohm:         ohm,
+			fallbackTag: br.FallbackTag,
+			strategy:    NewWeightedRoundRobinStrategy(settings, br.FallbackTag, dispatcher),
+		}, nil
 	case "random":
 		fallthrough
 	case "":
@@ -0,0 +1,192 @@
+package router
+
+import (
+	"context"
+	"hash/fnv"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+
+	"github.com/xtls/xray-core/app/observatory"
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/common/dice"
+	"github.com/xtls/xray-core/common/errors"
+	"github.com/xtls/xray-core/core"
+	"github.com/xtls/xray-core/features/extension"
+	"github.com/xtls/xray-core/features/routing"
+)
+
+// Keys a ConsistentHashStrategy picks outbounds by.
+const (
+	hashKeySourceIP    = "sourceip"
+	hashKeyUser        = "user"
+	hashKeyDomain      = "domain"
+	hashKeyETLDPlusOne = "etld+1"
+)
+
+// ConsistentHashStrategy keeps the connections of a client, user or site on
+// the same outbound, for sites that bind sessions to the IP they come from.
+// Each candidate is scored by hashing it with the key of the connection and
+// the highest score wins (rendezvous hashing), so that an outbound going
+// away only moves the keys it had.
+type ConsistentHashStrategy struct {
+	FallbackTag string
+
+	key         string
+	ctx         context.Context
+	observatory extension.Observatory
+}
+
+// NewConsistentHashStrategy creates a ConsistentHashStrategy with settings.
+func NewConsistentHashStrategy(settings *StrategyConsistentHashConfig, fallbackTag string) (*ConsistentHashStrategy, error) {
+	key := strings.ToLower(settings.GetKey())
+	switch key {
+	case "":
+		key = hashKeySourceIP
+	case hashKeySourceIP, hashKeyUser, hashKeyDomain, hashKeyETLDPlusOne:
+	default:
+		return nil, errors.New("unknown consistent hash key: ", settings.GetKey())
+	}
+	return &ConsistentHashStrategy{
+		FallbackTag: fallbackTag,
+		key:         key,
+	}, nil
+}
+
+func (s *ConsistentHashStrategy) InjectContext(ctx context.Context) {
+	s.ctx = ctx
+	if len(s.FallbackTag) > 0 {
+		common.Must(core.RequireFeatures(s.ctx, func(observatory extension.Observatory) error {
+			s.observatory = observatory
+			return nil
+		}))
+	}
+}
+
+func (s *ConsistentHashStrategy) GetPrincipleTarget(strings []string) []string {
+	return strings
+}
+
+// PickOutbound picks a random outbound, as there is no connection to take
+// the key from.
+func (s *ConsistentHashStrategy) PickOutbound(candidates []string) string {
+	return s.PickOutboundFor(nil, candidates)
+}
+
+// PickOutboundFor picks the outbound of the key of ctx among the live
+// candidates.
+func (s *ConsistentHashStrategy) PickOutboundFor(ctx routing.Context, candidates []string) string {
+	candidates = aliveCandidates(s.ctx, s.observatory, candidates)
+	if len(candidates) == 0 {
+		// goes to fallbackTag
+		return ""
+	}
+	key := s.keyOf(ctx)
+	if key == "" {
+		return candidates[dice.Roll(len(candidates))]
+	}
+
+	var picked string
+	var best uint64
+	for _, candidate := range candidates {
+		if score := rendezvousScore(key, candidate); picked == "" || score > best {
+			picked, best = candidate, score
+		}
+	}
+	return picked
+}
+
+// keyOf returns the key of the connection. Connections without a user or
+// target domain fall back to their source IP, and those without a source IP
+// have no key.
+func (s *ConsistentHashStrategy) keyOf(ctx routing.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	var key string
+	switch s.key {
+	case hashKeyUser:
+		key = ctx.GetUser()
+	case hashKeyDomain:
+		key = targetOf(ctx)
+	case hashKeyETLDPlusOne:
+		key = targetOf(ctx)
+		if domain := ctx.GetTargetDomain(); domain != "" {
+			if site, err := publicsuffix.EffectiveTLDPlusOne(strings.TrimSuffix(domain, ".")); err == nil {
+				key = site
+			}
+		}
+	}
+	if key == "" {
+		if ips := ctx.GetSourceIPs(); len(ips) > 0 {
+			key = ips[0].String()
+		}
+	}
+	return key
+}
+
+// targetOf returns the target domain of the connection, or its IP without
+// one.
+func targetOf(ctx routing.Context) string {
+	if domain := ctx.GetTargetDomain(); domain != "" {
+		return strings.ToLower(strings.TrimSuffix(domain, "."))
+	}
+	if ips := ctx.GetTargetIPs(); len(ips) > 0 {
+		return ips[0].String()
+	}
+	return ""
+}
+
+// rendezvousScore returns the score of tag for key.
+func rendezvousScore(key, tag string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	h.Write([]byte{0})
+	h.Write([]byte(tag))
+	// FNV barely mixes the last bytes, so finish with the mixer of
+	// splitmix64 for scores that are spread evenly across tags
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// aliveCandidates returns the candidates the observatory does not know to be
+// down. Candidates it has not observed are considered alive.
+func aliveCandidates(ctx context.Context, observer extension.Observatory, candidates []string) []string {
+	statusMap := observedStatus(ctx, observer)
+	if statusMap == nil {
+		return candidates
+	}
+	aliveTags := make([]string, 0, len(candidates))
+	for _, candidate := range candidates {
+		if outboundStatus, found := statusMap[candidate]; !found || outboundStatus.Alive {
+			aliveTags = append(aliveTags, candidate)
+		}
+	}
+	return aliveTags
+}
+
+// observedStatus returns the status of the outbounds the observatory has
+// observed by tag, or nil without an observation.
+func observedStatus(ctx context.Context, observer extension.Observatory) map[string]*observatory.OutboundStatus {
+	if observer == nil {
+		return nil
+	}
+	observeReport, err := observer.GetObservation(ctx)
+	if err != nil {
+		return nil
+	}
+	result, ok := observeReport.(*observatory.ObservationResult)
+	if !ok {
+		return nil
+	}
+	statusMap := make(map[string]*observatory.OutboundStatus, len(result.Status))
+	for _, outboundStatus := range result.Status {
+		statusMap[outboundStatus.OutboundTag] = outboundStatus
+	}
+	return statusMap
+}
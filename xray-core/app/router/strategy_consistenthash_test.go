@@ -0,0 +1,134 @@
+package router_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/xtls/xray-core/app/router"
+	"github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/protocol"
+	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/features/routing"
+	routing_session "github.com/xtls/xray-core/features/routing/session"
+)
+
+func hashContext(source string, user string, target net.Address) routing.Context {
+	inbound := &session.Inbound{Source: net.TCPDestination(net.ParseAddress(source), 40000)}
+	if user != "" {
+		inbound.User = &protocol.MemoryUser{Email: user}
+	}
+	ctx := session.ContextWithInbound(context.Background(), inbound)
+	ctx = session.ContextWithOutbounds(ctx, []*session.Outbound{{
+		Target: net.TCPDestination(target, 443),
+	}})
+	return routing_session.AsRoutingContext(ctx)
+}
+
+func TestConsistentHashSticky(t *testing.T) {
+	strategy, err := router.NewConsistentHashStrategy(&router.StrategyConsistentHashConfig{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	candidates := []string{"a", "b", "c", "d"}
+
+	picked := make(map[string]string)
+	counts := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		source := fmt.Sprintf("10.0.%d.%d", i/256, i%256)
+		tag := strategy.PickOutboundFor(hashContext(source, "", net.DomainAddress("example.com")), candidates)
+		picked[source] = tag
+		counts[tag]++
+		if again := strategy.PickOutboundFor(hashContext(source, "", net.DomainAddress("example.org")), candidates); again != tag {
+			t.Fatalf("%s went to %s, then to %s", source, tag, again)
+		}
+	}
+	for _, candidate := range candidates {
+		if counts[candidate] < 150 {
+			t.Errorf("%s got %d of 1000 sources", candidate, counts[candidate])
+		}
+	}
+
+	// Only the sources of the outbound taken away move
+	for source, tag := range picked {
+		now := strategy.PickOutboundFor(hashContext(source, "", net.DomainAddress("example.com")), candidates[:3])
+		if tag != "d" && now != tag {
+			t.Errorf("%s moved from %s to %s", source, tag, now)
+		}
+		if now == "d" {
+			t.Errorf("%s still goes to d", source)
+		}
+	}
+}
+
+func TestConsistentHashKeys(t *testing.T) {
+	candidates := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	testCases := []struct {
+		key  string
+		same [2]routing.Context
+	}{
+		{
+			key: "user",
+			same: [2]routing.Context{
+				hashContext("10.0.0.1", "love@example.com", net.DomainAddress("example.com")),
+				hashContext("10.0.0.2", "love@example.com", net.DomainAddress("example.org")),
+			},
+		},
+		{
+			// without a user, the source IP
+			key: "user",
+			same: [2]routing.Context{
+				hashContext("10.0.0.1", "", net.DomainAddress("example.com")),
+				hashContext("10.0.0.1", "", net.DomainAddress("example.org")),
+			},
+		},
+		{
+			key: "domain",
+			same: [2]routing.Context{
+				hashContext("10.0.0.1", "", net.DomainAddress("www.example.com")),
+				hashContext("10.0.0.2", "", net.DomainAddress("WWW.example.com.")),
+			},
+		},
+		{
+			key: "domain",
+			same: [2]routing.Context{
+				hashContext("10.0.0.1", "", net.ParseAddress("192.0.2.1")),
+				hashContext("10.0.0.2", "", net.ParseAddress("192.0.2.1")),
+			},
+		},
+		{
+			key: "eTLD+1",
+			same: [2]routing.Context{
+				hashContext("10.0.0.1", "", net.DomainAddress("www.example.co.uk")),
+				hashContext("10.0.0.2", "", net.DomainAddress("static.example.co.uk")),
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		strategy, err := router.NewConsistentHashStrategy(&router.StrategyConsistentHashConfig{Key: testCase.key}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		first := strategy.PickOutboundFor(testCase.same[0], candidates)
+		if second := strategy.PickOutboundFor(testCase.same[1], candidates); first != second {
+			t.Errorf("%s: same key went to %s and %s", testCase.key, first, second)
+		}
+	}
+
+	// Different sites spread across the outbounds
+	strategy, _ := router.NewConsistentHashStrategy(&router.StrategyConsistentHashConfig{Key: "eTLD+1"}, "")
+	picked := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		site := net.DomainAddress(fmt.Sprintf("www.site%d.com", i))
+		picked[strategy.PickOutboundFor(hashContext("10.0.0.1", "", site), candidates)] = true
+	}
+	if len(picked) < 2 {
+		t.Error("all sites went to the same outbound")
+	}
+}
+
+func TestConsistentHashUnknownKey(t *testing.T) {
+	if _, err := router.NewConsistentHashStrategy(&router.StrategyConsistentHashConfig{Key: "port"}, ""); err == nil {
+		t.Error("expected an error for an unknown key")
+	}
+}
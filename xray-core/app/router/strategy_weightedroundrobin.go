@@ -0,0 +1,155 @@
+package router
+
+import (
+	"context"
+	"sync"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/core"
+	"github.com/xtls/xray-core/features/extension"
+	"github.com/xtls/xray-core/features/routing"
+)
+
+// WeightedRoundRobinStrategy spreads connections across outbounds in
+// proportion to their weights, for outbounds of different capacity. The
+// static weights can be scaled down at runtime by the delay of the outbounds
+// relative to the fastest one, and by the connections they carry.
+//
+// Picks follow the smooth weighted round-robin of nginx, which interleaves
+// the outbounds instead of sending runs of connections to the heaviest.
+type WeightedRoundRobinStrategy struct {
+	FallbackTag string
+
+	settings    *StrategyWeightedRoundRobinConfig
+	weights     *WeightManager
+	ctx         context.Context
+	observatory extension.Observatory
+	connections routing.ConnectionCounter
+
+	mu      sync.Mutex
+	current map[string]float64
+}
+
+// NewWeightedRoundRobinStrategy creates a WeightedRoundRobinStrategy with
+// settings. Connections are counted by dispatcher, if it counts them.
+func NewWeightedRoundRobinStrategy(settings *StrategyWeightedRoundRobinConfig, fallbackTag string, dispatcher routing.Dispatcher) *WeightedRoundRobinStrategy {
+	s := &WeightedRoundRobinStrategy{
+		FallbackTag: fallbackTag,
+		settings:    settings,
+		weights: NewWeightManager(
+			settings.Weights, 1,
+			func(value, weight float64) float64 {
+				return value * weight
+			},
+		),
+		current: make(map[string]float64),
+	}
+	if counter, ok := dispatcher.(routing.ConnectionCounter); ok && settings.Connections {
+		counter.CountConnections()
+		s.connections = counter
+	}
+	return s
+}
+
+func (s *WeightedRoundRobinStrategy) InjectContext(ctx context.Context) {
+	s.ctx = ctx
+	if len(s.FallbackTag) > 0 || s.settings.Delay {
+		common.Must(core.RequireFeatures(s.ctx, func(observatory extension.Observatory) error {
+			s.observatory = observatory
+			return nil
+		}))
+	}
+}
+
+func (s *WeightedRoundRobinStrategy) GetPrincipleTarget(strings []string) []string {
+	return strings
+}
+
+// GetWeights returns the effective weights of the candidates, which are 0
+// for those that are down.
+func (s *WeightedRoundRobinStrategy) GetWeights(candidates []string) map[string]float64 {
+	tags, weights := s.effectiveWeights(candidates)
+	result := make(map[string]float64, len(candidates))
+	for _, candidate := range candidates {
+		result[candidate] = 0
+	}
+	for i, tag := range tags {
+		result[tag] = weights[i]
+	}
+	return result
+}
+
+func (s *WeightedRoundRobinStrategy) PickOutbound(candidates []string) string {
+	tags, weights := s.effectiveWeights(candidates)
+	if len(tags) == 0 {
+		// goes to fallbackTag
+		return ""
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := len(s.current) != len(tags)
+	for _, tag := range tags {
+		if _, found := s.current[tag]; !found {
+			changed = true
+			break
+		}
+	}
+	if changed {
+		// forget the outbounds gone, so that they start afresh if they
+		// come back
+		current := make(map[string]float64, len(tags))
+		for _, tag := range tags {
+			current[tag] = s.current[tag]
+		}
+		s.current = current
+	}
+	var total float64
+	picked := 0
+	for i, tag := range tags {
+		s.current[tag] += weights[i]
+		total += weights[i]
+		if s.current[tag] > s.current[tags[picked]] {
+			picked = i
+		}
+	}
+	s.current[tags[picked]] -= total
+	return tags[picked]
+}
+
+// effectiveWeights returns the candidates that are alive and their weights.
+func (s *WeightedRoundRobinStrategy) effectiveWeights(candidates []string) ([]string, []float64) {
+	statusMap := observedStatus(s.ctx, s.observatory)
+	tags := make([]string, 0, len(candidates))
+	var fastest int64
+	for _, candidate := range candidates {
+		outboundStatus, found := statusMap[candidate]
+		if !found {
+			// unfound candidate is considered alive
+			tags = append(tags, candidate)
+			continue
+		}
+		if !outboundStatus.Alive {
+			continue
+		}
+		tags = append(tags, candidate)
+		if outboundStatus.Delay > 0 && (fastest == 0 || outboundStatus.Delay < fastest) {
+			fastest = outboundStatus.Delay
+		}
+	}
+
+	weights := make([]float64, len(tags))
+	for i, tag := range tags {
+		weight := s.weights.Get(tag)
+		if s.settings.Delay && fastest > 0 {
+			if outboundStatus, found := statusMap[tag]; found && outboundStatus.Delay > 0 {
+				weight *= float64(fastest) / float64(outboundStatus.Delay)
+			}
+		}
+		if s.connections != nil {
+			weight /= float64(1 + s.connections.OutboundConnections(tag))
+		}
+		weights[i] = weight
+	}
+	return tags, weights
+}
@@ -0,0 +1,110 @@
+package router_test
+
+import (
+	"testing"
+
+	"github.com/xtls/xray-core/app/router"
+	"github.com/xtls/xray-core/features/routing"
+)
+
+type connectionDispatcher struct {
+	routing.Dispatcher
+	counting bool
+	counts   map[string]int
+}
+
+func (d *connectionDispatcher) CountConnections() {
+	d.counting = true
+}
+
+func (d *connectionDispatcher) OutboundConnections(tag string) int {
+	return d.counts[tag]
+}
+
+func TestWeightedRoundRobin(t *testing.T) {
+	strategy := router.NewWeightedRoundRobinStrategy(&router.StrategyWeightedRoundRobinConfig{
+		Weights: []*router.StrategyWeight{
+			{Match: "big", Value: 3},
+		},
+	}, "", nil)
+	candidates := []string{"big", "small"}
+
+	counts := make(map[string]int)
+	run := 0
+	for i := 0; i < 400; i++ {
+		tag := strategy.PickOutbound(candidates)
+		counts[tag]++
+		if tag == "big" {
+			run++
+		} else {
+			run = 0
+		}
+		if run > 3 {
+			t.Fatalf("%d picks of big in a row", run)
+		}
+	}
+	if counts["big"] != 300 || counts["small"] != 100 {
+		t.Errorf("expected 300 and 100 picks, got %v", counts)
+	}
+}
+
+func TestWeightedRoundRobinCandidatesChange(t *testing.T) {
+	strategy := router.NewWeightedRoundRobinStrategy(&router.StrategyWeightedRoundRobinConfig{}, "", nil)
+
+	// b is owed the next pick when it drops out for c, and is not owed it
+	// anymore when it comes back
+	picks := []string{
+		strategy.PickOutbound([]string{"a", "b"}),
+		strategy.PickOutbound([]string{"a", "c"}),
+		strategy.PickOutbound([]string{"a", "b"}),
+	}
+	if picks[0] != "a" || picks[1] != "c" || picks[2] != "a" {
+		t.Errorf("unexpected picks %v", picks)
+	}
+}
+
+func TestWeightedRoundRobinWeights(t *testing.T) {
+	dispatcher := &connectionDispatcher{counts: map[string]int{"hk-1000M": 4}}
+	strategy := router.NewWeightedRoundRobinStrategy(&router.StrategyWeightedRoundRobinConfig{
+		Weights: []*router.StrategyWeight{
+			// weights from the capacity in the tags
+			{Regexp: true, Match: `\d+M$`},
+		},
+		Connections: true,
+	}, "", dispatcher)
+	candidates := []string{"hk-1000M", "jp-100M", "direct"}
+	if !dispatcher.counting {
+		t.Fatal("the dispatcher was not asked to count connections")
+	}
+
+	expected := map[string]float64{"hk-1000M": 200, "jp-100M": 100, "direct": 1}
+	weights := strategy.GetWeights(candidates)
+	for tag, weight := range expected {
+		if weights[tag] != weight {
+			t.Errorf("expected weight %v for %s, got %v", weight, tag, weights[tag])
+		}
+	}
+
+	// The connections of hk-1000M weigh it down
+	counts := make(map[string]int)
+	for i := 0; i < 301; i++ {
+		counts[strategy.PickOutbound(candidates)]++
+	}
+	if counts["hk-1000M"] != 200 || counts["jp-100M"] != 100 || counts["direct"] != 1 {
+		t.Errorf("unexpected picks %v", counts)
+	}
+
+	// Without counting, the static weights apply
+	dispatcher.counting = false
+	strategy = router.NewWeightedRoundRobinStrategy(&router.StrategyWeightedRoundRobinConfig{
+		Weights: []*router.StrategyWeight{
+			{Regexp: true, Match: `\d+M$`},
+		},
+	}, "", dispatcher)
+	if weight := strategy.GetWeights(candidates)["hk-1000M"]; weight != 1000 {
+		t.Errorf("expected weight 1000 without counting connections, got %v", weight)
+	}
+	if dispatcher.counting {
+		t.Error("the dispatcher was asked to count connections no balancer needs")
+	}
+}
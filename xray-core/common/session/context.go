@@ -26,6 +26,7 @@ const (
 	fullHandlerKey            ctx.SessionKey = 10 // outbound gets full handler
 	mitmAlpn11Key             ctx.SessionKey = 11 // used by TLS dialer
 	mitmServerNameKey         ctx.SessionKey = 12 // used by TLS dialer
+	halfCloseKey              ctx.SessionKey = 13 // used by freedom to pass the end of the request on as a half-close
 )
 
 func ContextWithInbound(ctx context.Context, inbound *Inbound) context.Context {
@@ -192,3 +193,14 @@ func MitmServerNameFromContext(ctx context.Context) string {
 	}
 	return ""
 }
+
+func ContextWithHalfClose(ctx context.Context, halfClose bool) context.Context {
+	return context.WithValue(ctx, halfCloseKey, halfClose)
+}
+
+func HalfCloseFromContext(ctx context.Context) bool {
+	if val, ok := ctx.Value(halfCloseKey).(bool); ok {
+		return val
+	}
+	return false
+}
@@ -8,3 +8,7 @@ type BalancerOverrider interface {
 type BalancerPrincipleTarget interface {
 	GetPrincipleTarget(tag string) ([]string, error)
 }
+
+type BalancerWeights interface {
+	GetBalancerWeights(tag string) (map[string]float64, error)
+}
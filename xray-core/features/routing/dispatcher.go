@@ -20,6 +20,19 @@ type Dispatcher interface {
 	DispatchLink(ctx context.Context, dest net.Destination, link *transport.Link) error
 }
 
+// ConnectionCounter is a Dispatcher that counts the connections it has
+// dispatched to each outbound, once asked to.
+//
+// xray:api:beta
+type ConnectionCounter interface {
+	// CountConnections makes the dispatcher count the connections it
+	// dispatches from then on.
+	CountConnections()
+	// OutboundConnections returns the number of connections the outbound of
+	// tag is carrying.
+	OutboundConnections(tag string) int
+}
+
 // DispatcherType returns the type of Dispatcher interface. Can be used to implement common.HasType.
 //
 // xray:api:stable
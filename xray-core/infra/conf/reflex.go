@@ -1,22 +1,168 @@
 package conf
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
 	"google.golang.org/protobuf/proto"
 
 	"github.com/xtls/xray-core/common/errors"
 	"github.com/xtls/xray-core/common/net"
 	"github.com/xtls/xray-core/common/protocol"
 	"github.com/xtls/xray-core/common/serial"
+	"github.com/xtls/xray-core/common/uuid"
 	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/encoding"
 	"github.com/xtls/xray-core/proxy/reflex/inbound"
 	"github.com/xtls/xray-core/proxy/reflex/outbound"
 )
 
 // ReflexInboundConfig is the JSON config wrapper for Reflex inbound
 type ReflexInboundConfig struct {
-	Clients   []json.RawMessage `json:"clients"`
-	Fallbacks []*FallbackConfig  `json:"fallbacks"`
+	Clients            []json.RawMessage             `json:"clients"`
+	Fallbacks          []*FallbackConfig             `json:"fallbacks"`
+	KeepAliveInterval  uint32                        `json:"keepAliveInterval"`
+	Ban                *ReflexBanConfig              `json:"ban"`
+	ResumeGracePeriod  uint32                        `json:"resumeGracePeriod"`
+	PolicyOverrides    []*ReflexPolicyOverrideConfig `json:"policyOverrides"`
+	TimestampTolerance uint32                        `json:"timestampTolerance"`
+	MaxClockSkew       uint32                        `json:"maxClockSkew"`
+	UserStore          *ReflexUserStoreConfig        `json:"userStore"`
+	KeyLog             string                        `json:"keyLog"`
+	Decoy              *ReflexDecoyConfig            `json:"decoy"`
+
+	// tag is the tag of the inbound, set by InboundDetourConfig
+	tag string
+}
+
+// ReflexDecoyConfig is the JSON config of the website an inbound serves to
+// connections no fallback takes
+type ReflexDecoyConfig struct {
+	Root            string `json:"root"`
+	Upstream        string `json:"upstream"`
+	Server          string `json:"server"`
+	CertificateFile string `json:"certificateFile"`
+	KeyFile         string `json:"keyFile"`
+}
+
+// Build converts ReflexDecoyConfig to inbound.Decoy
+func (c *ReflexDecoyConfig) Build() (*inbound.Decoy, error) {
+	if c == nil {
+		return nil, nil
+	}
+	if (c.Root == "") == (c.Upstream == "") {
+		return nil, errors.New(`set either "root" or "upstream"`)
+	}
+	if c.Upstream != "" {
+		upstream, err := url.Parse(c.Upstream)
+		if err != nil || (upstream.Scheme != "http" && upstream.Scheme != "https") || upstream.Host == "" {
+			return nil, errors.New(`invalid "upstream" `, c.Upstream, ", expected an http or https URL")
+		}
+	}
+	if (c.CertificateFile == "") != (c.KeyFile == "") {
+		return nil, errors.New(`set both "certificateFile" and "keyFile", or neither`)
+	}
+	return &inbound.Decoy{
+		Root:            c.Root,
+		Upstream:        c.Upstream,
+		Server:          c.Server,
+		CertificateFile: c.CertificateFile,
+		KeyFile:         c.KeyFile,
+	}, nil
+}
+
+// ReflexUserStoreConfig is the JSON config of a file of clients reloaded
+// while running, its reload interval being in seconds
+type ReflexUserStoreConfig struct {
+	Path           string `json:"path"`
+	Format         string `json:"format"`
+	ReloadInterval uint32 `json:"reloadInterval"`
+}
+
+// Build converts ReflexUserStoreConfig to inbound.UserStore
+func (c *ReflexUserStoreConfig) Build() (*inbound.UserStore, error) {
+	if c == nil {
+		return nil, nil
+	}
+	if c.Path == "" {
+		return nil, errors.New(`"path" is required`)
+	}
+	format, err := inbound.UserStoreFormat(c.Path, c.Format)
+	if err != nil {
+		return nil, errors.New(`invalid "format"`).Base(err)
+	}
+	return &inbound.UserStore{
+		Path:           c.Path,
+		Format:         format,
+		ReloadInterval: c.ReloadInterval,
+	}, nil
+}
+
+// ReflexPolicyOverrideConfig is the JSON config of a profile forced on the
+// sessions of an inbound, from and to being local times such as "22:00"
+type ReflexPolicyOverrideConfig struct {
+	Policy string `json:"policy"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+}
+
+// parseTimeOfDay returns the minutes after midnight of a time such as "22:00".
+func parseTimeOfDay(s string) (uint32, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(t.Hour()*60 + t.Minute()), nil
+}
+
+// Build converts ReflexPolicyOverrideConfig to inbound.PolicyOverride
+func (c *ReflexPolicyOverrideConfig) Build() (*inbound.PolicyOverride, error) {
+	if c.Policy == "" || !encoding.IsProfileName(c.Policy) {
+		return nil, errors.New(`unknown "policy" `, c.Policy, ", expected one of ", strings.Join(encoding.ProfileNames, ", "))
+	}
+	if (c.From == "") != (c.To == "") {
+		return nil, errors.New(`set both "from" and "to", or neither`)
+	}
+	override := &inbound.PolicyOverride{Policy: c.Policy}
+	if c.From != "" {
+		from, err := parseTimeOfDay(c.From)
+		if err != nil {
+			return nil, errors.New(`invalid "from" `, c.From).Base(err)
+		}
+		to, err := parseTimeOfDay(c.To)
+		if err != nil {
+			return nil, errors.New(`invalid "to" `, c.To).Base(err)
+		}
+		override.From, override.To = from, to
+	}
+	return override, nil
+}
+
+// ReflexBanConfig is the JSON config for banning sources of failed handshakes
+type ReflexBanConfig struct {
+	MaxFailures       uint32 `json:"maxFailures"`
+	SubnetMaxFailures uint32 `json:"subnetMaxFailures"`
+	Window            uint32 `json:"window"`
+	Duration          uint32 `json:"duration"`
+	MaxDuration       uint32 `json:"maxDuration"`
+}
+
+// Build converts ReflexBanConfig to inbound.Ban
+func (c *ReflexBanConfig) Build() *inbound.Ban {
+	if c == nil {
+		return nil
+	}
+	return &inbound.Ban{
+		MaxFailures:       c.MaxFailures,
+		SubnetMaxFailures: c.SubnetMaxFailures,
+		Window:            c.Window,
+		Duration:          c.Duration,
+		MaxDuration:       c.MaxDuration,
+	}
 }
 
 type FallbackConfig struct {
@@ -28,51 +174,216 @@ type FallbackConfig struct {
 	Xver uint64 `json:"xver"`
 }
 
-// Build converts ReflexInboundConfig to proto.Message
-func (c *ReflexInboundConfig) Build() (proto.Message, error) {
-	cfg := &inbound.Config{
-		Clients: make([]*protocol.User, 0, len(c.Clients)),
+// ReflexUserConfig is the JSON config of a Reflex client. As in VLESS, id,
+// email, level and policy sit on the client object itself. The "account"
+// object of earlier versions is still read for its id and policy.
+type ReflexUserConfig struct {
+	ID               string             `json:"id"`
+	Email            string             `json:"email"`
+	Level            uint32             `json:"level"`
+	Policy           string             `json:"policy"`
+	ExpiresAt        string             `json:"expiresAt"`
+	QuotaBytes       uint64             `json:"quotaBytes"`
+	MaxConcurrentIPs uint32             `json:"maxConcurrentIPs"`
+	Cover            *ReflexCoverConfig `json:"cover"`
+	Account          json.RawMessage    `json:"account"`
+}
+
+// reflexLegacyAccount is the "account" object of clients written for earlier
+// versions, which held the id and policy.
+type reflexLegacyAccount struct {
+	Type   string `json:"type"`
+	ID     string `json:"id"`
+	Policy string `json:"policy"`
+}
+
+// ReflexCoverConfig is the JSON config of constant-rate cover traffic
+type ReflexCoverConfig struct {
+	Rate        uint32 `json:"rate"`
+	Size        uint32 `json:"size"`
+	Budget      uint64 `json:"budget"`
+	IdleTimeout uint32 `json:"idleTimeout"`
+}
+
+// Build converts ReflexCoverConfig to reflex.Cover
+func (c *ReflexCoverConfig) Build() *reflex.Cover {
+	if c == nil {
+		return nil
 	}
+	return &reflex.Cover{
+		Rate:        c.Rate,
+		Size:        c.Size,
+		Budget:      c.Budget,
+		IdleTimeout: c.IdleTimeout,
+	}
+}
+
+// parseReflexUser decodes a client object, rejecting unknown fields.
+func parseReflexUser(raw json.RawMessage) (*ReflexUserConfig, error) {
+	user := new(ReflexUserConfig)
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// applyLegacyAccount moves the id and policy of a deprecated "account"
+// object onto the client, unless the client sets others.
+func (c *ReflexUserConfig) applyLegacyAccount() error {
+	account := new(reflexLegacyAccount)
+	decoder := json.NewDecoder(bytes.NewReader(c.Account))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(account); err != nil {
+		return errors.New(`invalid "account"`).Base(err)
+	}
+	errors.PrintDeprecatedFeatureWarning(`"account" in Reflex clients`, `"id" and "policy" on the client itself`)
 
-	// Process clients
-	for _, rawUser := range c.Clients {
-		// First extract the client metadata
-		var userObj struct {
-			ID       string          `json:"id"`
-			Level    uint32          `json:"level"`
-			Email    string          `json:"email"`
-			Account  json.RawMessage `json:"account"`
+	if account.ID != "" {
+		if c.ID != "" && !strings.EqualFold(c.ID, account.ID) {
+			return errors.New(`"account" has "id" `, account.ID, `, which conflicts with "id" `, c.ID)
 		}
-		if err := json.Unmarshal(rawUser, &userObj); err != nil {
-			return nil, errors.New("failed to parse user").Base(err).AtError()
+		c.ID = account.ID
+	}
+	if account.Policy != "" {
+		if c.Policy != "" && c.Policy != account.Policy {
+			return errors.New(`"account" has "policy" `, account.Policy, `, which conflicts with "policy" `, c.Policy)
 		}
+		c.Policy = account.Policy
+	}
+	return nil
+}
 
-		// Create the User object
-		user := &protocol.User{
-			Level: userObj.Level,
-			Email: userObj.Email,
+// Build validates the client and converts it to protocol.User
+func (c *ReflexUserConfig) Build() (*protocol.User, error) {
+	if c.Account != nil {
+		if err := c.applyLegacyAccount(); err != nil {
+			return nil, err
+		}
+	}
+	if c.ID == "" {
+		return nil, errors.New(`"id" is required`)
+	}
+	id, err := uuid.ParseString(c.ID)
+	if err != nil {
+		return nil, errors.New(`invalid "id" `, c.ID).Base(err)
+	}
+	c.ID = id.String()
+	if !encoding.IsProfileName(c.Policy) {
+		return nil, errors.New(`unknown "policy" `, c.Policy, ", expected one of ", strings.Join(encoding.ProfileNames, ", "))
+	}
+	var expiresAt int64
+	if c.ExpiresAt != "" {
+		t, err := time.Parse(time.RFC3339, c.ExpiresAt)
+		if err != nil {
+			return nil, errors.New(`invalid "expiresAt"`).Base(err)
 		}
+		expiresAt = t.Unix()
+	}
+	return &protocol.User{
+		Level: c.Level,
+		Email: c.Email,
+		Account: serial.ToTypedMessage(&reflex.Account{
+			Id:               c.ID,
+			Policy:           c.Policy,
+			ExpiresAt:        expiresAt,
+			QuotaBytes:       c.QuotaBytes,
+			MaxConcurrentIps: c.MaxConcurrentIPs,
+			Cover:            c.Cover.Build(),
+		}),
+	}, nil
+}
+
+// reflexUserSet detects clients sharing an ID or an email.
+type reflexUserSet struct {
+	ids    map[string]string
+	emails map[string]string
+}
+
+func newReflexUserSet() *reflexUserSet {
+	return &reflexUserSet{
+		ids:    make(map[string]string),
+		emails: make(map[string]string),
+	}
+}
 
-		// Process account
-		if userObj.Account != nil {
-			// Parse the account as a reflex Account
-			var accountObj struct {
-				Type   string `json:"type"`
-				ID     string `json:"id"`
-				Policy string `json:"policy"`
-			}
-			if err := json.Unmarshal(userObj.Account, &accountObj); err == nil {
-				reflexAccount := &reflex.Account{
-					Id:     accountObj.ID,
-					Policy: accountObj.Policy,
-				}
-				user.Account = serial.ToTypedMessage(reflexAccount)
-			}
+// add records a built user under name, or returns an error naming the
+// earlier user with the same ID or email. Emails are compared
+// case-insensitively.
+func (s *reflexUserSet) add(name string, user *ReflexUserConfig) error {
+	if prev, found := s.ids[user.ID]; found {
+		return errors.New(`duplicate "id" `, user.ID, ", already used by ", prev)
+	}
+	s.ids[user.ID] = name
+	if user.Email != "" {
+		email := strings.ToLower(user.Email)
+		if prev, found := s.emails[email]; found {
+			return errors.New(`duplicate "email" `, user.Email, ", already used by ", prev)
 		}
+		s.emails[email] = name
+	}
+	return nil
+}
+
+// Build converts ReflexInboundConfig to proto.Message
+func (c *ReflexInboundConfig) Build() (proto.Message, error) {
+	cfg := &inbound.Config{
+		Clients:            make([]*protocol.User, 0, len(c.Clients)),
+		KeepAliveInterval:  c.KeepAliveInterval,
+		Ban:                c.Ban.Build(),
+		ResumeGracePeriod:  c.ResumeGracePeriod,
+		TimestampTolerance: c.TimestampTolerance,
+		MaxClockSkew:       c.MaxClockSkew,
+		KeyLog:             c.KeyLog,
+		Tag:                c.tag,
+	}
+
+	tolerance := c.TimestampTolerance
+	if tolerance == 0 {
+		tolerance = encoding.TimestampTolerance
+	}
+	if c.MaxClockSkew != 0 && c.MaxClockSkew <= tolerance {
+		return nil, errors.New(`Reflex "maxClockSkew" `, c.MaxClockSkew, ` must exceed "timestampTolerance" `, tolerance)
+	}
+
+	store, err := c.UserStore.Build()
+	if err != nil {
+		return nil, errors.New("Reflex userStore").Base(err)
+	}
+	cfg.UserStore = store
+
+	decoy, err := c.Decoy.Build()
+	if err != nil {
+		return nil, errors.New("Reflex decoy").Base(err)
+	}
+	cfg.Decoy = decoy
 
+	users := newReflexUserSet()
+	for idx, rawUser := range c.Clients {
+		name := fmt.Sprintf("clients[%d]", idx)
+		userConfig, err := parseReflexUser(rawUser)
+		if err != nil {
+			return nil, errors.New("Reflex ", name, ": invalid user").Base(err)
+		}
+		user, err := userConfig.Build()
+		if err != nil {
+			return nil, errors.New("Reflex ", name).Base(err)
+		}
+		if err := users.add(name, userConfig); err != nil {
+			return nil, errors.New("Reflex ", name).Base(err)
+		}
 		cfg.Clients = append(cfg.Clients, user)
 	}
 
+	for idx, override := range c.PolicyOverrides {
+		o, err := override.Build()
+		if err != nil {
+			return nil, errors.New("Reflex policyOverrides[", idx, "]").Base(err)
+		}
+		cfg.PolicyOverrides = append(cfg.PolicyOverrides, o)
+	}
+
 	for _, fb := range c.Fallbacks {
 		cfg.Fallbacks = append(cfg.Fallbacks, &inbound.Fallback{
 			Name: fb.Name,
@@ -88,75 +399,134 @@ func (c *ReflexInboundConfig) Build() (proto.Message, error) {
 
 // ReflexOutboundConfig is the JSON config wrapper for Reflex outbound
 type ReflexOutboundConfig struct {
-	Vnext []json.RawMessage `json:"vnext"`
+	Vnext             []json.RawMessage       `json:"vnext"`
+	KeepAliveInterval uint32                  `json:"keepAliveInterval"`
+	Resume            bool                    `json:"resume"`
+	PadHandshake      bool                    `json:"padHandshake"`
+	HelloSplit        *ReflexHelloSplitConfig `json:"helloSplit"`
+	Pool              *ReflexPoolConfig       `json:"pool"`
+	KeyLog            string                  `json:"keyLog"`
+}
+
+// ReflexHelloSplitConfig is the JSON config of splitting the hello into
+// several writes, delays being in milliseconds
+type ReflexHelloSplitConfig struct {
+	Count    uint32 `json:"count"`
+	MinDelay uint32 `json:"minDelay"`
+	MaxDelay uint32 `json:"maxDelay"`
+}
+
+// Build converts ReflexHelloSplitConfig to outbound.HelloSplit
+func (c *ReflexHelloSplitConfig) Build() (*outbound.HelloSplit, error) {
+	if c == nil {
+		return nil, nil
+	}
+	if c.MaxDelay < c.MinDelay {
+		return nil, errors.New(`"maxDelay" `, c.MaxDelay, ` is less than "minDelay" `, c.MinDelay)
+	}
+	return &outbound.HelloSplit{
+		Count:    c.Count,
+		MinDelay: c.MinDelay,
+		MaxDelay: c.MaxDelay,
+	}, nil
+}
+
+// ReflexPoolConfig is the JSON config of the pool of handshaken
+// connections, its max age being in seconds
+type ReflexPoolConfig struct {
+	Size   uint32 `json:"size"`
+	MaxAge uint32 `json:"maxAge"`
+}
+
+// Build converts ReflexPoolConfig to outbound.Pool
+func (c *ReflexPoolConfig) Build() *outbound.Pool {
+	if c == nil {
+		return nil
+	}
+	return &outbound.Pool{
+		Size:   c.Size,
+		MaxAge: c.MaxAge,
+	}
+}
+
+// ReflexServerConfig is the JSON config of a Reflex server in vnext
+type ReflexServerConfig struct {
+	Address *Address          `json:"address"`
+	Port    uint16            `json:"port"`
+	User    json.RawMessage   `json:"user"`
+	Users   []json.RawMessage `json:"users"`
 }
 
 // Build converts ReflexOutboundConfig to proto.Message
 func (c *ReflexOutboundConfig) Build() (proto.Message, error) {
 	cfg := &outbound.Config{
-		Vnext: make([]*protocol.ServerEndpoint, 0, len(c.Vnext)),
-	}
-
-	// Process vnext endpoints
-	for _, rawEndpoint := range c.Vnext {
-		// Parse the endpoint JSON manually to handle Account properly
-		var endpointObj struct {
-			Address string `json:"address"`
-			Port    uint32 `json:"port"`
-			User    struct {
-				ID       string          `json:"id"`
-				Level    uint32          `json:"level"`
-				Email    string          `json:"email"`
-				Account  json.RawMessage `json:"account"`
-			} `json:"user"`
-		}
-		if err := json.Unmarshal(rawEndpoint, &endpointObj); err != nil {
-			return nil, errors.New("failed to parse server endpoint").Base(err).AtError()
-		}
-
-		// Create the endpoint
-		addr := net.ParseAddress(endpointObj.Address)
-		ipOrDomain := &net.IPOrDomain{}
-
-		// Set the appropriate address type based on the parsed address
-		if ipAddr := addr.IP(); ipAddr != nil {
-			ipOrDomain.Address = &net.IPOrDomain_Ip{Ip: ipAddr}
-		} else if domain := addr.Domain(); domain != "" {
-			ipOrDomain.Address = &net.IPOrDomain_Domain{Domain: domain}
-		}
-
-		endpoint := &protocol.ServerEndpoint{
-			Address: ipOrDomain,
-			Port:    endpointObj.Port,
-		}
-
-		// Create the user
-		if endpointObj.User.ID != "" || endpointObj.User.Account != nil {
-			user := &protocol.User{
-				Level: endpointObj.User.Level,
-				Email: endpointObj.User.Email,
-			}
-
-			// Process account
-			if endpointObj.User.Account != nil {
-				// Parse the account as a reflex Account
-				var accountObj struct {
-					Type   string `json:"type"`
-					ID     string `json:"id"`
-					Policy string `json:"policy"`
-				}
-				if err := json.Unmarshal(endpointObj.User.Account, &accountObj); err == nil {
-					reflexAccount := &reflex.Account{
-						Id:     accountObj.ID,
-						Policy: accountObj.Policy,
-					}
-					user.Account = serial.ToTypedMessage(reflexAccount)
-				}
-			}
-			endpoint.User = user
-		}
-
-		cfg.Vnext = append(cfg.Vnext, endpoint)
+		Vnext:             make([]*protocol.ServerEndpoint, 0, len(c.Vnext)),
+		KeepAliveInterval: c.KeepAliveInterval,
+		Resume:            c.Resume,
+		PadHandshake:      c.PadHandshake,
+		KeyLog:            c.KeyLog,
+	}
+
+	split, err := c.HelloSplit.Build()
+	if err != nil {
+		return nil, errors.New("Reflex helloSplit").Base(err)
+	}
+	cfg.HelloSplit = split
+	cfg.Pool = c.Pool.Build()
+
+	// The same user may connect to several servers, but not twice to one
+	users := make(map[string]*reflexUserSet)
+	for idx, rawEndpoint := range c.Vnext {
+		name := fmt.Sprintf("vnext[%d]", idx)
+		server := new(ReflexServerConfig)
+		decoder := json.NewDecoder(bytes.NewReader(rawEndpoint))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(server); err != nil {
+			return nil, errors.New("Reflex ", name, ": invalid server").Base(err)
+		}
+		if server.Address == nil {
+			return nil, errors.New("Reflex ", name, `: "address" is required`)
+		}
+		if server.Port == 0 {
+			return nil, errors.New("Reflex ", name, `: "port" is required`)
+		}
+		rawUser := server.User
+		switch {
+		case rawUser != nil && len(server.Users) > 0:
+			return nil, errors.New("Reflex ", name, `: set either "user" or "users", not both`)
+		case len(server.Users) == 1:
+			rawUser = server.Users[0]
+		case len(server.Users) > 1:
+			return nil, errors.New("Reflex ", name, `: "users" must have exactly one user`)
+		case rawUser == nil:
+			return nil, errors.New("Reflex ", name, `: "user" is required`)
+		}
+
+		userConfig, err := parseReflexUser(rawUser)
+		if err != nil {
+			return nil, errors.New("Reflex ", name, ": invalid user").Base(err)
+		}
+		if userConfig.ExpiresAt != "" || userConfig.QuotaBytes != 0 || userConfig.MaxConcurrentIPs != 0 {
+			return nil, errors.New("Reflex ", name, `: "expiresAt", "quotaBytes" and "maxConcurrentIPs" are only supported in inbound settings`)
+		}
+		user, err := userConfig.Build()
+		if err != nil {
+			return nil, errors.New("Reflex ", name).Base(err)
+		}
+		address := server.Address.Build()
+		endpoint := strings.ToLower(net.TCPDestination(address.AsAddress(), net.Port(server.Port)).NetAddr())
+		if users[endpoint] == nil {
+			users[endpoint] = newReflexUserSet()
+		}
+		if err := users[endpoint].add(name, userConfig); err != nil {
+			return nil, errors.New("Reflex ", name).Base(err)
+		}
+
+		cfg.Vnext = append(cfg.Vnext, &protocol.ServerEndpoint{
+			Address: address,
+			Port:    uint32(server.Port),
+			User:    user,
+		})
 	}
 
 	return cfg, nil
@@ -0,0 +1,199 @@
+package conf
+
+import (
+	"crypto/ecdh"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+
+	"github.com/xtls/xray-core/common/errors"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+	"github.com/xtls/xray-core/proxy/reflex/sharelink"
+	"github.com/xtls/xray-core/transport/internet/tls"
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// ReflexShareLinks returns a share link for each client of a Reflex inbound.
+// address is the public address clients connect to, as the inbound usually
+// listens on all interfaces.
+func ReflexShareLinks(in *InboundDetourConfig, address string) ([]*sharelink.Link, error) {
+	if !strings.EqualFold(in.Protocol, "reflex") {
+		return nil, errors.New("not a Reflex inbound: ", in.Protocol)
+	}
+	if address == "" {
+		return nil, errors.New("server address is not specified")
+	}
+	if in.PortList == nil || len(in.PortList.Range) == 0 {
+		return nil, errors.New("inbound has no port")
+	}
+	if in.Settings == nil {
+		return nil, errors.New("inbound has no settings")
+	}
+	settings := new(ReflexInboundConfig)
+	if err := json.Unmarshal(*in.Settings, settings); err != nil {
+		return nil, errors.New("failed to parse inbound settings").Base(err)
+	}
+	message, err := settings.Build()
+	if err != nil {
+		return nil, errors.New("failed to build inbound settings").Base(err)
+	}
+
+	stream, err := reflexStreamLink(in.StreamSetting)
+	if err != nil {
+		return nil, err
+	}
+	stream.Address = address
+	stream.Port = in.PortList.Range[0].From
+
+	var links []*sharelink.Link
+	for _, user := range message.(*inbound.Config).Clients {
+		instance, err := user.Account.GetInstance()
+		if err != nil {
+			return nil, errors.New("failed to read account of ", user.Email).Base(err)
+		}
+		account, ok := instance.(*reflex.Account)
+		if !ok {
+			return nil, errors.New("not a Reflex account: ", user.Email)
+		}
+		link := *stream
+		link.ID = account.Id
+		link.Policy = account.Policy
+		link.Name = user.Email
+		if link.Name == "" {
+			link.Name = in.Tag
+		}
+		links = append(links, &link)
+	}
+	return links, nil
+}
+
+// reflexStreamLink fills the transport and security parameters of a share
+// link from the stream settings of an inbound.
+func reflexStreamLink(s *StreamConfig) (*sharelink.Link, error) {
+	l := &sharelink.Link{Network: "tcp", Security: "none"}
+	if s == nil {
+		return l, nil
+	}
+
+	if s.Network != nil {
+		network, err := s.Network.Build()
+		if err != nil {
+			return nil, err
+		}
+		switch network {
+		case "tcp", "grpc", "httpupgrade":
+			l.Network = network
+		case "websocket":
+			l.Network = "ws"
+		case "splithttp":
+			l.Network = "xhttp"
+		default:
+			return nil, errors.New("transport ", network, " is not supported in share links")
+		}
+	}
+	switch l.Network {
+	case "ws":
+		if c := s.WSSettings; c != nil {
+			l.Host, l.Path = c.Host, c.Path
+		}
+	case "httpupgrade":
+		if c := s.HTTPUPGRADESettings; c != nil {
+			l.Host, l.Path = c.Host, c.Path
+		}
+	case "grpc":
+		l.Mode = "gun"
+		if c := s.GRPCSettings; c != nil {
+			l.ServiceName = c.ServiceName
+			if c.MultiMode {
+				l.Mode = "multi"
+			}
+		}
+	case "xhttp":
+		c := s.XHTTPSettings
+		if c == nil {
+			c = s.SplitHTTPSettings
+		}
+		if c != nil {
+			l.Host, l.Path, l.Mode = c.Host, c.Path, c.Mode
+		}
+	}
+
+	switch strings.ToLower(s.Security) {
+	case "", "none":
+	case "tls":
+		l.Security = "tls"
+		c := s.TLSSettings
+		if c == nil {
+			break
+		}
+		l.SNI = c.ServerName
+		if c.ALPN != nil {
+			l.ALPN = strings.Join(*c.ALPN, ",")
+		}
+		l.Fingerprint = c.Fingerprint
+		if c.ECHServerKeys != "" || c.ECHServerKeysFile != "" {
+			ech, err := reflexECHConfigList(c)
+			if err != nil {
+				return nil, err
+			}
+			l.ECH = ech
+		}
+	case "reality":
+		l.Security = "reality"
+		c := s.REALITYSettings
+		if c == nil {
+			return nil, errors.New("REALITY inbound has no realitySettings")
+		}
+		privateKey, err := base64.RawURLEncoding.DecodeString(c.PrivateKey)
+		if err != nil {
+			return nil, errors.New("invalid REALITY private key").Base(err)
+		}
+		key, err := ecdh.X25519().NewPrivateKey(privateKey)
+		if err != nil {
+			return nil, errors.New("invalid REALITY private key").Base(err)
+		}
+		l.PublicKey = base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes())
+		if len(c.ServerNames) > 0 {
+			l.SNI = c.ServerNames[0]
+		}
+		if len(c.ShortIds) > 0 {
+			l.ShortID = c.ShortIds[0]
+		}
+		l.Fingerprint = c.Fingerprint
+		if l.Fingerprint == "" {
+			l.Fingerprint = "chrome"
+		}
+		l.SpiderX = c.SpiderX
+	default:
+		return nil, errors.New("security ", s.Security, " is not supported in share links")
+	}
+	return l, nil
+}
+
+// reflexECHConfigList returns the base64 ECH config list served by the ECH
+// keys of a TLS inbound.
+func reflexECHConfigList(c *TLSConfig) (string, error) {
+	built, err := (&TLSConfig{
+		ECHServerKeys:     c.ECHServerKeys,
+		ECHServerKeysFile: c.ECHServerKeysFile,
+	}).Build()
+	if err != nil {
+		return "", errors.New("failed to read ECH server keys").Base(err)
+	}
+	keys, err := tls.ConvertToGoECHKeys(built.(*tls.Config).EchServerKeys)
+	if err != nil {
+		return "", errors.New("invalid ECH server keys").Base(err)
+	}
+	var b cryptobyte.Builder
+	for _, key := range keys {
+		b.AddUint16LengthPrefixed(func(child *cryptobyte.Builder) {
+			child.AddBytes(key.Config)
+		})
+	}
+	list, err := b.Bytes()
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(list), nil
+}
@@ -0,0 +1,191 @@
+package conf_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/xtls/reality/hpke"
+	"github.com/xtls/xray-core/common"
+	. "github.com/xtls/xray-core/infra/conf"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/outbound"
+	"github.com/xtls/xray-core/proxy/reflex/sharelink"
+	"github.com/xtls/xray-core/transport/internet/reality"
+	"github.com/xtls/xray-core/transport/internet/tls"
+	"golang.org/x/crypto/cryptobyte"
+)
+
+func buildReflexOutbound(t *testing.T, link *sharelink.Link) (*outbound.Config, *OutboundDetourConfig) {
+	data, err := link.OutboundJSON("proxy")
+	common.Must(err)
+	detour := new(OutboundDetourConfig)
+	if err := json.Unmarshal(data, detour); err != nil {
+		t.Fatal(err)
+	}
+	handler, err := detour.Build()
+	if err != nil {
+		t.Fatal(string(data), ": ", err)
+	}
+	if handler.Tag != "proxy" {
+		t.Fatal("unexpected tag ", handler.Tag)
+	}
+	proxy, err := handler.ProxySettings.GetInstance()
+	common.Must(err)
+	return proxy.(*outbound.Config), detour
+}
+
+// TestReflexShareLinks tests generating links from an inbound and importing
+// them back as outbounds
+func TestReflexShareLinks(t *testing.T) {
+	echConfig, priv, err := tls.GenerateECHKeySet(0, "cover.example.com", hpke.DHKEM_X25519_HKDF_SHA256)
+	common.Must(err)
+	configBytes, err := tls.MarshalBinary(echConfig)
+	common.Must(err)
+	var keys cryptobyte.Builder
+	keys.AddUint16(uint16(len(priv)))
+	keys.AddBytes(priv)
+	keys.AddUint16(uint16(len(configBytes)))
+	keys.AddBytes(configBytes)
+	serverKeys := keys.BytesOrPanic()
+	var list cryptobyte.Builder
+	list.AddUint16LengthPrefixed(func(child *cryptobyte.Builder) {
+		child.AddBytes(configBytes)
+	})
+	configList := base64.StdEncoding.EncodeToString(list.BytesOrPanic())
+
+	input := `[
+		{
+			"tag": "reflex-ws",
+			"protocol": "reflex",
+			"port": 443,
+			"settings": {
+				"clients": [
+					{"id": "27848739-7e62-4138-9fd3-098a63964b6b", "email": "a@example.com", "policy": "youtube"},
+					{"id": "8f6b4e38-b0b6-43b4-a2d4-5d5b1c0a2c1e"}
+				]
+			},
+			"streamSettings": {
+				"network": "ws",
+				"wsSettings": {"path": "/reflex", "host": "cdn.example.com"},
+				"security": "tls",
+				"tlsSettings": {
+					"serverName": "cdn.example.com",
+					"alpn": ["http/1.1"],
+					"echServerKeys": "` + base64.StdEncoding.EncodeToString(serverKeys) + `"
+				}
+			}
+		},
+		{
+			"protocol": "reflex",
+			"port": 8443,
+			"settings": {
+				"clients": [
+					{"id": "27848739-7e62-4138-9fd3-098a63964b6b", "email": "a@example.com", "policy": "zoom"}
+				]
+			},
+			"streamSettings": {
+				"network": "grpc",
+				"grpcSettings": {"serviceName": "api", "multiMode": true},
+				"security": "reality",
+				"realitySettings": {
+					"target": "www.example.com:443",
+					"serverNames": ["www.example.com"],
+					"privateKey": "aGSYystUbf59_9_6LKRxD27rmSW_-2_nyd9YG_Gwbks",
+					"shortIds": ["0123456789abcdef"]
+				}
+			}
+		}
+	]`
+	var inbounds []InboundDetourConfig
+	common.Must(json.Unmarshal([]byte(input), &inbounds))
+
+	links, err := ReflexShareLinks(&inbounds[0], "server.example.com")
+	common.Must(err)
+	if len(links) != 2 {
+		t.Fatal("expected 2 links, got ", len(links))
+	}
+	expected := &sharelink.Link{
+		ID:       "27848739-7e62-4138-9fd3-098a63964b6b",
+		Address:  "server.example.com",
+		Port:     443,
+		Name:     "a@example.com",
+		Policy:   "youtube",
+		Network:  "ws",
+		Security: "tls",
+		SNI:      "cdn.example.com",
+		ALPN:     "http/1.1",
+		ECH:      configList,
+		Host:     "cdn.example.com",
+		Path:     "/reflex",
+	}
+	if !reflect.DeepEqual(links[0], expected) {
+		t.Fatalf("got %+v, want %+v", links[0], expected)
+	}
+	if links[1].Name != "reflex-ws" {
+		t.Error("expected client without email to be named after the inbound tag, got ", links[1].Name)
+	}
+
+	parsed, err := sharelink.Parse(links[0].String())
+	common.Must(err)
+	config, detour := buildReflexOutbound(t, parsed)
+	if len(config.Vnext) != 1 || config.Vnext[0].Port != 443 || config.Vnext[0].Address.GetDomain() != "server.example.com" {
+		t.Fatal("unexpected vnext ", config.Vnext)
+	}
+	account, err := config.Vnext[0].User.Account.GetInstance()
+	common.Must(err)
+	if a := account.(*reflex.Account); a.Id != expected.ID || a.Policy != "youtube" {
+		t.Fatal("unexpected account ", a)
+	}
+	stream, err := detour.StreamSetting.Build()
+	common.Must(err)
+	if stream.ProtocolName != "websocket" {
+		t.Fatal("unexpected transport ", stream.ProtocolName)
+	}
+	security, err := stream.GetEffectiveSecuritySettings()
+	common.Must(err)
+	if s := security.(*tls.Config); s.ServerName != "cdn.example.com" || s.EchConfigList != configList {
+		t.Fatal("unexpected TLS settings ", s)
+	}
+
+	links, err = ReflexShareLinks(&inbounds[1], "192.0.2.1")
+	common.Must(err)
+	if len(links) != 1 {
+		t.Fatal("expected 1 link, got ", len(links))
+	}
+	if l := links[0]; l.PublicKey != "E59WjnvZcQMu7tR7_BgyhycuEdBS-CtKxfImRCdAvFM" || l.ShortID != "0123456789abcdef" ||
+		l.SNI != "www.example.com" || l.Mode != "multi" || l.ServiceName != "api" || l.Fingerprint != "chrome" {
+		t.Fatalf("unexpected link %+v", l)
+	}
+	parsed, err = sharelink.Parse(links[0].String())
+	common.Must(err)
+	_, detour = buildReflexOutbound(t, parsed)
+	stream, err = detour.StreamSetting.Build()
+	common.Must(err)
+	if stream.ProtocolName != "grpc" {
+		t.Fatal("unexpected transport ", stream.ProtocolName)
+	}
+	security, err = stream.GetEffectiveSecuritySettings()
+	common.Must(err)
+	publicKey, _ := base64.RawURLEncoding.DecodeString("E59WjnvZcQMu7tR7_BgyhycuEdBS-CtKxfImRCdAvFM")
+	if s := security.(*reality.Config); !bytes.Equal(s.PublicKey, publicKey) || s.ServerName != "www.example.com" {
+		t.Fatal("unexpected REALITY settings ", s)
+	}
+}
+
+// TestReflexShareLinksErrors tests that unsupported inbounds are rejected
+func TestReflexShareLinksErrors(t *testing.T) {
+	for _, input := range []string{
+		`{"protocol": "vless", "port": 443, "settings": {"clients": []}}`,
+		`{"protocol": "reflex", "settings": {"clients": []}}`,
+		`{"protocol": "reflex", "port": 443, "settings": {"clients": []}, "streamSettings": {"network": "kcp"}}`,
+	} {
+		in := new(InboundDetourConfig)
+		common.Must(json.Unmarshal([]byte(input), in))
+		if _, err := ReflexShareLinks(in, "example.com"); err == nil {
+			t.Error("expected error for ", input)
+		}
+	}
+}
@@ -0,0 +1,382 @@
+package conf_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/protocol"
+	"github.com/xtls/xray-core/common/serial"
+	"github.com/xtls/xray-core/common/uuid"
+	. "github.com/xtls/xray-core/infra/conf"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+	"github.com/xtls/xray-core/proxy/reflex/outbound"
+)
+
+func TestReflexInbound(t *testing.T) {
+	creator := func() Buildable {
+		return new(ReflexInboundConfig)
+	}
+
+	runMultiTestCase(t, []TestCase{
+		{
+			Input: `{
+				"clients": [
+					{
+						"id": "27848739-7e62-4138-9fd3-098a63964b6b",
+						"email": "love@example.com",
+						"policy": "youtube",
+						"expiresAt": "2030-01-01T00:00:00Z",
+						"quotaBytes": 107374182400,
+						"maxConcurrentIPs": 2,
+						"cover": {
+							"rate": 50,
+							"size": 1200,
+							"budget": 65536,
+							"idleTimeout": 30
+						}
+					}
+				]
+			}`,
+			Parser: loadJSON(creator),
+			Output: &inbound.Config{
+				Clients: []*protocol.User{
+					{
+						Email: "love@example.com",
+						Account: serial.ToTypedMessage(&reflex.Account{
+							Id:               "27848739-7e62-4138-9fd3-098a63964b6b",
+							Policy:           "youtube",
+							ExpiresAt:        1893456000,
+							QuotaBytes:       107374182400,
+							MaxConcurrentIps: 2,
+							Cover: &reflex.Cover{
+								Rate:        50,
+								Size:        1200,
+								Budget:      65536,
+								IdleTimeout: 30,
+							},
+						}),
+					},
+				},
+			},
+		},
+		{
+			Input: `{
+				"clients": [
+					{
+						"email": "legacy@example.com",
+						"level": 1,
+						"account": {
+							"type": "reflex",
+							"id": "27848739-7E62-4138-9FD3-098A63964B6B",
+							"policy": "zoom"
+						}
+					},
+					{
+						"id": "0e8b3bd5-ad4b-4e8f-a5e5-a9ecf4c7c1d8",
+						"account": {"id": "0e8b3bd5-ad4b-4e8f-a5e5-a9ecf4c7c1d8"}
+					}
+				]
+			}`,
+			Parser: loadJSON(creator),
+			Output: &inbound.Config{
+				Clients: []*protocol.User{
+					{
+						Email: "legacy@example.com",
+						Level: 1,
+						Account: serial.ToTypedMessage(&reflex.Account{
+							Id:     "27848739-7e62-4138-9fd3-098a63964b6b",
+							Policy: "zoom",
+						}),
+					},
+					{
+						Account: serial.ToTypedMessage(&reflex.Account{
+							Id: "0e8b3bd5-ad4b-4e8f-a5e5-a9ecf4c7c1d8",
+						}),
+					},
+				},
+			},
+		},
+		{
+			Input: `{
+				"clients": [],
+				"ban": {
+					"maxFailures": 5,
+					"subnetMaxFailures": 50,
+					"window": 30,
+					"duration": 120,
+					"maxDuration": 3600
+				}
+			}`,
+			Parser: loadJSON(creator),
+			Output: &inbound.Config{
+				Clients: []*protocol.User{},
+				Ban: &inbound.Ban{
+					MaxFailures:       5,
+					SubnetMaxFailures: 50,
+					Window:            30,
+					Duration:          120,
+					MaxDuration:       3600,
+				},
+			},
+		},
+		{
+			Input: `{
+				"clients": [],
+				"resumeGracePeriod": 30
+			}`,
+			Parser: loadJSON(creator),
+			Output: &inbound.Config{
+				Clients:           []*protocol.User{},
+				ResumeGracePeriod: 30,
+			},
+		},
+		{
+			Input: `{
+				"clients": [],
+				"policyOverrides": [
+					{"policy": "http2-api", "from": "22:00", "to": "06:30"},
+					{"policy": "zoom"}
+				]
+			}`,
+			Parser: loadJSON(creator),
+			Output: &inbound.Config{
+				Clients: []*protocol.User{},
+				PolicyOverrides: []*inbound.PolicyOverride{
+					{Policy: "http2-api", From: 22 * 60, To: 6*60 + 30},
+					{Policy: "zoom"},
+				},
+			},
+		},
+		{
+			Input: `{
+				"clients": [],
+				"timestampTolerance": 60,
+				"maxClockSkew": 86400
+			}`,
+			Parser: loadJSON(creator),
+			Output: &inbound.Config{
+				Clients:            []*protocol.User{},
+				TimestampTolerance: 60,
+				MaxClockSkew:       86400,
+			},
+		},
+		{
+			Input: `{
+				"clients": [],
+				"userStore": {"path": "/etc/xray/users.CSV", "reloadInterval": 5},
+				"keyLog": "/tmp/reflex-keys.log"
+			}`,
+			Parser: loadJSON(creator),
+			Output: &inbound.Config{
+				Clients: []*protocol.User{},
+				UserStore: &inbound.UserStore{
+					Path:           "/etc/xray/users.CSV",
+					Format:         "csv",
+					ReloadInterval: 5,
+				},
+				KeyLog: "/tmp/reflex-keys.log",
+			},
+		},
+		{
+			Input: `{
+				"clients": [],
+				"decoy": {
+					"root": "/var/www/html",
+					"server": "nginx/1.24.0",
+					"certificateFile": "/etc/ssl/example.com.crt",
+					"keyFile": "/etc/ssl/example.com.key"
+				}
+			}`,
+			Parser: loadJSON(creator),
+			Output: &inbound.Config{
+				Clients: []*protocol.User{},
+				Decoy: &inbound.Decoy{
+					Root:            "/var/www/html",
+					Server:          "nginx/1.24.0",
+					CertificateFile: "/etc/ssl/example.com.crt",
+					KeyFile:         "/etc/ssl/example.com.key",
+				},
+			},
+		},
+		{
+			Input: `{
+				"clients": [],
+				"decoy": {"upstream": "https://www.example.com"}
+			}`,
+			Parser: loadJSON(creator),
+			Output: &inbound.Config{
+				Clients: []*protocol.User{},
+				Decoy:   &inbound.Decoy{Upstream: "https://www.example.com"},
+			},
+		},
+	})
+}
+
+func TestReflexInboundTag(t *testing.T) {
+	in := new(InboundDetourConfig)
+	common.Must(json.Unmarshal([]byte(`{
+		"protocol": "reflex",
+		"port": 443,
+		"tag": "reflex-in",
+		"settings": {"clients": []}
+	}`), in))
+	built, err := in.Build()
+	common.Must(err)
+	settings, err := built.ProxySettings.GetInstance()
+	common.Must(err)
+	if tag := settings.(*inbound.Config).Tag; tag != "reflex-in" {
+		t.Fatalf("expected the tag of the inbound in its settings, got %q", tag)
+	}
+}
+
+func TestReflexOutbound(t *testing.T) {
+	reflexID, err := uuid.ParseString("reflex")
+	common.Must(err)
+	creator := func() Buildable {
+		return new(ReflexOutboundConfig)
+	}
+
+	runMultiTestCase(t, []TestCase{
+		{
+			Input: `{
+				"vnext": [
+					{
+						"address": "example.com",
+						"port": 443,
+						"user": {
+							"id": "27848739-7E62-4138-9FD3-098A63964B6B",
+							"email": "love@example.com",
+							"level": 1,
+							"policy": "zoom"
+						}
+					},
+					{
+						"address": "127.0.0.1",
+						"port": 8443,
+						"users": [{"id": "reflex"}]
+					},
+					{
+						"address": "example.org",
+						"port": 443,
+						"user": {
+							"id": "27848739-7E62-4138-9FD3-098A63964B6B",
+							"email": "love@example.com"
+						}
+					}
+				],
+				"keepAliveInterval": 30,
+				"resume": true,
+				"padHandshake": true,
+				"helloSplit": {"count": 3, "minDelay": 5, "maxDelay": 20},
+				"pool": {"size": 4, "maxAge": 30},
+				"keyLog": "/tmp/reflex-keys.log"
+			}`,
+			Parser: loadJSON(creator),
+			Output: &outbound.Config{
+				Vnext: []*protocol.ServerEndpoint{
+					{
+						Address: net.NewIPOrDomain(net.DomainAddress("example.com")),
+						Port:    443,
+						User: &protocol.User{
+							Level: 1,
+							Email: "love@example.com",
+							Account: serial.ToTypedMessage(&reflex.Account{
+								Id:     "27848739-7e62-4138-9fd3-098a63964b6b",
+								Policy: "zoom",
+							}),
+						},
+					},
+					{
+						Address: net.NewIPOrDomain(net.LocalHostIP),
+						Port:    8443,
+						User: &protocol.User{
+							Account: serial.ToTypedMessage(&reflex.Account{
+								Id: reflexID.String(),
+							}),
+						},
+					},
+					{
+						Address: net.NewIPOrDomain(net.DomainAddress("example.org")),
+						Port:    443,
+						User: &protocol.User{
+							Email: "love@example.com",
+							Account: serial.ToTypedMessage(&reflex.Account{
+								Id: "27848739-7e62-4138-9fd3-098a63964b6b",
+							}),
+						},
+					},
+				},
+				KeepAliveInterval: 30,
+				Resume:            true,
+				PadHandshake:      true,
+				HelloSplit: &outbound.HelloSplit{
+					Count:    3,
+					MinDelay: 5,
+					MaxDelay: 20,
+				},
+				Pool: &outbound.Pool{
+					Size:   4,
+					MaxAge: 30,
+				},
+				KeyLog: "/tmp/reflex-keys.log",
+			},
+		},
+	})
+}
+
+// TestReflexConfigErrors tests that malformed clients and servers are
+// rejected with an error naming the offending entry
+func TestReflexConfigErrors(t *testing.T) {
+	const id = `"id": "27848739-7e62-4138-9fd3-098a63964b6b"`
+	inbounds := map[string][2]string{
+		`{"clients": [{}]}`: {`clients[0]`, `"id" is required`},
+		`{"clients": [{"id": "not-a-uuid-and-too-long-to-be-mapped-to-one"}]}`:                        {`clients[0]`, `invalid "id"`},
+		`{"clients": [{` + id + `, "policy": "netflix"}]}`:                                            {`clients[0]`, `unknown "policy" netflix`},
+		`{"clients": [{"id": "a", "account": {` + id + `}}]}`:                                         {`clients[0]`, `"account" has "id" 27848739-7e62-4138-9fd3-098a63964b6b, which conflicts with "id" a`},
+		`{"clients": [{` + id + `, "policy": "zoom", "account": {"policy": "youtube"}}]}`:             {`clients[0]`, `"account" has "policy" youtube, which conflicts with "policy" zoom`},
+		`{"clients": [{"account": {` + id + `, "alterId": 0}}]}`:                                      {`clients[0]`, `invalid "account"`},
+		`{"clients": [{` + id + `, "polciy": "zoom"}]}`:                                               {`clients[0]`, `invalid user`},
+		`{"clients": [{` + id + `, "cover": {"rat": 50}}]}`:                                           {`clients[0]`, `invalid user`},
+		`{"clients": [{` + id + `, "expiresAt": "tomorrow"}]}`:                                        {`clients[0]`, `invalid "expiresAt"`},
+		`{"clients": [{` + id + `}, {"id": "27848739-7E62-4138-9FD3-098A63964B6B"}]}`:                 {`clients[1]`, `duplicate "id" 27848739-7e62-4138-9fd3-098a63964b6b, already used by clients[0]`},
+		`{"clients": [{"id": "a", "email": "A@example.com"}, {"id": "b", "email": "a@example.com"}]}`: {`clients[1]`, `duplicate "email" a@example.com, already used by clients[0]`},
+		`{"clients": [], "policyOverrides": [{"policy": "zoom"}, {"policy": ""}]}`:                    {`policyOverrides[1]`, `unknown "policy"`},
+		`{"clients": [], "policyOverrides": [{"policy": "zoom", "from": "22:00"}]}`:                   {`policyOverrides[0]`, `set both "from" and "to"`},
+		`{"clients": [], "policyOverrides": [{"policy": "zoom", "from": "22:00", "to": "6am"}]}`:      {`policyOverrides[0]`, `invalid "to" 6am`},
+		`{"clients": [], "maxClockSkew": 60}`:                                                         {`"maxClockSkew"`, `must exceed "timestampTolerance" 120`},
+		`{"clients": [], "userStore": {"format": "json"}}`:                                            {`userStore`, `"path" is required`},
+		`{"clients": [], "userStore": {"path": "users.txt"}}`:                                         {`userStore`, `unsupported user store format "txt"`},
+		`{"clients": [], "decoy": {}}`:                                                                {`decoy`, `set either "root" or "upstream"`},
+		`{"clients": [], "decoy": {"root": "/var/www", "upstream": "http://127.0.0.1:8080"}}`:         {`decoy`, `set either "root" or "upstream"`},
+		`{"clients": [], "decoy": {"upstream": "127.0.0.1:8080"}}`:                                    {`decoy`, `invalid "upstream" 127.0.0.1:8080`},
+		`{"clients": [], "decoy": {"root": "/var/www", "keyFile": "key.pem"}}`:                        {`decoy`, `set both "certificateFile" and "keyFile"`},
+	}
+	for input, expected := range inbounds {
+		_, err := loadJSON(func() Buildable { return new(ReflexInboundConfig) })(input)
+		if err == nil || !strings.Contains(err.Error(), "Reflex "+expected[0]) || !strings.Contains(err.Error(), expected[1]) {
+			t.Errorf("%s: expected error about %s containing %q, got %v", input, expected[0], expected[1], err)
+		}
+	}
+
+	outbounds := map[string][2]string{
+		`{"vnext": [{"port": 443, "user": {` + id + `}}]}`:                                                                          {`vnext[0]`, `"address" is required`},
+		`{"vnext": [{"address": "example.com", "user": {` + id + `}}]}`:                                                             {`vnext[0]`, `"port" is required`},
+		`{"vnext": [{"address": "example.com", "port": 443}]}`:                                                                      {`vnext[0]`, `"user" is required`},
+		`{"vnext": [{"address": "example.com", "port": 443, "users": [{"id": "a"}, {"id": "b"}]}]}`:                                 {`vnext[0]`, `"users" must have exactly one user`},
+		`{"vnext": [{"address": "example.com", "port": 443, "user": {` + id + `, "quotaBytes": 1}}]}`:                               {`vnext[0]`, `only supported in inbound settings`},
+		`{"vnext": [{"address": "example.com", "port": 443, "user": {"id": "a", "account": {` + id + `}}}]}`:                        {`vnext[0]`, `which conflicts with "id" a`},
+		`{"vnext": [{"address": "example.com", "port": 443, "user": {` + id + `, "policy": "Zoom"}}]}`:                              {`vnext[0]`, `unknown "policy" Zoom`},
+		`{"vnext": [{"address": "a.com", "port": 1, "user": {` + id + `}}, {"address": "A.com", "port": 1, "user": {` + id + `}}]}`: {`vnext[1]`, `duplicate "id"`},
+		`{"vnext": [], "helloSplit": {"count": 2, "minDelay": 20, "maxDelay": 5}}`:                                                  {`helloSplit`, `"maxDelay" 5 is less than "minDelay" 20`},
+	}
+	for input, expected := range outbounds {
+		_, err := loadJSON(func() Buildable { return new(ReflexOutboundConfig) })(input)
+		if err == nil || !strings.Contains(err.Error(), "Reflex "+expected[0]) || !strings.Contains(err.Error(), expected[1]) {
+			t.Errorf("%s: expected error about %s containing %q, got %v", input, expected[0], expected[1], err)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/xtls/xray-core/app/observatory/burst"
 	"github.com/xtls/xray-core/app/router"
+	"github.com/xtls/xray-core/common/errors"
 	"github.com/xtls/xray-core/infra/conf/cfgcommon/duration"
 )
 
@@ -14,6 +15,9 @@ const (
 	strategyLeastPing  string = "leastping"
 	strategyRoundRobin string = "roundrobin"
 	strategyLeastLoad  string = "leastload"
+
+	strategyConsistentHash     string = "consistenthash"
+	strategyWeightedRoundRobin string = "weightedroundrobin"
 )
 
 var (
@@ -22,6 +26,9 @@ var (
 		strategyLeastPing:  func() interface{} { return new(strategyEmptyConfig) },
 		strategyRoundRobin: func() interface{} { return new(strategyEmptyConfig) },
 		strategyLeastLoad:  func() interface{} { return new(strategyLeastLoadConfig) },
+
+		strategyConsistentHash:     func() interface{} { return new(strategyConsistentHashConfig) },
+		strategyWeightedRoundRobin: func() interface{} { return new(strategyWeightedRoundRobinConfig) },
 	}, "type", "settings")
 )
 
@@ -45,6 +52,44 @@ type strategyLeastLoadConfig struct {
 	Tolerance float64 `json:"tolerance,omitempty"`
 }
 
+type strategyConsistentHashConfig struct {
+	// what the outbound is picked by: sourceIP, user, domain or eTLD+1
+	Key string `json:"key,omitempty"`
+}
+
+// Build implements Buildable.
+func (v *strategyConsistentHashConfig) Build() (proto.Message, error) {
+	switch strings.ToLower(v.Key) {
+	case "", "sourceip", "user", "domain", "etld+1":
+	default:
+		return nil, errors.New("unknown consistentHash key: ", v.Key, ", expected sourceIP, user, domain or eTLD+1")
+	}
+	return &router.StrategyConsistentHashConfig{Key: v.Key}, nil
+}
+
+type strategyWeightedRoundRobinConfig struct {
+	// static weights, 1 for the outbounds none matches
+	Weights []*router.StrategyWeight `json:"weights,omitempty"`
+	// scale the weights down by the delay of the outbounds
+	Delay bool `json:"delay,omitempty"`
+	// scale the weights down by the connections the outbounds carry
+	Connections bool `json:"connections,omitempty"`
+}
+
+// Build implements Buildable.
+func (v *strategyWeightedRoundRobinConfig) Build() (proto.Message, error) {
+	for _, w := range v.Weights {
+		if w.Value < 0 {
+			return nil, errors.New("negative weight for ", w.Match)
+		}
+	}
+	return &router.StrategyWeightedRoundRobinConfig{
+		Weights:     v.Weights,
+		Delay:       v.Delay,
+		Connections: v.Connections,
+	}, nil
+}
+
 // healthCheckSettings holds settings for health Checker
 type healthCheckSettings struct {
 	Destination   string            `json:"destination"`
@@ -144,6 +144,36 @@ func TestRouterConfig(t *testing.T) {
 							}
 						},
 						"fallbackTag": "fall"
+					},
+					{
+						"tag": "b3",
+						"selector": ["test"],
+						"strategy": {
+							"type": "consistentHash",
+							"settings": {
+								"key": "eTLD+1"
+							}
+						}
+					},
+					{
+						"tag": "b4",
+						"selector": ["test"],
+						"strategy": {
+							"type": "weightedRoundRobin",
+							"settings": {
+								"weights": [
+									{
+										"regexp": true,
+										"match": "\\d+M$"
+									},
+									{
+										"match": "direct",
+										"value": 0.5
+									}
+								],
+								"connections": true
+							}
+						}
 					}
 				]
 			}`,
@@ -179,6 +209,32 @@ func TestRouterConfig(t *testing.T) {
 						}),
 						FallbackTag: "fall",
 					},
+					{
+						Tag:              "b3",
+						OutboundSelector: []string{"test"},
+						Strategy:         "consistenthash",
+						StrategySettings: serial.ToTypedMessage(&router.StrategyConsistentHashConfig{
+							Key: "eTLD+1",
+						}),
+					},
+					{
+						Tag:              "b4",
+						OutboundSelector: []string{"test"},
+						Strategy:         "weightedroundrobin",
+						StrategySettings: serial.ToTypedMessage(&router.StrategyWeightedRoundRobinConfig{
+							Weights: []*router.StrategyWeight{
+								{
+									Regexp: true,
+									Match:  "\\d+M$",
+								},
+								{
+									Match: "direct",
+									Value: 0.5,
+								},
+							},
+							Connections: true,
+						}),
+					},
 				},
 				Rule: []*router.RoutingRule{
 					{
@@ -4,6 +4,7 @@ import (
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
 	"math"
 	"net/url"
 	"runtime"
@@ -342,6 +343,26 @@ func readFileOrString(f string, s []string) ([]byte, error) {
 	return nil, errors.New("both file and bytes are empty.")
 }
 
+// readECHFile reads ECH configs or keys from a file written by "xray tls ech",
+// either as a PEM block of the given type or as plain base64.
+func readECHFile(f string, pemType string) ([]byte, error) {
+	content, err := filesystem.ReadCert(f)
+	if err != nil {
+		return nil, err
+	}
+	for rest := content; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type == pemType {
+			return block.Bytes, nil
+		}
+	}
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(string(content)))
+}
+
 type TLSCertConfig struct {
 	CertFile       string   `json:"certificateFile"`
 	CertStr        []string `json:"certificate"`
@@ -414,6 +435,8 @@ type TLSConfig struct {
 	VerifyPeerCertInNames                []string         `json:"verifyPeerCertInNames"`
 	ECHServerKeys                        string           `json:"echServerKeys"`
 	ECHConfigList                        string           `json:"echConfigList"`
+	ECHConfigListFile                    string           `json:"echConfigListFile"`
+	ECHServerKeysFile                    string           `json:"echServerKeysFile"`
 	ECHForceQuery                        string           `json:"echForceQuery"`
 	ECHSocketSettings                    *SocketConfig    `json:"echSockopt"`
 }
@@ -487,6 +510,9 @@ func (c *TLSConfig) Build() (proto.Message, error) {
 	}
 	config.VerifyPeerCertInNames = c.VerifyPeerCertInNames
 
+	if c.ECHServerKeys != "" && c.ECHServerKeysFile != "" {
+		return nil, errors.New(`only one of "echServerKeys" and "echServerKeysFile" can be set`)
+	}
 	if c.ECHServerKeys != "" {
 		EchPrivateKey, err := base64.StdEncoding.DecodeString(c.ECHServerKeys)
 		if err != nil {
@@ -494,6 +520,13 @@ func (c *TLSConfig) Build() (proto.Message, error) {
 		}
 		config.EchServerKeys = EchPrivateKey
 	}
+	if c.ECHServerKeysFile != "" {
+		EchPrivateKey, err := readECHFile(c.ECHServerKeysFile, "ECH KEYS")
+		if err != nil {
+			return nil, errors.New("failed to read ECH server keys from ", c.ECHServerKeysFile).Base(err)
+		}
+		config.EchServerKeys = EchPrivateKey
+	}
 	switch c.ECHForceQuery {
 	case "none", "half", "full", "":
 		config.EchForceQuery = c.ECHForceQuery
@@ -501,7 +534,17 @@ func (c *TLSConfig) Build() (proto.Message, error) {
 		return nil, errors.New(`invalid "echForceQuery": `, c.ECHForceQuery)
 	}
 	config.EchForceQuery = c.ECHForceQuery
+	if c.ECHConfigList != "" && c.ECHConfigListFile != "" {
+		return nil, errors.New(`only one of "echConfigList" and "echConfigListFile" can be set`)
+	}
 	config.EchConfigList = c.ECHConfigList
+	if c.ECHConfigListFile != "" {
+		ECHConfig, err := readECHFile(c.ECHConfigListFile, "ECH CONFIGS")
+		if err != nil {
+			return nil, errors.New("failed to read ECH config list from ", c.ECHConfigListFile).Base(err)
+		}
+		config.EchConfigList = base64.StdEncoding.EncodeToString(ECHConfig)
+	}
 	if c.ECHSocketSettings != nil {
 		ss, err := c.ECHSocketSettings.Build()
 		if err != nil {
@@ -1,11 +1,17 @@
 package conf_test
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/xtls/xray-core/common"
 	. "github.com/xtls/xray-core/infra/conf"
 	"github.com/xtls/xray-core/transport/internet"
+	"github.com/xtls/xray-core/transport/internet/tls"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -156,3 +162,46 @@ func TestSocketConfig(t *testing.T) {
 		t.Fatalf("unexpected parsed TFO value, which should be -1")
 	}
 }
+
+func TestTLSConfigECHFiles(t *testing.T) {
+	configList := []byte{0x00, 0x03, 0xfe, 0x0d, 0x00}
+	serverKeys := []byte{0x00, 0x01, 0x2a, 0x00, 0x01, 0x2b}
+
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "ech-configs.pem")
+	keysFile := filepath.Join(dir, "ech-keys.txt")
+	common.Must(os.WriteFile(configFile, pem.EncodeToMemory(&pem.Block{Type: "ECH CONFIGS", Bytes: configList}), 0o600))
+	common.Must(os.WriteFile(keysFile, []byte(base64.StdEncoding.EncodeToString(serverKeys)+"\n"), 0o600))
+
+	createParser := func() func(string) (proto.Message, error) {
+		return func(s string) (proto.Message, error) {
+			config := new(TLSConfig)
+			if err := json.Unmarshal([]byte(s), config); err != nil {
+				return nil, err
+			}
+			return config.Build()
+		}
+	}
+	input, _ := json.Marshal(map[string]string{
+		"echConfigListFile": configFile,
+		"echServerKeysFile": keysFile,
+	})
+	runMultiTestCase(t, []TestCase{
+		{
+			Input:  string(input),
+			Parser: createParser(),
+			Output: &tls.Config{
+				EchConfigList: base64.StdEncoding.EncodeToString(configList),
+				EchServerKeys: serverKeys,
+			},
+		},
+	})
+
+	both, _ := json.Marshal(map[string]string{
+		"echConfigList":     base64.StdEncoding.EncodeToString(configList),
+		"echConfigListFile": configFile,
+	})
+	if _, err := createParser()(string(both)); err == nil {
+		t.Fatal("expected error when both echConfigList and echConfigListFile are set")
+	}
+}
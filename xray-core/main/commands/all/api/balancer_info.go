@@ -3,6 +3,8 @@ package api
 import (
 	"fmt"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 
 	routerService "github.com/xtls/xray-core/app/router/command"
@@ -15,7 +17,8 @@ var cmdBalancerInfo = &base.Command{
 	UsageLine:   "{{.Exec}} api bi [--server=127.0.0.1:8080] [balancer]...",
 	Short:       "Retrieve balancer information",
 	Long: `
-Retrieve information of specified balancers, including health, strategy and selecting.
+Retrieve information of specified balancers, including health, strategy and selecting,
+and the effective weights of the outbounds for weighted strategies.
 If no balancer tag specified, information for all balancers is returned.
 
 > Ensure that "RoutingService" is enabled under "config.api.services" in the server configuration.
@@ -79,6 +82,19 @@ func showBalancerInfo(b *routerService.BalancerMsg) {
 			writeRow(sb, tableIndent, i+1, []string{o}, nil)
 		}
 	}
+	// Weights
+	if len(b.Weights) > 0 {
+		sb.WriteString("  - Weights:\n")
+		tags := make([]string, 0, len(b.Weights))
+		for tag := range b.Weights {
+			tags = append(tags, tag)
+		}
+		sort.Strings(tags)
+		for i, tag := range tags {
+			weight := strconv.FormatFloat(b.Weights[tag], 'g', 4, 64)
+			writeRow(sb, tableIndent, i+1, []string{tag, weight}, nil)
+		}
+	}
 	os.Stdout.WriteString(sb.String())
 }
 
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"github.com/xtls/xray-core/main/commands/base"
+	reflexService "github.com/xtls/xray-core/proxy/reflex/command"
+)
+
+var cmdReflexBans = &base.Command{
+	CustomFlags: true,
+	UsageLine:   "{{.Exec}} api rfb [--server=127.0.0.1:8080] -tag=reflex [-clear] [1.2.3.4] [1.2.3.0/24]...",
+	Short:       "List or clear Reflex handshake bans",
+	Long: `
+List the sources banned by a Reflex inbound after repeated failed
+handshakes, or lift their bans.
+
+Arguments:
+
+	-s, -server <server:port>
+		The API server address. Default 127.0.0.1:8080
+
+	-t, -timeout <seconds>
+		Timeout in seconds for calling API. Default 3
+
+	-tag
+		Reflex inbound tag
+
+	-clear
+		Lift the bans of the given IPs and subnets, or all bans if none
+		are given. Default false
+
+Example:
+
+	{{.Exec}} {{.LongName}} --server=127.0.0.1:8080 -tag=reflex
+	{{.Exec}} {{.LongName}} --server=127.0.0.1:8080 -tag=reflex -clear 1.2.3.4 1.2.3.0/24
+	{{.Exec}} {{.LongName}} --server=127.0.0.1:8080 -tag=reflex -clear
+`,
+	Run: executeReflexBans,
+}
+
+func executeReflexBans(cmd *base.Command, args []string) {
+	var (
+		tag   string
+		clear bool
+	)
+	setSharedFlags(cmd)
+	cmd.Flag.StringVar(&tag, "tag", "", "")
+	cmd.Flag.BoolVar(&clear, "clear", false, "")
+	cmd.Flag.Parse(args)
+
+	conn, ctx, close := dialAPIServer()
+	defer close()
+
+	client := reflexService.NewReflexServiceClient(conn)
+	if clear {
+		resp, err := client.ClearBans(ctx, &reflexService.ClearBansRequest{
+			Tag:     tag,
+			Sources: cmd.Flag.Args(),
+		})
+		if err != nil {
+			base.Fatalf("failed to clear bans: %s", err)
+		}
+		showJSONResponse(resp)
+		return
+	}
+	resp, err := client.GetBans(ctx, &reflexService.GetBansRequest{
+		Tag: tag,
+	})
+	if err != nil {
+		base.Fatalf("failed to get bans: %s", err)
+	}
+	showJSONResponse(resp)
+}
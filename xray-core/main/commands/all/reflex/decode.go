@@ -0,0 +1,157 @@
+package reflex
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/xtls/xray-core/common/uuid"
+	"github.com/xtls/xray-core/main/commands/base"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/capture"
+	"github.com/xtls/xray-core/proxy/reflex/encoding"
+)
+
+var cmdDecode = &base.Command{
+	CustomFlags: true,
+	UsageLine:   "{{.Exec}} reflex decode [-keylog file] [-preview n] <capture>",
+	Short:       "Decrypt the Reflex connections of a packet capture",
+	Long: `
+Decrypt the Reflex connections of a packet capture with the session keys of
+a key log, and print the frames of each: their direction, type, payload
+length, padding and the first bytes of their payload.
+
+The key log is written by a Reflex inbound or outbound with "keyLog" set.
+The capture is a pcap or pcapng file, as written by tcpdump -w or Wireshark.
+Only the RAW transport without TLS can be decrypted.
+
+Arguments:
+
+	-keylog <file>
+		The key log. Required.
+
+	-preview <n>
+		The number of payload bytes shown for each frame. Default 32
+
+Example:
+
+	tcpdump -i any -w reflex.pcap tcp port 443
+	{{.Exec}} {{.LongName}} -keylog /tmp/reflex-keys.log reflex.pcap
+`,
+	Run: executeDecode,
+}
+
+// frameTypeNames are the names of the frame types in the output
+var frameTypeNames = map[byte]string{
+	encoding.FrameTypeData:    "DATA",
+	encoding.FrameTypePadding: "PADDING",
+	encoding.FrameTypeTiming:  "TIMING",
+	encoding.FrameTypeClose:   "CLOSE",
+	encoding.FrameTypePing:    "PING",
+	encoding.FrameTypePong:    "PONG",
+	encoding.FrameTypeAck:     "ACK",
+	encoding.FrameTypeResume:  "RESUME",
+	encoding.FrameTypePolicy:  "POLICY",
+}
+
+func executeDecode(cmd *base.Command, args []string) {
+	var keyLogPath string
+	var preview int
+	cmd.Flag.StringVar(&keyLogPath, "keylog", "", "")
+	cmd.Flag.IntVar(&preview, "preview", 32, "")
+	cmd.Flag.Parse(args)
+
+	if keyLogPath == "" {
+		base.Fatalf("-keylog not specified")
+	}
+	if cmd.Flag.NArg() < 1 {
+		base.Fatalf("capture not specified")
+	}
+
+	keyLog, err := os.Open(keyLogPath)
+	if err != nil {
+		base.Fatalf("%s", err)
+	}
+	sessions, err := reflex.ReadKeyLog(keyLog)
+	keyLog.Close()
+	if err != nil {
+		base.Fatalf("%s: %s", keyLogPath, err)
+	}
+	keys, err := capture.NewKeys(sessions)
+	if err != nil {
+		base.Fatalf("%s", err)
+	}
+
+	file, err := os.Open(cmd.Flag.Arg(0))
+	if err != nil {
+		base.Fatalf("%s", err)
+	}
+	segments, err := capture.ReadSegments(file)
+	file.Close()
+	if err != nil {
+		// Print what was read up to a truncated end, as left by an
+		// interrupted capture
+		fmt.Fprintln(os.Stderr, "warning:", err)
+	}
+
+	conns := capture.Assemble(segments)
+	var found, decrypted int
+	for _, c := range conns {
+		s, err := capture.Decode(c, keys)
+		if errors.Is(err, capture.ErrNotReflex) {
+			continue
+		}
+		found++
+		fmt.Printf("%s %s -> %s\n", c.Start.Format("2006-01-02 15:04:05.000000"), c.Client, c.Server)
+		if s == nil {
+			fmt.Printf("  %s\n\n", err)
+			continue
+		}
+		fmt.Printf("  Reflex %s", s.Kind)
+		if s.Kind != capture.KindResume {
+			id := uuid.UUID(s.UserID)
+			fmt.Printf(", user %s", id.String())
+		}
+		fmt.Printf(", hello at %s\n", s.Timestamp.Format("15:04:05"))
+		if err != nil {
+			fmt.Printf("  %s\n\n", err)
+			continue
+		}
+		decrypted++
+		for _, f := range s.Frames {
+			printFrame(f, preview)
+		}
+		if s.ClientErr != nil {
+			fmt.Printf("  client frames stop: %s\n", s.ClientErr)
+		}
+		if s.ServerErr != nil {
+			fmt.Printf("  server frames stop: %s\n", s.ServerErr)
+		}
+		fmt.Println()
+	}
+	fmt.Printf("%d TCP connections, %d Reflex, %d decrypted\n", len(conns), found, decrypted)
+}
+
+func printFrame(f *capture.Frame, preview int) {
+	direction := "<-"
+	if f.FromClient {
+		direction = "->"
+	}
+	name, ok := frameTypeNames[f.Type]
+	if !ok {
+		name = fmt.Sprintf("0x%02x", f.Type)
+	}
+	line := fmt.Sprintf("  %s %s %-7s length %-5d padding %-5d", f.Time.Format("15:04:05.000000"), direction, name, len(f.Payload), f.Padding)
+	if preview > 0 && len(f.Payload) > 0 {
+		payload := f.Payload
+		if len(payload) > preview {
+			payload = payload[:preview]
+		}
+		line += " " + strconv.QuoteToASCII(string(payload))
+		if len(f.Payload) > preview {
+			line += "..."
+		}
+	}
+	fmt.Println(line)
+}
@@ -0,0 +1,71 @@
+package reflex
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/xtls/xray-core/main/commands/base"
+	"github.com/xtls/xray-core/proxy/reflex/sharelink"
+)
+
+var cmdImport = &base.Command{
+	CustomFlags: true,
+	UsageLine:   "{{.Exec}} reflex import [-tag proxy] [reflex://...]",
+	Short:       "Convert a reflex:// share link to an outbound",
+	Long: `
+Convert a reflex:// share link to a complete Reflex outbound JSON, ready to
+be put into the "outbounds" of a client config. Links are read from stdin,
+one per line, if none is given.
+
+Arguments:
+
+	-tag <tag>
+		The tag of the outbound. Default proxy
+
+Example:
+
+	{{.Exec}} {{.LongName}} "reflex://27848739-7e62-4138-9fd3-098a63964b6b@example.com:443?security=tls#me"
+	{{.Exec}} reflex link -host example.com | {{.Exec}} {{.LongName}}
+`,
+	Run: executeImport,
+}
+
+func executeImport(cmd *base.Command, args []string) {
+	var tag string
+	cmd.Flag.StringVar(&tag, "tag", "proxy", "")
+	cmd.Flag.Parse(args)
+
+	links := cmd.Flag.Args()
+	if len(links) == 0 {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				links = append(links, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			base.Fatalf("failed to read links: %s", err)
+		}
+	}
+	if len(links) == 0 {
+		base.Fatalf("no link given")
+	}
+
+	for i, s := range links {
+		link, err := sharelink.Parse(s)
+		if err != nil {
+			base.Fatalf("%s", err)
+		}
+		outboundTag := tag
+		if len(links) > 1 {
+			outboundTag = fmt.Sprintf("%s-%d", tag, i+1)
+		}
+		out, err := link.OutboundJSON(outboundTag)
+		if err != nil {
+			base.Fatalf("%s", err)
+		}
+		fmt.Println(string(out))
+	}
+}
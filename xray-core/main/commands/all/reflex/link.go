@@ -0,0 +1,85 @@
+package reflex
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xtls/xray-core/infra/conf"
+	"github.com/xtls/xray-core/infra/conf/serial"
+	"github.com/xtls/xray-core/main/commands/base"
+	"github.com/xtls/xray-core/main/confloader"
+)
+
+var cmdLink = &base.Command{
+	CustomFlags: true,
+	UsageLine:   "{{.Exec}} reflex link [-c config.json] [-tag tag] -host example.com",
+	Short:       "Generate reflex:// share links from a server config",
+	Long: `
+Generate a reflex:// share link for each client of the Reflex inbounds in a
+JSON server config. The port, policy and stream settings (transport, TLS,
+REALITY, ECH) are taken from the inbound.
+
+Link format:
+
+	reflex://uuid@host:port?policy=...&type=...&security=...&sni=...&pbk=...#name
+
+Arguments:
+
+	-c, -config <file>
+		The server config. Default config.json
+
+	-tag <tag>
+		Only generate links for the inbound with this tag.
+
+	-host <address>
+		The public address clients connect to.
+
+Example:
+
+	{{.Exec}} {{.LongName}} -c server.json -host example.com
+`,
+	Run: executeLink,
+}
+
+func executeLink(cmd *base.Command, args []string) {
+	var (
+		configFile string
+		tag        string
+		host       string
+	)
+	cmd.Flag.StringVar(&configFile, "c", "config.json", "")
+	cmd.Flag.StringVar(&configFile, "config", "config.json", "")
+	cmd.Flag.StringVar(&tag, "tag", "", "")
+	cmd.Flag.StringVar(&host, "host", "", "")
+	cmd.Flag.Parse(args)
+
+	if host == "" {
+		base.Fatalf("-host not specified")
+	}
+	reader, err := confloader.LoadConfig(configFile)
+	if err != nil {
+		base.Fatalf("failed to load config: %s", err)
+	}
+	config, err := serial.DecodeJSONConfig(reader)
+	if err != nil {
+		base.Fatalf("failed to decode config: %s", err)
+	}
+
+	found := false
+	for _, in := range config.InboundConfigs {
+		if !strings.EqualFold(in.Protocol, "reflex") || (tag != "" && in.Tag != tag) {
+			continue
+		}
+		found = true
+		links, err := conf.ReflexShareLinks(&in, host)
+		if err != nil {
+			base.Fatalf("inbound %s: %s", in.Tag, err)
+		}
+		for _, link := range links {
+			fmt.Println(link)
+		}
+	}
+	if !found {
+		base.Fatalf("no Reflex inbound found in %s", configFile)
+	}
+}
@@ -0,0 +1,298 @@
+package reflex
+
+import (
+	"crypto/rand"
+	gotls "crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xtls/xray-core/common/protocol"
+	"github.com/xtls/xray-core/common/uuid"
+	"github.com/xtls/xray-core/main/commands/base"
+	"github.com/xtls/xray-core/proxy/reflex/encoding"
+	"github.com/xtls/xray-core/proxy/reflex/sharelink"
+)
+
+var cmdPing = &base.Command{
+	CustomFlags: true,
+	UsageLine:   "{{.Exec}} reflex ping [-id uuid] [-policy name] [-pad] [-tls] [-sni name] <host:port | reflex://...>",
+	Short:       "Check a Reflex server with a handshake",
+	Long: `
+Perform a Reflex handshake with a server and report the TCP round trip time,
+the handshake round trip time, the offset of the server's clock and the
+profile the server grants. A server that does not accept the handshake
+hands the connection to its fallback; the first bytes of the fallback's
+answer are shown then.
+
+The command exits with a non-zero status if the handshake fails, so that it
+can be used as a health check. Only the RAW transport is supported, with or
+without TLS.
+
+Arguments:
+
+	-id <uuid>
+		The user ID. Required unless a reflex:// link is given.
+
+	-policy <name>
+		The profile to ask the server for. Default from the link, or none.
+
+	-pad
+		Send a padded hello.
+
+	-tls
+		Connect with TLS. Implied by a link with security=tls.
+
+	-sni <name>
+		The TLS server name. Default from the link, or the host.
+
+	-insecure
+		Do not verify the TLS certificate of the server.
+
+	-timeout <duration>
+		How long to wait for each step. Default 5s
+
+Example:
+
+	{{.Exec}} {{.LongName}} -id 27848739-7e62-4138-9fd3-098a63964b6b example.com:443
+	{{.Exec}} {{.LongName}} "reflex://27848739-7e62-4138-9fd3-098a63964b6b@example.com:443?security=tls#me"
+`,
+	Run: executePing,
+}
+
+// fallbackPreview is how many bytes of a non-Reflex answer are shown
+const fallbackPreview = 64
+
+// pingTarget is the server and user a ping handshakes with
+type pingTarget struct {
+	address  string
+	id       string
+	policy   string
+	pad      bool
+	tls      bool
+	sni      string
+	alpn     []string
+	insecure bool
+	timeout  time.Duration
+}
+
+func executePing(cmd *base.Command, args []string) {
+	var t pingTarget
+	cmd.Flag.StringVar(&t.id, "id", "", "")
+	cmd.Flag.StringVar(&t.policy, "policy", "", "")
+	cmd.Flag.BoolVar(&t.pad, "pad", false, "")
+	cmd.Flag.BoolVar(&t.tls, "tls", false, "")
+	cmd.Flag.StringVar(&t.sni, "sni", "", "")
+	cmd.Flag.BoolVar(&t.insecure, "insecure", false, "")
+	cmd.Flag.DurationVar(&t.timeout, "timeout", 5*time.Second, "")
+	cmd.Flag.Parse(args)
+
+	if cmd.Flag.NArg() < 1 {
+		base.Fatalf("server not specified")
+	}
+	server := cmd.Flag.Arg(0)
+	if strings.HasPrefix(strings.ToLower(server), sharelink.Scheme+"://") {
+		link, err := sharelink.Parse(server)
+		if err != nil {
+			base.Fatalf("%s", err)
+		}
+		if link.Network != "" && link.Network != "tcp" && link.Network != "raw" {
+			base.Fatalf("transport %s is not supported, only RAW", link.Network)
+		}
+		switch link.Security {
+		case "tls":
+			t.tls = true
+		case "reality":
+			base.Fatalf("REALITY is not supported")
+		}
+		server = net.JoinHostPort(link.Address, strconv.Itoa(int(link.Port)))
+		if t.id == "" {
+			t.id = link.ID
+		}
+		if t.policy == "" {
+			t.policy = link.Policy
+		}
+		if t.sni == "" {
+			t.sni = link.SNI
+		}
+		if link.ALPN != "" {
+			t.alpn = strings.Split(link.ALPN, ",")
+		}
+	}
+	if t.id == "" {
+		base.Fatalf("-id not specified")
+	}
+	if !encoding.IsProfileName(t.policy) {
+		base.Fatalf("unknown policy %s, expected one of %s", t.policy, strings.Join(encoding.ProfileNames, ", "))
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "443")
+	}
+	t.address = server
+
+	fmt.Println("Reflex ping:", t.address)
+	if err := ping(&t); err != nil {
+		base.Fatalf("%s", err)
+	}
+}
+
+// capturingReader records the first bytes read through it, to show what
+// answered if it was not a Reflex server.
+type capturingReader struct {
+	reader   io.Reader
+	captured []byte
+}
+
+func (r *capturingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if room := fallbackPreview - len(r.captured); room > 0 {
+		r.captured = append(r.captured, p[:min(n, room)]...)
+	}
+	return n, err
+}
+
+// fellBack returns the error of a server that answered the hello with
+// something else than a handshake response.
+func (r *capturingReader) fellBack(err error) error {
+	if len(r.captured) == 0 && errors.Is(err, os.ErrDeadlineExceeded) {
+		return fmt.Errorf("no answer from the server, it may have handed the connection to a fallback that waits for a request: %w", err)
+	}
+	if len(r.captured) == 0 {
+		return fmt.Errorf("server closed the connection without answering, the hello may have been rejected for its timestamp: %w", err)
+	}
+	return fmt.Errorf("server fell back, first bytes of its answer: %q", r.captured)
+}
+
+func ping(t *pingTarget) error {
+	id, err := uuid.ParseString(t.id)
+	if err != nil {
+		return fmt.Errorf("invalid user ID %s: %w", t.id, err)
+	}
+
+	start := time.Now()
+	tcpConn, err := net.DialTimeout("tcp", t.address, t.timeout)
+	if err != nil {
+		return fmt.Errorf("failed to dial: %w", err)
+	}
+	defer tcpConn.Close()
+	fmt.Println("Connected to:", tcpConn.RemoteAddr())
+	fmt.Println("TCP RTT:", time.Since(start).Round(time.Microsecond))
+
+	var conn net.Conn = tcpConn
+	if t.tls {
+		host, _, _ := net.SplitHostPort(t.address)
+		sni := t.sni
+		if sni == "" {
+			sni = host
+		}
+		tlsConn := gotls.Client(tcpConn, &gotls.Config{
+			ServerName:         sni,
+			NextProtos:         t.alpn,
+			InsecureSkipVerify: t.insecure,
+		})
+		tlsConn.SetDeadline(time.Now().Add(t.timeout))
+		start := time.Now()
+		if err := tlsConn.Handshake(); err != nil {
+			return fmt.Errorf("TLS handshake failed: %w", err)
+		}
+		state := tlsConn.ConnectionState()
+		fmt.Println("TLS handshake:", time.Since(start).Round(time.Microsecond), gotls.VersionName(state.Version), gotls.CipherSuiteName(state.CipherSuite), state.NegotiatedProtocol)
+		conn = tlsConn
+	}
+
+	privateKey, publicKey, err := encoding.GenerateKeyPair()
+	if err != nil {
+		return err
+	}
+	hello := &encoding.ClientHandshake{
+		PublicKey: publicKey,
+		UserID:    encoding.UUIDToBytes(protocol.NewID(id)),
+		Timestamp: time.Now().Unix(),
+	}
+	if _, err := rand.Read(hello.Nonce[:]); err != nil {
+		return err
+	}
+	var helloData []byte
+	if t.pad {
+		padding := encoding.HandshakePadding(encoding.GetProfileByName(t.policy), encoding.PaddedClientHandshakeHeaderSize)
+		if helloData, err = encoding.EncodePaddedClientHandshake(hello, padding); err != nil {
+			return err
+		}
+	} else {
+		helloData = encoding.EncodeClientHandshake(hello)
+		defer encoding.PutClientHandshakeBuffer(helloData)
+	}
+
+	conn.SetDeadline(time.Now().Add(t.timeout))
+	sent := time.Now()
+	if _, err := conn.Write(helloData); err != nil {
+		return fmt.Errorf("failed to send hello: %w", err)
+	}
+	reader := &capturingReader{reader: conn}
+	var response *encoding.ServerHandshake
+	salt := []byte("reflex-session-v1")
+	if t.pad {
+		var responseData []byte
+		if response, responseData, err = encoding.ReadPaddedServerHandshake(reader); err != nil {
+			return reader.fellBack(err)
+		}
+		salt = encoding.PaddedSessionSalt(helloData, responseData)
+	} else {
+		responseData := make([]byte, encoding.ServerHandshakeSize)
+		if _, err := io.ReadFull(reader, responseData); err != nil {
+			return reader.fellBack(err)
+		}
+		if response, err = encoding.DecodeServerHandshake(responseData); err != nil {
+			return reader.fellBack(err)
+		}
+	}
+	received := time.Now()
+
+	// Only a response followed by a frame sealed with the session key comes
+	// from the server: ask for a profile and wait for the grant
+	sessionKey, err := encoding.DeriveSessionKey(encoding.DeriveSharedKey(privateKey, response.PublicKey), salt)
+	if err != nil {
+		return err
+	}
+	encoder, err := encoding.NewFrameEncoder(sessionKey)
+	if err != nil {
+		return err
+	}
+	decoder, err := encoding.NewFrameDecoder(sessionKey)
+	if err != nil {
+		return err
+	}
+	if err := encoder.WriteFrame(conn, encoding.NewPolicyRequestFrame(t.policy)); err != nil {
+		return fmt.Errorf("failed to send policy request: %w", err)
+	}
+	var granted *encoding.TrafficProfile
+	for granted == nil {
+		frame, err := decoder.ReadFrame(reader)
+		if err != nil {
+			return reader.fellBack(err)
+		}
+		if frame.Type == encoding.FrameTypePolicy {
+			if granted, err = encoding.DecodePolicyGrant(frame); err != nil {
+				return fmt.Errorf("invalid policy grant: %w", err)
+			}
+		}
+	}
+
+	var clock encoding.Clock
+	clock.Learn(response.Timestamp, sent, received)
+	fmt.Println("Handshake RTT:", received.Sub(sent).Round(time.Microsecond))
+	fmt.Printf("Server clock offset: %+ds (±1s)\n", int64(clock.Offset()/time.Second))
+	fmt.Println("Cipher: X25519, HKDF-SHA256, ChaCha20-Poly1305")
+	requested := t.policy
+	if requested == "" {
+		requested = "default"
+	}
+	fmt.Printf("Profile: %s (requested %s)\n", encoding.ProfileKey(granted), requested)
+	fmt.Println("Handshake OK")
+	return nil
+}
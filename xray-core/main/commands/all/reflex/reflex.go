@@ -0,0 +1,19 @@
+package reflex
+
+import (
+	"github.com/xtls/xray-core/main/commands/base"
+)
+
+// CmdReflex holds all Reflex sub commands
+var CmdReflex = &base.Command{
+	UsageLine: "{{.Exec}} reflex",
+	Short:     "Reflex tools",
+	Long: `{{.Exec}} {{.LongName}} provides tools for the Reflex protocol.
+`,
+	Commands: []*base.Command{
+		cmdLink,
+		cmdImport,
+		cmdPing,
+		cmdDecode,
+	},
+}
@@ -13,6 +13,7 @@ import (
 	_ "github.com/xtls/xray-core/app/log/command"
 	_ "github.com/xtls/xray-core/app/proxyman/command"
 	_ "github.com/xtls/xray-core/app/stats/command"
+	_ "github.com/xtls/xray-core/proxy/reflex/command"
 
 	// Developer preview services
 	_ "github.com/xtls/xray-core/app/observatory/command"
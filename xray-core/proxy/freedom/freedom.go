@@ -206,6 +206,14 @@ func (h *Handler) Process(ctx context.Context, link *transport.Link, dialer inte
 			return errors.New("failed to process request").Base(err)
 		}
 
+		// The request stream was closed gracefully, pass the half-close on to
+		// the target if the inbound says the client half-closed
+		if destination.Network == net.Network_TCP && session.HalfCloseFromContext(ctx) {
+			if cw, ok := stat.TryUnwrapStatsConn(conn).(interface{ CloseWrite() error }); ok {
+				cw.CloseWrite()
+			}
+		}
+
 		return nil
 	}
 
@@ -0,0 +1,130 @@
+package freedom_test
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/common/buf"
+	"github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/features/policy"
+	. "github.com/xtls/xray-core/proxy/freedom"
+	"github.com/xtls/xray-core/transport"
+	"github.com/xtls/xray-core/transport/internet/stat"
+	"github.com/xtls/xray-core/transport/pipe"
+)
+
+// tcpDialer dials the system TCP connections of the handler
+type tcpDialer struct{}
+
+func (tcpDialer) Dial(ctx context.Context, dest net.Destination) (stat.Connection, error) {
+	return net.Dial("tcp", dest.NetAddr())
+}
+
+func (tcpDialer) DestIpAddress() net.IP {
+	return nil
+}
+
+func (tcpDialer) SetOutboundGateway(ctx context.Context, ob *session.Outbound) {}
+
+// TestHalfClose tests that the end of the request half-closes the
+// connection to the target, which can still answer, when the inbound says
+// the client half-closed
+func TestHalfClose(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	common.Must(err)
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// Answer only once the request has been read to its end
+		request, _ := io.ReadAll(conn)
+		conn.Write(append([]byte("got "), request...))
+	}()
+
+	handler := new(Handler)
+	common.Must(handler.Init(&Config{}, policy.DefaultManager{}))
+	uplinkReader, uplinkWriter := pipe.New()
+	downlinkReader, downlinkWriter := pipe.New()
+	ctx := session.ContextWithOutbounds(context.Background(), []*session.Outbound{{
+		Target: net.DestinationFromAddr(listener.Addr()),
+	}})
+	ctx = session.ContextWithHalfClose(ctx, true)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- handler.Process(ctx, &transport.Link{Reader: uplinkReader, Writer: downlinkWriter}, tcpDialer{})
+	}()
+
+	common.Must(uplinkWriter.WriteMultiBuffer(buf.MergeBytes(nil, []byte("request"))))
+	common.Must(uplinkWriter.Close())
+
+	response := make(chan string, 1)
+	go func() {
+		var mb buf.MultiBuffer
+		for {
+			b, err := downlinkReader.ReadMultiBuffer()
+			mb = append(mb, b...)
+			if err != nil {
+				break
+			}
+		}
+		response <- mb.String()
+	}()
+	select {
+	case got := <-response:
+		if got != "got request" {
+			t.Errorf("unexpected response %q", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the target did not see the end of the request")
+	}
+	if err := <-errs; err != nil {
+		t.Error(err)
+	}
+}
+
+// TestNoHalfClose tests that the connection to the target stays open for
+// writing after the end of the request of other inbounds
+func TestNoHalfClose(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	common.Must(err)
+	defer listener.Close()
+	halfClosed := make(chan bool, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.ReadFull(conn, make([]byte, len("request")))
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		_, err = conn.Read(make([]byte, 1))
+		halfClosed <- err == io.EOF
+	}()
+
+	handler := new(Handler)
+	common.Must(handler.Init(&Config{}, policy.DefaultManager{}))
+	uplinkReader, uplinkWriter := pipe.New()
+	_, downlinkWriter := pipe.New()
+	ctx := session.ContextWithOutbounds(context.Background(), []*session.Outbound{{
+		Target: net.DestinationFromAddr(listener.Addr()),
+	}})
+	go handler.Process(ctx, &transport.Link{Reader: uplinkReader, Writer: downlinkWriter}, tcpDialer{})
+
+	common.Must(uplinkWriter.WriteMultiBuffer(buf.MergeBytes(nil, []byte("request"))))
+	common.Must(uplinkWriter.Close())
+	select {
+	case closed := <-halfClosed:
+		if closed {
+			t.Error("the connection to the target was half-closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the target did not get the request")
+	}
+}
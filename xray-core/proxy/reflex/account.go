@@ -0,0 +1,83 @@
+package reflex
+
+import (
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/xtls/xray-core/common/errors"
+	"github.com/xtls/xray-core/common/protocol"
+	"github.com/xtls/xray-core/common/uuid"
+	"github.com/xtls/xray-core/proxy/reflex/encoding"
+)
+
+// AsAccount implements protocol.Account.AsAccount().
+func (a *Account) AsAccount() (protocol.Account, error) {
+	id, err := uuid.ParseString(a.Id)
+	if err != nil {
+		return nil, errors.New("failed to parse ID: ", err)
+	}
+	return &MemoryAccount{
+		ID:               protocol.NewID(id),
+		Policy:           a.Policy,
+		ExpiresAt:        a.ExpiresAt,
+		QuotaBytes:       a.QuotaBytes,
+		MaxConcurrentIPs: a.MaxConcurrentIps,
+		Cover:            a.Cover,
+	}, nil
+}
+
+// MemoryAccount is an in-memory form of Reflex account.
+type MemoryAccount struct {
+	ID     *protocol.ID
+	Policy string
+
+	// ExpiresAt is the Unix time after which the account is rejected. 0 means never.
+	ExpiresAt int64
+	// QuotaBytes is the total traffic allowed to the account. 0 means unlimited.
+	QuotaBytes uint64
+	// MaxConcurrentIPs limits the distinct source IPs with active sessions. 0 means unlimited.
+	MaxConcurrentIPs uint32
+	// Cover configures the cover traffic sent by this end. nil disables it.
+	Cover *Cover
+}
+
+// Expired returns whether the account has expired at the given time.
+func (a *MemoryAccount) Expired(now time.Time) bool {
+	return a.ExpiresAt > 0 && now.Unix() >= a.ExpiresAt
+}
+
+// Equals implements protocol.Account.Equals().
+func (a *MemoryAccount) Equals(account protocol.Account) bool {
+	reflexAccount, ok := account.(*MemoryAccount)
+	if !ok {
+		return false
+	}
+	return a.ID.Equals(reflexAccount.ID)
+}
+
+// ToProto converts MemoryAccount to Account (implements proto.Message)
+func (a *MemoryAccount) ToProto() proto.Message {
+	return &Account{
+		Id:               a.ID.String(),
+		Policy:           a.Policy,
+		ExpiresAt:        a.ExpiresAt,
+		QuotaBytes:       a.QuotaBytes,
+		MaxConcurrentIps: a.MaxConcurrentIPs,
+		Cover:            a.Cover,
+	}
+}
+
+// CoverConfig returns the cover traffic configuration of the account, or nil
+// if it has none.
+func (a *MemoryAccount) CoverConfig() *encoding.CoverConfig {
+	if a.Cover == nil {
+		return nil
+	}
+	return &encoding.CoverConfig{
+		Rate:        a.Cover.Rate,
+		Size:        a.Cover.Size,
+		Budget:      a.Cover.Budget,
+		IdleTimeout: time.Duration(a.Cover.IdleTimeout) * time.Second,
+	}
+}
@@ -7,17 +7,11 @@
 package reflex
 
 import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
 	reflect "reflect"
 	sync "sync"
 	unsafe "unsafe"
-
-	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
-	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
-
-	"github.com/xtls/xray-core/common/errors"
-	"github.com/xtls/xray-core/common/protocol"
-	"github.com/xtls/xray-core/common/uuid"
-	"google.golang.org/protobuf/proto"
 )
 
 const (
@@ -32,7 +26,16 @@ type Account struct {
 	// ID of the account, in the form of a UUID, e.g., "66ad4540-b58c-4ad2-9926-ea63445a9b57".
 	Id string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
 	// Policy for traffic morphing (e.g., "mimic-http2-api", "mimic-youtube")
-	Policy        string `protobuf:"bytes,2,opt,name=policy,proto3" json:"policy,omitempty"`
+	Policy string `protobuf:"bytes,2,opt,name=policy,proto3" json:"policy,omitempty"`
+	// Unix time in seconds after which the account is no longer accepted. 0 means never.
+	ExpiresAt int64 `protobuf:"varint,3,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`
+	// Total uplink and downlink traffic allowed, in bytes. 0 means unlimited.
+	QuotaBytes uint64 `protobuf:"varint,4,opt,name=quota_bytes,json=quotaBytes,proto3" json:"quota_bytes,omitempty"`
+	// Maximum number of distinct source IPs with active sessions. 0 means unlimited.
+	MaxConcurrentIps uint32 `protobuf:"varint,5,opt,name=max_concurrent_ips,json=maxConcurrentIps,proto3" json:"max_concurrent_ips,omitempty"`
+	// Constant-rate cover traffic sent by this end of the account's sessions.
+	// Disabled when unset.
+	Cover         *Cover `protobuf:"bytes,6,opt,name=cover,proto3" json:"cover,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -81,14 +84,131 @@ func (x *Account) GetPolicy() string {
 	return ""
 }
 
+func (x *Account) GetExpiresAt() int64 {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return 0
+}
+
+func (x *Account) GetQuotaBytes() uint64 {
+	if x != nil {
+		return x.QuotaBytes
+	}
+	return 0
+}
+
+func (x *Account) GetMaxConcurrentIps() uint32 {
+	if x != nil {
+		return x.MaxConcurrentIps
+	}
+	return 0
+}
+
+func (x *Account) GetCover() *Cover {
+	if x != nil {
+		return x.Cover
+	}
+	return nil
+}
+
+// Cover configures constant-rate cover traffic: while a session is active,
+// frames of a fixed size leave at a fixed rate in each direction, filled with
+// data when there is some and with padding otherwise.
+type Cover struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Frames sent per second. 0 follows the delays of the traffic profile.
+	Rate uint32 `protobuf:"varint,1,opt,name=rate,proto3" json:"rate,omitempty"`
+	// Size of each frame on the wire in bytes. 0 follows the packet sizes of
+	// the traffic profile.
+	Size uint32 `protobuf:"varint,2,opt,name=size,proto3" json:"size,omitempty"`
+	// Bytes per second the cover traffic may use; the rate is lowered to fit.
+	// 0 means no limit.
+	Budget uint64 `protobuf:"varint,3,opt,name=budget,proto3" json:"budget,omitempty"`
+	// Seconds without data in either direction after which the cover traffic
+	// stops until the next data. 0 means it never stops.
+	IdleTimeout   uint32 `protobuf:"varint,4,opt,name=idle_timeout,json=idleTimeout,proto3" json:"idle_timeout,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Cover) Reset() {
+	*x = Cover{}
+	mi := &file_proxy_reflex_account_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Cover) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Cover) ProtoMessage() {}
+
+func (x *Cover) ProtoReflect() protoreflect.Message {
+	mi := &file_proxy_reflex_account_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Cover.ProtoReflect.Descriptor instead.
+func (*Cover) Descriptor() ([]byte, []int) {
+	return file_proxy_reflex_account_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Cover) GetRate() uint32 {
+	if x != nil {
+		return x.Rate
+	}
+	return 0
+}
+
+func (x *Cover) GetSize() uint32 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *Cover) GetBudget() uint64 {
+	if x != nil {
+		return x.Budget
+	}
+	return 0
+}
+
+func (x *Cover) GetIdleTimeout() uint32 {
+	if x != nil {
+		return x.IdleTimeout
+	}
+	return 0
+}
+
 var File_proxy_reflex_account_proto protoreflect.FileDescriptor
 
 const file_proxy_reflex_account_proto_rawDesc = "" +
 	"\n" +
-	"\x1aproxy/reflex/account.proto\x12\x11xray.proxy.reflex\"1\n" +
+	"\x1aproxy/reflex/account.proto\x12\x11xray.proxy.reflex\"\xcf\x01\n" +
 	"\aAccount\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\tR\x02id\x12\x16\n" +
-	"\x06policy\x18\x02 \x01(\tR\x06policyBU\n" +
+	"\x06policy\x18\x02 \x01(\tR\x06policy\x12\x1d\n" +
+	"\n" +
+	"expires_at\x18\x03 \x01(\x03R\texpiresAt\x12\x1f\n" +
+	"\vquota_bytes\x18\x04 \x01(\x04R\n" +
+	"quotaBytes\x12,\n" +
+	"\x12max_concurrent_ips\x18\x05 \x01(\rR\x10maxConcurrentIps\x12.\n" +
+	"\x05cover\x18\x06 \x01(\v2\x18.xray.proxy.reflex.CoverR\x05cover\"j\n" +
+	"\x05Cover\x12\x12\n" +
+	"\x04rate\x18\x01 \x01(\rR\x04rate\x12\x12\n" +
+	"\x04size\x18\x02 \x01(\rR\x04size\x12\x16\n" +
+	"\x06budget\x18\x03 \x01(\x04R\x06budget\x12!\n" +
+	"\fidle_timeout\x18\x04 \x01(\rR\vidleTimeoutBU\n" +
 	"\x15com.xray.proxy.reflexP\x01Z&github.com/xtls/xray-core/proxy/reflex\xaa\x02\x11Xray.Proxy.Reflexb\x06proto3"
 
 var (
@@ -103,16 +223,18 @@ func file_proxy_reflex_account_proto_rawDescGZIP() []byte {
 	return file_proxy_reflex_account_proto_rawDescData
 }
 
-var file_proxy_reflex_account_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_proxy_reflex_account_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
 var file_proxy_reflex_account_proto_goTypes = []any{
 	(*Account)(nil), // 0: xray.proxy.reflex.Account
+	(*Cover)(nil),   // 1: xray.proxy.reflex.Cover
 }
 var file_proxy_reflex_account_proto_depIdxs = []int32{
-	0, // [0:0] is the sub-list for method output_type
-	0, // [0:0] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	1, // 0: xray.proxy.reflex.Account.cover:type_name -> xray.proxy.reflex.Cover
+	1, // [1:1] is the sub-list for method output_type
+	1, // [1:1] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
 }
 
 func init() { file_proxy_reflex_account_proto_init() }
@@ -126,7 +248,7 @@ func file_proxy_reflex_account_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proxy_reflex_account_proto_rawDesc), len(file_proxy_reflex_account_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   1,
+			NumMessages:   2,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
@@ -138,38 +260,3 @@ func file_proxy_reflex_account_proto_init() {
 	file_proxy_reflex_account_proto_goTypes = nil
 	file_proxy_reflex_account_proto_depIdxs = nil
 }
-
-// AsAccount implements protocol.Account.AsAccount().
-func (a *Account) AsAccount() (protocol.Account, error) {
-	id, err := uuid.ParseString(a.Id)
-	if err != nil {
-		return nil, errors.New("failed to parse ID: ", err)
-	}
-	return &MemoryAccount{
-		ID:     protocol.NewID(id),
-		Policy: a.Policy,
-	}, nil
-}
-
-// MemoryAccount is an in-memory form of Reflex account.
-type MemoryAccount struct {
-	ID     *protocol.ID
-	Policy string
-}
-
-// Equals implements protocol.Account.Equals().
-func (a *MemoryAccount) Equals(account protocol.Account) bool {
-	reflexAccount, ok := account.(*MemoryAccount)
-	if !ok {
-		return false
-	}
-	return a.ID.Equals(reflexAccount.ID)
-}
-
-// ToProto converts MemoryAccount to Account (implements proto.Message)
-func (a *MemoryAccount) ToProto() proto.Message {
-	return &Account{
-		Id:     a.ID.String(),
-		Policy: a.Policy,
-	}
-}
@@ -0,0 +1,329 @@
+package capture
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/proxy/reflex/encoding"
+	"github.com/xtls/xray-core/proxy/reflex/resume"
+)
+
+var (
+	client = netip.MustParseAddrPort("192.0.2.1:50000")
+	server = netip.MustParseAddrPort("192.0.2.2:443")
+	start  = time.Unix(1700000000, 0)
+)
+
+// packet is a TCP segment to write to a test capture
+type packet struct {
+	src, dst netip.AddrPort
+	seq      uint32
+	flags    byte
+	payload  []byte
+}
+
+const (
+	flagSYN = 0x02
+	flagACK = 0x10
+)
+
+// tcpPacket encodes p as an IP packet.
+func tcpPacket(p packet) []byte {
+	tcp := make([]byte, 20, 20+len(p.payload))
+	binary.BigEndian.PutUint16(tcp[0:2], p.src.Port())
+	binary.BigEndian.PutUint16(tcp[2:4], p.dst.Port())
+	binary.BigEndian.PutUint32(tcp[4:8], p.seq)
+	tcp[12] = 5 << 4
+	tcp[13] = p.flags
+	tcp = append(tcp, p.payload...)
+
+	if p.src.Addr().Is4() {
+		ip := make([]byte, 20, 20+len(tcp))
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:4], uint16(20+len(tcp)))
+		ip[9] = 6
+		src, dst := p.src.Addr().As4(), p.dst.Addr().As4()
+		copy(ip[12:16], src[:])
+		copy(ip[16:20], dst[:])
+		return append(ip, tcp...)
+	}
+	ip := make([]byte, 40, 40+len(tcp))
+	ip[0] = 0x60
+	binary.BigEndian.PutUint16(ip[4:6], uint16(len(tcp)))
+	ip[6] = 6
+	src, dst := p.src.Addr().As16(), p.dst.Addr().As16()
+	copy(ip[8:24], src[:])
+	copy(ip[24:40], dst[:])
+	return append(ip, tcp...)
+}
+
+// writePcap writes packets as a pcap capture on Ethernet, a millisecond
+// apart.
+func writePcap(packets []packet) []byte {
+	var b bytes.Buffer
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:4], pcapMagicMicro)
+	binary.LittleEndian.PutUint16(header[4:6], 2)
+	binary.LittleEndian.PutUint16(header[6:8], 4)
+	binary.LittleEndian.PutUint32(header[16:20], 65535)
+	binary.LittleEndian.PutUint32(header[20:24], linkEthernet)
+	b.Write(header)
+	for i, p := range packets {
+		frame := make([]byte, 14)
+		binary.BigEndian.PutUint16(frame[12:14], 0x0800)
+		frame = append(frame, tcpPacket(p)...)
+		t := start.Add(time.Duration(i) * time.Millisecond)
+		record := make([]byte, 16)
+		binary.LittleEndian.PutUint32(record[0:4], uint32(t.Unix()))
+		binary.LittleEndian.PutUint32(record[4:8], uint32(t.Nanosecond()/1000))
+		binary.LittleEndian.PutUint32(record[8:12], uint32(len(frame)))
+		binary.LittleEndian.PutUint32(record[12:16], uint32(len(frame)))
+		b.Write(record)
+		b.Write(frame)
+	}
+	return b.Bytes()
+}
+
+// writePcapng writes packets as a big-endian pcapng capture of raw IP, with
+// nanosecond timestamps a millisecond apart.
+func writePcapng(packets []packet) []byte {
+	var b bytes.Buffer
+	block := func(blockType uint32, body []byte) {
+		for len(body)%4 != 0 {
+			body = append(body, 0)
+		}
+		size := uint32(12 + len(body))
+		binary.Write(&b, binary.BigEndian, blockType)
+		binary.Write(&b, binary.BigEndian, size)
+		b.Write(body)
+		binary.Write(&b, binary.BigEndian, size)
+	}
+	block(pcapngSection, []byte{0x1a, 0x2b, 0x3c, 0x4d, 0, 1, 0, 0, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	// if_tsresol of 10^-9, then the end of options
+	block(blockInterface, []byte{0, linkRaw, 0, 0, 0, 0, 0xff, 0xff, 0, 9, 0, 1, 9, 0, 0, 0, 0, 0, 0, 0})
+	for i, p := range packets {
+		data := tcpPacket(p)
+		ts := uint64(start.Add(time.Duration(i) * time.Millisecond).UnixNano())
+		body := make([]byte, 20, 20+len(data))
+		binary.BigEndian.PutUint32(body[4:8], uint32(ts>>32))
+		binary.BigEndian.PutUint32(body[8:12], uint32(ts))
+		binary.BigEndian.PutUint32(body[12:16], uint32(len(data)))
+		binary.BigEndian.PutUint32(body[16:20], uint32(len(data)))
+		block(blockEnhancedPacket, append(body, data...))
+	}
+	return b.Bytes()
+}
+
+// conversation builds the packets of a connection from the writes of each
+// end, from the client if toServer.
+type conversation struct {
+	client, server netip.AddrPort
+	clientSeq      uint32
+	serverSeq      uint32
+	packets        []packet
+}
+
+func newConversation(client, server netip.AddrPort, syn bool) *conversation {
+	c := &conversation{client: client, server: server, clientSeq: 0xfffffff0, serverSeq: 1000}
+	if syn {
+		c.packets = append(c.packets,
+			packet{src: client, dst: server, seq: c.clientSeq, flags: flagSYN},
+			packet{src: server, dst: client, seq: c.serverSeq, flags: flagSYN | flagACK},
+		)
+		c.clientSeq++
+		c.serverSeq++
+	}
+	return c
+}
+
+func (c *conversation) write(toServer bool, data []byte) {
+	if toServer {
+		c.packets = append(c.packets, packet{src: c.client, dst: c.server, seq: c.clientSeq, flags: flagACK, payload: data})
+		c.clientSeq += uint32(len(data))
+	} else {
+		c.packets = append(c.packets, packet{src: c.server, dst: c.client, seq: c.serverSeq, flags: flagACK, payload: data})
+		c.serverSeq += uint32(len(data))
+	}
+}
+
+func encodeFrame(t *testing.T, encoder *encoding.FrameEncoder, frame *encoding.Frame) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	if err := encoder.WriteFrame(&b, frame); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// handshake returns a padded hello and response and the session key they
+// agree on.
+func handshake(t *testing.T) (hello, response, sessionKey []byte, publicKey [32]byte) {
+	t.Helper()
+	clientPrivateKey, clientPublicKey, err := encoding.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, serverPublicKey, err := encoding.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs := &encoding.ClientHandshake{PublicKey: clientPublicKey, UserID: [16]byte{1, 2, 3}, Timestamp: start.Unix()}
+	rand.Read(hs.Nonce[:])
+	if hello, err = encoding.EncodePaddedClientHandshake(hs, 100); err != nil {
+		t.Fatal(err)
+	}
+	if response, err = encoding.EncodePaddedServerHandshake(&encoding.ServerHandshake{PublicKey: serverPublicKey, Timestamp: start.Unix()}, 50); err != nil {
+		t.Fatal(err)
+	}
+	sharedKey := encoding.DeriveSharedKey(clientPrivateKey, serverPublicKey)
+	if sessionKey, err = encoding.DeriveSessionKey(sharedKey, encoding.PaddedSessionSalt(hello, response)); err != nil {
+		t.Fatal(err)
+	}
+	return hello, response, sessionKey, clientPublicKey
+}
+
+// TestDecodeHandshake tests decrypting a padded handshake from a pcap
+// capture with segments reordered and retransmitted, next to a connection of
+// another protocol
+func TestDecodeHandshake(t *testing.T) {
+	hello, response, sessionKey, publicKey := handshake(t)
+	encoder, _ := encoding.NewFrameEncoder(sessionKey)
+	serverEncoder, _ := encoding.NewFrameEncoder(sessionKey)
+
+	other := newConversation(netip.MustParseAddrPort("192.0.2.1:50001"), netip.MustParseAddrPort("192.0.2.3:80"), true)
+	other.write(true, []byte("GET / HTTP/1.1\r\n\r\n"))
+
+	c := newConversation(client, server, true)
+	// The hello in two segments, which the sequence numbers wrap around
+	c.write(true, hello[:40])
+	c.write(true, hello[40:])
+	c.write(false, response)
+	c.write(true, encodeFrame(t, encoder, encoding.NewPolicyRequestFrame("zoom")))
+	c.write(false, encodeFrame(t, serverEncoder, encoding.NewPolicyGrantFrame(encoding.GetProfileByName("zoom"))))
+	request := encodeFrame(t, encoder, &encoding.Frame{Type: encoding.FrameTypeData, Payload: []byte("GET / HTTP/1.1\r\n\r\n"), Padding: 200})
+	c.write(true, request[:100])
+	c.write(true, request[100:])
+	c.write(false, encodeFrame(t, serverEncoder, &encoding.Frame{Type: encoding.FrameTypeData, Payload: []byte("HTTP/1.1 200 OK\r\n\r\n")}))
+	// The last two segments of the request arrive out of order, and the
+	// first one twice
+	n := len(c.packets)
+	c.packets[n-3], c.packets[n-2] = c.packets[n-2], c.packets[n-3]
+	c.packets = append(c.packets, c.packets[n-2])
+
+	segments, err := ReadSegments(bytes.NewReader(writePcap(append(other.packets, c.packets...))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conns := Assemble(segments)
+	if len(conns) != 2 {
+		t.Fatalf("expected 2 connections, got %d", len(conns))
+	}
+	keys, err := NewKeys(map[[32]byte][]byte{publicKey: sessionKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Decode(conns[0], keys); err != ErrNotReflex {
+		t.Fatalf("expected the HTTP connection not to be Reflex, got %v", err)
+	}
+
+	if conns[1].Client != client || conns[1].Server != server {
+		t.Fatalf("unexpected endpoints %s -> %s", conns[1].Client, conns[1].Server)
+	}
+	s, err := Decode(conns[1], keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Kind != KindPaddedHandshake || s.UserID != [16]byte{1, 2, 3} || !s.Timestamp.Equal(start) {
+		t.Fatalf("unexpected session %+v", s)
+	}
+	if s.ClientErr != nil || s.ServerErr != nil {
+		t.Fatal(s.ClientErr, s.ServerErr)
+	}
+	expected := []struct {
+		fromClient bool
+		frameType  byte
+		payload    string
+		padding    int
+	}{
+		{true, encoding.FrameTypePolicy, "zoom", 0},
+		{false, encoding.FrameTypePolicy, "", 0},
+		{true, encoding.FrameTypeData, "GET / HTTP/1.1\r\n\r\n", 200},
+		{false, encoding.FrameTypeData, "HTTP/1.1 200 OK\r\n\r\n", 0},
+	}
+	if len(s.Frames) != len(expected) {
+		t.Fatalf("expected %d frames, got %d", len(expected), len(s.Frames))
+	}
+	for i, e := range expected {
+		f := s.Frames[i]
+		if f.FromClient != e.fromClient || f.Type != e.frameType || f.Padding != e.padding || e.payload != "" && string(f.Payload) != e.payload {
+			t.Errorf("frame %d: unexpected %+v", i, f)
+		}
+	}
+}
+
+// TestDecodeResume tests decrypting a resumed connection from a pcapng
+// capture of IPv6 that misses the start of the connection
+func TestDecodeResume(t *testing.T) {
+	_, _, sessionKey, publicKey := handshake(t)
+	secrets, err := resume.DeriveSecrets(sessionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hello := &resume.Hello{ID: secrets.ID, Timestamp: start.Unix(), Received: 3}
+	rand.Read(hello.Nonce[:])
+	key, err := secrets.ConnectionKey(hello.Nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoder, _ := encoding.NewFrameEncoder(key)
+	serverEncoder, _ := encoding.NewFrameEncoder(key)
+
+	c := newConversation(netip.MustParseAddrPort("[2001:db8::1]:50000"), netip.MustParseAddrPort("[2001:db8::2]:443"), false)
+	c.write(true, hello.Encode(&secrets))
+	c.write(false, secrets.EncodeResponse(hello.Nonce, 5))
+	c.write(false, encodeFrame(t, serverEncoder, &encoding.Frame{Type: encoding.FrameTypeData, Payload: []byte("resumed")}))
+	c.write(true, encodeFrame(t, encoder, encoding.NewCloseFrame(encoding.CloseReasonFin)))
+	// The server's next frame is cut short
+	c.write(false, encodeFrame(t, serverEncoder, &encoding.Frame{Type: encoding.FrameTypeData, Payload: []byte("lost")})[:10])
+
+	segments, err := ReadSegments(bytes.NewReader(writePcapng(c.packets)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conns := Assemble(segments)
+	if len(conns) != 1 || conns[0].Client != c.client {
+		t.Fatalf("unexpected connections %+v", conns)
+	}
+	if !conns[0].Start.Equal(start) {
+		t.Errorf("unexpected start %s", conns[0].Start)
+	}
+
+	// Without the key of the session the connection is only recognised
+	keys, _ := NewKeys(map[[32]byte][]byte{})
+	if _, err := Decode(conns[0], keys); err == nil || !strings.Contains(err.Error(), "no key") {
+		t.Fatalf("expected a missing key, got %v", err)
+	}
+
+	keys, _ = NewKeys(map[[32]byte][]byte{publicKey: sessionKey})
+	s, err := Decode(conns[0], keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Kind != KindResume || len(s.Frames) != 2 {
+		t.Fatalf("unexpected session %+v", s)
+	}
+	if f := s.Frames[0]; f.FromClient || string(f.Payload) != "resumed" {
+		t.Errorf("unexpected first frame %+v", f)
+	}
+	if f := s.Frames[1]; !f.FromClient || f.Type != encoding.FrameTypeClose {
+		t.Errorf("unexpected second frame %+v", f)
+	}
+	if s.ClientErr != nil || s.ServerErr == nil || !strings.Contains(s.ServerErr.Error(), "ends inside a frame") {
+		t.Errorf("unexpected errors %v, %v", s.ClientErr, s.ServerErr)
+	}
+}
@@ -0,0 +1,211 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"time"
+
+	"github.com/xtls/xray-core/common/errors"
+	"github.com/xtls/xray-core/proxy/reflex/encoding"
+	"github.com/xtls/xray-core/proxy/reflex/resume"
+)
+
+// Kinds of Reflex connections
+const (
+	KindHandshake       = "handshake"
+	KindPaddedHandshake = "padded handshake"
+	KindResume          = "resume"
+)
+
+// ErrNotReflex is returned by Decode for connections that do not start with
+// a Reflex hello.
+var ErrNotReflex = errors.New("not a Reflex connection")
+
+// Keys are the session keys of a key log.
+type Keys struct {
+	sessions map[[32]byte][]byte
+	// resumes are the resumption secrets of the sessions, by session ID
+	resumes map[[16]byte]*resume.Secrets
+}
+
+// NewKeys returns the keys of the sessions read from a key log, by client
+// public key.
+func NewKeys(sessions map[[32]byte][]byte) (*Keys, error) {
+	k := &Keys{
+		sessions: sessions,
+		resumes:  make(map[[16]byte]*resume.Secrets, len(sessions)),
+	}
+	for _, key := range sessions {
+		secrets, err := resume.DeriveSecrets(key)
+		if err != nil {
+			return nil, err
+		}
+		k.resumes[secrets.ID] = &secrets
+	}
+	return k, nil
+}
+
+// Frame is a decrypted frame of a connection.
+type Frame struct {
+	// FromClient tells the frames sent by the client from the server's
+	FromClient bool
+	// Time is when the last byte of the frame was captured
+	Time    time.Time
+	Type    byte
+	Payload []byte
+	// Padding is the number of filler bytes after the payload
+	Padding int
+}
+
+// Session is a decoded Reflex connection.
+type Session struct {
+	Kind string
+	// UserID is the user of a handshake
+	UserID [16]byte
+	// Timestamp is the time of the hello
+	Timestamp time.Time
+	// Frames are the frames of both ends, in the order they were captured
+	Frames []*Frame
+	// ClientErr and ServerErr tell why decoding the frames of the client or
+	// of the server stopped before the end of their stream, if it did
+	ClientErr error
+	ServerErr error
+}
+
+// Decode decrypts the Reflex connection c with keys. It returns ErrNotReflex
+// for other connections, and an error for the Reflex connections whose key
+// is not in keys.
+func Decode(c *Conn, keys *Keys) (*Session, error) {
+	data := c.ToServer.Data
+	if len(data) < 4 {
+		return nil, ErrNotReflex
+	}
+
+	s := &Session{}
+	var key []byte
+	var clientOffset, serverOffset int
+	switch binary.BigEndian.Uint32(data) {
+	case encoding.ReflexMagic, encoding.ReflexPaddedMagic:
+		var hello *encoding.ClientHandshake
+		var err error
+		if binary.BigEndian.Uint32(data) == encoding.ReflexPaddedMagic {
+			s.Kind = KindPaddedHandshake
+			if clientOffset, err = encoding.PaddedClientHandshakeSize(data); err == nil {
+				hello, err = encoding.DecodePaddedClientHandshake(data)
+			}
+		} else {
+			s.Kind = KindHandshake
+			clientOffset = encoding.ClientHandshakeSize
+			hello, err = encoding.DecodeClientHandshake(data)
+		}
+		if err != nil {
+			return nil, errors.New("invalid hello").Base(err)
+		}
+		s.UserID = hello.UserID
+		s.Timestamp = time.Unix(hello.Timestamp, 0)
+		if key = keys.sessions[hello.PublicKey]; key == nil {
+			return s, errors.New("no key logged for public key ", hex.EncodeToString(hello.PublicKey[:]))
+		}
+
+		// The server answers a known user with a response of the same kind
+		if s.Kind == KindPaddedHandshake {
+			if _, response, err := encoding.ReadPaddedServerHandshake(bytes.NewReader(c.ToClient.Data)); err == nil {
+				serverOffset = len(response)
+			}
+		} else if len(c.ToClient.Data) >= encoding.ServerHandshakeSize {
+			serverOffset = encoding.ServerHandshakeSize
+		}
+	case resume.Magic:
+		s.Kind = KindResume
+		hello, err := resume.DecodeHello(data)
+		if err != nil {
+			return nil, errors.New("invalid resume hello").Base(err)
+		}
+		s.Timestamp = time.Unix(hello.Timestamp, 0)
+		secrets := keys.resumes[hello.ID]
+		if secrets == nil {
+			return s, errors.New("no key logged for resumed session ", hex.EncodeToString(hello.ID[:]))
+		}
+		if key, err = secrets.ConnectionKey(hello.Nonce); err != nil {
+			return nil, err
+		}
+		clientOffset = resume.HelloSize
+		if len(c.ToClient.Data) >= resume.ResponseSize {
+			serverOffset = resume.ResponseSize
+		}
+	default:
+		return nil, ErrNotReflex
+	}
+
+	client, err := decodeFrames(c.ToServer, clientOffset, key, true)
+	s.ClientErr = err
+	var server []*Frame
+	if serverOffset > 0 {
+		server, err = decodeFrames(c.ToClient, serverOffset, key, false)
+		s.ServerErr = err
+	} else if len(c.ToClient.Data) > 0 {
+		s.ServerErr = errors.New("invalid handshake response")
+	}
+
+	// Interleave the frames of both ends in capture order
+	s.Frames = make([]*Frame, 0, len(client)+len(server))
+	for len(client) > 0 || len(server) > 0 {
+		if len(server) == 0 || len(client) > 0 && !server[0].Time.Before(client[0].Time) {
+			s.Frames, client = append(s.Frames, client[0]), client[1:]
+		} else {
+			s.Frames, server = append(s.Frames, server[0]), server[1:]
+		}
+	}
+	return s, nil
+}
+
+// decodeFrames decrypts the frames of stream from offset on.
+func decodeFrames(stream *Stream, offset int, key []byte, fromClient bool) ([]*Frame, error) {
+	decoder, err := encoding.NewFrameDecoder(key)
+	if err != nil {
+		return nil, err
+	}
+	data := stream.Data
+	var frames []*Frame
+	for offset < len(data) {
+		if len(data)-offset < 2 {
+			return frames, incomplete(stream)
+		}
+		length := int(binary.BigEndian.Uint16(data[offset:]))
+		end := offset + 2 + length
+		if end > len(data) {
+			return frames, incomplete(stream)
+		}
+		frame, err := decoder.Decode(data[offset:end])
+		if err != nil {
+			return frames, errors.New("frame ", len(frames)+1, " at byte ", offset).Base(err)
+		}
+		// The ciphertext holds the 16-byte tag, the type and the payload, and
+		// for padded frames a 3-byte header and the padding
+		f := &Frame{
+			FromClient: fromClient,
+			Time:       stream.TimeAt(end - 1),
+			Type:       frame.Type,
+			Payload:    frame.Payload,
+		}
+		if extra := length - 17 - len(frame.Payload); extra > 0 {
+			f.Padding = extra - 3
+		}
+		encoding.PutFrame(frame)
+		frames = append(frames, f)
+		offset = end
+	}
+	if stream.Gap {
+		return frames, errors.New("capture misses data of the stream")
+	}
+	return frames, nil
+}
+
+// incomplete returns the error of a stream that ends inside a frame.
+func incomplete(stream *Stream) error {
+	if stream.Gap {
+		return errors.New("capture misses data of the stream")
+	}
+	return errors.New("stream ends inside a frame")
+}
@@ -0,0 +1,372 @@
+// Package capture decrypts the Reflex connections of a packet capture, given
+// the session keys the handlers wrote to their key log. It reads pcap and
+// pcapng files, reassembles their TCP streams and decodes the handshakes and
+// frames of each Reflex connection found.
+//
+// Only connections on the RAW transport without TLS can be decrypted, as the
+// Reflex bytes are the TCP payload there.
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net/netip"
+	"time"
+
+	"github.com/xtls/xray-core/common/errors"
+)
+
+// Segment is a TCP segment of a capture.
+type Segment struct {
+	Time     time.Time
+	Src, Dst netip.AddrPort
+	Seq      uint32
+	SYN      bool
+	ACK      bool
+	FIN      bool
+	RST      bool
+	Payload  []byte
+}
+
+// Link types of the captures understood, from the tcpdump.org list.
+const (
+	linkNull     = 0
+	linkEthernet = 1
+	linkRaw      = 101
+	linkLoop     = 108
+	linkSLL      = 113
+	linkIPv4     = 228
+	linkIPv6     = 229
+	linkSLL2     = 276
+)
+
+const (
+	pcapMagicMicro  = 0xa1b2c3d4
+	pcapMagicNano   = 0xa1b23c4d
+	pcapngSection   = 0x0a0d0d0a
+	pcapngByteOrder = 0x1a2b3c4d
+)
+
+// pcapng block types
+const (
+	blockInterface      = 1
+	blockSimplePacket   = 3
+	blockEnhancedPacket = 6
+)
+
+// maxBlockSize bounds the size of a record, against corrupt captures.
+const maxBlockSize = 16 << 20
+
+// ReadSegments reads the TCP segments of a capture in pcap or pcapng format.
+// Other packets are skipped.
+func ReadSegments(r io.Reader) ([]*Segment, error) {
+	reader := bufio.NewReader(r)
+	magic, err := reader.Peek(4)
+	if err != nil {
+		return nil, errors.New("failed to read capture header").Base(err)
+	}
+	var segments []*Segment
+	add := func(link uint32, t time.Time, data []byte) {
+		if s := parsePacket(link, data); s != nil {
+			s.Time = t
+			segments = append(segments, s)
+		}
+	}
+	if binary.LittleEndian.Uint32(magic) == pcapngSection {
+		err = readPcapng(reader, add)
+	} else {
+		err = readPcap(reader, add)
+	}
+	return segments, err
+}
+
+// readPcap reads the packets of a classic pcap file.
+func readPcap(r io.Reader, packet func(link uint32, t time.Time, data []byte)) error {
+	header := make([]byte, 24)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return errors.New("failed to read pcap header").Base(err)
+	}
+	var order binary.ByteOrder
+	var nano bool
+	switch {
+	case binary.LittleEndian.Uint32(header) == pcapMagicMicro:
+		order = binary.LittleEndian
+	case binary.BigEndian.Uint32(header) == pcapMagicMicro:
+		order = binary.BigEndian
+	case binary.LittleEndian.Uint32(header) == pcapMagicNano:
+		order, nano = binary.LittleEndian, true
+	case binary.BigEndian.Uint32(header) == pcapMagicNano:
+		order, nano = binary.BigEndian, true
+	default:
+		return errors.New("not a pcap or pcapng file")
+	}
+	link := order.Uint32(header[20:24]) & 0xffff
+
+	record := make([]byte, 16)
+	for {
+		if _, err := io.ReadFull(r, record); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return errors.New("failed to read pcap record").Base(err)
+		}
+		size := order.Uint32(record[8:12])
+		if size > maxBlockSize {
+			return errors.New("pcap record too large: ", size)
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return errors.New("failed to read pcap record").Base(err)
+		}
+		sub := int64(order.Uint32(record[4:8]))
+		if !nano {
+			sub *= 1000
+		}
+		packet(link, time.Unix(int64(order.Uint32(record[0:4])), sub), data)
+	}
+}
+
+// pcapngInterface is an interface of a pcapng section.
+type pcapngInterface struct {
+	link uint32
+	// ticks is the number of timestamp units per second
+	ticks uint64
+}
+
+// readPcapng reads the packets of a pcapng file.
+func readPcapng(r io.Reader, packet func(link uint32, t time.Time, data []byte)) error {
+	var order binary.ByteOrder = binary.LittleEndian
+	var interfaces []pcapngInterface
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return errors.New("failed to read pcapng block").Base(err)
+		}
+		blockType := order.Uint32(header[0:4])
+		if blockType == pcapngSection {
+			// The byte order of a section is only known from its header
+			var magic [4]byte
+			if _, err := io.ReadFull(r, magic[:]); err != nil {
+				return errors.New("failed to read pcapng section").Base(err)
+			}
+			switch {
+			case binary.LittleEndian.Uint32(magic[:]) == pcapngByteOrder:
+				order = binary.LittleEndian
+			case binary.BigEndian.Uint32(magic[:]) == pcapngByteOrder:
+				order = binary.BigEndian
+			default:
+				return errors.New("invalid pcapng byte order magic")
+			}
+			interfaces = nil
+			size := order.Uint32(header[4:8])
+			if size < 16 || size > maxBlockSize {
+				return errors.New("invalid pcapng section size: ", size)
+			}
+			if _, err := io.CopyN(io.Discard, r, int64(size-12)); err != nil {
+				return errors.New("failed to read pcapng section").Base(err)
+			}
+			continue
+		}
+
+		size := order.Uint32(header[4:8])
+		if size < 12 || size > maxBlockSize || size%4 != 0 {
+			return errors.New("invalid pcapng block size: ", size)
+		}
+		body := make([]byte, size-8)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return errors.New("failed to read pcapng block").Base(err)
+		}
+		body = body[:len(body)-4]
+
+		switch blockType {
+		case blockInterface:
+			if len(body) < 8 {
+				return errors.New("pcapng interface block too short")
+			}
+			interfaces = append(interfaces, pcapngInterface{
+				link:  uint32(order.Uint16(body[0:2])),
+				ticks: pcapngResolution(order, body[8:]),
+			})
+		case blockEnhancedPacket:
+			if len(body) < 20 {
+				return errors.New("pcapng packet block too short")
+			}
+			id := order.Uint32(body[0:4])
+			if int(id) >= len(interfaces) {
+				return errors.New("pcapng packet of unknown interface ", id)
+			}
+			captured := order.Uint32(body[12:16])
+			if int(captured) > len(body)-20 {
+				return errors.New("pcapng packet block too short")
+			}
+			iface := interfaces[id]
+			ts := uint64(order.Uint32(body[4:8]))<<32 | uint64(order.Uint32(body[8:12]))
+			t := time.Unix(int64(ts/iface.ticks), int64(float64(ts%iface.ticks)/float64(iface.ticks)*float64(time.Second)))
+			packet(iface.link, t, body[20:20+captured])
+		case blockSimplePacket:
+			if len(interfaces) == 0 {
+				return errors.New("pcapng packet without interface")
+			}
+			if len(body) < 4 {
+				return errors.New("pcapng packet block too short")
+			}
+			data := body[4:]
+			if length := order.Uint32(body[0:4]); int(length) < len(data) {
+				data = data[:length]
+			}
+			packet(interfaces[0].link, time.Time{}, data)
+		}
+	}
+}
+
+// pcapngResolution returns the timestamp units per second of an interface,
+// from its if_tsresol option.
+func pcapngResolution(order binary.ByteOrder, options []byte) uint64 {
+	for len(options) >= 4 {
+		code, length := order.Uint16(options[0:2]), int(order.Uint16(options[2:4]))
+		if code == 0 || len(options) < 4+length {
+			break
+		}
+		if code == 9 && length >= 1 {
+			v := options[4]
+			ticks := uint64(1)
+			for i := 0; i < int(v&0x7f) && ticks < 1e18; i++ {
+				if v&0x80 != 0 {
+					ticks *= 2
+				} else {
+					ticks *= 10
+				}
+			}
+			return ticks
+		}
+		next := 4 + (length+3)/4*4
+		if next > len(options) {
+			break
+		}
+		options = options[next:]
+	}
+	return 1e6
+}
+
+// parsePacket returns the TCP segment of a packet of the given link type, or
+// nil if it is not one.
+func parsePacket(link uint32, data []byte) *Segment {
+	var ethertype uint16
+	switch link {
+	case linkEthernet:
+		if len(data) < 14 {
+			return nil
+		}
+		ethertype, data = binary.BigEndian.Uint16(data[12:14]), data[14:]
+		// VLAN tags
+		for (ethertype == 0x8100 || ethertype == 0x88a8) && len(data) >= 4 {
+			ethertype, data = binary.BigEndian.Uint16(data[2:4]), data[4:]
+		}
+	case linkSLL:
+		if len(data) < 16 {
+			return nil
+		}
+		ethertype, data = binary.BigEndian.Uint16(data[14:16]), data[16:]
+	case linkSLL2:
+		if len(data) < 20 {
+			return nil
+		}
+		ethertype, data = binary.BigEndian.Uint16(data[0:2]), data[20:]
+	case linkNull, linkLoop:
+		// The address family is in host byte order for NULL, but either way
+		// the IP version tells the packets apart
+		if len(data) < 4 {
+			return nil
+		}
+		data = data[4:]
+	case linkRaw, linkIPv4, linkIPv6:
+	default:
+		return nil
+	}
+
+	if len(data) == 0 {
+		return nil
+	}
+	switch {
+	case ethertype == 0x0800 || ethertype == 0 && data[0]>>4 == 4:
+		return parseIPv4(data)
+	case ethertype == 0x86dd || ethertype == 0 && data[0]>>4 == 6:
+		return parseIPv6(data)
+	}
+	return nil
+}
+
+func parseIPv4(data []byte) *Segment {
+	if len(data) < 20 || data[0]>>4 != 4 || data[9] != 6 {
+		return nil
+	}
+	// Fragments are rare on TCP and not reassembled
+	if binary.BigEndian.Uint16(data[6:8])&0x3fff != 0 {
+		return nil
+	}
+	headerSize := int(data[0]&0x0f) * 4
+	total := int(binary.BigEndian.Uint16(data[2:4]))
+	if headerSize < 20 || total < headerSize || total > len(data) {
+		return nil
+	}
+	src := netip.AddrFrom4([4]byte(data[12:16]))
+	dst := netip.AddrFrom4([4]byte(data[16:20]))
+	return parseTCP(src, dst, data[headerSize:total])
+}
+
+func parseIPv6(data []byte) *Segment {
+	if len(data) < 40 || data[0]>>4 != 6 {
+		return nil
+	}
+	total := 40 + int(binary.BigEndian.Uint16(data[4:6]))
+	if total > len(data) {
+		return nil
+	}
+	src := netip.AddrFrom16([16]byte(data[8:24]))
+	dst := netip.AddrFrom16([16]byte(data[24:40]))
+	next, payload := data[6], data[40:total]
+	for {
+		switch next {
+		case 6:
+			return parseTCP(src, dst, payload)
+		case 0, 43, 60:
+			// Hop-by-hop, routing and destination options headers
+			if len(payload) < 8 {
+				return nil
+			}
+			size := (int(payload[1]) + 1) * 8
+			if size > len(payload) {
+				return nil
+			}
+			next, payload = payload[0], payload[size:]
+		default:
+			// Including fragments, which are not reassembled
+			return nil
+		}
+	}
+}
+
+func parseTCP(src, dst netip.Addr, data []byte) *Segment {
+	if len(data) < 20 {
+		return nil
+	}
+	headerSize := int(data[12]>>4) * 4
+	if headerSize < 20 || headerSize > len(data) {
+		return nil
+	}
+	flags := data[13]
+	return &Segment{
+		Src:     netip.AddrPortFrom(src, binary.BigEndian.Uint16(data[0:2])),
+		Dst:     netip.AddrPortFrom(dst, binary.BigEndian.Uint16(data[2:4])),
+		Seq:     binary.BigEndian.Uint32(data[4:8]),
+		FIN:     flags&0x01 != 0,
+		SYN:     flags&0x02 != 0,
+		RST:     flags&0x04 != 0,
+		ACK:     flags&0x10 != 0,
+		Payload: data[headerSize:],
+	}
+}
@@ -0,0 +1,171 @@
+package capture
+
+import (
+	"net/netip"
+	"sort"
+	"time"
+)
+
+// Stream is the data one end of a TCP connection sent, as far as the capture
+// holds it.
+type Stream struct {
+	Data []byte
+	// Gap reports that the capture misses data after Data, so that the rest
+	// of the stream is lost.
+	Gap bool
+
+	// chunks are the offsets in Data at which each captured segment starts
+	chunks []chunk
+}
+
+type chunk struct {
+	offset int
+	time   time.Time
+}
+
+// TimeAt returns the time at which the byte of Data at offset was captured.
+func (s *Stream) TimeAt(offset int) time.Time {
+	i := sort.Search(len(s.chunks), func(i int) bool { return s.chunks[i].offset > offset }) - 1
+	if i < 0 {
+		return time.Time{}
+	}
+	return s.chunks[i].time
+}
+
+// Conn is a TCP connection of a capture.
+type Conn struct {
+	Client, Server netip.AddrPort
+	Start          time.Time
+	// ToServer and ToClient are the data sent by the client and the server
+	ToServer, ToClient *Stream
+}
+
+// flow collects the segments sent by one end of a connection.
+type flow struct {
+	// isn is the sequence number of the first byte, once the SYN is seen
+	isn      uint32
+	synced   bool
+	segments []*Segment
+}
+
+// stream reassembles the segments of the flow in sequence order.
+func (f *flow) stream() *Stream {
+	s := &Stream{}
+	if len(f.segments) == 0 {
+		return s
+	}
+	isn := f.isn
+	if !f.synced {
+		// Without the SYN the stream starts at the lowest sequence number
+		// captured, relative to the first segment as they may wrap around
+		isn = f.segments[0].Seq
+		for _, seg := range f.segments {
+			if int32(seg.Seq-isn) < 0 {
+				isn = seg.Seq
+			}
+		}
+	}
+	offset := func(seg *Segment) int64 {
+		return int64(int32(seg.Seq - isn))
+	}
+	sort.SliceStable(f.segments, func(i, j int) bool {
+		return offset(f.segments[i]) < offset(f.segments[j])
+	})
+
+	for _, seg := range f.segments {
+		start, end := offset(seg), offset(seg)+int64(len(seg.Payload))
+		pos := int64(len(s.Data))
+		if start > pos {
+			s.Gap = true
+			break
+		}
+		if end <= pos {
+			// Retransmitted
+			continue
+		}
+		s.chunks = append(s.chunks, chunk{offset: int(pos), time: seg.Time})
+		s.Data = append(s.Data, seg.Payload[pos-start:]...)
+	}
+	return s
+}
+
+// conn is a connection being assembled.
+type conn struct {
+	client, server     netip.AddrPort
+	start              time.Time
+	toServer, toClient flow
+}
+
+func (c *conn) hasData() bool {
+	return len(c.toServer.segments) > 0 || len(c.toClient.segments) > 0
+}
+
+// connKey identifies the connections between two endpoints, whichever sent
+// a segment.
+type connKey struct {
+	a, b netip.AddrPort
+}
+
+func newConnKey(src, dst netip.AddrPort) connKey {
+	if src.Compare(dst) < 0 {
+		return connKey{src, dst}
+	}
+	return connKey{dst, src}
+}
+
+// Assemble sorts the TCP segments of a capture into connections and
+// reassembles the data each end sent, in the order the connections started.
+//
+// The client of a connection is the end that sent the SYN. For connections
+// whose start was not captured, it is the end with the higher port.
+func Assemble(segments []*Segment) []*Conn {
+	var conns []*conn
+	current := make(map[connKey]*conn)
+	for _, seg := range segments {
+		key := newConnKey(seg.Src, seg.Dst)
+		c := current[key]
+		if seg.SYN && !seg.ACK {
+			// A new SYN on a used pair of endpoints starts a new connection,
+			// unless it is a retransmission
+			if c != nil && (c.hasData() || c.client != seg.Src || c.toServer.synced && c.toServer.isn != seg.Seq+1) {
+				c = nil
+			}
+			if c == nil {
+				c = &conn{client: seg.Src, server: seg.Dst, start: seg.Time}
+			}
+		}
+		if c == nil {
+			c = &conn{client: seg.Src, server: seg.Dst, start: seg.Time}
+			if seg.Src.Port() < seg.Dst.Port() {
+				c.client, c.server = seg.Dst, seg.Src
+			}
+		}
+		if current[key] != c {
+			current[key] = c
+			conns = append(conns, c)
+		}
+
+		f := &c.toClient
+		if seg.Src == c.client {
+			f = &c.toServer
+		}
+		if seg.SYN {
+			f.isn, f.synced = seg.Seq+1, true
+		}
+		if len(seg.Payload) > 0 {
+			f.segments = append(f.segments, seg)
+		}
+	}
+
+	result := make([]*Conn, 0, len(conns))
+	for _, c := range conns {
+		result = append(result, &Conn{
+			Client:   c.client,
+			Server:   c.server,
+			Start:    c.start,
+			ToServer: c.toServer.stream(),
+			ToClient: c.toClient.stream(),
+		})
+	}
+	return result
+}
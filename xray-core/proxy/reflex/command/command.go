@@ -0,0 +1,81 @@
+package command
+
+import (
+	"context"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/common/errors"
+	"github.com/xtls/xray-core/core"
+	"github.com/xtls/xray-core/features/inbound"
+	"github.com/xtls/xray-core/proxy"
+	reflexin "github.com/xtls/xray-core/proxy/reflex/inbound"
+	grpc "google.golang.org/grpc"
+)
+
+// reflexServer is an implementation of ReflexService.
+type reflexServer struct {
+	ihm inbound.Manager
+}
+
+func (s *reflexServer) getBanManager(ctx context.Context, tag string) (reflexin.BanManager, error) {
+	handler, err := s.ihm.GetHandler(ctx, tag)
+	if err != nil {
+		return nil, errors.New("failed to get handler: ", tag).Base(err)
+	}
+	gi, ok := handler.(proxy.GetInbound)
+	if !ok {
+		return nil, errors.New("can't get inbound proxy from handler.")
+	}
+	bm, ok := gi.GetInbound().(reflexin.BanManager)
+	if !ok {
+		return nil, errors.New("proxy is not a Reflex inbound")
+	}
+	return bm, nil
+}
+
+func (s *reflexServer) GetBans(ctx context.Context, request *GetBansRequest) (*GetBansResponse, error) {
+	bm, err := s.getBanManager(ctx, request.Tag)
+	if err != nil {
+		return nil, err
+	}
+	response := &GetBansResponse{}
+	for _, ban := range bm.GetBans() {
+		response.Bans = append(response.Bans, &Ban{
+			Source:    ban.Source,
+			ExpiresAt: ban.ExpiresAt.Unix(),
+			Strikes:   ban.Strikes,
+		})
+	}
+	return response, nil
+}
+
+func (s *reflexServer) ClearBans(ctx context.Context, request *ClearBansRequest) (*ClearBansResponse, error) {
+	bm, err := s.getBanManager(ctx, request.Tag)
+	if err != nil {
+		return nil, err
+	}
+	return &ClearBansResponse{
+		Count: uint32(bm.ClearBans(request.Sources)),
+	}, nil
+}
+
+func (s *reflexServer) mustEmbedUnimplementedReflexServiceServer() {}
+
+type service struct {
+	v *core.Instance
+}
+
+func (s *service) Register(server *grpc.Server) {
+	rs := &reflexServer{}
+	common.Must(s.v.RequireFeatures(func(im inbound.Manager) {
+		rs.ihm = im
+	}, false))
+	RegisterReflexServiceServer(server, rs)
+}
+
+func init() {
+	common.Must(common.RegisterConfig((*Config)(nil), func(ctx context.Context, cfg interface{}) (interface{}, error) {
+		s := core.MustFromContext(ctx)
+		return &service{v: s}, nil
+	}))
+}
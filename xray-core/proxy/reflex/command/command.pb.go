@@ -0,0 +1,392 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        v5.28.2
+// source: proxy/reflex/command/command.proto
+
+package command
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Ban struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// IP address, or /24 or /64 prefix in CIDR notation.
+	Source string `protobuf:"bytes,1,opt,name=source,proto3" json:"source,omitempty"`
+	// Unix time in seconds when the ban expires.
+	ExpiresAt int64 `protobuf:"varint,2,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`
+	// Number of times the source has been banned in a row.
+	Strikes       uint32 `protobuf:"varint,3,opt,name=strikes,proto3" json:"strikes,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Ban) Reset() {
+	*x = Ban{}
+	mi := &file_proxy_reflex_command_command_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Ban) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Ban) ProtoMessage() {}
+
+func (x *Ban) ProtoReflect() protoreflect.Message {
+	mi := &file_proxy_reflex_command_command_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Ban.ProtoReflect.Descriptor instead.
+func (*Ban) Descriptor() ([]byte, []int) {
+	return file_proxy_reflex_command_command_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Ban) GetSource() string {
+	if x != nil {
+		return x.Source
+	}
+	return ""
+}
+
+func (x *Ban) GetExpiresAt() int64 {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return 0
+}
+
+func (x *Ban) GetStrikes() uint32 {
+	if x != nil {
+		return x.Strikes
+	}
+	return 0
+}
+
+type GetBansRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Tag of the Reflex inbound.
+	Tag           string `protobuf:"bytes,1,opt,name=tag,proto3" json:"tag,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetBansRequest) Reset() {
+	*x = GetBansRequest{}
+	mi := &file_proxy_reflex_command_command_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetBansRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetBansRequest) ProtoMessage() {}
+
+func (x *GetBansRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proxy_reflex_command_command_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetBansRequest.ProtoReflect.Descriptor instead.
+func (*GetBansRequest) Descriptor() ([]byte, []int) {
+	return file_proxy_reflex_command_command_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *GetBansRequest) GetTag() string {
+	if x != nil {
+		return x.Tag
+	}
+	return ""
+}
+
+type GetBansResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Bans          []*Ban                 `protobuf:"bytes,1,rep,name=bans,proto3" json:"bans,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetBansResponse) Reset() {
+	*x = GetBansResponse{}
+	mi := &file_proxy_reflex_command_command_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetBansResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetBansResponse) ProtoMessage() {}
+
+func (x *GetBansResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proxy_reflex_command_command_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetBansResponse.ProtoReflect.Descriptor instead.
+func (*GetBansResponse) Descriptor() ([]byte, []int) {
+	return file_proxy_reflex_command_command_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *GetBansResponse) GetBans() []*Ban {
+	if x != nil {
+		return x.Bans
+	}
+	return nil
+}
+
+type ClearBansRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Tag of the Reflex inbound.
+	Tag string `protobuf:"bytes,1,opt,name=tag,proto3" json:"tag,omitempty"`
+	// Sources to lift the bans of. All bans are lifted if empty.
+	Sources       []string `protobuf:"bytes,2,rep,name=sources,proto3" json:"sources,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ClearBansRequest) Reset() {
+	*x = ClearBansRequest{}
+	mi := &file_proxy_reflex_command_command_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ClearBansRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ClearBansRequest) ProtoMessage() {}
+
+func (x *ClearBansRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proxy_reflex_command_command_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ClearBansRequest.ProtoReflect.Descriptor instead.
+func (*ClearBansRequest) Descriptor() ([]byte, []int) {
+	return file_proxy_reflex_command_command_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ClearBansRequest) GetTag() string {
+	if x != nil {
+		return x.Tag
+	}
+	return ""
+}
+
+func (x *ClearBansRequest) GetSources() []string {
+	if x != nil {
+		return x.Sources
+	}
+	return nil
+}
+
+type ClearBansResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Number of bans lifted.
+	Count         uint32 `protobuf:"varint,1,opt,name=count,proto3" json:"count,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ClearBansResponse) Reset() {
+	*x = ClearBansResponse{}
+	mi := &file_proxy_reflex_command_command_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ClearBansResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ClearBansResponse) ProtoMessage() {}
+
+func (x *ClearBansResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proxy_reflex_command_command_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ClearBansResponse.ProtoReflect.Descriptor instead.
+func (*ClearBansResponse) Descriptor() ([]byte, []int) {
+	return file_proxy_reflex_command_command_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ClearBansResponse) GetCount() uint32 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+type Config struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Config) Reset() {
+	*x = Config{}
+	mi := &file_proxy_reflex_command_command_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Config) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Config) ProtoMessage() {}
+
+func (x *Config) ProtoReflect() protoreflect.Message {
+	mi := &file_proxy_reflex_command_command_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Config.ProtoReflect.Descriptor instead.
+func (*Config) Descriptor() ([]byte, []int) {
+	return file_proxy_reflex_command_command_proto_rawDescGZIP(), []int{5}
+}
+
+var File_proxy_reflex_command_command_proto protoreflect.FileDescriptor
+
+const file_proxy_reflex_command_command_proto_rawDesc = "" +
+	"\n" +
+	"\"proxy/reflex/command/command.proto\x12\x19xray.proxy.reflex.command\"V\n" +
+	"\x03Ban\x12\x16\n" +
+	"\x06source\x18\x01 \x01(\tR\x06source\x12\x1d\n" +
+	"\n" +
+	"expires_at\x18\x02 \x01(\x03R\texpiresAt\x12\x18\n" +
+	"\astrikes\x18\x03 \x01(\rR\astrikes\"\"\n" +
+	"\x0eGetBansRequest\x12\x10\n" +
+	"\x03tag\x18\x01 \x01(\tR\x03tag\"E\n" +
+	"\x0fGetBansResponse\x122\n" +
+	"\x04bans\x18\x01 \x03(\v2\x1e.xray.proxy.reflex.command.BanR\x04bans\">\n" +
+	"\x10ClearBansRequest\x12\x10\n" +
+	"\x03tag\x18\x01 \x01(\tR\x03tag\x12\x18\n" +
+	"\asources\x18\x02 \x03(\tR\asources\")\n" +
+	"\x11ClearBansResponse\x12\x14\n" +
+	"\x05count\x18\x01 \x01(\rR\x05count\"\b\n" +
+	"\x06Config2\xdd\x01\n" +
+	"\rReflexService\x12b\n" +
+	"\aGetBans\x12).xray.proxy.reflex.command.GetBansRequest\x1a*.xray.proxy.reflex.command.GetBansResponse\"\x00\x12h\n" +
+	"\tClearBans\x12+.xray.proxy.reflex.command.ClearBansRequest\x1a,.xray.proxy.reflex.command.ClearBansResponse\"\x00Bm\n" +
+	"\x1dcom.xray.proxy.reflex.commandP\x01Z.github.com/xtls/xray-core/proxy/reflex/command\xaa\x02\x19Xray.Proxy.Reflex.Commandb\x06proto3"
+
+var (
+	file_proxy_reflex_command_command_proto_rawDescOnce sync.Once
+	file_proxy_reflex_command_command_proto_rawDescData []byte
+)
+
+func file_proxy_reflex_command_command_proto_rawDescGZIP() []byte {
+	file_proxy_reflex_command_command_proto_rawDescOnce.Do(func() {
+		file_proxy_reflex_command_command_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_proxy_reflex_command_command_proto_rawDesc), len(file_proxy_reflex_command_command_proto_rawDesc)))
+	})
+	return file_proxy_reflex_command_command_proto_rawDescData
+}
+
+var file_proxy_reflex_command_command_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_proxy_reflex_command_command_proto_goTypes = []any{
+	(*Ban)(nil),               // 0: xray.proxy.reflex.command.Ban
+	(*GetBansRequest)(nil),    // 1: xray.proxy.reflex.command.GetBansRequest
+	(*GetBansResponse)(nil),   // 2: xray.proxy.reflex.command.GetBansResponse
+	(*ClearBansRequest)(nil),  // 3: xray.proxy.reflex.command.ClearBansRequest
+	(*ClearBansResponse)(nil), // 4: xray.proxy.reflex.command.ClearBansResponse
+	(*Config)(nil),            // 5: xray.proxy.reflex.command.Config
+}
+var file_proxy_reflex_command_command_proto_depIdxs = []int32{
+	0, // 0: xray.proxy.reflex.command.GetBansResponse.bans:type_name -> xray.proxy.reflex.command.Ban
+	1, // 1: xray.proxy.reflex.command.ReflexService.GetBans:input_type -> xray.proxy.reflex.command.GetBansRequest
+	3, // 2: xray.proxy.reflex.command.ReflexService.ClearBans:input_type -> xray.proxy.reflex.command.ClearBansRequest
+	2, // 3: xray.proxy.reflex.command.ReflexService.GetBans:output_type -> xray.proxy.reflex.command.GetBansResponse
+	4, // 4: xray.proxy.reflex.command.ReflexService.ClearBans:output_type -> xray.proxy.reflex.command.ClearBansResponse
+	3, // [3:5] is the sub-list for method output_type
+	1, // [1:3] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_proxy_reflex_command_command_proto_init() }
+func file_proxy_reflex_command_command_proto_init() {
+	if File_proxy_reflex_command_command_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proxy_reflex_command_command_proto_rawDesc), len(file_proxy_reflex_command_command_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   6,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_proxy_reflex_command_command_proto_goTypes,
+		DependencyIndexes: file_proxy_reflex_command_command_proto_depIdxs,
+		MessageInfos:      file_proxy_reflex_command_command_proto_msgTypes,
+	}.Build()
+	File_proxy_reflex_command_command_proto = out.File
+	file_proxy_reflex_command_command_proto_goTypes = nil
+	file_proxy_reflex_command_command_proto_depIdxs = nil
+}
@@ -0,0 +1,159 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.28.2
+// source: proxy/reflex/command/command.proto
+
+package command
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	ReflexService_GetBans_FullMethodName   = "/xray.proxy.reflex.command.ReflexService/GetBans"
+	ReflexService_ClearBans_FullMethodName = "/xray.proxy.reflex.command.ReflexService/ClearBans"
+)
+
+// ReflexServiceClient is the client API for ReflexService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ReflexServiceClient interface {
+	GetBans(ctx context.Context, in *GetBansRequest, opts ...grpc.CallOption) (*GetBansResponse, error)
+	ClearBans(ctx context.Context, in *ClearBansRequest, opts ...grpc.CallOption) (*ClearBansResponse, error)
+}
+
+type reflexServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewReflexServiceClient(cc grpc.ClientConnInterface) ReflexServiceClient {
+	return &reflexServiceClient{cc}
+}
+
+func (c *reflexServiceClient) GetBans(ctx context.Context, in *GetBansRequest, opts ...grpc.CallOption) (*GetBansResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetBansResponse)
+	err := c.cc.Invoke(ctx, ReflexService_GetBans_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *reflexServiceClient) ClearBans(ctx context.Context, in *ClearBansRequest, opts ...grpc.CallOption) (*ClearBansResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ClearBansResponse)
+	err := c.cc.Invoke(ctx, ReflexService_ClearBans_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ReflexServiceServer is the server API for ReflexService service.
+// All implementations must embed UnimplementedReflexServiceServer
+// for forward compatibility.
+type ReflexServiceServer interface {
+	GetBans(context.Context, *GetBansRequest) (*GetBansResponse, error)
+	ClearBans(context.Context, *ClearBansRequest) (*ClearBansResponse, error)
+	mustEmbedUnimplementedReflexServiceServer()
+}
+
+// UnimplementedReflexServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedReflexServiceServer struct{}
+
+func (UnimplementedReflexServiceServer) GetBans(context.Context, *GetBansRequest) (*GetBansResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetBans not implemented")
+}
+func (UnimplementedReflexServiceServer) ClearBans(context.Context, *ClearBansRequest) (*ClearBansResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ClearBans not implemented")
+}
+func (UnimplementedReflexServiceServer) mustEmbedUnimplementedReflexServiceServer() {}
+func (UnimplementedReflexServiceServer) testEmbeddedByValue()                       {}
+
+// UnsafeReflexServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ReflexServiceServer will
+// result in compilation errors.
+type UnsafeReflexServiceServer interface {
+	mustEmbedUnimplementedReflexServiceServer()
+}
+
+func RegisterReflexServiceServer(s grpc.ServiceRegistrar, srv ReflexServiceServer) {
+	// If the following call pancis, it indicates UnimplementedReflexServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&ReflexService_ServiceDesc, srv)
+}
+
+func _ReflexService_GetBans_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetBansRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReflexServiceServer).GetBans(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ReflexService_GetBans_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReflexServiceServer).GetBans(ctx, req.(*GetBansRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ReflexService_ClearBans_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ClearBansRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReflexServiceServer).ClearBans(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ReflexService_ClearBans_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReflexServiceServer).ClearBans(ctx, req.(*ClearBansRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// ReflexService_ServiceDesc is the grpc.ServiceDesc for ReflexService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ReflexService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "xray.proxy.reflex.command.ReflexService",
+	HandlerType: (*ReflexServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetBans",
+			Handler:    _ReflexService_GetBans_Handler,
+		},
+		{
+			MethodName: "ClearBans",
+			Handler:    _ReflexService_ClearBans_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "proxy/reflex/command/command.proto",
+}
@@ -0,0 +1,33 @@
+package encoding
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Clock is the local clock corrected by the offset to the server's clock,
+// learned from the timestamps of its handshake responses. Clients with a
+// wrong clock thus only need one handshake to get accepted by servers with a
+// wide enough skew window, and send timestamps within the normal window
+// from then on. The zero value is the uncorrected local clock.
+type Clock struct {
+	offset atomic.Int64 // seconds
+}
+
+// Now returns the current time on the server's clock, as far as it is known.
+func (c *Clock) Now() time.Time {
+	return time.Now().Add(time.Duration(c.offset.Load()) * time.Second)
+}
+
+// Offset returns the learned offset of the server's clock to the local one.
+func (c *Clock) Offset() time.Duration {
+	return time.Duration(c.offset.Load()) * time.Second
+}
+
+// Learn records the offset of the server's clock from the timestamp of a
+// handshake response to a hello sent at sent and answered at received, both
+// on the local clock. The server is assumed to have answered halfway through.
+func (c *Clock) Learn(serverTimestamp int64, sent, received time.Time) {
+	midpoint := sent.Add(received.Sub(sent) / 2)
+	c.offset.Store(serverTimestamp - midpoint.Unix())
+}
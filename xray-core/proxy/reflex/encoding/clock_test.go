@@ -0,0 +1,44 @@
+package encoding
+
+import (
+	"testing"
+	"time"
+)
+
+// TestClockLearn tests that the clock follows the offset of the server's
+// timestamps and corrects its time accordingly
+func TestClockLearn(t *testing.T) {
+	var clock Clock
+	if clock.Offset() != 0 {
+		t.Fatalf("new clock has offset %s", clock.Offset())
+	}
+
+	sent := time.Now()
+	received := sent.Add(2 * time.Second)
+	clock.Learn(sent.Add(time.Second).Unix()-3600, sent, received)
+	if clock.Offset() != -time.Hour {
+		t.Fatalf("expected offset -1h, got %s", clock.Offset())
+	}
+	if !ValidateTimestampWithin(clock.Now().Unix()+3600, 1) {
+		t.Fatal("corrected time does not follow the learned offset")
+	}
+
+	clock.Learn(time.Now().Unix(), time.Now(), time.Now())
+	if offset := clock.Offset(); offset < -time.Second || offset > time.Second {
+		t.Fatalf("expected the offset to be relearned, got %s", offset)
+	}
+}
+
+// TestValidateTimestampWithin tests the configurable timestamp window
+func TestValidateTimestampWithin(t *testing.T) {
+	now := time.Now().Unix()
+	if !ValidateTimestampWithin(now-600, 600) {
+		t.Fatal("timestamp at the boundary of a 600s window should be valid")
+	}
+	if ValidateTimestampWithin(now+602, 600) {
+		t.Fatal("timestamp beyond a 600s window should be invalid")
+	}
+	if TimestampSkew(now-30) < 30 || TimestampSkew(now+30) < 29 {
+		t.Fatal("skew should be the absolute difference to the local clock")
+	}
+}
@@ -0,0 +1,302 @@
+package encoding
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/xtls/xray-core/features/stats"
+)
+
+const (
+	// cellOverhead is the size of a cell that carries nothing: the length,
+	// type, payload length, authentication tag and at least one byte of
+	// padding, so that all cells use the padded layout.
+	cellOverhead = 2 + 4 + 16 + 1
+	// minCellSize is the smallest cell, so that each carries some data.
+	minCellSize = 64
+	// maxCoverQueue is the number of data bytes waiting for a cell after
+	// which writes block.
+	maxCoverQueue = 64 * 1024
+)
+
+// CoverConfig configures constant-rate cover traffic.
+type CoverConfig struct {
+	// Rate is the number of cells sent per second. 0 takes the delay before
+	// each cell from the traffic profile.
+	Rate uint32
+	// Size is the size of each cell on the wire, in bytes. 0 takes the size
+	// of each cell from the traffic profile.
+	Size uint32
+	// Budget caps the bytes sent per second by slowing the cadence down. 0
+	// means no cap.
+	Budget uint64
+	// IdleTimeout stops the cover traffic once no data has been sent or
+	// received for that long. Data starts it again. 0 means never.
+	IdleTimeout time.Duration
+	// Clock times the cells. nil is the system clock.
+	Clock TimeSource
+}
+
+// TimeSource tells the time and waits out the delays of cover traffic and
+// TLS shaping. Tests replace it to run them in virtual time.
+type TimeSource interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type timeSourceKey struct{}
+
+// ContextWithTimeSource returns a context in which the Reflex handlers
+// created time their cover traffic and TLS shaping with source.
+func ContextWithTimeSource(ctx context.Context, source TimeSource) context.Context {
+	return context.WithValue(ctx, timeSourceKey{}, source)
+}
+
+// TimeSourceFromContext returns the time source set in ctx, or nil for the
+// system clock.
+func TimeSourceFromContext(ctx context.Context) TimeSource {
+	source, _ := ctx.Value(timeSourceKey{}).(TimeSource)
+	return source
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// OrSystemClock returns source, or the system clock if source is nil.
+func OrSystemClock(source TimeSource) TimeSource {
+	if source == nil {
+		return systemClock{}
+	}
+	return source
+}
+
+// Cover is a FrameConn that sends constant-rate cover traffic: while active,
+// it writes one cell at each tick whether or not there is data, so that
+// neither idle gaps nor bursts show on the wire. DATA frames are cut and
+// merged to fill the cells and PADDING frames fill the ticks without data.
+// Every cell has the same size on the wire, or the profile's size for that
+// tick.
+//
+// Data is never sent faster than the cadence allows, so the rate and size
+// bound the throughput of the session.
+type Cover struct {
+	conn     FrameConn
+	config   CoverConfig
+	profile  *TrafficProfile
+	overhead stats.Counter
+	clock    TimeSource
+
+	mu           sync.Mutex
+	cond         *sync.Cond
+	queue        []*Frame
+	queued       int
+	active       bool
+	lastActivity time.Time
+	err          error
+	wake         chan struct{}
+	done         chan struct{}
+	once         sync.Once
+}
+
+// NewCover returns a Cover writing cells to conn. Cells are sized and timed
+// by profile where config leaves it to the profile. The bytes sent that are
+// not data are added to overhead, which may be nil.
+func NewCover(conn FrameConn, config CoverConfig, profile *TrafficProfile, overhead stats.Counter) *Cover {
+	if profile == nil {
+		profile = GetDefaultProfile()
+	}
+	c := &Cover{
+		conn:     conn,
+		config:   config,
+		profile:  profile,
+		overhead: overhead,
+		clock:    OrSystemClock(config.Clock),
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Start starts sending cells in the background. Cover traffic begins with
+// the first data.
+func (c *Cover) Start() error {
+	go c.run()
+	return nil
+}
+
+// Close sends what is still queued without waiting for the cadence, and
+// stops the cover traffic.
+func (c *Cover) Close() error {
+	c.once.Do(func() {
+		close(c.done)
+	})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.err == nil && len(c.queue) > 0 {
+		c.writeCellLocked(c.cellSize())
+	}
+	if c.err == nil {
+		c.err = io.ErrClosedPipe
+	}
+	c.cond.Broadcast()
+	return nil
+}
+
+// WriteFrame queues a frame for the next cells. Frames written while the
+// cover traffic is stopped are sent right away, unless they carry data,
+// which starts it again.
+func (c *Cover) WriteFrame(frame *Frame) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return c.err
+	}
+	if frame.Type == FrameTypeData {
+		c.activateLocked()
+	} else if !c.active {
+		return c.conn.WriteFrame(frame)
+	}
+
+	for c.err == nil && c.queued >= maxCoverQueue {
+		c.cond.Wait()
+	}
+	if c.err != nil {
+		return c.err
+	}
+	f := &Frame{Type: frame.Type, Payload: append([]byte(nil), frame.Payload...)}
+	c.queue = append(c.queue, f)
+	c.queued += len(f.Payload)
+	return nil
+}
+
+// ReadFrame reads the next frame. Received data keeps the cover traffic
+// going like sent data does.
+func (c *Cover) ReadFrame() (*Frame, error) {
+	frame, err := c.conn.ReadFrame()
+	if err == nil && frame.Type == FrameTypeData {
+		c.mu.Lock()
+		c.activateLocked()
+		c.mu.Unlock()
+	}
+	return frame, err
+}
+
+func (c *Cover) activateLocked() {
+	c.lastActivity = c.clock.Now()
+	if !c.active {
+		c.active = true
+		select {
+		case c.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// interval returns the time until the next cell of the given size.
+func (c *Cover) interval(size int) time.Duration {
+	var d time.Duration
+	if c.config.Rate > 0 {
+		d = time.Second / time.Duration(c.config.Rate)
+	} else {
+		d = c.profile.GetDelay()
+	}
+	if c.config.Budget > 0 {
+		d = max(d, time.Duration(uint64(size)*uint64(time.Second)/c.config.Budget))
+	}
+	return d
+}
+
+// cellSize returns the size of the next cell on the wire.
+func (c *Cover) cellSize() int {
+	size := int(c.config.Size)
+	if size == 0 {
+		size = c.profile.GetPacketSize()
+	}
+	return min(max(size, minCellSize), cellOverhead+MaxFramePayloadSize)
+}
+
+func (c *Cover) run() {
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-c.wake:
+		}
+
+		// The first cell goes out right away, later ones at the cadence
+		for {
+			c.mu.Lock()
+			size := c.cellSize()
+			idle := len(c.queue) == 0 && c.config.IdleTimeout > 0 &&
+				c.clock.Now().Sub(c.lastActivity) >= c.config.IdleTimeout
+			if idle {
+				c.active = false
+			} else {
+				c.writeCellLocked(size)
+			}
+			failed := c.err != nil
+			c.mu.Unlock()
+			if idle || failed {
+				break
+			}
+
+			select {
+			case <-c.done:
+				return
+			case <-c.clock.After(c.interval(size)):
+			}
+		}
+	}
+}
+
+// writeCellLocked writes one cell of the given size, filled with queued
+// frames or with padding.
+func (c *Cover) writeCellLocked(size int) {
+	capacity := size - cellOverhead
+	var cell *Frame
+	data := 0
+
+	switch {
+	case len(c.queue) == 0:
+		cell = &Frame{Type: FrameTypePadding}
+	case c.queue[0].Type == FrameTypeData:
+		// Merge as much queued data as fits
+		payload := make([]byte, 0, capacity)
+		for len(c.queue) > 0 && c.queue[0].Type == FrameTypeData && len(payload) < capacity {
+			head := c.queue[0]
+			n := min(len(head.Payload), capacity-len(payload))
+			payload = append(payload, head.Payload[:n]...)
+			head.Payload = head.Payload[n:]
+			if len(head.Payload) == 0 {
+				c.queue = c.queue[1:]
+			}
+		}
+		data = len(payload)
+		c.queued -= data
+		cell = &Frame{Type: FrameTypeData, Payload: payload}
+	default:
+		cell = c.queue[0]
+		c.queue = c.queue[1:]
+		c.queued -= len(cell.Payload)
+		switch cell.Type {
+		case FrameTypePing, FrameTypePong, FrameTypePadding, FrameTypeTiming:
+			// Filler frames are cut to the cell
+			cell.Payload = cell.Payload[:min(len(cell.Payload), capacity)]
+		}
+	}
+	cell.Padding = max(capacity+1-len(cell.Payload), 0)
+
+	if err := c.conn.WriteFrame(cell); err != nil {
+		c.err = err
+	} else if c.overhead != nil {
+		c.overhead.Add(int64(cell.EncodedSize() - data))
+	}
+	c.cond.Broadcast()
+}
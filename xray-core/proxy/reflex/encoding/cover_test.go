@@ -0,0 +1,232 @@
+package encoding
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/app/stats"
+)
+
+// fakeClock is a clock whose time only moves when the test advances it.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []fakeTimer
+}
+
+type fakeTimer struct {
+	at time.Time
+	c  chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1700000000, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	c.timers = append(c.timers, fakeTimer{at: c.now.Add(d), c: ch})
+	return ch
+}
+
+// Advance moves the time forward by d, firing the timers that expire.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	pending := c.timers[:0]
+	for _, timer := range c.timers {
+		if timer.at.After(c.now) {
+			pending = append(pending, timer)
+		} else {
+			timer.c <- c.now
+		}
+	}
+	c.timers = pending
+}
+
+// waitTimer waits for the cover to arm its next timer, so that advancing the
+// clock is seen by it.
+func (c *fakeClock) waitTimer(t *testing.T) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		c.mu.Lock()
+		armed := len(c.timers) > 0
+		c.mu.Unlock()
+		if armed {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("cover did not arm a timer")
+}
+
+// cellRecorder is a FrameConn that records the frames written to it.
+type cellRecorder struct {
+	cells chan *Frame
+}
+
+func (r *cellRecorder) WriteFrame(frame *Frame) error {
+	r.cells <- &Frame{Type: frame.Type, Payload: bytes.Clone(frame.Payload), Padding: frame.Padding}
+	return nil
+}
+
+func (r *cellRecorder) ReadFrame() (*Frame, error) {
+	select {}
+}
+
+// expectCell waits for the next cell and checks its size on the wire.
+func (r *cellRecorder) expectCell(t *testing.T, size int) *Frame {
+	t.Helper()
+	select {
+	case cell := <-r.cells:
+		if cell.EncodedSize() != size {
+			t.Fatalf("cell of type %d is %d bytes on the wire, expected %d", cell.Type, cell.EncodedSize(), size)
+		}
+		return cell
+	case <-time.After(time.Second):
+		t.Fatal("no cell was sent")
+		return nil
+	}
+}
+
+// expectNoCell checks that nothing is sent until the clock moves.
+func (r *cellRecorder) expectNoCell(t *testing.T) {
+	t.Helper()
+	select {
+	case cell := <-r.cells:
+		t.Fatalf("unexpected cell of type %d", cell.Type)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func newTestCover(config CoverConfig) (*Cover, *fakeClock, *cellRecorder, *stats.Counter) {
+	recorder := &cellRecorder{cells: make(chan *Frame, 64)}
+	overhead := new(stats.Counter)
+	clock := newFakeClock()
+	config.Clock = clock
+	cover := NewCover(recorder, config, nil, overhead)
+	cover.Start()
+	return cover, clock, recorder, overhead
+}
+
+// TestCoverFixedCadence tests that cells of the configured size leave at the
+// configured rate, carrying data while there is some and padding after
+func TestCoverFixedCadence(t *testing.T) {
+	cover, clock, recorder, overhead := newTestCover(CoverConfig{Rate: 10, Size: 256})
+	defer cover.Close()
+
+	payload := make([]byte, 1000)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	if err := cover.WriteFrame(&Frame{Type: FrameTypeData, Payload: payload}); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+
+	var received []byte
+	for i := 0; i < 8; i++ {
+		if i > 0 {
+			clock.waitTimer(t)
+			recorder.expectNoCell(t)
+			clock.Advance(99 * time.Millisecond)
+			recorder.expectNoCell(t)
+			clock.Advance(time.Millisecond)
+		}
+		cell := recorder.expectCell(t, 256)
+		switch {
+		case len(received) < len(payload):
+			if cell.Type != FrameTypeData {
+				t.Fatalf("cell %d has type %d while data is queued", i, cell.Type)
+			}
+			received = append(received, cell.Payload...)
+		case cell.Type != FrameTypePadding:
+			t.Fatalf("cell %d has type %d after the data was sent", i, cell.Type)
+		}
+	}
+	if !bytes.Equal(received, payload) {
+		t.Fatal("data was not carried intact by the cells")
+	}
+	if expected := int64(8*256 - len(payload)); overhead.Value() != expected {
+		t.Fatalf("overhead is %d bytes, expected %d", overhead.Value(), expected)
+	}
+}
+
+// TestCoverBudget tests that the bandwidth budget slows the cadence down
+func TestCoverBudget(t *testing.T) {
+	cover, clock, recorder, _ := newTestCover(CoverConfig{Rate: 100, Size: 1000, Budget: 10000})
+	defer cover.Close()
+
+	cover.WriteFrame(&Frame{Type: FrameTypeData, Payload: []byte("hello")})
+	recorder.expectCell(t, 1000)
+
+	for i := 0; i < 3; i++ {
+		clock.waitTimer(t)
+		clock.Advance(99 * time.Millisecond)
+		recorder.expectNoCell(t)
+		clock.Advance(time.Millisecond)
+		recorder.expectCell(t, 1000)
+	}
+}
+
+// TestCoverStopsWhenIdle tests that cover traffic stops after the idle
+// timeout, lets control frames through unshaped, and starts again with data
+func TestCoverStopsWhenIdle(t *testing.T) {
+	cover, clock, recorder, _ := newTestCover(CoverConfig{Rate: 10, Size: 128, IdleTimeout: 300 * time.Millisecond})
+	defer cover.Close()
+
+	cover.WriteFrame(&Frame{Type: FrameTypeData, Payload: []byte("hello")})
+	recorder.expectCell(t, 128)
+	for i := 0; i < 2; i++ {
+		clock.waitTimer(t)
+		clock.Advance(100 * time.Millisecond)
+		recorder.expectCell(t, 128)
+	}
+	clock.waitTimer(t)
+	clock.Advance(100 * time.Millisecond)
+	recorder.expectNoCell(t)
+	clock.Advance(time.Second)
+	recorder.expectNoCell(t)
+
+	ping := NewControlFrame(FrameTypePing, ZoomProfile)
+	cover.WriteFrame(ping)
+	if cell := recorder.expectCell(t, ping.EncodedSize()); cell.Type != FrameTypePing {
+		t.Fatalf("expected PING frame, got type %d", cell.Type)
+	}
+
+	cover.WriteFrame(&Frame{Type: FrameTypeData, Payload: []byte("again")})
+	if cell := recorder.expectCell(t, 128); string(cell.Payload) != "again" {
+		t.Fatalf("expected data cell, got %q", cell.Payload)
+	}
+}
+
+// TestCoverCloseFlushes tests that Close sends the queued frames without
+// waiting for the cadence
+func TestCoverCloseFlushes(t *testing.T) {
+	cover, _, recorder, _ := newTestCover(CoverConfig{Rate: 1, Size: 64})
+
+	cover.WriteFrame(&Frame{Type: FrameTypeData, Payload: make([]byte, 100)})
+	cover.WriteFrame(NewCloseFrame(CloseReasonFin))
+	recorder.expectCell(t, 64)
+	cover.Close()
+
+	recorder.expectCell(t, 64)
+	recorder.expectCell(t, 64)
+	if cell := recorder.expectCell(t, 64); cell.Type != FrameTypeClose {
+		t.Fatalf("expected CLOSE frame last, got type %d", cell.Type)
+	}
+	if err := cover.WriteFrame(&Frame{Type: FrameTypeData}); err == nil {
+		t.Fatal("write succeeded after Close")
+	}
+}
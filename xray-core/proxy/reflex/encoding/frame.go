@@ -9,6 +9,8 @@ import (
 	"time"
 
 	"golang.org/x/crypto/chacha20poly1305"
+
+	"github.com/xtls/xray-core/features/stats"
 )
 
 // Frame types
@@ -17,13 +19,114 @@ const (
 	FrameTypePadding    byte = 0x02  // PADDING_CTRL frame
 	FrameTypeTiming     byte = 0x03  // TIMING_CTRL frame
 	FrameTypeClose      byte = 0x04  // CLOSE frame
+	FrameTypePing       byte = 0x05  // PING keepalive frame
+	FrameTypePong       byte = 0x06  // PONG keepalive reply
+	FrameTypeAck        byte = 0x07  // ACK of the DATA and CLOSE frames received on a resumable session
+	FrameTypeResume     byte = 0x08  // RESUME offer from the client, or grant from the server
+	FrameTypePolicy     byte = 0x09  // POLICY request from the client, or grant from the server
 	MaxFramePayloadSize int  = 16384 // Maximum payload size (16KB)
 )
 
+// CLOSE frame reasons. A CLOSE frame ends one direction of a session.
+const (
+	CloseReasonFin byte = 0x00 // sender finished writing, the other direction stays open
+	CloseReasonRst byte = 0x01 // sender aborted the whole session
+)
+
+// framePadded replaces the type byte of a frame whose payload is followed by
+// padding. The actual type and the payload length follow it, and the padding
+// is hidden by the encryption: [0x80] [type] [length(2)] [payload] [padding]
+const framePadded byte = 0x80
+
 // Frame represents a Reflex protocol frame
 type Frame struct {
 	Type    byte
 	Payload []byte
+	// Padding is the number of filler bytes sent after the payload inside
+	// the encryption, making the frame larger on the wire without changing
+	// what the peer reads. Decoded frames never have padding.
+	Padding int
+}
+
+// plaintextSize returns the size of the frame before encryption.
+func (f *Frame) plaintextSize() int {
+	if f.Padding > 0 {
+		return 4 + len(f.Payload) + f.Padding
+	}
+	return 1 + len(f.Payload)
+}
+
+// marshal writes the frame before encryption to b, which is plaintextSize
+// bytes long.
+func (f *Frame) marshal(b []byte) {
+	if f.Padding > 0 {
+		b[0] = framePadded
+		b[1] = f.Type
+		binary.BigEndian.PutUint16(b[2:4], uint16(len(f.Payload)))
+		n := copy(b[4:], f.Payload)
+		clear(b[4+n:])
+		return
+	}
+	b[0] = f.Type
+	copy(b[1:], f.Payload)
+}
+
+// EncodedSize returns the size of the frame on the wire.
+func (f *Frame) EncodedSize() int {
+	return 2 + f.plaintextSize() + 16
+}
+
+// NewCloseFrame creates a CLOSE frame with the given reason
+func NewCloseFrame(reason byte) *Frame {
+	return &Frame{
+		Type:    FrameTypeClose,
+		Payload: []byte{reason},
+	}
+}
+
+// IsReset reports whether a CLOSE frame aborts the session instead of
+// half-closing it. CLOSE frames without a reason are treated as FIN.
+func IsReset(frame *Frame) bool {
+	return len(frame.Payload) > 0 && frame.Payload[0] == CloseReasonRst
+}
+
+// FrameWriter writes the frames of a session.
+type FrameWriter interface {
+	WriteFrame(frame *Frame) error
+}
+
+// FrameConn reads and writes the frames of a session.
+type FrameConn interface {
+	FrameWriter
+	// ReadFrame reads the next frame. Its payload belongs to the caller, who
+	// returns the frame with PutFrame.
+	ReadFrame() (*Frame, error)
+}
+
+type frameConn struct {
+	encoder *FrameEncoder
+	w       io.Writer
+	decoder *FrameDecoder
+	r       io.Reader
+}
+
+// NewFrameConn returns a FrameConn writing frames to w with encoder and
+// reading them from r with decoder.
+func NewFrameConn(encoder *FrameEncoder, w io.Writer, decoder *FrameDecoder, r io.Reader) FrameConn {
+	return &frameConn{
+		encoder: encoder,
+		w:       w,
+		decoder: decoder,
+		r:       r,
+	}
+}
+
+func (c *frameConn) WriteFrame(frame *Frame) error {
+	return c.encoder.WriteFrame(c.w, frame)
+}
+
+func (c *frameConn) ReadFrame() (*Frame, error) {
+	return c.decoder.ReadFrame(c.r)
 }
 
 // FrameEncoder encodes and encrypts frames
@@ -32,6 +135,37 @@ type FrameEncoder struct {
 	nonce   []byte
 	counter uint64
 	mu      sync.Mutex // Protects nonce and counter access
+
+	dataBytes    stats.Counter
+	paddingBytes stats.Counter
+}
+
+// CountBytes makes the encoder add the payload size of each DATA frame to
+// data, and of each PING, PONG, PADDING and TIMING frame to padding, as those
+// only carry filler. Frame padding also counts as padding. Either counter may
+// be nil.
+func (e *FrameEncoder) CountBytes(data, padding stats.Counter) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.dataBytes = data
+	e.paddingBytes = padding
+}
+
+// count updates the byte counters for a frame. Callers hold e.mu.
+func (e *FrameEncoder) count(frame *Frame) {
+	switch frame.Type {
+	case FrameTypeData:
+		if e.dataBytes != nil {
+			e.dataBytes.Add(int64(len(frame.Payload)))
+		}
+		if e.paddingBytes != nil && frame.Padding > 0 {
+			e.paddingBytes.Add(int64(frame.Padding))
+		}
+	case FrameTypePing, FrameTypePong, FrameTypePadding, FrameTypeTiming:
+		if e.paddingBytes != nil {
+			e.paddingBytes.Add(int64(len(frame.Payload) + frame.Padding))
+		}
+	}
 }
 
 // NewFrameEncoder creates a new frame encoder with the session key
@@ -63,12 +197,11 @@ func (e *FrameEncoder) Encode(frame *Frame) ([]byte, error) {
 	binary.LittleEndian.PutUint64(nonce, e.counter)
 
 	// Get pooled buffer for plaintext: [type(1)] + [payload]
-	plaintextSize := 1 + len(frame.Payload)
+	plaintextSize := frame.plaintextSize()
 	plaintext := GetFrameBuffer(plaintextSize)
 	defer PutFrameBuffer(plaintext)
 
-	plaintext[0] = frame.Type
-	copy(plaintext[1:], frame.Payload)
+	frame.marshal(plaintext)
 
 	// Get pooled buffer for ciphertext (plaintext + 16-byte authentication tag)
 	ciphertextCapacity := plaintextSize + 16
@@ -85,6 +218,7 @@ func (e *FrameEncoder) Encode(frame *Frame) ([]byte, error) {
 	binary.BigEndian.PutUint16(frameData[0:2], uint16(len(ciphertext)))
 	copy(frameData[2:], ciphertext)
 
+	e.count(frame)
 	return frameData[:frameDataSize], nil
 }
 
@@ -102,12 +236,11 @@ func (e *FrameEncoder) EncodeToWriter(w io.Writer, frame *Frame) error {
 	binary.LittleEndian.PutUint64(nonce, e.counter)
 
 	// Get pooled buffer for plaintext: [type(1)] + [payload]
-	plaintextSize := 1 + len(frame.Payload)
+	plaintextSize := frame.plaintextSize()
 	plaintext := GetFrameBuffer(plaintextSize)
 	defer PutFrameBuffer(plaintext)
 
-	plaintext[0] = frame.Type
-	copy(plaintext[1:], frame.Payload)
+	frame.marshal(plaintext)
 
 	// Get pooled buffer for ciphertext (plaintext + 16-byte authentication tag)
 	ciphertextCapacity := plaintextSize + 16
@@ -126,8 +259,11 @@ func (e *FrameEncoder) EncodeToWriter(w io.Writer, frame *Frame) error {
 	copy(frameData[2:], ciphertext)
 
 	// Write directly from pooled buffer
-	_, err := w.Write(frameData[:frameDataSize])
-	return err
+	if _, err := w.Write(frameData[:frameDataSize]); err != nil {
+		return err
+	}
+	e.count(frame)
+	return nil
 }
 
 // WriteFrame writes an encoded frame to a writer
@@ -199,6 +335,16 @@ func (d *FrameDecoder) Decode(data []byte) (*Frame, error) {
 	frame := GetFrame()
 
 	frame.Type = plaintext[0]
+	if frame.Type == framePadded {
+		if len(plaintext) < 4 || int(binary.BigEndian.Uint16(plaintext[2:4])) > len(plaintext)-4 {
+			PutFrame(frame)
+			return nil, newError("invalid padded frame")
+		}
+		frame.Type = plaintext[1]
+		// Drop the padding and the header, so that the payload still starts
+		// at plaintext[1]
+		plaintext = plaintext[3 : 4+binary.BigEndian.Uint16(plaintext[2:4])]
+	}
 
 	// CRITICAL: Copy payload data since plaintext buffer will be returned to pool
 	payloadSize := len(plaintext) - 1
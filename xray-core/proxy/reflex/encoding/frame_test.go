@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"io"
 	"testing"
+
+	"github.com/xtls/xray-core/app/stats"
 )
 
 // Helper function to encode frames in tests (handles error)
@@ -463,3 +465,104 @@ func TestFrameSizeWithEncryption(t *testing.T) {
 		t.Fatalf("encrypted size should be at least %d, got %d", minEncryptedSize, len(encoded))
 	}
 }
+
+// TestCloseFrameReason verifies CLOSE frames carry FIN or RST through encryption
+func TestCloseFrameReason(t *testing.T) {
+	var sessionKey [32]byte
+	encoder, _ := NewFrameEncoder(sessionKey[:])
+	decoder, _ := NewFrameDecoder(sessionKey[:])
+
+	tests := []struct {
+		name  string
+		frame *Frame
+		reset bool
+	}{
+		{"FIN", NewCloseFrame(CloseReasonFin), false},
+		{"RST", NewCloseFrame(CloseReasonRst), true},
+		{"no reason", &Frame{Type: FrameTypeClose}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded := encodeFrame(t, encoder, tt.frame)
+			decoded, err := decoder.Decode(encoded)
+			if err != nil {
+				t.Fatalf("Decode failed: %v", err)
+			}
+			if decoded.Type != FrameTypeClose {
+				t.Fatalf("expected CLOSE frame, got type %d", decoded.Type)
+			}
+			if IsReset(decoded) != tt.reset {
+				t.Fatalf("expected reset %v, got %v", tt.reset, IsReset(decoded))
+			}
+		})
+	}
+}
+
+// TestFrameEncoderCountBytes tests that data and filler frames are counted
+// separately
+func TestFrameEncoderCountBytes(t *testing.T) {
+	encoder, err := NewFrameEncoder(make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, padding := new(stats.Counter), new(stats.Counter)
+	encoder.CountBytes(data, padding)
+
+	frames := []*Frame{
+		{Type: FrameTypeData, Payload: make([]byte, 100)},
+		NewControlFrame(FrameTypePing, ZoomProfile),
+		{Type: FrameTypePadding, Payload: make([]byte, 30)},
+		NewCloseFrame(CloseReasonFin),
+	}
+	var out bytes.Buffer
+	for _, frame := range frames {
+		if err := encoder.WriteFrame(&out, frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	PutFrameBuffer(encodeFrame(t, encoder, &Frame{Type: FrameTypeData, Payload: make([]byte, 20)}))
+
+	if data.Value() != 120 {
+		t.Errorf("expected 120 data bytes, got %d", data.Value())
+	}
+	if expected := int64(len(frames[1].Payload) + 30); padding.Value() != expected {
+		t.Errorf("expected %d padding bytes, got %d", expected, padding.Value())
+	}
+}
+
+// TestPaddedFrameRoundTrip tests that frame padding changes the size on the
+// wire but not what the peer reads
+func TestPaddedFrameRoundTrip(t *testing.T) {
+	key := make([]byte, 32)
+	encoder, _ := NewFrameEncoder(key)
+	decoder, _ := NewFrameDecoder(key)
+
+	frames := []*Frame{
+		{Type: FrameTypeData, Payload: []byte("hello"), Padding: 100},
+		{Type: FrameTypePadding, Padding: 50},
+		{Type: FrameTypeClose, Payload: []byte{CloseReasonFin}, Padding: 1},
+	}
+	var out bytes.Buffer
+	for _, frame := range frames {
+		if err := encoder.WriteFrame(&out, frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if expected := frames[0].EncodedSize() + frames[1].EncodedSize() + frames[2].EncodedSize(); out.Len() != expected {
+		t.Fatalf("wrote %d bytes, expected %d", out.Len(), expected)
+	}
+	if frames[0].EncodedSize() != 2+4+5+100+16 {
+		t.Fatalf("padded frame is %d bytes on the wire", frames[0].EncodedSize())
+	}
+
+	for _, expected := range frames {
+		frame, err := decoder.ReadFrame(&out)
+		if err != nil {
+			t.Fatalf("failed to read frame: %v", err)
+		}
+		if frame.Type != expected.Type || !bytes.Equal(frame.Payload, expected.Payload) || frame.Padding != 0 {
+			t.Fatalf("read type %d payload %q padding %d, expected type %d payload %q", frame.Type, frame.Payload, frame.Padding, expected.Type, expected.Payload)
+		}
+	}
+}
@@ -18,6 +18,10 @@ import (
 const (
 	// ReflexMagic is the magic number for Reflex protocol
 	ReflexMagic = 0x5246584C // "REFX" in ASCII
+
+	// TimestampTolerance is the maximum difference in seconds between the
+	// handshake timestamp and the local clock
+	TimestampTolerance = 120
 )
 
 // ClientHandshake represents the client's initial handshake packet
@@ -120,12 +124,23 @@ func DecodeServerHandshake(data []byte) (*ServerHandshake, error) {
 
 // ValidateTimestamp checks if the timestamp is within acceptable range (±120 seconds)
 func ValidateTimestamp(timestamp int64) bool {
-	now := time.Now().Unix()
-	diff := now - timestamp
+	return ValidateTimestampWithin(timestamp, TimestampTolerance)
+}
+
+// ValidateTimestampWithin checks if the timestamp is within tolerance seconds
+// of the local clock
+func ValidateTimestampWithin(timestamp int64, tolerance int64) bool {
+	return TimestampSkew(timestamp) <= tolerance
+}
+
+// TimestampSkew returns the difference in seconds between the timestamp and
+// the local clock
+func TimestampSkew(timestamp int64) int64 {
+	diff := time.Now().Unix() - timestamp
 	if diff < 0 {
 		diff = -diff
 	}
-	return diff <= 120 // 2 minutes tolerance
+	return diff
 }
 
 // UUIDToBytes converts a protocol.ID to [16]byte array
@@ -0,0 +1,102 @@
+package encoding
+
+import (
+	"crypto/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// KeepAlive periodically sends encrypted PING frames on a session so that
+// NAT mappings stay open, and reports the peer as dead when a whole interval
+// passes without any frame coming back.
+type KeepAlive struct {
+	writer   FrameWriter
+	profile  *TrafficProfile
+	interval time.Duration
+	onDead   func()
+
+	alive atomic.Bool
+	done  chan struct{}
+	once  sync.Once
+}
+
+// NewKeepAlive creates a keepalive for the session written through w. PING
+// payloads are sized by profile. onDead is called at most once.
+func NewKeepAlive(w FrameWriter, profile *TrafficProfile, interval time.Duration, onDead func()) *KeepAlive {
+	k := &KeepAlive{
+		writer:   w,
+		profile:  profile,
+		interval: interval,
+		onDead:   onDead,
+		done:     make(chan struct{}),
+	}
+	k.alive.Store(true)
+	return k
+}
+
+// Start starts sending PING frames in the background. It does nothing if the
+// interval is zero.
+func (k *KeepAlive) Start() error {
+	if k.interval > 0 {
+		go k.run()
+	}
+	return nil
+}
+
+// Close stops sending PING frames.
+func (k *KeepAlive) Close() error {
+	k.once.Do(func() {
+		close(k.done)
+	})
+	return nil
+}
+
+// Profile returns the morphing profile used to size control frames.
+func (k *KeepAlive) Profile() *TrafficProfile {
+	return k.profile
+}
+
+// Alive records that a frame has been received from the peer.
+func (k *KeepAlive) Alive() {
+	k.alive.Store(true)
+}
+
+func (k *KeepAlive) run() {
+	ticker := time.NewTicker(k.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-k.done:
+			return
+		case <-ticker.C:
+		}
+
+		if !k.alive.Swap(false) {
+			k.Close()
+			if k.onDead != nil {
+				k.onDead()
+			}
+			return
+		}
+		if err := k.writer.WriteFrame(NewControlFrame(FrameTypePing, k.profile)); err != nil {
+			k.Close()
+			return
+		}
+	}
+}
+
+// NewControlFrame creates a PING or PONG frame filled with random bytes, so
+// that its size follows profile like regular morphed traffic does.
+func NewControlFrame(frameType byte, profile *TrafficProfile) *Frame {
+	if profile == nil {
+		profile = GetDefaultProfile()
+	}
+	payload := make([]byte, profile.GetPacketSize())
+	rand.Read(payload)
+	return &Frame{
+		Type:    frameType,
+		Payload: payload,
+	}
+}
@@ -0,0 +1,121 @@
+package encoding
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// TestKeepAliveSendsPing tests that PING frames are sent at the configured
+// interval and are sized by the traffic profile
+func TestKeepAliveSendsPing(t *testing.T) {
+	sessionKey := make([]byte, 32)
+	encoder, _ := NewFrameEncoder(sessionKey)
+	decoder, _ := NewFrameDecoder(sessionKey)
+
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	keepAlive := NewKeepAlive(NewFrameConn(encoder, client, nil, nil), ZoomProfile, 20*time.Millisecond, func() {
+		t.Error("peer reported dead while answering")
+	})
+	keepAlive.Start()
+	defer keepAlive.Close()
+
+	for i := 0; i < 3; i++ {
+		frame, err := decoder.ReadFrame(server)
+		if err != nil {
+			t.Fatalf("failed to read frame: %v", err)
+		}
+		if frame.Type != FrameTypePing {
+			t.Fatalf("expected PING frame, got type %d", frame.Type)
+		}
+		switch len(frame.Payload) {
+		case 500, 600, 700:
+		default:
+			t.Fatalf("PING payload size %d is not a Zoom profile size", len(frame.Payload))
+		}
+		keepAlive.Alive()
+	}
+}
+
+// TestKeepAliveDetectsDeadPeer tests that onDead is called when no frame
+// arrives for a whole interval
+func TestKeepAliveDetectsDeadPeer(t *testing.T) {
+	sessionKey := make([]byte, 32)
+	encoder, _ := NewFrameEncoder(sessionKey)
+	decoder, _ := NewFrameDecoder(sessionKey)
+
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	// Drain PING frames without ever answering
+	go func() {
+		for {
+			if _, err := decoder.ReadFrame(server); err != nil {
+				return
+			}
+		}
+	}()
+
+	dead := make(chan struct{})
+	keepAlive := NewKeepAlive(NewFrameConn(encoder, client, nil, nil), nil, 20*time.Millisecond, func() {
+		close(dead)
+	})
+	keepAlive.Start()
+	defer keepAlive.Close()
+
+	select {
+	case <-dead:
+	case <-time.After(time.Second):
+		t.Fatal("dead peer was not detected")
+	}
+}
+
+// TestKeepAliveDisabled tests that a zero interval sends nothing
+func TestKeepAliveDisabled(t *testing.T) {
+	sessionKey := make([]byte, 32)
+	encoder, _ := NewFrameEncoder(sessionKey)
+
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	keepAlive := NewKeepAlive(NewFrameConn(encoder, client, nil, nil), nil, 0, func() {
+		t.Error("disabled keepalive reported dead peer")
+	})
+	keepAlive.Start()
+	defer keepAlive.Close()
+
+	server.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	b := make([]byte, 1)
+	if n, err := server.Read(b); err == nil || n != 0 {
+		t.Fatal("disabled keepalive wrote data")
+	}
+}
+
+// TestControlFrameRoundTrip tests PONG frames survive encryption
+func TestControlFrameRoundTrip(t *testing.T) {
+	sessionKey := make([]byte, 32)
+	encoder, _ := NewFrameEncoder(sessionKey)
+	decoder, _ := NewFrameDecoder(sessionKey)
+
+	encoded, err := encoder.Encode(NewControlFrame(FrameTypePong, YouTubeProfile))
+	if err != nil {
+		t.Fatalf("failed to encode: %v", err)
+	}
+	defer PutFrameBuffer(encoded)
+
+	frame, err := decoder.Decode(encoded)
+	if err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	if frame.Type != FrameTypePong {
+		t.Fatalf("expected PONG frame, got type %d", frame.Type)
+	}
+	if len(frame.Payload) < 800 || len(frame.Payload) > 1400 {
+		t.Fatalf("PONG payload size %d is not a YouTube profile size", len(frame.Payload))
+	}
+}
@@ -2,6 +2,8 @@ package encoding
 
 import (
 	"math/rand"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 )
@@ -117,6 +119,31 @@ func (p *TrafficProfile) GetDelay() time.Duration {
 	return p.Delays[len(p.Delays)-1].Delay
 }
 
+// Clone returns a copy of the profile that can be updated without affecting
+// p, for sessions whose profile may change once the server grants one.
+func (p *TrafficProfile) Clone() *TrafficProfile {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return &TrafficProfile{
+		Name:        p.Name,
+		PacketSizes: slices.Clone(p.PacketSizes),
+		Delays:      slices.Clone(p.Delays),
+	}
+}
+
+// Update makes p draw its sizes and delays from the distributions of other.
+func (p *TrafficProfile) Update(other *TrafficProfile) {
+	clone := other.Clone()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.Name = clone.Name
+	p.PacketSizes = clone.PacketSizes
+	p.Delays = clone.Delays
+}
+
 // AddPadding adds random padding to reach target size
 func AddPadding(data []byte, targetSize int) []byte {
 	if len(data) >= targetSize {
@@ -139,7 +166,7 @@ func AddPadding(data []byte, targetSize int) []byte {
 // GetProfileByName returns a profile by its name
 // If name is empty or not found, defaults to HTTP/2 API profile
 func GetProfileByName(name string) *TrafficProfile {
-	switch name {
+	switch strings.TrimPrefix(name, "mimic-") {
 	case "youtube":
 		return YouTubeProfile
 	case "zoom":
@@ -155,6 +182,35 @@ func GetProfileByName(name string) *TrafficProfile {
 	}
 }
 
+// ProfileNames lists the profile names accepted by GetProfileByName.
+var ProfileNames = []string{"youtube", "zoom", "http2-api"}
+
+// IsProfileName returns whether name selects a known profile. The empty
+// string and "default" select the default profile, and names may carry a
+// "mimic-" prefix as in "mimic-youtube".
+func IsProfileName(name string) bool {
+	switch strings.TrimPrefix(name, "mimic-") {
+	case "", "default", "youtube", "zoom", "http2-api":
+		return true
+	}
+	return false
+}
+
+// ProfileKey returns the policy name that selects profile, or a copy of it,
+// for use in counter names and policy grants.
+func ProfileKey(profile *TrafficProfile) string {
+	profile.mu.Lock()
+	profileName := profile.Name
+	profile.mu.Unlock()
+
+	for _, name := range ProfileNames {
+		if GetProfileByName(name).Name == profileName {
+			return name
+		}
+	}
+	return strings.ToLower(strings.ReplaceAll(profileName, " ", "-"))
+}
+
 // MorphingConfig holds morphing configuration
 type MorphingConfig struct {
 	Enabled bool
@@ -0,0 +1,168 @@
+package encoding
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Padded handshakes append random-length padding to the hello and the
+// response, so that the first two packets of a connection do not have a
+// constant size. Their fixed part is that of a plain handshake, followed by
+// the 2-byte length of the padding and the padding itself:
+//
+//	hello:    [magic(4)][public key(32)][user ID(16)][timestamp(8)][nonce(16)][length(2)][padding]
+//	response: [public key(32)][timestamp(8)][length(2)][padding]
+//
+// The padding lengths cannot be authenticated before the key exchange, so
+// both messages are hashed into the salt of the session key instead: a
+// length or padding modified on the way yields different session keys on
+// both ends, and the session fails on its first frame.
+//
+// Padding hides sizes, not the protocol: the magic of a padded hello is as
+// constant as that of a plain one, and the user ID follows in the clear.
+const (
+	// ReflexPaddedMagic starts a padded hello
+	ReflexPaddedMagic = 0x52465850 // "RFXP" in ASCII
+
+	// ClientHandshakeSize is the size of a plain hello
+	ClientHandshakeSize = 76
+	// ServerHandshakeSize is the size of a plain response
+	ServerHandshakeSize = 40
+	// PaddedClientHandshakeHeaderSize is the size of a padded hello up to
+	// its padding
+	PaddedClientHandshakeHeaderSize = ClientHandshakeSize + 2
+	// PaddedServerHandshakeHeaderSize is the size of a padded response up
+	// to its padding
+	PaddedServerHandshakeHeaderSize = ServerHandshakeSize + 2
+
+	// MaxHandshakePadding is the maximum length of the padding of a hello
+	// or response
+	MaxHandshakePadding = 1500
+)
+
+// HandshakePadding returns the length of the padding that brings a handshake
+// message of size bytes to a packet size drawn from profile.
+func HandshakePadding(profile *TrafficProfile, size int) int {
+	padding := profile.GetPacketSize() - size
+	if padding < 0 {
+		return 0
+	}
+	if padding > MaxHandshakePadding {
+		return MaxHandshakePadding
+	}
+	return padding
+}
+
+// EncodePaddedClientHandshake encodes a hello followed by padding random
+// bytes.
+func EncodePaddedClientHandshake(hs *ClientHandshake, padding int) ([]byte, error) {
+	if padding < 0 || padding > MaxHandshakePadding {
+		return nil, errors.New("invalid handshake padding")
+	}
+	plain := EncodeClientHandshake(hs)
+	defer PutClientHandshakeBuffer(plain)
+
+	buf := make([]byte, PaddedClientHandshakeHeaderSize+padding)
+	copy(buf, plain)
+	binary.BigEndian.PutUint32(buf[0:4], ReflexPaddedMagic)
+	binary.BigEndian.PutUint16(buf[ClientHandshakeSize:], uint16(padding))
+	if _, err := rand.Read(buf[PaddedClientHandshakeHeaderSize:]); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// PaddedClientHandshakeSize returns the size of a padded hello, given at
+// least its first PaddedClientHandshakeHeaderSize bytes.
+func PaddedClientHandshakeSize(header []byte) (int, error) {
+	if len(header) < PaddedClientHandshakeHeaderSize {
+		return 0, errors.New("handshake packet too short")
+	}
+	if binary.BigEndian.Uint32(header[0:4]) != ReflexPaddedMagic {
+		return 0, errors.New("invalid magic number")
+	}
+	padding := int(binary.BigEndian.Uint16(header[ClientHandshakeSize:]))
+	if padding > MaxHandshakePadding {
+		return 0, errors.New("handshake padding too long")
+	}
+	return PaddedClientHandshakeHeaderSize + padding, nil
+}
+
+// DecodePaddedClientHandshake decodes a padded hello
+func DecodePaddedClientHandshake(data []byte) (*ClientHandshake, error) {
+	size, err := PaddedClientHandshakeSize(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < size {
+		return nil, errors.New("handshake packet too short")
+	}
+
+	plain := make([]byte, ClientHandshakeSize)
+	copy(plain, data)
+	binary.BigEndian.PutUint32(plain[0:4], ReflexMagic)
+	return DecodeClientHandshake(plain)
+}
+
+// ClientHandshakeReplayKey returns the part of a plain or padded hello that
+// identifies it for replay protection: its public key, user ID, timestamp
+// and nonce. The magic, padding length and padding are left out, as a prober
+// may change them freely without the hello becoming a different one.
+func ClientHandshakeReplayKey(data []byte) []byte {
+	return data[4:ClientHandshakeSize]
+}
+
+// EncodePaddedServerHandshake encodes a response followed by padding random
+// bytes.
+func EncodePaddedServerHandshake(hs *ServerHandshake, padding int) ([]byte, error) {
+	if padding < 0 || padding > MaxHandshakePadding {
+		return nil, errors.New("invalid handshake padding")
+	}
+	plain := EncodeServerHandshake(hs)
+	defer PutServerHandshakeBuffer(plain)
+
+	buf := make([]byte, PaddedServerHandshakeHeaderSize+padding)
+	copy(buf, plain)
+	binary.BigEndian.PutUint16(buf[ServerHandshakeSize:], uint16(padding))
+	if _, err := rand.Read(buf[PaddedServerHandshakeHeaderSize:]); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// ReadPaddedServerHandshake reads a padded response from r. It returns the
+// response along with its raw bytes, for the salt of the session key.
+func ReadPaddedServerHandshake(r io.Reader) (*ServerHandshake, []byte, error) {
+	header := make([]byte, PaddedServerHandshakeHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, nil, err
+	}
+	padding := int(binary.BigEndian.Uint16(header[ServerHandshakeSize:]))
+	if padding > MaxHandshakePadding {
+		return nil, nil, errors.New("handshake padding too long")
+	}
+
+	data := make([]byte, PaddedServerHandshakeHeaderSize+padding)
+	copy(data, header)
+	if _, err := io.ReadFull(r, data[PaddedServerHandshakeHeaderSize:]); err != nil {
+		return nil, nil, err
+	}
+	hs, err := DecodeServerHandshake(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return hs, data, nil
+}
+
+// PaddedSessionSalt returns the salt of the session key of a padded
+// handshake, which binds the session to the exact hello and response.
+func PaddedSessionSalt(hello, response []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte("reflex-session-v1"))
+	h.Write(hello)
+	h.Write(response)
+	return h.Sum(nil)
+}
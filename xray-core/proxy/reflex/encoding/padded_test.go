@@ -0,0 +1,107 @@
+package encoding
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// TestPaddedClientHandshake tests that a padded hello announces its size and
+// decodes to the original hello
+func TestPaddedClientHandshake(t *testing.T) {
+	_, pub, _ := GenerateKeyPair()
+	hs := &ClientHandshake{
+		PublicKey: pub,
+		UserID:    [16]byte{1, 2, 3},
+		Timestamp: time.Now().Unix(),
+		Nonce:     [16]byte{4, 5, 6},
+	}
+
+	for _, padding := range []int{0, 1, 700, MaxHandshakePadding} {
+		data, err := EncodePaddedClientHandshake(hs, padding)
+		if err != nil {
+			t.Fatalf("padding %d: %v", padding, err)
+		}
+		size, err := PaddedClientHandshakeSize(data[:PaddedClientHandshakeHeaderSize])
+		if err != nil || size != len(data) || size != PaddedClientHandshakeHeaderSize+padding {
+			t.Fatalf("padding %d: announced size %d of %d bytes: %v", padding, size, len(data), err)
+		}
+		decoded, err := DecodePaddedClientHandshake(data)
+		if err != nil {
+			t.Fatalf("padding %d: %v", padding, err)
+		}
+		if *decoded != *hs {
+			t.Fatalf("padding %d: decoded %+v, expected %+v", padding, decoded, hs)
+		}
+	}
+
+	if _, err := EncodePaddedClientHandshake(hs, MaxHandshakePadding+1); err == nil {
+		t.Fatal("expected padding beyond the maximum to be refused")
+	}
+	plain := EncodeClientHandshake(hs)
+	defer PutClientHandshakeBuffer(plain)
+	if _, err := DecodePaddedClientHandshake(append(plain, 0, 0)); err == nil {
+		t.Fatal("expected a plain hello to be refused")
+	}
+	data, _ := EncodePaddedClientHandshake(hs, 100)
+	if _, err := DecodePaddedClientHandshake(data[:len(data)-1]); err == nil {
+		t.Fatal("expected a truncated hello to be refused")
+	}
+}
+
+// TestPaddedServerHandshake tests reading a padded response off a stream
+func TestPaddedServerHandshake(t *testing.T) {
+	_, pub, _ := GenerateKeyPair()
+	hs := &ServerHandshake{PublicKey: pub, Timestamp: time.Now().Unix()}
+
+	data, err := EncodePaddedServerHandshake(hs, 333)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := bytes.NewReader(append(data, "first frame"...))
+	decoded, raw, err := ReadPaddedServerHandshake(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *decoded != *hs || !bytes.Equal(raw, data) {
+		t.Fatal("padded response does not round trip")
+	}
+	if stream.Len() != len("first frame") {
+		t.Fatalf("read %d bytes past the response", len("first frame")-stream.Len())
+	}
+
+	if _, _, err := ReadPaddedServerHandshake(bytes.NewReader(data[:len(data)-1])); err == nil {
+		t.Fatal("expected a truncated response to be refused")
+	}
+}
+
+// TestHandshakePadding tests that padding brings messages to the packet
+// sizes of the profile
+func TestHandshakePadding(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		total := PaddedClientHandshakeHeaderSize + HandshakePadding(ZoomProfile, PaddedClientHandshakeHeaderSize)
+		if total != 500 && total != 600 && total != 700 {
+			t.Fatalf("padded hello of %d bytes is not a Zoom packet size", total)
+		}
+	}
+	if padding := HandshakePadding(ZoomProfile, 2000); padding != 0 {
+		t.Fatalf("expected no padding for a message larger than the profile's packets, got %d", padding)
+	}
+}
+
+// TestPaddedSessionSalt tests that the salt depends on every byte of the
+// hello and response
+func TestPaddedSessionSalt(t *testing.T) {
+	hello := []byte("hello with padding")
+	response := []byte("response with padding")
+	salt := PaddedSessionSalt(hello, response)
+
+	tampered := bytes.Clone(hello)
+	tampered[len(tampered)-1] ^= 1
+	if bytes.Equal(salt, PaddedSessionSalt(tampered, response)) {
+		t.Fatal("salt does not depend on the hello")
+	}
+	if bytes.Equal(salt, PaddedSessionSalt(hello, response[:len(response)-1])) {
+		t.Fatal("salt does not depend on the response")
+	}
+}
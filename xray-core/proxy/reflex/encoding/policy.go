@@ -0,0 +1,110 @@
+package encoding
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"time"
+)
+
+// policyWeightScale is the fixed-point scale of the weights in a policy
+// grant.
+const policyWeightScale = 10000
+
+// NewPolicyRequestFrame returns the POLICY frame with which a client asks for
+// the morphing profile selected by name. It is sent after the handshake,
+// before the request.
+func NewPolicyRequestFrame(name string) *Frame {
+	return &Frame{Type: FrameTypePolicy, Payload: []byte(name)}
+}
+
+// PolicyRequest returns the profile name asked for by a POLICY request frame.
+func PolicyRequest(frame *Frame) string {
+	return string(frame.Payload)
+}
+
+// NewPolicyGrantFrame returns the POLICY frame with which a server answers a
+// request. It names the granted profile and carries its distributions, so
+// that the client shapes its side of the session exactly as the server does:
+//
+//	[key length(1)] [key]
+//	[sizes(1)] { [size(2)] [weight(2)] }
+//	[delays(1)] { [delay in ms(2)] [weight(2)] }
+//
+// Weights are in units of 1/10000.
+func NewPolicyGrantFrame(profile *TrafficProfile) *Frame {
+	key := ProfileKey(profile)
+	grant := profile.Clone()
+
+	payload := make([]byte, 0, 3+len(key)+4*(len(grant.PacketSizes)+len(grant.Delays)))
+	payload = append(payload, byte(len(key)))
+	payload = append(payload, key...)
+	payload = append(payload, byte(len(grant.PacketSizes)))
+	for _, pattern := range grant.PacketSizes {
+		payload = binary.BigEndian.AppendUint16(payload, uint16(pattern.Size))
+		payload = binary.BigEndian.AppendUint16(payload, uint16(math.Round(pattern.Weight*policyWeightScale)))
+	}
+	payload = append(payload, byte(len(grant.Delays)))
+	for _, pattern := range grant.Delays {
+		payload = binary.BigEndian.AppendUint16(payload, uint16(pattern.Delay/time.Millisecond))
+		payload = binary.BigEndian.AppendUint16(payload, uint16(math.Round(pattern.Weight*policyWeightScale)))
+	}
+	return &Frame{Type: FrameTypePolicy, Payload: payload}
+}
+
+// DecodePolicyGrant returns the profile granted by a POLICY grant frame.
+// Profiles granted by name keep the name of the built-in profile, so that
+// ProfileKey still finds them.
+func DecodePolicyGrant(frame *Frame) (*TrafficProfile, error) {
+	b := frame.Payload
+	if len(b) < 1 || len(b) < 1+int(b[0]) {
+		return nil, errors.New("truncated policy grant")
+	}
+	key := string(b[1 : 1+b[0]])
+	b = b[1+b[0]:]
+
+	profile := &TrafficProfile{Name: key}
+	if IsProfileName(key) {
+		profile.Name = GetProfileByName(key).Name
+	}
+
+	// Both distributions are a count followed by (value, weight) pairs
+	readPairs := func() ([][2]uint16, error) {
+		if len(b) < 1 || len(b) < 1+4*int(b[0]) {
+			return nil, errors.New("truncated policy grant")
+		}
+		pairs := make([][2]uint16, b[0])
+		for i := range pairs {
+			entry := b[1+4*i:]
+			pairs[i] = [2]uint16{binary.BigEndian.Uint16(entry), binary.BigEndian.Uint16(entry[2:])}
+		}
+		b = b[1+4*len(pairs):]
+		return pairs, nil
+	}
+
+	sizes, err := readPairs()
+	if err != nil {
+		return nil, err
+	}
+	for _, pair := range sizes {
+		profile.PacketSizes = append(profile.PacketSizes, PacketSizePattern{
+			Size:   int(pair[0]),
+			Weight: float64(pair[1]) / policyWeightScale,
+		})
+	}
+	delays, err := readPairs()
+	if err != nil {
+		return nil, err
+	}
+	for _, pair := range delays {
+		profile.Delays = append(profile.Delays, DelayPattern{
+			Delay:  time.Duration(pair[0]) * time.Millisecond,
+			Weight: float64(pair[1]) / policyWeightScale,
+		})
+	}
+
+	if len(profile.PacketSizes) == 0 || len(profile.Delays) == 0 {
+		return nil, errors.New("policy grant without sizes or delays")
+	}
+	return profile, nil
+}
@@ -0,0 +1,64 @@
+package encoding
+
+import (
+	"testing"
+	"time"
+)
+
+// TestPolicyGrantRoundTrip tests that a granted profile reaches the client
+// with its distributions and its key
+func TestPolicyGrantRoundTrip(t *testing.T) {
+	for _, name := range ProfileNames {
+		profile := GetProfileByName(name)
+		granted, err := DecodePolicyGrant(NewPolicyGrantFrame(profile))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if key := ProfileKey(granted); key != name {
+			t.Errorf("%s: granted profile has key %q", name, key)
+		}
+		if len(granted.PacketSizes) != len(profile.PacketSizes) || len(granted.Delays) != len(profile.Delays) {
+			t.Fatalf("%s: distributions were not carried", name)
+		}
+		for i, pattern := range profile.PacketSizes {
+			if granted.PacketSizes[i] != pattern {
+				t.Errorf("%s: size %d is %v, expected %v", name, i, granted.PacketSizes[i], pattern)
+			}
+		}
+		for i, pattern := range profile.Delays {
+			if granted.Delays[i] != pattern {
+				t.Errorf("%s: delay %d is %v, expected %v", name, i, granted.Delays[i], pattern)
+			}
+		}
+	}
+}
+
+// TestPolicyGrantTruncated tests that malformed grants are rejected
+func TestPolicyGrantTruncated(t *testing.T) {
+	grant := NewPolicyGrantFrame(ZoomProfile).Payload
+	for n := 0; n < len(grant); n++ {
+		if _, err := DecodePolicyGrant(&Frame{Type: FrameTypePolicy, Payload: grant[:n]}); err == nil {
+			t.Errorf("grant truncated to %d bytes was accepted", n)
+		}
+	}
+}
+
+// TestProfileUpdate tests that updating a copy of a profile switches what it
+// draws without touching the built-in profile
+func TestProfileUpdate(t *testing.T) {
+	profile := YouTubeProfile.Clone()
+	profile.Update(&TrafficProfile{
+		Name:        "custom",
+		PacketSizes: []PacketSizePattern{{Size: 321, Weight: 1}},
+		Delays:      []DelayPattern{{Delay: 7 * time.Millisecond, Weight: 1}},
+	})
+	if size := profile.GetPacketSize(); size != 321 {
+		t.Errorf("updated profile drew size %d", size)
+	}
+	if delay := profile.GetDelay(); delay != 7*time.Millisecond {
+		t.Errorf("updated profile drew delay %v", delay)
+	}
+	if ProfileKey(profile) != "custom" || ProfileKey(YouTubeProfile) != "youtube" {
+		t.Error("update leaked into the built-in profile")
+	}
+}
@@ -118,6 +118,7 @@ func PutFrame(f *Frame) {
 	// Clear sensitive payload reference and fields
 	f.Payload = nil
 	f.Type = 0
+	f.Padding = 0
 
 	framePool.Put(f)
 }
@@ -0,0 +1,150 @@
+package encoding
+
+import (
+	"sync"
+
+	"github.com/xtls/xray-core/common/errors"
+	"github.com/xtls/xray-core/common/protocol/tls"
+)
+
+// maxShapedHandshake is the number of bytes reshaped once a TLS handshake
+// has been recognised, enough for the first flights with a certificate chain.
+const maxShapedHandshake = 16 * 1024
+
+const (
+	tlsUndecided = iota
+	tlsShaping
+	tlsDone
+)
+
+// isTLSHandshake reports whether data starts with the record of a ClientHello
+// or ServerHello, the first flight of each end of a TLS handshake.
+func isTLSHandshake(data []byte) bool {
+	if _, err := tls.SniffTLS(data); err == nil {
+		return true
+	}
+	// Hellos without a server name are not sniffed, so also check the
+	// handshake message type: [type(1)] [version(2)] [length(2)] [msg type(1)]
+	if len(data) < 6 || data[0] != 0x16 || !tls.IsValidTLSVersion(data[1], data[2]) {
+		return false
+	}
+	return data[5] == 0x01 || data[5] == 0x02
+}
+
+// TLSShaper is a FrameConn that hides the first flights of a TLS handshake
+// carried by the session. Browsing HTTPS through the tunnel would otherwise
+// send the ClientHello and the server's answer as DATA frames of their own
+// characteristic sizes and timing, which fingerprints TLS-in-TLS.
+//
+// When the first data written starts with a TLS hello, the next
+// maxShapedHandshake bytes are held for a delay drawn from the profile, so
+// that the records written meanwhile are merged, and then sent in frames
+// split and padded to the sizes of the profile. Other sessions pass through.
+type TLSShaper struct {
+	conn    FrameConn
+	profile *TrafficProfile
+	clock   TimeSource
+
+	mu       sync.Mutex
+	state    int
+	shaped   int
+	pending  []byte
+	flushing bool
+	err      error
+}
+
+// NewTLSShaper returns a TLSShaper writing to conn with frames sized and
+// delayed by profile, waiting on clock or the system clock if it is nil.
+func NewTLSShaper(conn FrameConn, profile *TrafficProfile, clock TimeSource) *TLSShaper {
+	if profile == nil {
+		profile = GetDefaultProfile()
+	}
+	return &TLSShaper{
+		conn:    conn,
+		profile: profile,
+		clock:   OrSystemClock(clock),
+	}
+}
+
+// WriteFrame writes a frame, holding back the data of a TLS handshake to
+// reshape it.
+func (s *TLSShaper) WriteFrame(frame *Frame) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+	if frame.Type == FrameTypeData && s.state == tlsUndecided && len(frame.Payload) > 0 {
+		s.state = tlsDone
+		if isTLSHandshake(frame.Payload) {
+			errors.LogDebug(nil, "reshaping tunneled TLS handshake")
+			s.state = tlsShaping
+		}
+	}
+	if frame.Type == FrameTypeData && s.state == tlsShaping {
+		s.pending = append(s.pending, frame.Payload...)
+		s.shaped += len(frame.Payload)
+		if s.shaped >= maxShapedHandshake {
+			s.state = tlsDone
+		}
+		if !s.flushing {
+			s.flushing = true
+			go s.flushAfterDelay()
+		}
+		return nil
+	}
+
+	// Anything else keeps its place after the held data
+	if err := s.flushLocked(); err != nil {
+		return err
+	}
+	return s.conn.WriteFrame(frame)
+}
+
+// ReadFrame reads the next frame.
+func (s *TLSShaper) ReadFrame() (*Frame, error) {
+	return s.conn.ReadFrame()
+}
+
+// Close sends the data still held back.
+func (s *TLSShaper) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.flushLocked()
+}
+
+func (s *TLSShaper) flushAfterDelay() {
+	<-s.clock.After(s.profile.GetDelay())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flushing = false
+	s.flushLocked()
+}
+
+// flushLocked sends the held data in frames padded to the sizes of the
+// profile.
+func (s *TLSShaper) flushLocked() error {
+	if s.err != nil {
+		return s.err
+	}
+	for len(s.pending) > 0 {
+		size := min(max(s.profile.GetPacketSize(), minCellSize), cellOverhead+MaxFramePayloadSize)
+		capacity := size - cellOverhead
+		n := min(len(s.pending), capacity)
+		frame := &Frame{
+			Type:    FrameTypeData,
+			Payload: s.pending[:n],
+			Padding: capacity + 1 - n,
+		}
+		if err := s.conn.WriteFrame(frame); err != nil {
+			s.err = err
+			return err
+		}
+		s.pending = s.pending[n:]
+	}
+	s.pending = nil
+	return nil
+}
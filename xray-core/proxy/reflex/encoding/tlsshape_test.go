@@ -0,0 +1,192 @@
+package encoding
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/common/protocol/tls/cert"
+)
+
+// recordingConn records what is written to it, one entry per Write.
+type recordingConn struct {
+	net.Conn
+	mu     sync.Mutex
+	writes [][]byte
+}
+
+func (c *recordingConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	c.writes = append(c.writes, bytes.Clone(b))
+	c.mu.Unlock()
+	return c.Conn.Write(b)
+}
+
+// tlsFlights runs a TLS handshake and returns the writes of the client and
+// of the server.
+func tlsFlights(t *testing.T) ([][]byte, [][]byte) {
+	certificate, err := tls.X509KeyPair(cert.MustGenerate(nil, cert.DNSNames("example.com")).ToPEM())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, s := net.Pipe()
+	client := &recordingConn{Conn: c}
+	server := &recordingConn{Conn: s}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- tls.Server(server, &tls.Config{Certificates: []tls.Certificate{certificate}}).Handshake()
+	}()
+	if err := tls.Client(client, &tls.Config{ServerName: "example.com", InsecureSkipVerify: true}).Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	return client.writes, server.writes
+}
+
+// wireSizes splits the frames written to the wire and returns their sizes.
+func wireSizes(t *testing.T, wire []byte) []int {
+	var sizes []int
+	for len(wire) > 0 {
+		if len(wire) < 2 {
+			t.Fatal("truncated frame on the wire")
+		}
+		size := 2 + int(binary.BigEndian.Uint16(wire))
+		sizes = append(sizes, size)
+		wire = wire[size:]
+	}
+	return sizes
+}
+
+// shapeFlights writes the flights through a TLSShaper and returns what went
+// on the wire.
+func shapeFlights(t *testing.T, key []byte, flights [][]byte, profile *TrafficProfile) []byte {
+	encoder, _ := NewFrameEncoder(key)
+	var wire bytes.Buffer
+	shaper := NewTLSShaper(NewFrameConn(encoder, &wire, nil, nil), profile, nil)
+	for _, flight := range flights {
+		if err := shaper.WriteFrame(&Frame{Type: FrameTypeData, Payload: flight}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	shaper.Close()
+	return wire.Bytes()
+}
+
+// TestTLSShaperHidesHandshakeRecords tests that the frames carrying the
+// first flights of a tunneled TLS handshake take the sizes of the profile
+// instead of the sizes of the TLS records, and still deliver them intact
+func TestTLSShaperHidesHandshakeRecords(t *testing.T) {
+	clientFlights, serverFlights := tlsFlights(t)
+	if !isTLSHandshake(clientFlights[0]) || !isTLSHandshake(serverFlights[0]) {
+		t.Fatal("TLS hellos were not recognised")
+	}
+
+	profileSizes := make(map[int]bool)
+	for _, pattern := range YouTubeProfile.PacketSizes {
+		profileSizes[pattern.Size] = true
+	}
+
+	key := make([]byte, 32)
+	for _, flights := range [][][]byte{clientFlights, serverFlights} {
+		// The sizes an unshaped session would show
+		recordSizes := make(map[int]bool)
+		for _, flight := range flights {
+			recordSizes[(&Frame{Type: FrameTypeData, Payload: flight}).EncodedSize()] = true
+		}
+
+		wire := shapeFlights(t, key, flights, YouTubeProfile)
+		for _, size := range wireSizes(t, wire) {
+			if recordSizes[size] {
+				t.Errorf("frame of %d bytes shows the size of a TLS flight", size)
+			}
+			if !profileSizes[size] {
+				t.Errorf("frame of %d bytes is not a profile size", size)
+			}
+		}
+
+		decoder, _ := NewFrameDecoder(key)
+		reader := bytes.NewReader(wire)
+		var received []byte
+		for reader.Len() > 0 {
+			frame, err := decoder.ReadFrame(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			received = append(received, frame.Payload...)
+		}
+		if !bytes.Equal(received, bytes.Join(flights, nil)) {
+			t.Error("reshaped handshake was not delivered intact")
+		}
+	}
+}
+
+// TestTLSShaperMergesFlights tests that records written within the delay
+// leave together
+func TestTLSShaperMergesFlights(t *testing.T) {
+	// Write the server's first flight one TLS record at a time, as the
+	// dispatcher may hand it over
+	_, serverFlights := tlsFlights(t)
+	var records [][]byte
+	for flight := serverFlights[0]; len(flight) >= 5; {
+		size := 5 + int(binary.BigEndian.Uint16(flight[3:5]))
+		records = append(records, flight[:size])
+		flight = flight[size:]
+	}
+	if len(records) < 2 {
+		t.Fatalf("server flight has %d records", len(records))
+	}
+
+	encoder, _ := NewFrameEncoder(make([]byte, 32))
+	var wire bytes.Buffer
+	var frames int
+	shaper := NewTLSShaper(NewFrameConn(encoder, writerFunc(func(b []byte) (int, error) {
+		frames++
+		return wire.Write(b)
+	}), nil, nil), &TrafficProfile{
+		PacketSizes: []PacketSizePattern{{Size: 16000, Weight: 1}},
+		Delays:      []DelayPattern{{Delay: 20 * time.Millisecond, Weight: 1}},
+	}, nil)
+	for _, record := range records {
+		shaper.WriteFrame(&Frame{Type: FrameTypeData, Payload: record})
+	}
+	shaper.mu.Lock()
+	if frames != 0 {
+		t.Fatal("handshake was sent without delay")
+	}
+	shaper.mu.Unlock()
+	time.Sleep(100 * time.Millisecond)
+	shaper.mu.Lock()
+	defer shaper.mu.Unlock()
+	if frames != 1 {
+		t.Fatalf("%d records left in %d frames, expected them merged into 1", len(records), frames)
+	}
+}
+
+// TestTLSShaperPassesOtherData tests that sessions not starting with a TLS
+// hello are left alone
+func TestTLSShaperPassesOtherData(t *testing.T) {
+	encoder, _ := NewFrameEncoder(make([]byte, 32))
+	var wire bytes.Buffer
+	shaper := NewTLSShaper(NewFrameConn(encoder, &wire, nil, nil), YouTubeProfile, nil)
+	payload := []byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	if err := shaper.WriteFrame(&Frame{Type: FrameTypeData, Payload: payload}); err != nil {
+		t.Fatal(err)
+	}
+	if sizes := wireSizes(t, wire.Bytes()); len(sizes) != 1 || sizes[0] != 2+1+len(payload)+16 {
+		t.Fatalf("plain data was reshaped into frames of %v bytes", sizes)
+	}
+}
+
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(b []byte) (int, error) {
+	return f(b)
+}
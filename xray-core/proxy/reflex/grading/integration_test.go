@@ -2,8 +2,9 @@
 // the step docs: structure (Step 1), handshake (Step 2), encryption (Step 3),
 // fallback (Step 4), and advanced/morphing (Step 5).
 //
-// These tests run against the Reflex inbound handler. Implement the full protocol
-// per the docs so that these tests pass.
+// These tests run against a Reflex server and client started by reflextest,
+// which connects them, an echo target and a fallback web server in memory.
+// Implement the full protocol per the docs so that these tests pass.
 
 package grading
 
@@ -13,119 +14,96 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/binary"
-	"fmt"
 	"io"
-	stdnet "net"
+	"net"
 	"net/http"
 	"sync"
 	"testing"
 	"time"
 
-	"github.com/xtls/xray-core/common"
-	"github.com/xtls/xray-core/common/net"
-	"github.com/xtls/xray-core/features/routing"
+	"github.com/xtls/xray-core/common/protocol"
+	"github.com/xtls/xray-core/features/inbound"
+	"github.com/xtls/xray-core/features/outbound"
+	"github.com/xtls/xray-core/proxy"
 	"github.com/xtls/xray-core/proxy/reflex"
-	_ "github.com/xtls/xray-core/proxy/reflex/inbound"
-	_ "github.com/xtls/xray-core/proxy/reflex/outbound"
-	"github.com/xtls/xray-core/transport"
-	"github.com/xtls/xray-core/transport/internet/stat"
+	"github.com/xtls/xray-core/proxy/reflex/encoding"
+	"github.com/xtls/xray-core/proxy/reflex/reflextest"
 )
 
-// mockDispatcher implements routing.Dispatcher. Returns error on Dispatch so
-// that the handler can still complete handshake without a real outbound.
-type mockDispatcher struct{}
+// newHarness starts a Reflex server and client for a user with policy.
+func newHarness(t *testing.T, policy string) *reflextest.Harness {
+	return reflextest.New(t, reflextest.Options{Account: &reflex.Account{Policy: policy}})
+}
 
-func (m *mockDispatcher) Type() interface{} { return (*routing.Dispatcher)(nil) }
-func (m *mockDispatcher) Dispatch(ctx context.Context, dest net.Destination) (*transport.Link, error) {
-	return nil, fmt.Errorf("mock: no outbound")
+// dialServer connects to the Reflex server of h directly, as a prober would.
+func dialServer(t *testing.T, h *reflextest.Harness, timeout time.Duration) net.Conn {
+	conn, err := h.DialServer()
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(timeout))
+	return conn
 }
-func (m *mockDispatcher) DispatchLink(ctx context.Context, dest net.Destination, link *transport.Link) error {
-	return fmt.Errorf("mock: no outbound")
+
+// writeHandshake sends the magic and the client handshake of user.
+func writeHandshake(t *testing.T, conn net.Conn, user *protocol.ID) {
+	if err := WriteMagic(conn); err != nil {
+		t.Fatalf("WriteMagic: %v", err)
+	}
+	_, publicKey, err := encoding.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs := &encoding.ClientHandshake{
+		PublicKey: publicKey,
+		UserID:    encoding.UUIDToBytes(user),
+		Timestamp: time.Now().Unix(),
+	}
+	rand.Read(hs.Nonce[:])
+	handshake := encoding.EncodeClientHandshake(hs)
+	defer encoding.PutClientHandshakeBuffer(handshake)
+	// The magic is already sent
+	if _, err := conn.Write(handshake[4:]); err != nil {
+		t.Fatalf("write handshake: %v", err)
+	}
 }
 
 // --- Step 1: Structure ---
 
 // TestStep1Structure verifies that the Reflex package exists, config types work,
-// and a handler can be created from InboundConfig (step1: package, config, handler).
+// and a handler is created from the inbound config (step1: package, config, handler).
 func TestStep1Structure(t *testing.T) {
-	ctx := context.Background()
-	cfg := &reflex.InboundConfig{
-		Clients: []*reflex.User{{Id: "00000000-0000-0000-0000-000000000001", Policy: "default"}},
-	}
-	obj, err := common.CreateObject(ctx, cfg)
+	h := newHarness(t, "default")
+	manager := h.Server.GetFeature(inbound.ManagerType()).(inbound.Manager)
+	handler, err := manager.GetHandler(context.Background(), "reflex")
 	if err != nil {
-		t.Fatalf("CreateObject(reflex.InboundConfig): %v (step1: handler creation)", err)
+		t.Fatalf("GetHandler: %v (step1: handler creation)", err)
 	}
-	if obj == nil {
-		t.Fatal("CreateObject returned nil handler (step1: structure)")
+	getter, ok := handler.(proxy.GetInbound)
+	if !ok {
+		t.Fatal("step1: inbound handler has no proxy")
 	}
-	// Handler should implement Network()
-	type networker interface{ Network() []net.Network }
-	if h, ok := obj.(networker); ok {
-		nets := h.Network()
-		if len(nets) == 0 {
-			t.Error("step1: handler.Network() should return at least one network")
-		}
+	if nets := getter.GetInbound().Network(); len(nets) == 0 {
+		t.Error("step1: handler.Network() should return at least one network")
 	}
 }
 
-// TestStep1OutboundCreation verifies that OutboundConfig creates an outbound handler (step1: structure).
+// TestStep1OutboundCreation verifies that the outbound config creates an outbound handler (step1: structure).
 // Name matches script count_tests; increases unit test count for grading.
 func TestStep1OutboundCreation(t *testing.T) {
-	ctx := context.Background()
-	cfg := &reflex.OutboundConfig{
-		Address: "127.0.0.1",
-		Port:    443,
-		Id:      "00000000-0000-0000-0000-00000000000a",
-	}
-	obj, err := common.CreateObject(ctx, cfg)
-	if err != nil {
-		t.Skipf("CreateObject(OutboundConfig): %v", err)
-		return
-	}
-	if obj == nil {
-		t.Fatal("step1 outbound: CreateObject returned nil")
+	h := newHarness(t, "default")
+	manager := h.Client.GetFeature(outbound.ManagerType()).(outbound.Manager)
+	if manager.GetDefaultHandler() == nil {
+		t.Fatal("step1 outbound: no outbound handler")
 	}
 }
 
-// TestStep1BuildAndListen verifies that the handler can be used with a real TCP listener
-// and Process is called without panic (step1: handler works).
+// TestStep1BuildAndListen verifies that the handler takes a connection and
+// processes it without panic (step1: handler works).
 func TestStep1BuildAndListen(t *testing.T) {
-	ctx := context.Background()
-	cfg := &reflex.InboundConfig{
-		Clients: []*reflex.User{{Id: "00000000-0000-0000-0000-000000000002", Policy: "default"}},
-	}
-	obj, err := common.CreateObject(ctx, cfg)
-	if err != nil {
-		t.Skipf("CreateObject: %v", err)
-		return
-	}
-	handler, ok := obj.(interface {
-		Process(context.Context, net.Network, stat.Connection, routing.Dispatcher) error
-		Network() []net.Network
-	})
-	if !ok {
-		t.Skip("handler does not implement Process")
-		return
-	}
-	ln, err := stdnet.Listen("tcp", "127.0.0.1:0")
-	if err != nil {
-		t.Fatalf("Listen: %v", err)
-	}
-	defer ln.Close()
-	disp := &mockDispatcher{}
-	go func() {
-		conn, _ := ln.Accept()
-		if conn == nil {
-			return
-		}
-		defer conn.Close()
-		_ = handler.Process(ctx, net.Network_TCP, stat.Connection(conn), disp)
-	}()
-	client, err := stdnet.Dial("tcp", ln.Addr().String())
-	if err != nil {
-		t.Fatalf("Dial: %v", err)
-	}
+	h := newHarness(t, "default")
+	client := dialServer(t, h, 5*time.Second)
 	client.Write([]byte("X")) // non-Reflex byte
 	client.Close()
 	// If we get here without panic, step1 listener/Process is ok
@@ -136,67 +114,9 @@ func TestStep1BuildAndListen(t *testing.T) {
 // TestStep2HandshakeMagic sends the Reflex magic number (REFX) followed by a minimal
 // client handshake payload and checks that the server responds (step2: handshake).
 func TestStep2HandshakeMagic(t *testing.T) {
-	ctx := context.Background()
-	userID := "10000000-2000-4000-8000-000000000003"
-	cfg := &reflex.InboundConfig{
-		Clients: []*reflex.User{{Id: userID, Policy: "default"}},
-	}
-	obj, err := common.CreateObject(ctx, cfg)
-	if err != nil {
-		t.Skipf("CreateObject: %v", err)
-		return
-	}
-	handler, ok := obj.(interface {
-		Process(context.Context, net.Network, stat.Connection, routing.Dispatcher) error
-		Network() []net.Network
-	})
-	if !ok {
-		t.Skip("handler does not implement Process")
-		return
-	}
-	ln, err := stdnet.Listen("tcp", "127.0.0.1:0")
-	if err != nil {
-		t.Fatalf("Listen: %v", err)
-	}
-	defer ln.Close()
-	disp := &mockDispatcher{}
-	var serverConn stdnet.Conn
-	var wg sync.WaitGroup
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		serverConn, _ = ln.Accept()
-		if serverConn != nil {
-			_ = handler.Process(ctx, net.Network_TCP, stat.Connection(serverConn), disp)
-			serverConn.Close()
-		}
-	}()
-	client, err := stdnet.Dial("tcp", ln.Addr().String())
-	if err != nil {
-		t.Fatalf("Dial: %v", err)
-	}
-	defer client.Close()
-	client.SetDeadline(time.Now().Add(5 * time.Second))
-	// Send magic (step2: protocol)
-	if err := WriteMagic(client); err != nil {
-		t.Fatalf("WriteMagic: %v", err)
-	}
-	// Minimal client handshake: 32 byte pubkey + 16 byte UUID + rest padding to 64+
-	pubKey := make([]byte, 32)
-	_, _ = rand.Read(pubKey)
-	if _, err := client.Write(pubKey); err != nil {
-		t.Fatalf("write pubkey: %v", err)
-	}
-	uuidBytes := make([]byte, 16)
-	_, _ = rand.Read(uuidBytes)
-	if _, err := client.Write(uuidBytes); err != nil {
-		t.Fatalf("write uuid: %v", err)
-	}
-	// Padding so server has enough to parse
-	pad := make([]byte, 32)
-	if _, err := client.Write(pad); err != nil {
-		t.Fatalf("write pad: %v", err)
-	}
+	h := newHarness(t, "default")
+	client := dialServer(t, h, 5*time.Second)
+	writeHandshake(t, client, h.UserID)
 	// Read response: server should send something (HTTP 200-like or binary)
 	resp := make([]byte, 512)
 	n, err := client.Read(resp)
@@ -206,146 +126,32 @@ func TestStep2HandshakeMagic(t *testing.T) {
 	if n == 0 {
 		t.Error("step2 handshake: server sent no response (expected HTTP 200-like or server key)")
 	}
-	wg.Wait()
 }
 
-// TestStep2AuthWithUUID verifies handshake when client sends a UUID (auth by UUID in config).
-// Name matches script pattern "Auth|UUID" for test-based Step2 scoring.
+// TestStep2AuthWithUUID verifies that a client authenticating with the UUID in the config
+// gets through. Name matches script pattern "Auth|UUID" for test-based Step2 scoring.
 func TestStep2AuthWithUUID(t *testing.T) {
-	ctx := context.Background()
-	cfg := &reflex.InboundConfig{
-		Clients: []*reflex.User{{Id: "a1b2c3d4-2000-4000-8000-00000000000a", Policy: "default"}},
-	}
-	obj, err := common.CreateObject(ctx, cfg)
-	if err != nil {
-		t.Skipf("CreateObject: %v", err)
-		return
-	}
-	handler, ok := obj.(interface {
-		Process(context.Context, net.Network, stat.Connection, routing.Dispatcher) error
-		Network() []net.Network
-	})
-	if !ok {
-		t.Skip("handler does not implement Process")
-		return
-	}
-	ln, err := stdnet.Listen("tcp", "127.0.0.1:0")
-	if err != nil {
-		t.Fatalf("Listen: %v", err)
-	}
-	defer ln.Close()
-	disp := &mockDispatcher{}
-	go func() {
-		conn, _ := ln.Accept()
-		if conn != nil {
-			_ = handler.Process(ctx, net.Network_TCP, stat.Connection(conn), disp)
-			conn.Close()
-		}
-	}()
-	client, err := stdnet.Dial("tcp", ln.Addr().String())
-	if err != nil {
-		t.Fatalf("Dial: %v", err)
-	}
-	defer client.Close()
-	client.SetDeadline(time.Now().Add(5 * time.Second))
-	_ = WriteMagic(client)
-	_, _ = client.Write(make([]byte, 32+16+32))
-	resp := make([]byte, 512)
-	n, _ := client.Read(resp)
-	if n == 0 {
-		t.Error("step2 auth/UUID: server sent no response")
+	h := newHarness(t, "default")
+	if err := h.Echo([]byte("step2 auth"), 5*time.Second); err != nil {
+		t.Errorf("step2 auth/UUID: %v", err)
 	}
 }
 
-// TestStep2SessionKeyDerive verifies handshake completes and server responds (session key derivation).
-// Name matches script pattern "HKDF|Derive|Curve25519" for test-based Step2 scoring.
+// TestStep2SessionKeyDerive verifies that both ends derive the same session key, so that data
+// goes through. Name matches script pattern "HKDF|Derive|Curve25519" for test-based Step2 scoring.
 func TestStep2SessionKeyDerive(t *testing.T) {
-	ctx := context.Background()
-	cfg := &reflex.InboundConfig{
-		Clients: []*reflex.User{{Id: "b2c3d4e5-2000-4000-8000-00000000000b", Policy: "default"}},
-	}
-	obj, err := common.CreateObject(ctx, cfg)
-	if err != nil {
-		t.Skipf("CreateObject: %v", err)
-		return
-	}
-	handler, ok := obj.(interface {
-		Process(context.Context, net.Network, stat.Connection, routing.Dispatcher) error
-		Network() []net.Network
-	})
-	if !ok {
-		t.Skip("handler does not implement Process")
-		return
-	}
-	ln, err := stdnet.Listen("tcp", "127.0.0.1:0")
-	if err != nil {
-		t.Fatalf("Listen: %v", err)
-	}
-	defer ln.Close()
-	disp := &mockDispatcher{}
-	go func() {
-		conn, _ := ln.Accept()
-		if conn != nil {
-			_ = handler.Process(ctx, net.Network_TCP, stat.Connection(conn), disp)
-			conn.Close()
-		}
-	}()
-	client, err := stdnet.Dial("tcp", ln.Addr().String())
-	if err != nil {
-		t.Fatalf("Dial: %v", err)
-	}
-	defer client.Close()
-	client.SetDeadline(time.Now().Add(5 * time.Second))
-	_ = WriteMagic(client)
-	_, _ = client.Write(make([]byte, 32+16+32))
-	resp := make([]byte, 512)
-	n, _ := client.Read(resp)
-	if n == 0 {
-		t.Error("step2 session key derive: no server response")
+	h := newHarness(t, "default")
+	if err := h.Echo(bytes.Repeat([]byte("key"), 1000), 5*time.Second); err != nil {
+		t.Errorf("step2 session key derive: %v", err)
 	}
 }
 
 // TestStep2HandshakeKeyExchange verifies that the server response looks like a valid
 // handshake reply (contains "200" for HTTP-like or has reasonable length).
 func TestStep2HandshakeKeyExchange(t *testing.T) {
-	ctx := context.Background()
-	cfg := &reflex.InboundConfig{
-		Clients: []*reflex.User{{Id: "20000000-2000-4000-8000-000000000004", Policy: "default"}},
-	}
-	obj, err := common.CreateObject(ctx, cfg)
-	if err != nil {
-		t.Skipf("CreateObject: %v", err)
-		return
-	}
-	handler, ok := obj.(interface {
-		Process(context.Context, net.Network, stat.Connection, routing.Dispatcher) error
-		Network() []net.Network
-	})
-	if !ok {
-		t.Skip("handler does not implement Process")
-		return
-	}
-	ln, err := stdnet.Listen("tcp", "127.0.0.1:0")
-	if err != nil {
-		t.Fatalf("Listen: %v", err)
-	}
-	defer ln.Close()
-	disp := &mockDispatcher{}
-	go func() {
-		conn, _ := ln.Accept()
-		if conn != nil {
-			_ = handler.Process(ctx, net.Network_TCP, stat.Connection(conn), disp)
-			conn.Close()
-		}
-	}()
-	client, err := stdnet.Dial("tcp", ln.Addr().String())
-	if err != nil {
-		t.Fatalf("Dial: %v", err)
-	}
-	defer client.Close()
-	client.SetDeadline(time.Now().Add(5 * time.Second))
-	_ = WriteMagic(client)
-	_, _ = client.Write(make([]byte, 32+16+32))
+	h := newHarness(t, "default")
+	client := dialServer(t, h, 5*time.Second)
+	writeHandshake(t, client, h.UserID)
 	resp := make([]byte, 1024)
 	n, _ := client.Read(resp)
 	resp = resp[:n]
@@ -353,54 +159,17 @@ func TestStep2HandshakeKeyExchange(t *testing.T) {
 	if n > 0 && (bytes.Contains(resp, []byte("200")) || n >= 32) {
 		return // step2 key exchange / response ok
 	}
-	if n == 0 {
-		t.Error("step2 key exchange: no server response")
-	}
+	t.Error("step2 key exchange: no server response")
 }
 
 // TestStep2HandshakeResponseLength verifies the server responds with at least 32 bytes after
-// valid handshake (e.g. server public key). Name: Handshake for test-based Step2 scoring.
+// a handshake (e.g. server public key). Name: Handshake for test-based Step2 scoring.
 func TestStep2HandshakeResponseLength(t *testing.T) {
-	ctx := context.Background()
-	cfg := &reflex.InboundConfig{
-		Clients: []*reflex.User{{Id: "a1000000-2000-4000-8000-00000000000a", Policy: "default"}},
-	}
-	obj, err := common.CreateObject(ctx, cfg)
-	if err != nil {
-		t.Skipf("CreateObject: %v", err)
-		return
-	}
-	handler, ok := obj.(interface {
-		Process(context.Context, net.Network, stat.Connection, routing.Dispatcher) error
-		Network() []net.Network
-	})
-	if !ok {
-		t.Skip("handler does not implement Process")
-		return
-	}
-	ln, err := stdnet.Listen("tcp", "127.0.0.1:0")
-	if err != nil {
-		t.Fatalf("Listen: %v", err)
-	}
-	defer ln.Close()
-	disp := &mockDispatcher{}
-	go func() {
-		conn, _ := ln.Accept()
-		if conn != nil {
-			_ = handler.Process(ctx, net.Network_TCP, stat.Connection(conn), disp)
-			conn.Close()
-		}
-	}()
-	client, err := stdnet.Dial("tcp", ln.Addr().String())
-	if err != nil {
-		t.Fatalf("Dial: %v", err)
-	}
-	defer client.Close()
-	client.SetDeadline(time.Now().Add(5 * time.Second))
-	_ = WriteMagic(client)
-	_, _ = client.Write(make([]byte, 80))
+	h := newHarness(t, "default")
+	client := dialServer(t, h, 5*time.Second)
+	writeHandshake(t, client, h.UserID)
 	resp := make([]byte, 256)
-	n, _ := client.Read(resp)
+	n, _ := io.ReadAtLeast(client, resp, 32)
 	if n < 32 {
 		t.Errorf("step2 handshake response length: want >= 32 (e.g. server public key), got %d", n)
 	}
@@ -412,45 +181,10 @@ func TestStep2HandshakeResponseLength(t *testing.T) {
 // handshake; some implementations may close the connection. We only check that
 // the server doesn't panic and consumes data (step3: frame structure).
 func TestStep3FrameFormat(t *testing.T) {
-	ctx := context.Background()
-	cfg := &reflex.InboundConfig{
-		Clients: []*reflex.User{{Id: "30000000-2000-4000-8000-000000000005", Policy: "default"}},
-	}
-	obj, err := common.CreateObject(ctx, cfg)
-	if err != nil {
-		t.Skipf("CreateObject: %v", err)
-		return
-	}
-	handler, ok := obj.(interface {
-		Process(context.Context, net.Network, stat.Connection, routing.Dispatcher) error
-		Network() []net.Network
-	})
-	if !ok {
-		t.Skip("handler does not implement Process")
-		return
-	}
-	ln, err := stdnet.Listen("tcp", "127.0.0.1:0")
-	if err != nil {
-		t.Fatalf("Listen: %v", err)
-	}
-	defer ln.Close()
-	disp := &mockDispatcher{}
-	go func() {
-		conn, _ := ln.Accept()
-		if conn != nil {
-			_ = handler.Process(ctx, net.Network_TCP, stat.Connection(conn), disp)
-			conn.Close()
-		}
-	}()
-	client, err := stdnet.Dial("tcp", ln.Addr().String())
-	if err != nil {
-		t.Fatalf("Dial: %v", err)
-	}
-	defer client.Close()
-	client.SetDeadline(time.Now().Add(3 * time.Second))
+	h := newHarness(t, "default")
+	client := dialServer(t, h, 3*time.Second)
 	// Send REFX + handshake so server enters session mode, then one frame header
-	_ = WriteMagic(client)
-	_, _ = client.Write(make([]byte, 80))
+	writeHandshake(t, client, h.UserID)
 	// Frame: length=0, type=Data
 	_ = WriteU16BigEndian(client, 0)
 	client.Write([]byte{FrameTypeData})
@@ -458,93 +192,25 @@ func TestStep3FrameFormat(t *testing.T) {
 	_, _ = io.Copy(io.Discard, client)
 }
 
-// TestStep3ChaChaAEAD verifies that after handshake the server accepts frame data (AEAD-encrypted).
-// Name matches script pattern "Encrypt|ChaCha|AEAD" for test-based Step3 scoring.
+// TestStep3ChaChaAEAD verifies that after the handshake the frames, AEAD-encrypted, carry data
+// both ways. Name matches script pattern "Encrypt|ChaCha|AEAD" for test-based Step3 scoring.
 func TestStep3ChaChaAEAD(t *testing.T) {
-	ctx := context.Background()
-	cfg := &reflex.InboundConfig{
-		Clients: []*reflex.User{{Id: "c3d4e5f6-2000-4000-8000-00000000000c", Policy: "default"}},
+	h := newHarness(t, "default")
+	payload := make([]byte, 256*1024)
+	for i := range payload {
+		payload[i] = byte(i)
 	}
-	obj, err := common.CreateObject(ctx, cfg)
-	if err != nil {
-		t.Skipf("CreateObject: %v", err)
-		return
-	}
-	handler, ok := obj.(interface {
-		Process(context.Context, net.Network, stat.Connection, routing.Dispatcher) error
-		Network() []net.Network
-	})
-	if !ok {
-		t.Skip("handler does not implement Process")
-		return
-	}
-	ln, err := stdnet.Listen("tcp", "127.0.0.1:0")
-	if err != nil {
-		t.Fatalf("Listen: %v", err)
-	}
-	defer ln.Close()
-	disp := &mockDispatcher{}
-	go func() {
-		conn, _ := ln.Accept()
-		if conn != nil {
-			_ = handler.Process(ctx, net.Network_TCP, stat.Connection(conn), disp)
-			conn.Close()
-		}
-	}()
-	client, err := stdnet.Dial("tcp", ln.Addr().String())
-	if err != nil {
-		t.Fatalf("Dial: %v", err)
+	if err := h.Echo(payload, 5*time.Second); err != nil {
+		t.Errorf("step3 AEAD: %v", err)
 	}
-	defer client.Close()
-	client.SetDeadline(time.Now().Add(3 * time.Second))
-	_ = WriteMagic(client)
-	_, _ = client.Write(make([]byte, 80))
-	_ = WriteU16BigEndian(client, 0)
-	client.Write([]byte{FrameTypeData})
-	_, _ = io.Copy(io.Discard, client)
 }
 
 // TestStep3FrameTypeClose verifies the server handles FrameTypeClose without panic (step3: frame types).
 // Name matches script pattern "Frame" for test-based Step3 scoring.
 func TestStep3FrameTypeClose(t *testing.T) {
-	ctx := context.Background()
-	cfg := &reflex.InboundConfig{
-		Clients: []*reflex.User{{Id: "b3000000-2000-4000-8000-00000000000b", Policy: "default"}},
-	}
-	obj, err := common.CreateObject(ctx, cfg)
-	if err != nil {
-		t.Skipf("CreateObject: %v", err)
-		return
-	}
-	handler, ok := obj.(interface {
-		Process(context.Context, net.Network, stat.Connection, routing.Dispatcher) error
-		Network() []net.Network
-	})
-	if !ok {
-		t.Skip("handler does not implement Process")
-		return
-	}
-	ln, err := stdnet.Listen("tcp", "127.0.0.1:0")
-	if err != nil {
-		t.Fatalf("Listen: %v", err)
-	}
-	defer ln.Close()
-	disp := &mockDispatcher{}
-	go func() {
-		conn, _ := ln.Accept()
-		if conn != nil {
-			_ = handler.Process(ctx, net.Network_TCP, stat.Connection(conn), disp)
-			conn.Close()
-		}
-	}()
-	client, err := stdnet.Dial("tcp", ln.Addr().String())
-	if err != nil {
-		t.Fatalf("Dial: %v", err)
-	}
-	defer client.Close()
-	client.SetDeadline(time.Now().Add(3 * time.Second))
-	_ = WriteMagic(client)
-	_, _ = client.Write(make([]byte, 80))
+	h := newHarness(t, "default")
+	client := dialServer(t, h, 3*time.Second)
+	writeHandshake(t, client, h.UserID)
 	_ = WriteU16BigEndian(client, 0)
 	client.Write([]byte{FrameTypeClose})
 	_, _ = io.Copy(io.Discard, client)
@@ -576,44 +242,9 @@ func TestStep3ReadFrameWriteFrame(t *testing.T) {
 // TestStep3ReplayProtection verifies the handler responds to one handshake; replay rejection
 // is implementation-specific. Name matches script pattern "Replay" for Step3/Integration scoring.
 func TestStep3ReplayProtection(t *testing.T) {
-	ctx := context.Background()
-	cfg := &reflex.InboundConfig{
-		Clients: []*reflex.User{{Id: "d4e5f6a7-2000-4000-8000-00000000000d", Policy: "default"}},
-	}
-	obj, err := common.CreateObject(ctx, cfg)
-	if err != nil {
-		t.Skipf("CreateObject: %v", err)
-		return
-	}
-	handler, ok := obj.(interface {
-		Process(context.Context, net.Network, stat.Connection, routing.Dispatcher) error
-		Network() []net.Network
-	})
-	if !ok {
-		t.Skip("handler does not implement Process")
-		return
-	}
-	ln, err := stdnet.Listen("tcp", "127.0.0.1:0")
-	if err != nil {
-		t.Fatalf("Listen: %v", err)
-	}
-	defer ln.Close()
-	disp := &mockDispatcher{}
-	go func() {
-		conn, _ := ln.Accept()
-		if conn != nil {
-			_ = handler.Process(ctx, net.Network_TCP, stat.Connection(conn), disp)
-			conn.Close()
-		}
-	}()
-	client, err := stdnet.Dial("tcp", ln.Addr().String())
-	if err != nil {
-		t.Fatalf("Dial: %v", err)
-	}
-	defer client.Close()
-	client.SetDeadline(time.Now().Add(5 * time.Second))
-	_ = WriteMagic(client)
-	_, _ = client.Write(make([]byte, 80))
+	h := newHarness(t, "default")
+	client := dialServer(t, h, 5*time.Second)
+	writeHandshake(t, client, h.UserID)
 	resp := make([]byte, 256)
 	n, _ := client.Read(resp)
 	if n == 0 {
@@ -632,76 +263,19 @@ func TestStep3EncryptionReplay(t *testing.T) {
 // TestStep4Fallback verifies that when the first bytes are NOT Reflex (e.g. plain HTTP GET),
 // the handler forwards the connection to the fallback server (step4: fallback).
 func TestStep4Fallback(t *testing.T) {
-	// Start a fake HTTP server (fallback target)
-	fallbackDone := make(chan struct{})
-	var fallbackReq *http.Request
-	fallbackSrv := &http.Server{
-		Addr: "127.0.0.1:0",
-		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			fallbackReq = r
-			w.WriteHeader(http.StatusOK)
-			_, _ = w.Write([]byte("fallback-ok"))
-			close(fallbackDone)
-		}),
-	}
-	fln, err := stdnet.Listen("tcp", "127.0.0.1:0")
-	if err != nil {
-		t.Fatalf("fallback Listen: %v", err)
-	}
-	fallbackPort := uint32(fln.Addr().(*stdnet.TCPAddr).Port)
-	go fallbackSrv.Serve(fln)
-	defer fallbackSrv.Close()
-
-	ctx := context.Background()
-	cfg := &reflex.InboundConfig{
-		Clients: []*reflex.User{{Id: "40000000-2000-4000-8000-000000000006", Policy: "default"}},
-		Fallback: &reflex.Fallback{Dest: fallbackPort},
-	}
-	obj, err := common.CreateObject(ctx, cfg)
-	if err != nil {
-		t.Skipf("CreateObject: %v", err)
-		return
-	}
-	handler, ok := obj.(interface {
-		Process(context.Context, net.Network, stat.Connection, routing.Dispatcher) error
-		Network() []net.Network
-	})
-	if !ok {
-		t.Skip("handler does not implement Process")
-		return
-	}
-	reflexLn, err := stdnet.Listen("tcp", "127.0.0.1:0")
-	if err != nil {
-		t.Fatalf("Reflex Listen: %v", err)
-	}
-	defer reflexLn.Close()
-	go func() {
-		conn, _ := reflexLn.Accept()
-		if conn != nil {
-			_ = handler.Process(ctx, net.Network_TCP, stat.Connection(conn), &mockDispatcher{})
-			conn.Close()
-		}
-	}()
-
-	client, err := stdnet.Dial("tcp", reflexLn.Addr().String())
-	if err != nil {
-		t.Fatalf("Dial reflex: %v", err)
-	}
-	defer client.Close()
-	client.SetDeadline(time.Now().Add(5 * time.Second))
+	h := newHarness(t, "default")
+	client := dialServer(t, h, 5*time.Second)
 	// Send plain HTTP GET (non-Reflex) so handler should fallback
-	_, err = client.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
-	if err != nil {
+	if _, err := client.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")); err != nil {
 		t.Fatalf("write GET: %v", err)
 	}
-	select {
-	case <-fallbackDone:
-		// Fallback server received the request
-		if fallbackReq == nil {
-			t.Error("step4 fallback: request was not received by fallback server")
-		}
-	case <-time.After(5 * time.Second):
-		t.Error("step4 fallback: fallback server did not receive request (handler may not be forwarding)")
+	response, err := http.ReadResponse(bufio.NewReader(client), nil)
+	if err != nil {
+		t.Fatalf("step4 fallback: no response from the fallback server: %v", err)
+	}
+	body, _ := io.ReadAll(response.Body)
+	if response.StatusCode != http.StatusOK || string(body) != reflextest.WebPage {
+		t.Errorf("step4 fallback: unexpected response %d %q (handler may not be forwarding)", response.StatusCode, body)
 	}
 }
 
@@ -713,75 +287,25 @@ func TestStep4Peek(t *testing.T) {
 	})
 }
 
-// TestStep4ProxyDetectReflexNotFallback verifies that REFX connection gets handshake response
-// (treated as Reflex, not forwarded to fallback). Name: ProxyDetect for test-based Step4 scoring.
+// TestStep4ProxyDetectReflexNotFallback verifies that a Reflex client is proxied, not
+// forwarded to the fallback. Name: ProxyDetect for test-based Step4 scoring.
 func TestStep4ProxyDetectReflexNotFallback(t *testing.T) {
-	ctx := context.Background()
-	cfg := &reflex.InboundConfig{
-		Clients:  []*reflex.User{{Id: "b4000000-2000-4000-8000-00000000000b", Policy: "default"}},
-		Fallback: &reflex.Fallback{Dest: 80},
-	}
-	obj, err := common.CreateObject(ctx, cfg)
-	if err != nil {
-		t.Skipf("CreateObject: %v", err)
-		return
-	}
-	handler, ok := obj.(interface {
-		Process(context.Context, net.Network, stat.Connection, routing.Dispatcher) error
-		Network() []net.Network
-	})
-	if !ok {
-		t.Skip("handler does not implement Process")
-		return
-	}
-	ln, err := stdnet.Listen("tcp", "127.0.0.1:0")
-	if err != nil {
-		t.Fatalf("Listen: %v", err)
-	}
-	defer ln.Close()
-	disp := &mockDispatcher{}
-	go func() {
-		conn, _ := ln.Accept()
-		if conn != nil {
-			_ = handler.Process(ctx, net.Network_TCP, stat.Connection(conn), disp)
-			conn.Close()
-		}
-	}()
-	client, err := stdnet.Dial("tcp", ln.Addr().String())
-	if err != nil {
-		t.Fatalf("Dial: %v", err)
-	}
-	defer client.Close()
-	client.SetDeadline(time.Now().Add(5 * time.Second))
-	_ = WriteMagic(client)
-	_, _ = client.Write(make([]byte, 80))
-	resp := make([]byte, 256)
-	n, _ := client.Read(resp)
-	// Reflex path: server should respond to handshake (not forward to fallback)
-	if n == 0 {
-		t.Error("step4 ProxyDetect: REFX connection should get handshake response, got none")
+	h := newHarness(t, "default")
+	// The echo target answers only through the proxy; the fallback would
+	// answer with its page
+	if err := h.Echo([]byte("GET / HTTP/1.1\r\n\r\n"), 5*time.Second); err != nil {
+		t.Errorf("step4 ProxyDetect: Reflex connection was not proxied: %v", err)
 	}
 }
 
 // --- Step 5: Advanced (Morphing) ---
 
-// TestStep5TrafficProfile checks that the code compiles and handler runs; morphing
-// is optional and can be tested via unit tests in the student's package.
+// TestStep5TrafficProfile checks that a session with a traffic profile carries data.
 func TestStep5TrafficProfile(t *testing.T) {
-	ctx := context.Background()
-	cfg := &reflex.InboundConfig{
-		Clients: []*reflex.User{{Id: "50000000-2000-4000-8000-000000000007", Policy: "mimic-http2-api"}},
-	}
-	obj, err := common.CreateObject(ctx, cfg)
-	if err != nil {
-		t.Skipf("CreateObject: %v", err)
-		return
+	h := newHarness(t, "mimic-http2-api")
+	if err := h.Echo([]byte("step5 profile"), 5*time.Second); err != nil {
+		t.Errorf("step5: %v", err)
 	}
-	if obj == nil {
-		t.Fatal("step5: handler is nil")
-	}
-	_ = ctx
-	_ = cfg
 }
 
 // TestStep5PaddingTimingControl verifies that the handler accepts connections;
@@ -790,41 +314,24 @@ func TestStep5PaddingTimingControl(t *testing.T) {
 	TestStep1BuildAndListen(t)
 }
 
-// TestStep5GetPacketSizeGetDelay verifies handler works with policy (morphing uses GetPacketSize/GetDelay).
-// Name matches script pattern "GetPacketSize|GetDelay|AddPadding" for test-based Step5 scoring.
+// TestStep5GetPacketSizeGetDelay verifies that data goes through sessions morphed to a policy
+// (morphing uses GetPacketSize/GetDelay). Name matches script pattern "GetPacketSize|GetDelay|AddPadding"
+// for test-based Step5 scoring.
 func TestStep5GetPacketSizeGetDelay(t *testing.T) {
-	ctx := context.Background()
-	cfg := &reflex.InboundConfig{
-		Clients: []*reflex.User{{Id: "e5f6a7b8-2000-4000-8000-00000000000e", Policy: "youtube"}},
-	}
-	obj, err := common.CreateObject(ctx, cfg)
-	if err != nil {
-		t.Skipf("CreateObject: %v", err)
-		return
+	h := newHarness(t, "youtube")
+	if err := h.Echo(bytes.Repeat([]byte("morph"), 10000), 5*time.Second); err != nil {
+		t.Errorf("step5 GetPacketSize/GetDelay: %v", err)
 	}
-	if obj == nil {
-		t.Fatal("step5 GetPacketSize/GetDelay: handler is nil")
-	}
-	// Handler exists and accepts config with policy; morphing logic is internal
-	_ = obj
 }
 
-// TestStep5MorphingPolicy verifies handler creation with different policies (default, youtube).
+// TestStep5MorphingPolicy verifies sessions with different policies (default, youtube).
 // Name matches script pattern "Morph|Profile|TrafficProfile" for test-based Step5 scoring.
 func TestStep5MorphingPolicy(t *testing.T) {
-	ctx := context.Background()
 	policies := []string{"default", "youtube", "mimic-http2-api"}
 	for _, policy := range policies {
-		cfg := &reflex.InboundConfig{
-			Clients: []*reflex.User{{Id: "f6000000-2000-4000-8000-00000000000f", Policy: policy}},
-		}
-		obj, err := common.CreateObject(ctx, cfg)
-		if err != nil {
-			t.Skipf("CreateObject policy=%q: %v", policy, err)
-			return
-		}
-		if obj == nil {
-			t.Errorf("step5 morphing policy %q: handler is nil", policy)
+		h := newHarness(t, policy)
+		if err := h.Echo([]byte(policy), 5*time.Second); err != nil {
+			t.Errorf("step5 morphing policy %q: %v", policy, err)
 		}
 	}
 }
@@ -850,106 +357,27 @@ func TestIntegrationReplay(t *testing.T) {
 // TestIntegrationMultipleHandshakes verifies two clients can each complete a handshake
 // (multiple connections, both get response). Name: Integration, Handshake for scoring.
 func TestIntegrationMultipleHandshakes(t *testing.T) {
-	ctx := context.Background()
-	cfg := &reflex.InboundConfig{
-		Clients: []*reflex.User{{Id: "c6000000-2000-4000-8000-00000000000c", Policy: "default"}},
-	}
-	obj, err := common.CreateObject(ctx, cfg)
-	if err != nil {
-		t.Skipf("CreateObject: %v", err)
-		return
-	}
-	handler, ok := obj.(interface {
-		Process(context.Context, net.Network, stat.Connection, routing.Dispatcher) error
-		Network() []net.Network
-	})
-	if !ok {
-		t.Skip("handler does not implement Process")
-		return
-	}
-	ln, err := stdnet.Listen("tcp", "127.0.0.1:0")
-	if err != nil {
-		t.Fatalf("Listen: %v", err)
-	}
-	defer ln.Close()
-	disp := &mockDispatcher{}
+	h := newHarness(t, "default")
 	var wg sync.WaitGroup
 	for i := 0; i < 2; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			conn, err := stdnet.Dial("tcp", ln.Addr().String())
-			if err != nil {
-				t.Error("Dial:", err)
-				return
-			}
-			defer conn.Close()
-			conn.SetDeadline(time.Now().Add(5 * time.Second))
-			_ = WriteMagic(conn)
-			_, _ = conn.Write(make([]byte, 80))
-			resp := make([]byte, 256)
-			n, _ := conn.Read(resp)
-			if n == 0 {
-				t.Error("integration multiple handshakes: one client got no response")
+			if err := h.Echo([]byte("integration"), 5*time.Second); err != nil {
+				t.Error("integration multiple handshakes: one client got no response:", err)
 			}
 		}()
 	}
-	go func() {
-		for i := 0; i < 2; i++ {
-			c, _ := ln.Accept()
-			if c != nil {
-				go func(conn stdnet.Conn) {
-					_ = handler.Process(ctx, net.Network_TCP, stat.Connection(conn), disp)
-					conn.Close()
-				}(c)
-			}
-		}
-	}()
 	wg.Wait()
 }
 
 // TestGradingReadResponse reads until timeout or newline (for HTTP-like response).
 func TestGradingReadResponse(t *testing.T) {
-	ctx := context.Background()
-	cfg := &reflex.InboundConfig{
-		Clients: []*reflex.User{{Id: "60000000-2000-4000-8000-000000000008", Policy: "default"}},
-	}
-	obj, err := common.CreateObject(ctx, cfg)
-	if err != nil {
-		t.Skipf("CreateObject: %v", err)
-		return
-	}
-	handler, ok := obj.(interface {
-		Process(context.Context, net.Network, stat.Connection, routing.Dispatcher) error
-		Network() []net.Network
-	})
-	if !ok {
-		t.Skip("handler does not implement Process")
-		return
-	}
-	ln, err := stdnet.Listen("tcp", "127.0.0.1:0")
-	if err != nil {
-		t.Fatalf("Listen: %v", err)
-	}
-	defer ln.Close()
-	disp := &mockDispatcher{}
-	go func() {
-		conn, _ := ln.Accept()
-		if conn != nil {
-			_ = handler.Process(ctx, net.Network_TCP, stat.Connection(conn), disp)
-			conn.Close()
-		}
-	}()
-	client, err := stdnet.Dial("tcp", ln.Addr().String())
-	if err != nil {
-		t.Fatalf("Dial: %v", err)
-	}
-	defer client.Close()
-	client.SetDeadline(time.Now().Add(3 * time.Second))
-	_ = WriteMagic(client)
-	_, _ = client.Write(make([]byte, 80))
+	h := newHarness(t, "default")
+	client := dialServer(t, h, 500*time.Millisecond)
+	writeHandshake(t, client, h.UserID)
 	rd := bufio.NewReader(client)
-	_, err = rd.ReadBytes('\n')
+	_, err := rd.ReadBytes('\n')
 	if err != nil && err != io.EOF {
 		t.Logf("ReadBytes: %v (may be ok if server sends binary)", err)
 	}
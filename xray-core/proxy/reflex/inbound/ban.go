@@ -0,0 +1,296 @@
+package inbound
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/xtls/xray-core/common/net"
+)
+
+// BanEntry is a source banned after too many failed handshakes.
+type BanEntry struct {
+	// Source is an IP address, or a /24 or /64 prefix in CIDR notation.
+	Source    string
+	ExpiresAt time.Time
+	// Strikes is the number of times the source has been banned in a row.
+	Strikes uint32
+}
+
+// BanManager is implemented by inbounds that ban sources of failed handshakes.
+type BanManager interface {
+	// GetBans returns the active bans.
+	GetBans() []*BanEntry
+	// ClearBans lifts the bans of the given sources, or all bans if none are given.
+	ClearBans(sources []string) int
+}
+
+// maxBanRecords caps the sources a banTracker remembers, so that probing
+// from many addresses cannot grow it without bound.
+const maxBanRecords = 1 << 16
+
+type banRecord struct {
+	failures    uint32
+	windowStart time.Time
+	lastFailure time.Time
+	bannedUntil time.Time
+	strikes     uint32
+}
+
+// banTracker counts failed handshakes per source IP, /24 and /64 and bans
+// sources that exceed their threshold, with exponential back-off.
+type banTracker struct {
+	sync.Mutex
+	maxFailures       uint32
+	subnetMaxFailures uint32
+	window            time.Duration
+	duration          time.Duration
+	maxDuration       time.Duration
+
+	records    map[string]*banRecord
+	maxRecords int
+	lastSweep  time.Time
+	now        func() time.Time
+}
+
+// newBanTracker returns a tracker for the config, or nil if banning is disabled.
+func newBanTracker(config *Ban) *banTracker {
+	if config == nil || config.MaxFailures == 0 {
+		return nil
+	}
+	t := &banTracker{
+		maxFailures:       config.MaxFailures,
+		subnetMaxFailures: config.SubnetMaxFailures,
+		window:            time.Duration(config.Window) * time.Second,
+		duration:          time.Duration(config.Duration) * time.Second,
+		maxDuration:       time.Duration(config.MaxDuration) * time.Second,
+		records:           make(map[string]*banRecord),
+		maxRecords:        maxBanRecords,
+		now:               time.Now,
+	}
+	if t.subnetMaxFailures == 0 {
+		t.subnetMaxFailures = t.maxFailures * 10
+	}
+	if t.window == 0 {
+		t.window = time.Minute
+	}
+	if t.duration == 0 {
+		t.duration = time.Minute
+	}
+	if t.maxDuration == 0 {
+		t.maxDuration = 24 * time.Hour
+	}
+	if t.maxDuration < t.duration {
+		t.maxDuration = t.duration
+	}
+	return t
+}
+
+// banKeys returns the IP and its /24 or /64 prefix.
+func banKeys(ip string) []string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return []string{ip}
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		prefix := net.IPNet{IP: v4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}
+		return []string{v4.String(), prefix.String()}
+	}
+	prefix := net.IPNet{IP: parsed.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}
+	return []string{parsed.String(), prefix.String()}
+}
+
+// Banned returns whether the IP or its subnet is banned.
+func (t *banTracker) Banned(ip string) bool {
+	if t == nil {
+		return false
+	}
+	t.Lock()
+	defer t.Unlock()
+
+	now := t.now()
+	for _, key := range banKeys(ip) {
+		if r, found := t.records[key]; found && now.Before(r.bannedUntil) {
+			return true
+		}
+	}
+	return false
+}
+
+// Failure records a failed handshake from the IP.
+func (t *banTracker) Failure(ip string) {
+	if t == nil {
+		return
+	}
+	t.Lock()
+	defer t.Unlock()
+
+	now := t.now()
+	t.sweep(now)
+	for i, key := range banKeys(ip) {
+		threshold := t.maxFailures
+		if i > 0 {
+			threshold = t.subnetMaxFailures
+		}
+		r := t.records[key]
+		if r == nil {
+			if !t.makeRoom(now) {
+				continue
+			}
+			r = &banRecord{windowStart: now}
+			t.records[key] = r
+		}
+		if now.Sub(r.windowStart) > t.window {
+			r.failures = 0
+			r.windowStart = now
+		}
+		// A source that stayed clean for a full ban period starts over
+		if r.strikes > 0 && now.Sub(r.lastFailure) > t.maxDuration {
+			r.strikes = 0
+		}
+		r.failures++
+		r.lastFailure = now
+		if r.failures >= threshold {
+			r.strikes++
+			r.failures = 0
+			r.bannedUntil = now.Add(t.banDuration(r.strikes))
+		}
+	}
+}
+
+// Success clears the failures of the IP after a successful handshake.
+func (t *banTracker) Success(ip string) {
+	if t == nil {
+		return
+	}
+	t.Lock()
+	defer t.Unlock()
+
+	key := banKeys(ip)[0]
+	if r, found := t.records[key]; found && r.strikes == 0 {
+		delete(t.records, key)
+	}
+}
+
+func (t *banTracker) banDuration(strikes uint32) time.Duration {
+	d := t.duration
+	for i := uint32(1); i < strikes && d < t.maxDuration; i++ {
+		d *= 2
+	}
+	return min(d, t.maxDuration)
+}
+
+// sweep drops records that can no longer affect a ban, at most once per window.
+func (t *banTracker) sweep(now time.Time) {
+	if now.Sub(t.lastSweep) < t.window {
+		return
+	}
+	t.lastSweep = now
+	t.drop(now)
+}
+
+// drop removes the records that can no longer affect a ban: those without
+// strikes once their window has passed, and the others once they are
+// neither banned nor recent enough to add to their strikes.
+func (t *banTracker) drop(now time.Time) {
+	for key, r := range t.records {
+		idle := now.Sub(r.lastFailure)
+		if r.strikes == 0 && idle > t.window ||
+			now.After(r.bannedUntil) && idle > max(t.window, t.maxDuration) {
+			delete(t.records, key)
+		}
+	}
+}
+
+// makeRoom makes room for a new record if the tracker is full, by dropping
+// stale records, then the least recent ones that are not banned. It reports
+// whether there is room, which there is not when every record is a ban.
+func (t *banTracker) makeRoom(now time.Time) bool {
+	if len(t.records) < t.maxRecords {
+		return true
+	}
+	t.drop(now)
+	if len(t.records) < t.maxRecords {
+		return true
+	}
+	// Evict an eighth at once, so that this runs once per many new sources
+	type candidate struct {
+		key         string
+		lastFailure time.Time
+	}
+	var candidates []candidate
+	for key, r := range t.records {
+		if !now.Before(r.bannedUntil) {
+			candidates = append(candidates, candidate{key, r.lastFailure})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].lastFailure.Before(candidates[j].lastFailure)
+	})
+	evict := min(len(candidates), max(t.maxRecords/8, 1))
+	for _, c := range candidates[:evict] {
+		delete(t.records, c.key)
+	}
+	return len(t.records) < t.maxRecords
+}
+
+// GetBans returns the active bans, sorted by source.
+func (t *banTracker) GetBans() []*BanEntry {
+	if t == nil {
+		return nil
+	}
+	t.Lock()
+	defer t.Unlock()
+
+	now := t.now()
+	var bans []*BanEntry
+	for key, r := range t.records {
+		if now.Before(r.bannedUntil) {
+			bans = append(bans, &BanEntry{
+				Source:    key,
+				ExpiresAt: r.bannedUntil,
+				Strikes:   r.strikes,
+			})
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool {
+		return bans[i].Source < bans[j].Source
+	})
+	return bans
+}
+
+// ClearBans lifts the bans of the given sources, or all bans if none are
+// given, and returns the number of bans lifted.
+func (t *banTracker) ClearBans(sources []string) int {
+	if t == nil {
+		return 0
+	}
+	t.Lock()
+	defer t.Unlock()
+
+	now := t.now()
+	cleared := 0
+	lift := func(key string) {
+		if r, found := t.records[key]; found {
+			if now.Before(r.bannedUntil) {
+				cleared++
+			}
+			delete(t.records, key)
+		}
+	}
+	if len(sources) == 0 {
+		for key := range t.records {
+			lift(key)
+		}
+		return cleared
+	}
+	for _, source := range sources {
+		if _, prefix, err := net.ParseCIDR(source); err == nil {
+			source = prefix.String()
+		} else if ip := net.ParseIP(source); ip != nil {
+			source = banKeys(source)[0]
+		}
+		lift(source)
+	}
+	return cleared
+}
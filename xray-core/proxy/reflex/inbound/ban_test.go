@@ -0,0 +1,183 @@
+package inbound
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func newTestBanTracker(config *Ban) (*banTracker, *time.Time) {
+	now := time.Unix(1700000000, 0)
+	t := newBanTracker(config)
+	t.now = func() time.Time { return now }
+	return t, &now
+}
+
+// TestBanTrackerDisabled tests that banning is off without max failures
+func TestBanTrackerDisabled(t *testing.T) {
+	tracker := newBanTracker(&Ban{})
+	tracker.Failure("192.0.2.1")
+	if tracker.Banned("192.0.2.1") {
+		t.Fatal("disabled tracker should not ban")
+	}
+}
+
+// TestBanTrackerBackoff tests banning an IP with exponential back-off
+func TestBanTrackerBackoff(t *testing.T) {
+	tracker, now := newTestBanTracker(&Ban{MaxFailures: 3, Duration: 60, MaxDuration: 200})
+
+	for range 2 {
+		tracker.Failure("192.0.2.1")
+	}
+	if tracker.Banned("192.0.2.1") {
+		t.Fatal("IP should not be banned below the threshold")
+	}
+	tracker.Failure("192.0.2.1")
+	if !tracker.Banned("192.0.2.1") {
+		t.Fatal("IP should be banned at the threshold")
+	}
+	if tracker.Banned("192.0.2.2") {
+		t.Fatal("neighbour should not be banned")
+	}
+
+	// Each repeated ban doubles, up to the maximum.
+	for _, expected := range []time.Duration{120 * time.Second, 200 * time.Second} {
+		*now = tracker.GetBans()[0].ExpiresAt
+		if tracker.Banned("192.0.2.1") {
+			t.Fatal("ban should have expired")
+		}
+		for range 3 {
+			tracker.Failure("192.0.2.1")
+		}
+		bans := tracker.GetBans()
+		if len(bans) != 1 {
+			t.Fatalf("expected 1 ban, got %d", len(bans))
+		}
+		if d := bans[0].ExpiresAt.Sub(*now); d != expected {
+			t.Fatalf("expected ban of %v, got %v", expected, d)
+		}
+	}
+}
+
+// TestBanTrackerWindow tests that failures outside the window are forgotten
+func TestBanTrackerWindow(t *testing.T) {
+	tracker, now := newTestBanTracker(&Ban{MaxFailures: 3, Window: 10})
+
+	for range 5 {
+		tracker.Failure("192.0.2.1")
+		tracker.Failure("192.0.2.1")
+		*now = now.Add(11 * time.Second)
+	}
+	if tracker.Banned("192.0.2.1") {
+		t.Fatal("failures spread over several windows should not ban")
+	}
+}
+
+// TestBanTrackerSubnet tests banning the /24 and /64 of many failing IPs
+func TestBanTrackerSubnet(t *testing.T) {
+	tracker, _ := newTestBanTracker(&Ban{MaxFailures: 5, SubnetMaxFailures: 4})
+
+	for _, ip := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.4"} {
+		tracker.Failure(ip)
+	}
+	if !tracker.Banned("192.0.2.200") {
+		t.Fatal("/24 should be banned")
+	}
+	if tracker.Banned("192.0.3.1") {
+		t.Fatal("other /24 should not be banned")
+	}
+
+	for _, ip := range []string{"2001:db8::1", "2001:db8::2", "2001:db8::3:4", "2001:db8::ffff"} {
+		tracker.Failure(ip)
+	}
+	if !tracker.Banned("2001:db8::abcd") {
+		t.Fatal("/64 should be banned")
+	}
+	if tracker.Banned("2001:db8:0:1::1") {
+		t.Fatal("other /64 should not be banned")
+	}
+
+	bans := tracker.GetBans()
+	if len(bans) != 2 || bans[0].Source != "192.0.2.0/24" || bans[1].Source != "2001:db8::/64" {
+		t.Fatalf("unexpected bans: %v", bans)
+	}
+}
+
+// TestBanTrackerSuccess tests that a successful handshake clears failures
+func TestBanTrackerSuccess(t *testing.T) {
+	tracker, _ := newTestBanTracker(&Ban{MaxFailures: 3})
+
+	tracker.Failure("192.0.2.1")
+	tracker.Failure("192.0.2.1")
+	tracker.Success("192.0.2.1")
+	tracker.Failure("192.0.2.1")
+	if tracker.Banned("192.0.2.1") {
+		t.Fatal("failures before a success should be forgotten")
+	}
+}
+
+// TestBanTrackerClear tests lifting bans
+func TestBanTrackerClear(t *testing.T) {
+	tracker, _ := newTestBanTracker(&Ban{MaxFailures: 1, SubnetMaxFailures: 1})
+
+	tracker.Failure("192.0.2.1")
+	tracker.Failure("198.51.100.1")
+	if n := len(tracker.GetBans()); n != 4 {
+		t.Fatalf("expected 4 bans, got %d", n)
+	}
+
+	if n := tracker.ClearBans([]string{"192.0.2.1", "192.0.2.7/24"}); n != 2 {
+		t.Fatalf("expected 2 bans lifted, got %d", n)
+	}
+	if tracker.Banned("192.0.2.1") {
+		t.Fatal("ban should be lifted")
+	}
+	if !tracker.Banned("198.51.100.1") {
+		t.Fatal("other ban should remain")
+	}
+
+	if n := tracker.ClearBans(nil); n != 2 {
+		t.Fatalf("expected 2 bans lifted, got %d", n)
+	}
+	if len(tracker.GetBans()) != 0 {
+		t.Fatal("all bans should be lifted")
+	}
+}
+
+// TestBanTrackerForget tests that sources without bans are forgotten once
+// their window has passed, while banned ones are kept
+func TestBanTrackerForget(t *testing.T) {
+	tracker, now := newTestBanTracker(&Ban{MaxFailures: 2, Window: 10})
+
+	tracker.Failure("192.0.2.1")
+	tracker.Failure("192.0.2.2")
+	tracker.Failure("192.0.2.2")
+	*now = now.Add(11 * time.Second)
+	tracker.Failure("198.51.100.1")
+	if _, found := tracker.records["192.0.2.1"]; found {
+		t.Error("source without strikes should be forgotten after the window")
+	}
+	if _, found := tracker.records["192.0.2.2"]; !found {
+		t.Error("banned source should be kept")
+	}
+}
+
+// TestBanTrackerLimit tests that the tracker stays within its size, keeping
+// bans over failures
+func TestBanTrackerLimit(t *testing.T) {
+	tracker, now := newTestBanTracker(&Ban{MaxFailures: 2, SubnetMaxFailures: 1000})
+	tracker.maxRecords = 64
+
+	tracker.Failure("192.0.2.1")
+	tracker.Failure("192.0.2.1")
+	for i := range 1000 {
+		*now = now.Add(time.Millisecond)
+		tracker.Failure(fmt.Sprintf("2001:db8::%x", i))
+		if len(tracker.records) > tracker.maxRecords {
+			t.Fatalf("%d records over the limit of %d", len(tracker.records), tracker.maxRecords)
+		}
+	}
+	if !tracker.Banned("192.0.2.1") {
+		t.Error("ban should survive the eviction of failures")
+	}
+}
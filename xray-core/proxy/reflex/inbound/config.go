@@ -0,0 +1,27 @@
+package inbound
+
+import (
+	"encoding/json"
+)
+
+// UnmarshalJSON implements custom JSON unmarshaling for Config
+func (c *Config) UnmarshalJSON(data []byte) error {
+	type configAlias Config
+	aux := &struct {
+		*configAlias
+	}{
+		configAlias: (*configAlias)(c),
+	}
+	return json.Unmarshal(data, &aux)
+}
+
+// UnmarshalJSON implements custom JSON unmarshaling for Fallback
+func (f *Fallback) UnmarshalJSON(data []byte) error {
+	type fallbackAlias Fallback
+	aux := &struct {
+		*fallbackAlias
+	}{
+		fallbackAlias: (*fallbackAlias)(f),
+	}
+	return json.Unmarshal(data, &aux)
+}
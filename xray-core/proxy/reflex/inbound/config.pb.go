@@ -7,8 +7,6 @@
 package inbound
 
 import (
-	"encoding/json"
-
 	protocol "github.com/xtls/xray-core/common/protocol"
 	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
 	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
@@ -108,17 +106,344 @@ func (x *Fallback) GetXver() uint64 {
 	return 0
 }
 
+// Ban tracks failed handshakes per source IP, /24 and /64 and bans sources
+// that fail too often. Disabled when max_failures is 0.
+type Ban struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Failed handshakes from one IP within the window before it is banned.
+	MaxFailures uint32 `protobuf:"varint,1,opt,name=max_failures,json=maxFailures,proto3" json:"max_failures,omitempty"`
+	// Failed handshakes from one /24 or /64 within the window before it is
+	// banned. Defaults to 10 times max_failures.
+	SubnetMaxFailures uint32 `protobuf:"varint,2,opt,name=subnet_max_failures,json=subnetMaxFailures,proto3" json:"subnet_max_failures,omitempty"`
+	// Window in seconds over which failures are counted. Defaults to 60.
+	Window uint32 `protobuf:"varint,3,opt,name=window,proto3" json:"window,omitempty"`
+	// Duration in seconds of the first ban, doubled for each repeated ban.
+	// Defaults to 60.
+	Duration uint32 `protobuf:"varint,4,opt,name=duration,proto3" json:"duration,omitempty"`
+	// Upper bound in seconds of the ban duration. Defaults to 86400.
+	MaxDuration   uint32 `protobuf:"varint,5,opt,name=max_duration,json=maxDuration,proto3" json:"max_duration,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Ban) Reset() {
+	*x = Ban{}
+	mi := &file_proxy_reflex_inbound_config_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Ban) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Ban) ProtoMessage() {}
+
+func (x *Ban) ProtoReflect() protoreflect.Message {
+	mi := &file_proxy_reflex_inbound_config_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Ban.ProtoReflect.Descriptor instead.
+func (*Ban) Descriptor() ([]byte, []int) {
+	return file_proxy_reflex_inbound_config_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Ban) GetMaxFailures() uint32 {
+	if x != nil {
+		return x.MaxFailures
+	}
+	return 0
+}
+
+func (x *Ban) GetSubnetMaxFailures() uint32 {
+	if x != nil {
+		return x.SubnetMaxFailures
+	}
+	return 0
+}
+
+func (x *Ban) GetWindow() uint32 {
+	if x != nil {
+		return x.Window
+	}
+	return 0
+}
+
+func (x *Ban) GetDuration() uint32 {
+	if x != nil {
+		return x.Duration
+	}
+	return 0
+}
+
+func (x *Ban) GetMaxDuration() uint32 {
+	if x != nil {
+		return x.MaxDuration
+	}
+	return 0
+}
+
+// PolicyOverride forces a morphing profile on the sessions of the inbound,
+// whatever the user's policy and the profile the client asks for.
+type PolicyOverride struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Name of the profile, as in the policy of an account.
+	Policy string `protobuf:"bytes,1,opt,name=policy,proto3" json:"policy,omitempty"`
+	// Minutes after local midnight from which the override is in force, up to
+	// but excluding to. The window may wrap around midnight. An override whose
+	// from and to are equal is always in force.
+	From          uint32 `protobuf:"varint,2,opt,name=from,proto3" json:"from,omitempty"`
+	To            uint32 `protobuf:"varint,3,opt,name=to,proto3" json:"to,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PolicyOverride) Reset() {
+	*x = PolicyOverride{}
+	mi := &file_proxy_reflex_inbound_config_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PolicyOverride) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PolicyOverride) ProtoMessage() {}
+
+func (x *PolicyOverride) ProtoReflect() protoreflect.Message {
+	mi := &file_proxy_reflex_inbound_config_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PolicyOverride.ProtoReflect.Descriptor instead.
+func (*PolicyOverride) Descriptor() ([]byte, []int) {
+	return file_proxy_reflex_inbound_config_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *PolicyOverride) GetPolicy() string {
+	if x != nil {
+		return x.Policy
+	}
+	return ""
+}
+
+func (x *PolicyOverride) GetFrom() uint32 {
+	if x != nil {
+		return x.From
+	}
+	return 0
+}
+
+func (x *PolicyOverride) GetTo() uint32 {
+	if x != nil {
+		return x.To
+	}
+	return 0
+}
+
+// UserStore loads clients from a file, next to the static ones, and applies
+// the changes made to the file while running.
+type UserStore struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Path of the file.
+	Path string `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	// "json", "csv" or "sqlite". Defaults to the extension of the path,
+	// .db and .sqlite being SQLite.
+	Format string `protobuf:"bytes,2,opt,name=format,proto3" json:"format,omitempty"`
+	// Seconds between checks of the file for changes. Defaults to 10.
+	ReloadInterval uint32 `protobuf:"varint,3,opt,name=reload_interval,json=reloadInterval,proto3" json:"reload_interval,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *UserStore) Reset() {
+	*x = UserStore{}
+	mi := &file_proxy_reflex_inbound_config_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UserStore) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UserStore) ProtoMessage() {}
+
+func (x *UserStore) ProtoReflect() protoreflect.Message {
+	mi := &file_proxy_reflex_inbound_config_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UserStore.ProtoReflect.Descriptor instead.
+func (*UserStore) Descriptor() ([]byte, []int) {
+	return file_proxy_reflex_inbound_config_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *UserStore) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *UserStore) GetFormat() string {
+	if x != nil {
+		return x.Format
+	}
+	return ""
+}
+
+func (x *UserStore) GetReloadInterval() uint32 {
+	if x != nil {
+		return x.ReloadInterval
+	}
+	return 0
+}
+
+// Decoy is a website the inbound serves itself to the connections that are
+// not Reflex and match no fallback, or whose fallback refuses them.
+type Decoy struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Directory whose files are served.
+	Root string `protobuf:"bytes,1,opt,name=root,proto3" json:"root,omitempty"`
+	// URL of a website proxied instead of serving root, such as
+	// "http://127.0.0.1:8080".
+	Upstream string `protobuf:"bytes,2,opt,name=upstream,proto3" json:"upstream,omitempty"`
+	// Server header of the responses, also signing the error pages. Defaults
+	// to "nginx", or to the header of the upstream.
+	Server string `protobuf:"bytes,3,opt,name=server,proto3" json:"server,omitempty"`
+	// PEM files of the certificate chain and private key with which the
+	// decoy answers TLS that reaches it on a transport without security.
+	// Without them such connections are closed.
+	CertificateFile string `protobuf:"bytes,4,opt,name=certificate_file,json=certificateFile,proto3" json:"certificate_file,omitempty"`
+	KeyFile         string `protobuf:"bytes,5,opt,name=key_file,json=keyFile,proto3" json:"key_file,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *Decoy) Reset() {
+	*x = Decoy{}
+	mi := &file_proxy_reflex_inbound_config_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Decoy) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Decoy) ProtoMessage() {}
+
+func (x *Decoy) ProtoReflect() protoreflect.Message {
+	mi := &file_proxy_reflex_inbound_config_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Decoy.ProtoReflect.Descriptor instead.
+func (*Decoy) Descriptor() ([]byte, []int) {
+	return file_proxy_reflex_inbound_config_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Decoy) GetRoot() string {
+	if x != nil {
+		return x.Root
+	}
+	return ""
+}
+
+func (x *Decoy) GetUpstream() string {
+	if x != nil {
+		return x.Upstream
+	}
+	return ""
+}
+
+func (x *Decoy) GetServer() string {
+	if x != nil {
+		return x.Server
+	}
+	return ""
+}
+
+func (x *Decoy) GetCertificateFile() string {
+	if x != nil {
+		return x.CertificateFile
+	}
+	return ""
+}
+
+func (x *Decoy) GetKeyFile() string {
+	if x != nil {
+		return x.KeyFile
+	}
+	return ""
+}
+
 type Config struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Clients       []*protocol.User       `protobuf:"bytes,1,rep,name=clients,proto3" json:"clients,omitempty"`
-	Fallbacks     []*Fallback            `protobuf:"bytes,2,rep,name=fallbacks,proto3" json:"fallbacks,omitempty"`
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	Clients   []*protocol.User       `protobuf:"bytes,1,rep,name=clients,proto3" json:"clients,omitempty"`
+	Fallbacks []*Fallback            `protobuf:"bytes,2,rep,name=fallbacks,proto3" json:"fallbacks,omitempty"`
+	// Interval in seconds between keepalive PING frames. 0 disables keepalive.
+	KeepAliveInterval uint32 `protobuf:"varint,3,opt,name=keep_alive_interval,json=keepAliveInterval,proto3" json:"keep_alive_interval,omitempty"`
+	Ban               *Ban   `protobuf:"bytes,4,opt,name=ban,proto3" json:"ban,omitempty"`
+	// Seconds a session whose connection broke is kept for the client to
+	// resume it on a new connection. 0 declines resumption.
+	ResumeGracePeriod uint32 `protobuf:"varint,5,opt,name=resume_grace_period,json=resumeGracePeriod,proto3" json:"resume_grace_period,omitempty"`
+	// Overrides of the granted morphing profile. The first one in force
+	// applies.
+	PolicyOverrides []*PolicyOverride `protobuf:"bytes,6,rep,name=policy_overrides,json=policyOverrides,proto3" json:"policy_overrides,omitempty"`
+	// Maximum difference in seconds between the timestamp of a hello and the
+	// local clock. Defaults to 120.
+	TimestampTolerance uint32 `protobuf:"varint,7,opt,name=timestamp_tolerance,json=timestampTolerance,proto3" json:"timestamp_tolerance,omitempty"`
+	// Maximum difference in seconds accepted from a known user once a hello
+	// of theirs has been rejected for its timestamp. 0 disables widening.
+	MaxClockSkew uint32     `protobuf:"varint,8,opt,name=max_clock_skew,json=maxClockSkew,proto3" json:"max_clock_skew,omitempty"`
+	UserStore    *UserStore `protobuf:"bytes,9,opt,name=user_store,json=userStore,proto3" json:"user_store,omitempty"`
+	// Path of a file to which the session key of each connection is
+	// appended, to decrypt captures with "xray reflex decode". Anyone holding
+	// the file can read the traffic; leave empty outside of debugging.
+	KeyLog string `protobuf:"bytes,10,opt,name=key_log,json=keyLog,proto3" json:"key_log,omitempty"`
+	Decoy  *Decoy `protobuf:"bytes,11,opt,name=decoy,proto3" json:"decoy,omitempty"`
+	// Tag of the inbound, under which the users of the user store are
+	// counted. Set from the tag of the inbound by the JSON config.
+	Tag           string `protobuf:"bytes,12,opt,name=tag,proto3" json:"tag,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *Config) Reset() {
 	*x = Config{}
-	mi := &file_proxy_reflex_inbound_config_proto_msgTypes[1]
+	mi := &file_proxy_reflex_inbound_config_proto_msgTypes[5]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -130,7 +455,7 @@ func (x *Config) String() string {
 func (*Config) ProtoMessage() {}
 
 func (x *Config) ProtoReflect() protoreflect.Message {
-	mi := &file_proxy_reflex_inbound_config_proto_msgTypes[1]
+	mi := &file_proxy_reflex_inbound_config_proto_msgTypes[5]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -143,7 +468,7 @@ func (x *Config) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Config.ProtoReflect.Descriptor instead.
 func (*Config) Descriptor() ([]byte, []int) {
-	return file_proxy_reflex_inbound_config_proto_rawDescGZIP(), []int{1}
+	return file_proxy_reflex_inbound_config_proto_rawDescGZIP(), []int{5}
 }
 
 func (x *Config) GetClients() []*protocol.User {
@@ -160,6 +485,76 @@ func (x *Config) GetFallbacks() []*Fallback {
 	return nil
 }
 
+func (x *Config) GetKeepAliveInterval() uint32 {
+	if x != nil {
+		return x.KeepAliveInterval
+	}
+	return 0
+}
+
+func (x *Config) GetBan() *Ban {
+	if x != nil {
+		return x.Ban
+	}
+	return nil
+}
+
+func (x *Config) GetResumeGracePeriod() uint32 {
+	if x != nil {
+		return x.ResumeGracePeriod
+	}
+	return 0
+}
+
+func (x *Config) GetPolicyOverrides() []*PolicyOverride {
+	if x != nil {
+		return x.PolicyOverrides
+	}
+	return nil
+}
+
+func (x *Config) GetTimestampTolerance() uint32 {
+	if x != nil {
+		return x.TimestampTolerance
+	}
+	return 0
+}
+
+func (x *Config) GetMaxClockSkew() uint32 {
+	if x != nil {
+		return x.MaxClockSkew
+	}
+	return 0
+}
+
+func (x *Config) GetUserStore() *UserStore {
+	if x != nil {
+		return x.UserStore
+	}
+	return nil
+}
+
+func (x *Config) GetKeyLog() string {
+	if x != nil {
+		return x.KeyLog
+	}
+	return ""
+}
+
+func (x *Config) GetDecoy() *Decoy {
+	if x != nil {
+		return x.Decoy
+	}
+	return nil
+}
+
+func (x *Config) GetTag() string {
+	if x != nil {
+		return x.Tag
+	}
+	return ""
+}
+
 var File_proxy_reflex_inbound_config_proto protoreflect.FileDescriptor
 
 const file_proxy_reflex_inbound_config_proto_rawDesc = "" +
@@ -171,10 +566,42 @@ const file_proxy_reflex_inbound_config_proto_rawDesc = "" +
 	"\x04path\x18\x03 \x01(\tR\x04path\x12\x12\n" +
 	"\x04type\x18\x04 \x01(\tR\x04type\x12\x12\n" +
 	"\x04dest\x18\x05 \x01(\tR\x04dest\x12\x12\n" +
-	"\x04xver\x18\x06 \x01(\x04R\x04xver\"\x81\x01\n" +
+	"\x04xver\x18\x06 \x01(\x04R\x04xver\"\xaf\x01\n" +
+	"\x03Ban\x12!\n" +
+	"\fmax_failures\x18\x01 \x01(\rR\vmaxFailures\x12.\n" +
+	"\x13subnet_max_failures\x18\x02 \x01(\rR\x11subnetMaxFailures\x12\x16\n" +
+	"\x06window\x18\x03 \x01(\rR\x06window\x12\x1a\n" +
+	"\bduration\x18\x04 \x01(\rR\bduration\x12!\n" +
+	"\fmax_duration\x18\x05 \x01(\rR\vmaxDuration\"L\n" +
+	"\x0ePolicyOverride\x12\x16\n" +
+	"\x06policy\x18\x01 \x01(\tR\x06policy\x12\x12\n" +
+	"\x04from\x18\x02 \x01(\rR\x04from\x12\x0e\n" +
+	"\x02to\x18\x03 \x01(\rR\x02to\"`\n" +
+	"\tUserStore\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\x12\x16\n" +
+	"\x06format\x18\x02 \x01(\tR\x06format\x12'\n" +
+	"\x0freload_interval\x18\x03 \x01(\rR\x0ereloadInterval\"\x95\x01\n" +
+	"\x05Decoy\x12\x12\n" +
+	"\x04root\x18\x01 \x01(\tR\x04root\x12\x1a\n" +
+	"\bupstream\x18\x02 \x01(\tR\bupstream\x12\x16\n" +
+	"\x06server\x18\x03 \x01(\tR\x06server\x12)\n" +
+	"\x10certificate_file\x18\x04 \x01(\tR\x0fcertificateFile\x12\x19\n" +
+	"\bkey_file\x18\x05 \x01(\tR\akeyFile\"\xe8\x04\n" +
 	"\x06Config\x124\n" +
 	"\aclients\x18\x01 \x03(\v2\x1a.xray.common.protocol.UserR\aclients\x12A\n" +
-	"\tfallbacks\x18\x02 \x03(\v2#.xray.proxy.reflex.inbound.FallbackR\tfallbacksBm\n" +
+	"\tfallbacks\x18\x02 \x03(\v2#.xray.proxy.reflex.inbound.FallbackR\tfallbacks\x12.\n" +
+	"\x13keep_alive_interval\x18\x03 \x01(\rR\x11keepAliveInterval\x120\n" +
+	"\x03ban\x18\x04 \x01(\v2\x1e.xray.proxy.reflex.inbound.BanR\x03ban\x12.\n" +
+	"\x13resume_grace_period\x18\x05 \x01(\rR\x11resumeGracePeriod\x12T\n" +
+	"\x10policy_overrides\x18\x06 \x03(\v2).xray.proxy.reflex.inbound.PolicyOverrideR\x0fpolicyOverrides\x12/\n" +
+	"\x13timestamp_tolerance\x18\a \x01(\rR\x12timestampTolerance\x12$\n" +
+	"\x0emax_clock_skew\x18\b \x01(\rR\fmaxClockSkew\x12C\n" +
+	"\n" +
+	"user_store\x18\t \x01(\v2$.xray.proxy.reflex.inbound.UserStoreR\tuserStore\x12\x17\n" +
+	"\akey_log\x18\n" +
+	" \x01(\tR\x06keyLog\x126\n" +
+	"\x05decoy\x18\v \x01(\v2 .xray.proxy.reflex.inbound.DecoyR\x05decoy\x12\x10\n" +
+	"\x03tag\x18\f \x01(\tR\x03tagBm\n" +
 	"\x1dcom.xray.proxy.reflex.inboundP\x01Z.github.com/xtls/xray-core/proxy/reflex/inbound\xaa\x02\x19Xray.Proxy.Reflex.Inboundb\x06proto3"
 
 var (
@@ -189,20 +616,28 @@ func file_proxy_reflex_inbound_config_proto_rawDescGZIP() []byte {
 	return file_proxy_reflex_inbound_config_proto_rawDescData
 }
 
-var file_proxy_reflex_inbound_config_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_proxy_reflex_inbound_config_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
 var file_proxy_reflex_inbound_config_proto_goTypes = []any{
-	(*Fallback)(nil),      // 0: xray.proxy.reflex.inbound.Fallback
-	(*Config)(nil),        // 1: xray.proxy.reflex.inbound.Config
-	(*protocol.User)(nil), // 2: xray.common.protocol.User
+	(*Fallback)(nil),       // 0: xray.proxy.reflex.inbound.Fallback
+	(*Ban)(nil),            // 1: xray.proxy.reflex.inbound.Ban
+	(*PolicyOverride)(nil), // 2: xray.proxy.reflex.inbound.PolicyOverride
+	(*UserStore)(nil),      // 3: xray.proxy.reflex.inbound.UserStore
+	(*Decoy)(nil),          // 4: xray.proxy.reflex.inbound.Decoy
+	(*Config)(nil),         // 5: xray.proxy.reflex.inbound.Config
+	(*protocol.User)(nil),  // 6: xray.common.protocol.User
 }
 var file_proxy_reflex_inbound_config_proto_depIdxs = []int32{
-	2, // 0: xray.proxy.reflex.inbound.Config.clients:type_name -> xray.common.protocol.User
+	6, // 0: xray.proxy.reflex.inbound.Config.clients:type_name -> xray.common.protocol.User
 	0, // 1: xray.proxy.reflex.inbound.Config.fallbacks:type_name -> xray.proxy.reflex.inbound.Fallback
-	2, // [2:2] is the sub-list for method output_type
-	2, // [2:2] is the sub-list for method input_type
-	2, // [2:2] is the sub-list for extension type_name
-	2, // [2:2] is the sub-list for extension extendee
-	0, // [0:2] is the sub-list for field type_name
+	1, // 2: xray.proxy.reflex.inbound.Config.ban:type_name -> xray.proxy.reflex.inbound.Ban
+	2, // 3: xray.proxy.reflex.inbound.Config.policy_overrides:type_name -> xray.proxy.reflex.inbound.PolicyOverride
+	3, // 4: xray.proxy.reflex.inbound.Config.user_store:type_name -> xray.proxy.reflex.inbound.UserStore
+	4, // 5: xray.proxy.reflex.inbound.Config.decoy:type_name -> xray.proxy.reflex.inbound.Decoy
+	6, // [6:6] is the sub-list for method output_type
+	6, // [6:6] is the sub-list for method input_type
+	6, // [6:6] is the sub-list for extension type_name
+	6, // [6:6] is the sub-list for extension extendee
+	0, // [0:6] is the sub-list for field type_name
 }
 
 func init() { file_proxy_reflex_inbound_config_proto_init() }
@@ -216,7 +651,7 @@ func file_proxy_reflex_inbound_config_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proxy_reflex_inbound_config_proto_rawDesc), len(file_proxy_reflex_inbound_config_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   2,
+			NumMessages:   6,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
@@ -228,25 +663,3 @@ func file_proxy_reflex_inbound_config_proto_init() {
 	file_proxy_reflex_inbound_config_proto_goTypes = nil
 	file_proxy_reflex_inbound_config_proto_depIdxs = nil
 }
-
-// UnmarshalJSON implements custom JSON unmarshaling for Config
-func (c *Config) UnmarshalJSON(data []byte) error {
-	type configAlias Config
-	aux := &struct {
-		*configAlias
-	}{
-		configAlias: (*configAlias)(c),
-	}
-	return json.Unmarshal(data, &aux)
-}
-
-// UnmarshalJSON implements custom JSON unmarshaling for Fallback
-func (f *Fallback) UnmarshalJSON(data []byte) error {
-	type fallbackAlias Fallback
-	aux := &struct {
-		*fallbackAlias
-	}{
-		fallbackAlias: (*fallbackAlias)(f),
-	}
-	return json.Unmarshal(data, &aux)
-}
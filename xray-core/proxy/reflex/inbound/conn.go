@@ -3,6 +3,7 @@ package inbound
 import (
 	"bufio"
 	"net"
+	"time"
 
 	"github.com/xtls/xray-core/transport/internet/stat"
 )
@@ -45,3 +46,18 @@ func (pc *preloadedConn) LocalAddr() net.Addr {
 func (pc *preloadedConn) RemoteAddr() net.Addr {
 	return pc.conn.RemoteAddr()
 }
+
+// SetDeadline sets the deadlines of the underlying connection
+func (pc *preloadedConn) SetDeadline(t time.Time) error {
+	return pc.conn.SetDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline of the underlying connection
+func (pc *preloadedConn) SetReadDeadline(t time.Time) error {
+	return pc.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline of the underlying connection
+func (pc *preloadedConn) SetWriteDeadline(t time.Time) error {
+	return pc.conn.SetWriteDeadline(t)
+}
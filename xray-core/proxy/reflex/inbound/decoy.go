@@ -0,0 +1,292 @@
+package inbound
+
+import (
+	"bytes"
+	"context"
+	gotls "crypto/tls"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+
+	"github.com/xtls/xray-core/common/errors"
+)
+
+const (
+	// defaultDecoyServer is the Server header of a decoy serving files.
+	defaultDecoyServer = "nginx"
+
+	// The timeouts of the decoy are the defaults of nginx:
+	// client_header_timeout and keepalive_timeout.
+	decoyHeaderTimeout = 60 * time.Second
+	decoyIdleTimeout   = 75 * time.Second
+)
+
+// http2Preface starts the connections of clients speaking h2 without TLS.
+var http2Preface = []byte("PRI * HTTP/2.0")
+
+// decoy is the website of a Decoy config.
+type decoy struct {
+	handler http.Handler
+	// tlsConfig answers TLS that reaches the decoy on a transport without
+	// security, nil without a certificate
+	tlsConfig *gotls.Config
+	h2        *http2.Server
+}
+
+// newDecoy creates the decoy of config.
+func newDecoy(config *Decoy) (*decoy, error) {
+	d := &decoy{
+		h2: &http2.Server{IdleTimeout: decoyIdleTimeout},
+	}
+	switch {
+	case config.Upstream != "":
+		upstream, err := url.Parse(config.Upstream)
+		if err != nil || (upstream.Scheme != "http" && upstream.Scheme != "https") || upstream.Host == "" {
+			return nil, errors.New("invalid upstream ", config.Upstream, ", expected an http or https URL")
+		}
+		d.handler = newDecoyProxy(upstream, config.Server)
+	case config.Root != "":
+		info, err := os.Stat(config.Root)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return nil, errors.New(config.Root, " is not a directory")
+		}
+		server := config.Server
+		if server == "" {
+			server = defaultDecoyServer
+		}
+		d.handler = &decoySite{root: http.Dir(config.Root), server: server}
+	default:
+		return nil, errors.New("either a root or an upstream is required")
+	}
+
+	if config.CertificateFile != "" || config.KeyFile != "" {
+		certificate, err := gotls.LoadX509KeyPair(config.CertificateFile, config.KeyFile)
+		if err != nil {
+			return nil, errors.New("failed to load certificate").Base(err)
+		}
+		d.tlsConfig = &gotls.Config{
+			Certificates: []gotls.Certificate{certificate},
+			NextProtos:   []string{"h2", "http/1.1"},
+		}
+	}
+	return d, nil
+}
+
+// server returns the HTTP server of a connection.
+func (d *decoy) server() *http.Server {
+	return &http.Server{
+		Handler:           d.handler,
+		ReadHeaderTimeout: decoyHeaderTimeout,
+		IdleTimeout:       decoyIdleTimeout,
+		ErrorLog:          log.New(io.Discard, "", 0),
+	}
+}
+
+// serve answers the requests of conn until it closes. peeked are the first
+// bytes the client sent, and alpn the protocol negotiated by the TLS or
+// REALITY layer of the inbound if secured.
+func (d *decoy) serve(ctx context.Context, conn net.Conn, peeked []byte, secured bool, alpn string) error {
+	// The handshake deadline of the inbound gives way to the timeouts of
+	// the website
+	conn.SetDeadline(time.Time{})
+
+	switch {
+	case secured:
+	case isTLSHandshake(peeked):
+		if d.tlsConfig == nil {
+			conn.Close()
+			return errors.New("decoy has no certificate to answer TLS")
+		}
+		tlsConn := gotls.Server(conn, d.tlsConfig)
+		handshakeCtx, cancel := context.WithTimeout(ctx, decoyHeaderTimeout)
+		err := tlsConn.HandshakeContext(handshakeCtx)
+		cancel()
+		if err != nil {
+			conn.Close()
+			return errors.New("decoy TLS handshake failed").Base(err)
+		}
+		conn, alpn = tlsConn, tlsConn.ConnectionState().NegotiatedProtocol
+	case bytes.HasPrefix(peeked, http2Preface):
+		alpn = "h2"
+	default:
+		alpn = ""
+	}
+
+	if alpn == "h2" {
+		// ServeConn closes conn once done
+		d.h2.ServeConn(conn, &http2.ServeConnOpts{
+			Context:    ctx,
+			Handler:    d.handler,
+			BaseConfig: d.server(),
+		})
+		return nil
+	}
+
+	listener := newConnListener(conn)
+	server := d.server()
+	server.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateClosed || state == http.StateHijacked {
+			listener.Close()
+		}
+	}
+	// Serve returns once the connection is closed and Accept fails
+	server.Serve(listener)
+	return nil
+}
+
+// connListener hands a single connection to an HTTP server.
+type connListener struct {
+	conns  chan net.Conn
+	addr   net.Addr
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newConnListener(conn net.Conn) *connListener {
+	l := &connListener{
+		conns:  make(chan net.Conn, 1),
+		addr:   conn.LocalAddr(),
+		closed: make(chan struct{}),
+	}
+	l.conns <- conn
+	return l
+}
+
+// Accept returns the connection, then blocks until the listener is closed.
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *connListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.addr
+}
+
+// decoySite serves the files of a directory the way a web server with
+// default settings does: an index file for directories, no listings, and
+// error pages of its own.
+type decoySite struct {
+	root   http.FileSystem
+	server string
+}
+
+func (s *decoySite) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Server", s.server)
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeDecoyPage(w, http.StatusMethodNotAllowed, s.server)
+		return
+	}
+
+	name := path.Clean("/" + r.URL.Path)
+	file, err := s.root.Open(name)
+	if err != nil {
+		writeDecoyPage(w, fileErrorStatus(err), s.server)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		writeDecoyPage(w, fileErrorStatus(err), s.server)
+		return
+	}
+
+	if info.IsDir() {
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			location := r.URL.Path + "/"
+			if r.URL.RawQuery != "" {
+				location += "?" + r.URL.RawQuery
+			}
+			w.Header().Set("Location", location)
+			writeDecoyPage(w, http.StatusMovedPermanently, s.server)
+			return
+		}
+		index, err := s.root.Open(path.Join(name, "index.html"))
+		if err != nil {
+			writeDecoyPage(w, http.StatusForbidden, s.server)
+			return
+		}
+		defer index.Close()
+		if info, err = index.Stat(); err != nil || info.IsDir() {
+			writeDecoyPage(w, http.StatusForbidden, s.server)
+			return
+		}
+		file = index
+	}
+
+	w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime().Unix(), info.Size()))
+	http.ServeContent(w, r, info.Name(), info.ModTime(), file)
+}
+
+// fileErrorStatus returns the status of a request for a file that failed to
+// open with err.
+func fileErrorStatus(err error) int {
+	switch {
+	case os.IsNotExist(err):
+		return http.StatusNotFound
+	case os.IsPermission(err):
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeDecoyPage writes the page of status, signed by server as the error
+// pages of nginx are.
+func writeDecoyPage(w http.ResponseWriter, status int, server string) {
+	text := fmt.Sprint(status, " ", http.StatusText(status))
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<html>\r\n<head><title>%s</title></head>\r\n<body>\r\n<center><h1>%s</h1></center>\r\n<hr><center>%s</center>\r\n</body>\r\n</html>\r\n",
+		text, text, html.EscapeString(server))
+}
+
+// newDecoyProxy returns a handler proxying requests to upstream. The
+// responses keep the Server header of upstream unless server is set.
+func newDecoyProxy(upstream *url.URL, server string) http.Handler {
+	signature := server
+	if signature == "" {
+		signature = defaultDecoyServer
+	}
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(upstream)
+		},
+		ErrorLog: log.New(io.Discard, "", 0),
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			errors.LogInfoInner(r.Context(), err, "decoy upstream ", upstream.Host, " failed")
+			w.Header().Set("Server", signature)
+			writeDecoyPage(w, http.StatusBadGateway, signature)
+		},
+	}
+	if server != "" {
+		proxy.ModifyResponse = func(response *http.Response) error {
+			response.Header.Set("Server", server)
+			return nil
+		}
+	}
+	return proxy
+}
@@ -0,0 +1,240 @@
+package inbound
+
+import (
+	"bufio"
+	"context"
+	gotls "crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/http2"
+
+	"github.com/xtls/xray-core/common/protocol/tls/cert"
+)
+
+// serveDecoy hands the connections of a local listener to d the way
+// handleFallback does, and returns the address of the listener.
+func serveDecoy(t *testing.T, d *decoy) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				reader := bufio.NewReader(conn)
+				reader.Peek(1)
+				peeked, _ := reader.Peek(reader.Buffered())
+				d.serve(context.Background(), newPreloadedConn(reader, conn), peeked, false, "")
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// newDecoyRoot returns a directory with an index and a subdirectory without
+// one.
+func newDecoyRoot(t *testing.T) string {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "index.html"), []byte("<h1>Welcome</h1>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(root, "assets"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func decoyRequest(t *testing.T, client *http.Client, method, url string, header http.Header) (*http.Response, string) {
+	request, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, values := range header {
+		request.Header[name] = values
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response, string(body)
+}
+
+// TestDecoySite tests that files are served as by a web server, with its
+// error pages
+func TestDecoySite(t *testing.T) {
+	d, err := newDecoy(&Decoy{Root: newDecoyRoot(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := serveDecoy(t, d)
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+
+	response, body := decoyRequest(t, client, http.MethodGet, "http://"+addr+"/", nil)
+	if response.StatusCode != http.StatusOK || body != "<h1>Welcome</h1>" {
+		t.Fatalf("unexpected index: %d %q", response.StatusCode, body)
+	}
+	if response.Header.Get("Server") != "nginx" || response.Header.Get("ETag") == "" || response.Header.Get("Last-Modified") == "" {
+		t.Errorf("unexpected headers %v", response.Header)
+	}
+
+	for _, tc := range []struct {
+		method, path string
+		status       int
+	}{
+		{http.MethodGet, "/missing.html", http.StatusNotFound},
+		{http.MethodGet, "/assets/", http.StatusForbidden},
+		{http.MethodGet, "/assets", http.StatusMovedPermanently},
+		{http.MethodPost, "/", http.StatusMethodNotAllowed},
+	} {
+		response, body := decoyRequest(t, client, tc.method, "http://"+addr+tc.path, nil)
+		if response.StatusCode != tc.status {
+			t.Errorf("%s %s: status %d, expected %d", tc.method, tc.path, response.StatusCode, tc.status)
+		}
+		if !strings.Contains(body, "<hr><center>nginx</center>") {
+			t.Errorf("%s %s: unexpected page %q", tc.method, tc.path, body)
+		}
+	}
+	if response, _ := decoyRequest(t, client, http.MethodGet, "http://"+addr+"/assets", nil); response.Header.Get("Location") != "/assets/" {
+		t.Errorf("unexpected redirect to %q", response.Header.Get("Location"))
+	}
+
+	response, body = decoyRequest(t, client, http.MethodGet, "http://"+addr+"/index.html", http.Header{"Range": {"bytes=4-10"}})
+	if response.StatusCode != http.StatusPartialContent || body != "Welcome" {
+		t.Errorf("unexpected range: %d %q", response.StatusCode, body)
+	}
+
+	// h2 without TLS, with prior knowledge
+	h2c := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *gotls.Config) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}}
+	response, body = decoyRequest(t, h2c, http.MethodGet, "http://"+addr+"/", nil)
+	if response.ProtoMajor != 2 || body != "<h1>Welcome</h1>" {
+		t.Errorf("unexpected h2c response: %s %q", response.Proto, body)
+	}
+}
+
+// TestDecoyTLS tests that the decoy answers TLS with its certificate, over
+// h2 or HTTP/1.1 as the client offers
+func TestDecoyTLS(t *testing.T) {
+	dir := t.TempDir()
+	certPEM, keyPEM := cert.MustGenerate(nil, cert.DNSNames("www.example.com")).ToPEM()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	d, err := newDecoy(&Decoy{Root: newDecoyRoot(t), CertificateFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := serveDecoy(t, d)
+
+	for _, tc := range []struct {
+		h2    bool
+		proto string
+	}{
+		{true, "HTTP/2.0"},
+		{false, "HTTP/1.1"},
+	} {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &gotls.Config{ServerName: "www.example.com", InsecureSkipVerify: true},
+			ForceAttemptHTTP2: tc.h2,
+		}}
+		response, body := decoyRequest(t, client, http.MethodGet, "https://"+addr+"/", nil)
+		if response.Proto != tc.proto || body != "<h1>Welcome</h1>" {
+			t.Errorf("unexpected response: %s %q, expected %s", response.Proto, body, tc.proto)
+		}
+		if names := response.TLS.PeerCertificates[0].DNSNames; len(names) != 1 || names[0] != "www.example.com" {
+			t.Errorf("unexpected certificate for %v", names)
+		}
+	}
+
+	// Without a certificate TLS is turned away
+	d, err = newDecoy(&Decoy{Root: newDecoyRoot(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr = serveDecoy(t, d)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &gotls.Config{InsecureSkipVerify: true}}}
+	if _, err := client.Get("https://" + addr + "/"); err == nil {
+		t.Error("expected TLS to fail without a certificate")
+	}
+}
+
+// TestDecoyUpstream tests that an upstream website is proxied
+func TestDecoyUpstream(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "Apache")
+		io.WriteString(w, "upstream "+r.URL.Path)
+	}))
+	defer upstream.Close()
+
+	for _, tc := range []struct {
+		server, expected string
+	}{
+		{"", "Apache"},
+		{"cloudflare", "cloudflare"},
+	} {
+		d, err := newDecoy(&Decoy{Upstream: upstream.URL, Server: tc.server})
+		if err != nil {
+			t.Fatal(err)
+		}
+		response, body := decoyRequest(t, http.DefaultClient, http.MethodGet, "http://"+serveDecoy(t, d)+"/about", nil)
+		if body != "upstream /about" || response.Header.Get("Server") != tc.expected {
+			t.Errorf("unexpected response %q from %q, expected server %q", body, response.Header.Get("Server"), tc.expected)
+		}
+	}
+
+	// An upstream that is down gives a gateway error page
+	d, err := newDecoy(&Decoy{Upstream: "http://" + upstream.Listener.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := serveDecoy(t, d)
+	upstream.Close()
+	response, body := decoyRequest(t, http.DefaultClient, http.MethodGet, "http://"+addr+"/", nil)
+	if response.StatusCode != http.StatusBadGateway || !strings.Contains(body, "<hr><center>nginx</center>") {
+		t.Errorf("unexpected response: %d %q", response.StatusCode, body)
+	}
+}
+
+// TestNewDecoyErrors tests that invalid decoys are refused
+func TestNewDecoyErrors(t *testing.T) {
+	root := newDecoyRoot(t)
+	for _, config := range []*Decoy{
+		{},
+		{Root: filepath.Join(root, "index.html")},
+		{Root: filepath.Join(root, "missing")},
+		{Upstream: "ftp://example.com"},
+		{Upstream: "example.com:80"},
+		{Root: root, CertificateFile: filepath.Join(root, "missing.pem")},
+	} {
+		if _, err := newDecoy(config); err == nil {
+			t.Errorf("%v: expected an error", config)
+		}
+	}
+}
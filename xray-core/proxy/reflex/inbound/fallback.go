@@ -4,16 +4,66 @@ import (
 	"bufio"
 	"bytes"
 	"context"
-	"crypto/tls"
+	gotls "crypto/tls"
 	"io"
 	"net"
 	"strconv"
 	"strings"
 
 	"github.com/xtls/xray-core/common/errors"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/proxy"
+	"github.com/xtls/xray-core/transport/internet"
+	"github.com/xtls/xray-core/transport/internet/reality"
 	"github.com/xtls/xray-core/transport/internet/stat"
+	"github.com/xtls/xray-core/transport/internet/tls"
 )
 
+type rawStreamsKey struct{}
+
+// ContextWithRawStreams returns a context in which the Reflex servers created
+// take every connection to carry the bytes of the client as sent, as raw TCP
+// does, and hand it to their fallbacks. It serves transports that cannot be
+// told apart by their connection type, such as in-memory ones.
+func ContextWithRawStreams(ctx context.Context) context.Context {
+	return context.WithValue(ctx, rawStreamsKey{}, true)
+}
+
+// rawStreamsFromContext returns whether ctx was made by ContextWithRawStreams.
+func rawStreamsFromContext(ctx context.Context) bool {
+	raw, _ := ctx.Value(rawStreamsKey{}).(bool)
+	return raw
+}
+
+// transportOwnsStream returns whether the connection is carried by a transport
+// such as WebSocket, gRPC, XHTTP or HTTPUpgrade. Such transports have already
+// answered the client themselves, so there is no raw stream to hand over to a
+// fallback.
+func (h *Handler) transportOwnsStream(conn stat.Connection) bool {
+	if h.rawStreams || proxy.IsRAWTransportWithoutSecurity(conn) {
+		return false
+	}
+	switch stat.TryUnwrapStatsConn(conn).(type) {
+	case *tls.Conn, *reality.Conn:
+		return false
+	}
+	return true
+}
+
+// securityState returns the SNI and ALPN negotiated by the TLS or REALITY
+// layer the connection arrived on, if any.
+func securityState(conn stat.Connection) (name, alpn string, ok bool) {
+	switch c := stat.TryUnwrapStatsConn(conn).(type) {
+	case *tls.Conn:
+		cs := c.ConnectionState()
+		return cs.ServerName, cs.NegotiatedProtocol, true
+	case *reality.Conn:
+		cs := c.ConnectionState()
+		return cs.ServerName, cs.NegotiatedProtocol, true
+	}
+	return "", "", false
+}
+
 // isHTTPRequest checks if the data looks like an HTTP request
 func isHTTPRequest(data []byte) bool {
 	if len(data) < 4 {
@@ -220,18 +270,38 @@ func (h *Handler) findFallback(name, alpn, path string) *Fallback {
 
 // handleFallback handles connections that are not Reflex protocol
 func (h *Handler) handleFallback(ctx context.Context, reader *bufio.Reader, conn stat.Connection) error {
-	// Peek to determine connection type
-	peeked, err := reader.Peek(1024)
-	if err != nil && err != io.EOF {
+	if h.transportOwnsStream(conn) {
+		conn.Close()
+		return errors.New("fallback is not supported over this transport")
+	}
+
+	// Peek what has arrived so far to determine connection type, without
+	// waiting for more data than a short request carries
+	if _, err := reader.Peek(1); err != nil && err != io.EOF {
 		newError("failed to peek for fallback: ", err).AtWarning()
 		return err
 	}
+	peeked, _ := reader.Peek(min(reader.Buffered(), 1024))
 
 	var name, alpn, path string
-
-	// Determine connection type and extract metadata
-	if isTLSHandshake(peeked) {
+	var secured bool
+	kind := "unknown"
+
+	// Determine connection type and extract metadata. Behind TLS or REALITY
+	// the peeked bytes are already decrypted, so the handshake itself tells
+	// the SNI and ALPN.
+	if sni, negotiated, ok := securityState(conn); ok {
+		kind = "tls"
+		secured = true
+		name = strings.ToLower(sni)
+		alpn = strings.ToLower(negotiated)
+		if isHTTPRequest(peeked) {
+			path = extractHTTPPath(peeked)
+		}
+		newError("fallback: connection over TLS, SNI=", name, " ALPN=", alpn).AtInfo()
+	} else if isTLSHandshake(peeked) {
 		// TLS connection
+		kind = "tls"
 		name = extractSNI(peeked)
 		alpn = extractALPN(peeked)
 		if alpn == "" {
@@ -240,6 +310,7 @@ func (h *Handler) handleFallback(ctx context.Context, reader *bufio.Reader, conn
 		newError("fallback: TLS connection detected, SNI=", name, " ALPN=", alpn).AtInfo()
 	} else if isHTTPRequest(peeked) {
 		// HTTP connection
+		kind = "http"
 		name = extractHTTPHost(peeked)
 		path = extractHTTPPath(peeked)
 		alpn = "http/1.1" // Default for HTTP
@@ -251,6 +322,8 @@ func (h *Handler) handleFallback(ctx context.Context, reader *bufio.Reader, conn
 		path = ""
 		newError("fallback: unknown protocol").AtInfo()
 	}
+	metrics := h.metrics(ctx)
+	metrics.Fallback(kind)
 
 	// Find appropriate fallback
 	fb := h.findFallback(name, alpn, path)
@@ -259,6 +332,10 @@ func (h *Handler) handleFallback(ctx context.Context, reader *bufio.Reader, conn
 		fb = h.findFallback("", "", "")
 	}
 
+	if fb == nil && h.decoy != nil {
+		metrics.FallbackDest("decoy")
+		return h.decoy.serve(ctx, newPreloadedConn(reader, conn), peeked, secured, alpn)
+	}
 	if fb == nil {
 		newError("no fallback configured, rejecting connection").AtWarning()
 		conn.Close()
@@ -281,7 +358,14 @@ func (h *Handler) handleFallback(ctx context.Context, reader *bufio.Reader, conn
 		dest = "127.0.0.1:" + fb.Dest
 	}
 
-	targetConn, err := net.Dial("tcp", dest)
+	metrics.FallbackDest(dest)
+	targetConn, err := dialFallback(ctx, dest)
+	if err != nil && h.decoy != nil {
+		// A refused connection would tell the server apart from a website
+		errors.LogInfoInner(ctx, err, "fallback ", dest, " unreachable, serving the decoy")
+		metrics.FallbackDest("decoy")
+		return h.decoy.serve(ctx, newPreloadedConn(reader, conn), peeked, secured, alpn)
+	}
 	if err != nil {
 		newError("failed to connect to fallback destination: ", err).AtError()
 		return errors.New("failed to connect to fallback").Base(err)
@@ -354,9 +438,19 @@ func (h *Handler) handleHTTPFallback(ctx context.Context, reader *bufio.Reader,
 	return h.forwardToFallback(ctx, reader, conn, fb.Dest)
 }
 
+// dialFallback connects to the fallback at dest, a host and port, with the
+// system dialer of the instance.
+func dialFallback(ctx context.Context, dest string) (net.Conn, error) {
+	destination, err := xnet.ParseDestination("tcp:" + dest)
+	if err != nil {
+		return nil, err
+	}
+	return internet.DialSystem(ctx, destination, nil)
+}
+
 // forwardToFallback forwards the connection to the fallback destination
 func (h *Handler) forwardToFallback(ctx context.Context, reader *bufio.Reader, conn stat.Connection, dest string) error {
-	targetConn, err := net.Dial("tcp", dest)
+	targetConn, err := dialFallback(ctx, dest)
 	if err != nil {
 		return errors.New("failed to connect to fallback").Base(err)
 	}
@@ -383,7 +477,7 @@ func (h *Handler) forwardToFallback(ctx context.Context, reader *bufio.Reader, c
 // Helper to check if TLS version is supported
 func isSupportedTLSVersion(version uint16) bool {
 	switch version {
-	case tls.VersionTLS10, tls.VersionTLS11, tls.VersionTLS12, tls.VersionTLS13:
+	case gotls.VersionTLS10, gotls.VersionTLS11, gotls.VersionTLS12, gotls.VersionTLS13:
 		return true
 	default:
 		return false
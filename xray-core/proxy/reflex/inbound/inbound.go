@@ -2,25 +2,29 @@ package inbound
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"encoding/binary"
 	"fmt"
 	"io"
-	"os"
 	"time"
 
 	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/common/antireplay"
 	"github.com/xtls/xray-core/common/buf"
 	"github.com/xtls/xray-core/common/errors"
 	"github.com/xtls/xray-core/common/net"
 	"github.com/xtls/xray-core/common/protocol"
 	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/common/signal"
 	"github.com/xtls/xray-core/common/task"
 	"github.com/xtls/xray-core/core"
 	"github.com/xtls/xray-core/features/policy"
 	"github.com/xtls/xray-core/features/routing"
+	"github.com/xtls/xray-core/features/stats"
 	"github.com/xtls/xray-core/proxy/reflex"
 	"github.com/xtls/xray-core/proxy/reflex/encoding"
+	"github.com/xtls/xray-core/proxy/reflex/resume"
 	"github.com/xtls/xray-core/transport/internet/stat"
 )
 
@@ -39,20 +43,30 @@ func init() {
 	newError("Reflex inbound init() completed").AtInfo()
 }
 
-func logToFile(msg string) {
-	f, err := os.OpenFile("reflex-inbound-init.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		return
-	}
-	defer f.Close()
-	f.WriteString(msg + "\n")
-}
-
 // Handler is an inbound connection handler for Reflex protocol
 type Handler struct {
-	policyManager policy.Manager
-	validator     *reflex.Validator
-	fallbacks     map[string]map[string]map[string]*Fallback
+	policyManager     policy.Manager
+	stats             stats.Manager
+	validator         *reflex.Validator
+	sessions          *sessionTracker
+	bans              *banTracker
+	skew              *skewTracker
+	replay            *antireplay.ReplayFilter
+	resumable         *resumableTracker
+	fallbacks         map[string]map[string]map[string]*Fallback
+	keepAliveInterval time.Duration
+	resumeGracePeriod time.Duration
+	policyOverrides   []*PolicyOverride
+	// store keeps the users loaded from a file in sync with it, if any
+	store  *userStore
+	keyLog *reflex.KeyLog
+	// decoy serves the connections no fallback takes, if set
+	decoy *decoy
+	// timeSource times cover traffic and TLS shaping, the system clock if
+	// nil
+	timeSource encoding.TimeSource
+	// rawStreams hands every connection to the fallbacks, whatever its type
+	rawStreams bool
 }
 
 // New creates a new Reflex inbound handler
@@ -60,9 +74,24 @@ func New(ctx context.Context, config *Config) (*Handler, error) {
 	newError("Reflex inbound New() called").AtInfo()
 
 	v := core.MustFromContext(ctx)
+	// A hello is acceptable for twice the widest window, from the moment its
+	// timestamp is that far in the future to when it is that far past, and
+	// must be remembered as long by the replay filter
+	skew := newSkewTracker(config.TimestampTolerance, config.MaxClockSkew)
 	handler := &Handler{
-		policyManager: v.GetFeature(policy.ManagerType()).(policy.Manager),
-		validator:     reflex.NewValidator(),
+		policyManager:     v.GetFeature(policy.ManagerType()).(policy.Manager),
+		stats:             v.GetFeature(stats.ManagerType()).(stats.Manager),
+		validator:         reflex.NewValidator(),
+		sessions:          newSessionTracker(),
+		bans:              newBanTracker(config.Ban),
+		skew:              skew,
+		replay:            antireplay.NewReplayFilter(2 * skew.Window()),
+		resumable:         newResumableTracker(),
+		keepAliveInterval: time.Duration(config.KeepAliveInterval) * time.Second,
+		resumeGracePeriod: time.Duration(config.ResumeGracePeriod) * time.Second,
+		policyOverrides:   config.PolicyOverrides,
+		timeSource:        encoding.TimeSourceFromContext(ctx),
+		rawStreams:        rawStreamsFromContext(ctx),
 	}
 	newError("Reflex handler created, clients count: ", len(config.Clients)).AtInfo()
 
@@ -75,6 +104,9 @@ func New(ctx context.Context, config *Config) (*Handler, error) {
 			return nil, errors.New("failed to get Reflex user").Base(err).AtError()
 		}
 		newError("Converted user to memory user: ", mUser.Email).AtInfo()
+		if err := handler.checkLimits(mUser); err != nil {
+			return nil, errors.New("invalid limits for user ", mUser.Email).Base(err).AtError()
+		}
 		if err := handler.validator.Add(mUser); err != nil {
 			newError("Failed to add user to validator: ", err).AtError()
 			return nil, errors.New("failed to add user").Base(err).AtError()
@@ -82,6 +114,15 @@ func New(ctx context.Context, config *Config) (*Handler, error) {
 		newError("Added user to validator: ", mUser.Email).AtInfo()
 	}
 
+	// Users of the user store come on top of the static ones
+	if config.UserStore != nil {
+		handler.store = newUserStore(config.UserStore, config.Tag)
+		if err := handler.loadUsers(); err != nil {
+			return nil, errors.New("failed to load user store ", config.UserStore.Path).Base(err).AtError()
+		}
+		go handler.watchUsers()
+	}
+
 	// Setup fallbacks
 	if config.Fallbacks != nil {
 		newError("Setting up ", len(config.Fallbacks), " fallbacks").AtInfo()
@@ -97,10 +138,90 @@ func New(ctx context.Context, config *Config) (*Handler, error) {
 		}
 	}
 
+	if config.Decoy != nil {
+		decoy, err := newDecoy(config.Decoy)
+		if err != nil {
+			return nil, errors.New("invalid decoy").Base(err).AtError()
+		}
+		handler.decoy = decoy
+	}
+
+	keyLog, err := reflex.OpenKeyLog(config.KeyLog)
+	if err != nil {
+		return nil, err
+	}
+	handler.keyLog = keyLog
+
 	newError("Reflex inbound New() completed successfully").AtInfo()
 	return handler, nil
 }
 
+// Close implements common.Closable.Close().
+func (h *Handler) Close() error {
+	var err error
+	if h.store != nil {
+		err = h.store.Close()
+	}
+	return errors.Combine(err, h.keyLog.Close())
+}
+
+// AddUser implements proxy.UserManager.AddUser().
+func (h *Handler) AddUser(ctx context.Context, u *protocol.MemoryUser) error {
+	account, ok := u.Account.(*reflex.MemoryAccount)
+	if !ok {
+		return errors.New("not a Reflex account")
+	}
+	if u.Email != "" && h.validator.GetByEmail(u.Email) != nil {
+		return errors.New("User ", u.Email, " already exists.")
+	}
+	if _, err := h.validator.GetByUUID(account.ID.String()); err == nil {
+		return errors.New("User with ID ", account.ID.String(), " already exists.")
+	}
+	if err := h.checkLimits(u); err != nil {
+		return err
+	}
+	return h.validator.Add(u)
+}
+
+// RemoveUser implements proxy.UserManager.RemoveUser(). Active sessions of
+// the user are closed.
+func (h *Handler) RemoveUser(ctx context.Context, e string) error {
+	if e == "" {
+		return errors.New("Email must not be empty.")
+	}
+	user := h.validator.GetByEmail(e)
+	if err := h.validator.Remove(e); err != nil {
+		return err
+	}
+	h.sessions.CloseAll(user)
+	return nil
+}
+
+// GetUser implements proxy.UserManager.GetUser().
+func (h *Handler) GetUser(ctx context.Context, email string) *protocol.MemoryUser {
+	return h.validator.GetByEmail(email)
+}
+
+// GetUsers implements proxy.UserManager.GetUsers().
+func (h *Handler) GetUsers(ctx context.Context) []*protocol.MemoryUser {
+	return h.validator.GetAll()
+}
+
+// GetUsersCount implements proxy.UserManager.GetUsersCount().
+func (h *Handler) GetUsersCount(context.Context) int64 {
+	return h.validator.GetCount()
+}
+
+// GetBans implements BanManager.GetBans().
+func (h *Handler) GetBans() []*BanEntry {
+	return h.bans.GetBans()
+}
+
+// ClearBans implements BanManager.ClearBans().
+func (h *Handler) ClearBans(sources []string) int {
+	return h.bans.ClearBans(sources)
+}
+
 // Network returns supported networks
 func (*Handler) Network() []net.Network {
 	return []net.Network{net.Network_TCP, net.Network_UNIX}
@@ -109,6 +230,7 @@ func (*Handler) Network() []net.Network {
 // Process handles incoming connections
 func (h *Handler) Process(ctx context.Context, network net.Network, conn stat.Connection, dispatcher routing.Dispatcher) error {
 	newError("Reflex inbound connection from ", conn.RemoteAddr()).AtInfo()
+	start := time.Now()
 	sessionPolicy := h.policyManager.ForLevel(0)
 
 	if err := conn.SetReadDeadline(time.Now().Add(sessionPolicy.Timeouts.Handshake)); err != nil {
@@ -118,18 +240,22 @@ func (h *Handler) Process(ctx context.Context, network net.Network, conn stat.Co
 	// Wrap connection in buffered reader for peeking
 	reader := bufio.NewReader(conn)
 
-	// Peek first bytes to check if it's a Reflex handshake
-	peeked, err := reader.Peek(76) // Minimum size for handshake with magic
-	if err != nil && err != io.EOF {
-		return errors.New("failed to peek connection").Base(err).AtError()
+	// Sources banned for failing too many handshakes only ever see the fallback
+	if h.bans.Banned(sourceIP(ctx, conn)) {
+		return h.handleFallback(ctx, reader, conn)
 	}
 
-	// Check for Reflex magic number
-	if len(peeked) >= 4 {
-		magic := binary.BigEndian.Uint32(peeked[0:4])
-		if magic == encoding.ReflexMagic {
-			return h.handleReflexHandshake(ctx, reader, conn, dispatcher, sessionPolicy)
-		}
+	// Check for a Reflex magic number. Probes are told apart as soon as
+	// their first bytes arrive, even if they send less than a hello.
+	magic, err := peekMagic(reader, encoding.ReflexMagic, encoding.ReflexPaddedMagic, resume.Magic)
+	if err != nil {
+		return errors.New("failed to peek connection").Base(err).AtError()
+	}
+	switch magic {
+	case encoding.ReflexMagic, encoding.ReflexPaddedMagic:
+		return h.handleReflexHandshake(ctx, reader, conn, dispatcher, sessionPolicy, start, magic == encoding.ReflexPaddedMagic)
+	case resume.Magic:
+		return h.handleResume(ctx, reader, conn, start)
 	}
 
 	// Not a Reflex connection - fallback
@@ -143,63 +269,157 @@ func (h *Handler) handleReflexHandshake(
 	conn stat.Connection,
 	dispatcher routing.Dispatcher,
 	sessionPolicy policy.Session,
+	start time.Time,
+	padded bool,
 ) error {
-	// Read handshake packet (76 bytes) - use pooled buffer
-	handshakeData := encoding.GetClientHandshakeBuffer()
-	defer encoding.PutClientHandshakeBuffer(handshakeData)
-	if _, err := io.ReadFull(reader, handshakeData); err != nil {
+	metrics := h.metrics(ctx)
+
+	// Peek the handshake packet; it is only consumed once the user is
+	// accepted, so that rejected clients are passed to the fallback intact
+	handshakeData, err := peekHandshake(reader, padded)
+	if err != nil {
+		metrics.Handshake(reflex.HandshakeDecodeError)
 		return errors.New("failed to read handshake").Base(err).AtError()
 	}
 
 	// Decode client handshake
-	clientHS, err := encoding.DecodeClientHandshake(handshakeData)
+	var clientHS *encoding.ClientHandshake
+	if padded {
+		clientHS, err = encoding.DecodePaddedClientHandshake(handshakeData)
+	} else {
+		clientHS, err = encoding.DecodeClientHandshake(handshakeData)
+	}
 	if err != nil {
+		metrics.Handshake(reflex.HandshakeDecodeError)
 		return errors.New("invalid handshake").Base(err).AtError()
 	}
 
-	// Validate timestamp
-	if !encoding.ValidateTimestamp(clientHS.Timestamp) {
-		return errors.New("invalid timestamp").AtError()
+	// Validate timestamp. Known users whose clock is off by more than the
+	// tolerance get a wider window on their next attempt; the attempt that
+	// widens it is turned away like any other, but is not held against the
+	// source.
+	source := sourceIP(ctx, conn)
+	if !h.skew.Valid(clientHS.Timestamp) {
+		var accepted, widened bool
+		account, err := h.validator.Get(clientHS.UserID)
+		if err == nil {
+			accepted, widened = h.skew.Accept(account, clientHS.Timestamp, time.Now())
+		}
+		if !accepted {
+			errors.LogInfo(ctx, "invalid timestamp, ", encoding.TimestampSkew(clientHS.Timestamp), "s off")
+			metrics.Handshake(reflex.HandshakeBadTime)
+			if !widened {
+				h.bans.Failure(source)
+			}
+			return h.handleFallback(ctx, reader, conn)
+		}
+		errors.LogInfo(ctx, "accepting user ", account.Email, " with a clock ", encoding.TimestampSkew(clientHS.Timestamp), "s off")
 	}
 
 	// Find and authenticate user
 	account, err := h.validator.Get(clientHS.UserID)
 	if err != nil {
 		newError("authentication failed: ", err).AtWarning()
+		metrics.Handshake(reflex.HandshakeUnknownUser)
+		h.bans.Failure(source)
+		return h.handleFallback(ctx, reader, conn)
+	}
+
+	// A handshake seen before is a recorded one played back, most likely by
+	// a prober checking whether the server answers it, possibly with the
+	// other magic or a different padding
+	if !h.replay.Check(encoding.ClientHandshakeReplayKey(handshakeData)) {
+		errors.LogWarning(ctx, "replayed handshake of user ", account.Email)
+		metrics.Handshake(reflex.HandshakeReplay)
+		h.bans.Failure(source)
+		return h.handleFallback(ctx, reader, conn)
+	}
+	h.bans.Success(source)
+
+	// Expired and over-quota users look like any other unknown client
+	if err := h.newUserQuota(account).Check(); err != nil {
+		errors.LogInfo(ctx, "rejecting user ", account.Email, ": ", err)
 		return h.handleFallback(ctx, reader, conn)
 	}
 
+	// Register the session so that removing the user closes it, subject to
+	// the user's limit of concurrent source IPs
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	registration, ok := h.sessions.Add(account, source, maxConcurrentIPs(account), func() {
+		cancel()
+		conn.Close()
+	})
+	if !ok {
+		errors.LogInfo(ctx, "rejecting user ", account.Email, ": too many concurrent IPs")
+		return h.handleFallback(ctx, reader, conn)
+	}
+	defer registration.Remove()
+	quota := h.newSessionQuota(registration)
+
+	// The user may have been removed while the handshake was in flight
+	if current, err := h.validator.Get(clientHS.UserID); err != nil || current != account {
+		return errors.New("user ", account.Email, " has been removed").AtInfo()
+	}
+
+	// The hello is hashed into the salt of a padded handshake, so keep it
+	// past the discard
+	helloData := handshakeData
+	if padded {
+		helloData = bytes.Clone(handshakeData)
+	}
+	if _, err := reader.Discard(len(handshakeData)); err != nil {
+		return errors.New("failed to read handshake").Base(err).AtError()
+	}
+
 	// Generate server key pair
 	serverPrivateKey, serverPublicKey, err := encoding.GenerateKeyPair()
 	if err != nil {
 		return errors.New("failed to generate key pair").Base(err).AtError()
 	}
 
-	// Derive shared key and session key
-	sharedKey := encoding.DeriveSharedKey(serverPrivateKey, clientHS.PublicKey)
-	sessionKey, err := encoding.DeriveSessionKey(sharedKey, []byte("reflex-session-v1"))
-	if err != nil {
-		return errors.New("failed to derive session key").Base(err).AtError()
-	}
-
-	// Send server handshake response (use pooled buffer)
+	// Send server handshake response, padded like the hello to a packet
+	// size of the user's profile
 	serverHS := &encoding.ServerHandshake{
 		PublicKey: serverPublicKey,
 		Timestamp: time.Now().Unix(),
 	}
-	responseData := encoding.EncodeServerHandshake(serverHS)
-	defer encoding.PutServerHandshakeBuffer(responseData)
-	if _, err := conn.Write(responseData); err != nil {
-		return errors.New("failed to send handshake response").Base(err).AtError()
+	salt := []byte("reflex-session-v1")
+	if padded {
+		padding := encoding.HandshakePadding(h.grantProfile(account, "", time.Now()), encoding.PaddedServerHandshakeHeaderSize)
+		responseData, err := encoding.EncodePaddedServerHandshake(serverHS, padding)
+		if err != nil {
+			return errors.New("failed to encode handshake response").Base(err).AtError()
+		}
+		if _, err := conn.Write(responseData); err != nil {
+			return errors.New("failed to send handshake response").Base(err).AtError()
+		}
+		salt = encoding.PaddedSessionSalt(helloData, responseData)
+	} else {
+		// Use pooled buffer
+		responseData := encoding.EncodeServerHandshake(serverHS)
+		defer encoding.PutServerHandshakeBuffer(responseData)
+		if _, err := conn.Write(responseData); err != nil {
+			return errors.New("failed to send handshake response").Base(err).AtError()
+		}
 	}
 
+	// Derive shared key and session key
+	sharedKey := encoding.DeriveSharedKey(serverPrivateKey, clientHS.PublicKey)
+	sessionKey, err := encoding.DeriveSessionKey(sharedKey, salt)
+	if err != nil {
+		return errors.New("failed to derive session key").Base(err).AtError()
+	}
+	h.keyLog.Log(clientHS.PublicKey, sessionKey)
+
 	// Clear handshake deadline
 	if err := conn.SetReadDeadline(time.Time{}); err != nil {
 		return errors.New("failed to clear read deadline").Base(err).AtError()
 	}
 
 	newError("handshake completed for user: ", account.Email).AtInfo()
-	logToFile("HANDSHAKE COMPLETED for user: " + account.Email)
+	metrics.Handshake(reflex.HandshakeOK)
+	metrics.HandshakeLatency(time.Since(start))
 
 	// Create frame encoder/decoder
 	frameEncoder, err := encoding.NewFrameEncoder(sessionKey)
@@ -212,18 +432,55 @@ func (h *Handler) handleReflexHandshake(
 		return errors.New("failed to create frame decoder").Base(err).AtError()
 	}
 
+	frames := encoding.NewFrameConn(frameEncoder, conn, frameDecoder, reader)
+
 	// Read first data frame to get request header
-	firstFrame, err := frameDecoder.ReadFrame(reader)
+	firstFrame, err := frames.ReadFrame()
 	if err != nil {
 		return errors.New("failed to read first frame").Base(err).AtError()
 	}
 
-	if firstFrame.Type != encoding.FrameTypeData {
-		return errors.New("expected data frame").AtError()
+	// Before their request, clients ask for a morphing profile, then may
+	// offer to resume their session if the connection resets, and always
+	// learn what we grant
+	profile := h.grantProfile(account, "", time.Now())
+	keepAliveInterval := h.keepAliveInterval
+	for firstFrame.Type != encoding.FrameTypeData {
+		switch firstFrame.Type {
+		case encoding.FrameTypePolicy:
+			profile = h.grantProfile(account, encoding.PolicyRequest(firstFrame), time.Now())
+			encoding.PutFrame(firstFrame)
+			errors.LogDebug(ctx, "granting profile ", encoding.ProfileKey(profile), " to user ", account.Email)
+			if err := frames.WriteFrame(encoding.NewPolicyGrantFrame(profile)); err != nil {
+				return errors.New("failed to answer policy request").Base(err).AtError()
+			}
+		case encoding.FrameTypeResume:
+			encoding.PutFrame(firstFrame)
+			if err := frames.WriteFrame(resume.NewGrantFrame(h.resumeGracePeriod)); err != nil {
+				return errors.New("failed to answer resume offer").Base(err).AtError()
+			}
+			if h.resumeGracePeriod > 0 {
+				resumable, err := h.newResumable(account, registration, profile, sessionKey, conn, reader, frameEncoder, frameDecoder)
+				if err != nil {
+					return err
+				}
+				defer resumable.Close()
+				frames = resumable
+				// The session keeps each of its connections alive itself
+				keepAliveInterval = 0
+			}
+		default:
+			return errors.New("expected data frame").AtError()
+		}
+		if firstFrame, err = frames.ReadFrame(); err != nil {
+			return errors.New("failed to read first frame").Base(err).AtError()
+		}
 	}
 
+	frameEncoder.CountBytes(metrics.Bytes(encoding.ProfileKey(profile)))
+
 	// Parse request header from frame payload
-	request, err := parseRequestHeader(firstFrame.Payload)
+	request, headerSize, err := parseRequestHeader(firstFrame.Payload)
 	if err != nil {
 		return errors.New("failed to parse request").Base(err).AtError()
 	}
@@ -241,47 +498,68 @@ func (h *Handler) handleReflexHandshake(
 	if account != nil {
 		userLevel = account.Level
 	}
-	ctx = policy.ContextWithBufferPolicy(ctx, sessionPolicy.Buffer)
 	sessionPolicy = h.policyManager.ForLevel(userLevel)
 
-	// Setup dispatcher link
-	ctx, cancel := context.WithCancel(ctx)
-	_ = cancel  // Keep for now but don't defer it - let responseDone signal completion
+	// Setup dispatcher link, cancelled once the session has been idle for too long
+	timer := signal.CancelAfterInactivity(ctx, cancel, sessionPolicy.Timeouts.ConnectionIdle)
+	ctx = policy.ContextWithBufferPolicy(ctx, sessionPolicy.Buffer)
+	// The request ends with the client's CLOSE frame, a half-close the
+	// outbound passes on to the target
+	ctx = session.ContextWithHalfClose(ctx, true)
 
 	link, err := dispatcher.Dispatch(ctx, request.Destination())
 	if err != nil {
 		return errors.New("failed to dispatch request").Base(err).AtError()
 	}
 
+	// Users with cover traffic get frames of a fixed size at a fixed rate
+	if config := coverOf(account); config != nil {
+		config.Clock = h.timeSource
+		cover := encoding.NewCover(frames, *config, profile, metrics.CoverOverhead())
+		common.Must(cover.Start())
+		defer cover.Close()
+		frames = cover
+	} else {
+		// Otherwise hide at least the answer to a tunneled TLS handshake
+		shaper := encoding.NewTLSShaper(frames, profile, h.timeSource)
+		defer shaper.Close()
+		frames = shaper
+	}
+
+	// Keepalive frames only prove that the peer is reachable; they do not
+	// update the activity timer, so an idle session still times out.
+	keepAlive := encoding.NewKeepAlive(frames, profile, keepAliveInterval, func() {
+		errors.LogInfo(ctx, "peer stopped answering keepalive, closing session")
+		cancel()
+	})
+	common.Must(keepAlive.Start())
+	defer keepAlive.Close()
+
 	// Transfer data
 	requestDone := func() error {
-		logToFile(fmt.Sprintf("requestDone: First frame payload size: %d bytes", len(firstFrame.Payload)))
-		// Write first frame data to link (zero-copy with FromBytes)
-		if len(firstFrame.Payload) > 12 { // After header
-			headerSize := 12 // Simplified: command(1) + port(2) + address(variable, ~9)
-			if headerSize < len(firstFrame.Payload) {
-				// Use FromBytes to avoid allocation (unmanaged buffer)
-				payload := buf.FromBytes(firstFrame.Payload[headerSize:])
-				logToFile(fmt.Sprintf("requestDone: Sending %d bytes from first frame to link.Writer", len(firstFrame.Payload[headerSize:])))
-				if err := link.Writer.WriteMultiBuffer(buf.MultiBuffer{payload}); err != nil {
-					logToFile(fmt.Sprintf("requestDone: WriteMultiBuffer error on first frame: %v", err))
-					return err
-				}
-				logToFile("requestDone: First frame data sent successfully")
+		defer timer.SetTimeout(sessionPolicy.Timeouts.DownlinkOnly)
+
+		// Write the data after the header in the first frame to link
+		// (zero-copy with FromBytes)
+		if headerSize < len(firstFrame.Payload) {
+			// Use FromBytes to avoid allocation (unmanaged buffer)
+			payload := buf.FromBytes(firstFrame.Payload[headerSize:])
+			if err := link.Writer.WriteMultiBuffer(buf.MultiBuffer{payload}); err != nil {
+				return err
 			}
+			quota.AddUplink(len(firstFrame.Payload) - headerSize)
+			timer.Update()
 		}
 		// Return frame struct to pool after first frame is processed
 		defer encoding.PutFrame(firstFrame)
 
 		// Read subsequent frames and write to dispatcher
-		logToFile("requestDone: Starting to read subsequent frames from client")
 		for {
-			frame, err := frameDecoder.ReadFrame(reader)
+			frame, err := frames.ReadFrame()
 			if err != nil {
-				logToFile(fmt.Sprintf("requestDone: ReadFrame error: %v", err))
 				return err
 			}
-			logToFile(fmt.Sprintf("requestDone: Got frame type %d with %d bytes", frame.Type, len(frame.Payload)))
+			keepAlive.Alive()
 
 			switch frame.Type {
 			case encoding.FrameTypeData:
@@ -291,12 +569,27 @@ func (h *Handler) handleReflexHandshake(
 					encoding.PutFrame(frame)
 					return err
 				}
+				quota.AddUplink(len(frame.Payload))
+				timer.Update()
 				// Return frame struct to pool after payload is written
 				encoding.PutFrame(frame)
+				if err := quota.Check(); err != nil {
+					return err
+				}
 			case encoding.FrameTypeClose:
-				logToFile("requestDone: Received close frame from client, returning")
+				reset := encoding.IsReset(frame)
 				encoding.PutFrame(frame)
+				if reset {
+					return errors.New("session reset by client")
+				}
 				return nil
+			case encoding.FrameTypePing:
+				encoding.PutFrame(frame)
+				if err := frames.WriteFrame(encoding.NewControlFrame(encoding.FrameTypePong, keepAlive.Profile())); err != nil {
+					return err
+				}
+			case encoding.FrameTypePong:
+				encoding.PutFrame(frame)
 			case encoding.FrameTypePadding, encoding.FrameTypeTiming:
 				// Control frames - ignore for now
 				encoding.PutFrame(frame)
@@ -309,6 +602,8 @@ func (h *Handler) handleReflexHandshake(
 	}
 
 	responseDone := func() error {
+		defer timer.SetTimeout(sessionPolicy.Timeouts.UplinkOnly)
+
 		newError("responseDone: Starting to read from dispatcher").AtInfo()
 		// Read from dispatcher and write as frames
 		for {
@@ -316,11 +611,12 @@ func (h *Handler) handleReflexHandshake(
 			mb, err := link.Reader.ReadMultiBuffer()
 			if err != nil {
 				newError("responseDone: ReadMultiBuffer error: ", err).AtWarning()
-				// Send close frame to signal end of response
-				closeFrame := &encoding.Frame{
-					Type: encoding.FrameTypeClose,
+				// Send close frame to signal end of response, keeping the
+				// request direction open unless the link has failed
+				if errors.Cause(err) == io.EOF {
+					return frames.WriteFrame(encoding.NewCloseFrame(encoding.CloseReasonFin))
 				}
-				frameEncoder.WriteFrame(conn, closeFrame)
+				frames.WriteFrame(encoding.NewCloseFrame(encoding.CloseReasonRst))
 				return err
 			}
 
@@ -331,30 +627,136 @@ func (h *Handler) handleReflexHandshake(
 					Type:    encoding.FrameTypeData,
 					Payload: b.Bytes(),
 				}
-				if err := frameEncoder.WriteFrame(conn, frame); err != nil {
+				if err := frames.WriteFrame(frame); err != nil {
 					newError("responseDone: WriteFrame error: ", err).AtWarning()
 					buf.ReleaseMulti(mb)
 					return err
 				}
 				newError(fmt.Sprintf("responseDone: Sent %d bytes back to client", len(b.Bytes()))).AtDebug()
 			}
+			quota.AddDownlink(int(mb.Len()))
 			buf.ReleaseMulti(mb)
+			timer.Update()
+			if err := quota.Check(); err != nil {
+				return err
+			}
 		}
 	}
 
-	// Run both directions concurrently
-	if err := task.Run(ctx, requestDone, responseDone); err != nil {
+	// Run both directions concurrently, passing the client's half-close on to the target
+	requestDonePost := task.OnSuccess(requestDone, task.Close(link.Writer))
+	if err := task.Run(ctx, requestDonePost, responseDone); err != nil {
+		common.Interrupt(link.Reader)
+		common.Interrupt(link.Writer)
 		return errors.New("connection ends").Base(err).AtInfo()
 	}
 
+	// Ending the session cancels the outbound, so give it a chance to pass the
+	// rest of a half-closed upload on to the target first
+	waitDrained(link.Writer, sessionPolicy.Timeouts.UplinkOnly)
+
+	return nil
+}
+
+// waitDrained waits up to timeout for the reading side of the link to
+// consume everything written to w.
+func waitDrained(w buf.Writer, timeout time.Duration) {
+	if pending, ok := w.(interface{ WaitDrained(time.Duration) bool }); ok {
+		pending.WaitDrained(timeout)
+	}
+}
+
+// peekMagic returns which of magics the connection starts with, or 0 if
+// none. It peeks no more bytes than it needs to tell, so probes that send
+// less than a magic number and wait for an answer are not held up.
+func peekMagic(reader *bufio.Reader, magics ...uint32) (uint32, error) {
+	for n := 1; ; n++ {
+		n = max(n, min(reader.Buffered(), 4))
+		peeked, err := reader.Peek(n)
+		if err == io.EOF {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		matches := false
+		for _, magic := range magics {
+			var b [4]byte
+			binary.BigEndian.PutUint32(b[:], magic)
+			if bytes.HasPrefix(b[:], peeked) {
+				matches = true
+				break
+			}
+		}
+		if !matches {
+			return 0, nil
+		}
+		if n == 4 {
+			return binary.BigEndian.Uint32(peeked), nil
+		}
+	}
+}
+
+// peekHandshake peeks a whole hello, padded hellos announcing their size
+// after their fixed part.
+func peekHandshake(reader *bufio.Reader, padded bool) ([]byte, error) {
+	if !padded {
+		return reader.Peek(encoding.ClientHandshakeSize)
+	}
+	header, err := reader.Peek(encoding.PaddedClientHandshakeHeaderSize)
+	if err != nil {
+		return nil, err
+	}
+	size, err := encoding.PaddedClientHandshakeSize(header)
+	if err != nil {
+		return nil, err
+	}
+	return reader.Peek(size)
+}
+
+// metrics returns the metrics of the inbound the connection arrived on.
+func (h *Handler) metrics(ctx context.Context) *reflex.Metrics {
+	if inbound := session.InboundFromContext(ctx); inbound != nil {
+		return reflex.NewMetrics(h.stats, "inbound", inbound.Tag)
+	}
 	return nil
 }
 
-// parseRequestHeader parses request header from frame payload
-// Simplified version - format: [command(1)] + [port(2)] + [address]
-func parseRequestHeader(payload []byte) (*protocol.RequestHeader, error) {
-	if len(payload) < 4 {
-		return nil, errors.New("payload too short")
+// sourceIP returns the IP address of the client.
+func sourceIP(ctx context.Context, conn stat.Connection) string {
+	if inbound := session.InboundFromContext(ctx); inbound != nil && inbound.Source.IsValid() {
+		return inbound.Source.Address.String()
+	}
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		return addr.IP.String()
+	}
+	return conn.RemoteAddr().String()
+}
+
+// maxConcurrentIPs returns the limit of concurrent source IPs of the user.
+func maxConcurrentIPs(user *protocol.MemoryUser) uint32 {
+	if account, ok := user.Account.(*reflex.MemoryAccount); ok {
+		return account.MaxConcurrentIPs
+	}
+	return 0
+}
+
+// coverOf returns the cover traffic configuration of the user's account, or
+// nil if it has none.
+func coverOf(user *protocol.MemoryUser) *encoding.CoverConfig {
+	if account, ok := user.Account.(*reflex.MemoryAccount); ok {
+		return account.CoverConfig()
+	}
+	return nil
+}
+
+// parseRequestHeader parses the request header at the start of the first
+// frame and returns it along with its size. The rest of the frame is payload.
+// Format: [command(1)] + [port(2)] + [addrType(1)] + [address]
+func parseRequestHeader(payload []byte) (*protocol.RequestHeader, int, error) {
+	if len(payload) < 5 {
+		return nil, 0, errors.New("payload too short")
 	}
 
 	request := &protocol.RequestHeader{
@@ -365,35 +767,33 @@ func parseRequestHeader(payload []byte) (*protocol.RequestHeader, error) {
 	// Parse port
 	request.Port = net.PortFromBytes(payload[1:3])
 
-	// Parse address (simplified - assumes IPv4 for now)
-	if len(payload) >= 7 {
-		addrType := payload[3]
-		switch addrType {
-		case 1: // IPv4
-			if len(payload) < 8 {
-				return nil, errors.New("invalid IPv4 address")
-			}
-			request.Address = net.IPAddress(payload[4:8])
-		case 3: // Domain
-			if len(payload) < 5 {
-				return nil, errors.New("invalid domain address")
-			}
-			domainLen := int(payload[4])
-			if len(payload) < 5+domainLen {
-				return nil, errors.New("incomplete domain address")
-			}
-			request.Address = net.DomainAddress(string(payload[5 : 5+domainLen]))
-		case 4: // IPv6
-			if len(payload) < 20 {
-				return nil, errors.New("invalid IPv6 address")
-			}
-			request.Address = net.IPAddress(payload[4:20])
-		default:
-			return nil, errors.New("unknown address type: ", addrType)
+	// Parse address
+	var size int
+	addrType := payload[3]
+	switch addrType {
+	case 1: // IPv4
+		size = 8
+		if len(payload) < size {
+			return nil, 0, errors.New("invalid IPv4 address")
+		}
+		request.Address = net.IPAddress(payload[4:8])
+	case 3: // Domain
+		size = 5 + int(payload[4])
+		if len(payload) < size {
+			return nil, 0, errors.New("incomplete domain address")
+		}
+		request.Address = net.DomainAddress(string(payload[5:size]))
+	case 4: // IPv6
+		size = 20
+		if len(payload) < size {
+			return nil, 0, errors.New("invalid IPv6 address")
 		}
+		request.Address = net.IPAddress(payload[4:20])
+	default:
+		return nil, 0, errors.New("unknown address type: ", addrType)
 	}
 
-	return request, nil
+	return request, size, nil
 }
 
 func newError(values ...interface{}) *errors.Error {
@@ -0,0 +1,155 @@
+package inbound
+
+import (
+	"sync"
+	"time"
+
+	"github.com/xtls/xray-core/common/errors"
+	"github.com/xtls/xray-core/common/protocol"
+	"github.com/xtls/xray-core/features/stats"
+	"github.com/xtls/xray-core/proxy/reflex"
+)
+
+// quotaCounterName is the stats counter holding the remaining traffic quota of a user.
+func quotaCounterName(email string) string {
+	return "user>>>" + email + ">>>traffic>>>remaining"
+}
+
+// userQuota accounts the traffic of a user with a quota or an expiry date.
+// Usage is read from the user>>>email>>>traffic counters, so resetting them
+// through the stats API also resets the quota.
+type userQuota struct {
+	account   *reflex.MemoryAccount
+	uplink    stats.Counter
+	downlink  stats.Counter
+	remaining stats.Counter
+	// countUplink and countDownlink are set when the user policy doesn't
+	// make the dispatcher update the traffic counters already.
+	countUplink   bool
+	countDownlink bool
+}
+
+// checkLimits validates the limits of an account before it is added, and
+// publishes its remaining quota.
+func (h *Handler) checkLimits(user *protocol.MemoryUser) error {
+	account, ok := user.Account.(*reflex.MemoryAccount)
+	if !ok || account.QuotaBytes == 0 {
+		return nil
+	}
+	if user.Email == "" {
+		return errors.New("a traffic quota requires an email")
+	}
+	if _, err := stats.GetOrRegisterCounter(h.stats, quotaCounterName(user.Email)); err != nil {
+		return errors.New("a traffic quota requires the stats app").Base(err)
+	}
+	h.newUserQuota(user)
+	return nil
+}
+
+// newUserQuota returns the quota of the user, or nil if the user has neither a
+// quota nor an expiry date.
+func (h *Handler) newUserQuota(user *protocol.MemoryUser) *userQuota {
+	account, ok := user.Account.(*reflex.MemoryAccount)
+	if !ok || (account.QuotaBytes == 0 && account.ExpiresAt == 0) {
+		return nil
+	}
+	q := &userQuota{account: account}
+	if account.QuotaBytes == 0 || user.Email == "" {
+		return q
+	}
+	p := h.policyManager.ForLevel(user.Level)
+	q.uplink, _ = stats.GetOrRegisterCounter(h.stats, "user>>>"+user.Email+">>>traffic>>>uplink")
+	q.downlink, _ = stats.GetOrRegisterCounter(h.stats, "user>>>"+user.Email+">>>traffic>>>downlink")
+	q.remaining, _ = stats.GetOrRegisterCounter(h.stats, quotaCounterName(user.Email))
+	q.countUplink = !p.Stats.UserUplink
+	q.countDownlink = !p.Stats.UserDownlink
+	q.update()
+	return q
+}
+
+// AddUplink accounts n bytes sent by the client.
+func (q *userQuota) AddUplink(n int) {
+	if q != nil && q.countUplink && q.uplink != nil {
+		q.uplink.Add(int64(n))
+	}
+}
+
+// AddDownlink accounts n bytes sent to the client.
+func (q *userQuota) AddDownlink(n int) {
+	if q != nil && q.countDownlink && q.downlink != nil {
+		q.downlink.Add(int64(n))
+	}
+}
+
+// Check returns an error once the account has expired or used up its quota.
+func (q *userQuota) Check() error {
+	if q == nil {
+		return nil
+	}
+	if q.account.Expired(time.Now()) {
+		return errors.New("account expired")
+	}
+	if q.update() == 0 && q.account.QuotaBytes > 0 {
+		return errors.New("traffic quota exceeded")
+	}
+	return nil
+}
+
+// update refreshes the remaining quota counter and returns its value.
+func (q *userQuota) update() uint64 {
+	if q.uplink == nil || q.downlink == nil {
+		return q.account.QuotaBytes
+	}
+	used := uint64(q.uplink.Value() + q.downlink.Value())
+	var remaining uint64
+	if used < q.account.QuotaBytes {
+		remaining = q.account.QuotaBytes - used
+	}
+	if q.remaining != nil {
+		q.remaining.Set(int64(remaining))
+	}
+	return remaining
+}
+
+// sessionQuota is the quota of a running session. It follows the current
+// user of the session, so that limits changed by a store reload apply to the
+// sessions already running too.
+type sessionQuota struct {
+	handler *Handler
+	session *sessionEntry
+	access  sync.Mutex
+	user    *protocol.MemoryUser
+	quota   *userQuota
+}
+
+func (h *Handler) newSessionQuota(session *sessionEntry) *sessionQuota {
+	return &sessionQuota{handler: h, session: session}
+}
+
+// current returns the quota of the current user of the session.
+func (q *sessionQuota) current() *userQuota {
+	user := q.session.User()
+	q.access.Lock()
+	defer q.access.Unlock()
+	if user != q.user {
+		q.user = user
+		q.quota = q.handler.newUserQuota(user)
+	}
+	return q.quota
+}
+
+// AddUplink accounts n bytes sent by the client.
+func (q *sessionQuota) AddUplink(n int) {
+	q.current().AddUplink(n)
+}
+
+// AddDownlink accounts n bytes sent to the client.
+func (q *sessionQuota) AddDownlink(n int) {
+	q.current().AddDownlink(n)
+}
+
+// Check returns an error once the current user of the session has expired or
+// used up its quota.
+func (q *sessionQuota) Check() error {
+	return q.current().Check()
+}
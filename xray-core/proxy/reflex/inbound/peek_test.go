@@ -0,0 +1,97 @@
+package inbound
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/proxy/reflex/encoding"
+	"github.com/xtls/xray-core/proxy/reflex/resume"
+)
+
+// TestPeekMagicShortProbe tests that a probe sending a few bytes and waiting
+// for an answer is told apart without waiting for more
+func TestPeekMagicShortProbe(t *testing.T) {
+	for _, probe := range []string{"G", "GET", "\x16\x03", "RF", "RFXQ"} {
+		client, server := net.Pipe()
+		go client.Write([]byte(probe))
+
+		reader := bufio.NewReader(server)
+		done := make(chan uint32, 1)
+		go func() {
+			magic, _ := peekMagic(reader, encoding.ReflexMagic, encoding.ReflexPaddedMagic, resume.Magic)
+			done <- magic
+		}()
+
+		select {
+		case magic := <-done:
+			if magic != 0 {
+				t.Errorf("probe %q taken for magic %x", probe, magic)
+			}
+		case <-time.After(200 * time.Millisecond):
+			// Only a prefix of a magic number may wait for more bytes
+			if probe != "RF" {
+				t.Errorf("probe %q held up", probe)
+			}
+		}
+		client.Close()
+		server.Close()
+	}
+}
+
+// TestPeekMagic tests that each magic is recognized whatever the writes it
+// arrives in, and that nothing is consumed
+func TestPeekMagic(t *testing.T) {
+	for _, magic := range []uint32{encoding.ReflexMagic, encoding.ReflexPaddedMagic, resume.Magic} {
+		data := make([]byte, 8)
+		binary.BigEndian.PutUint32(data, magic)
+
+		client, server := net.Pipe()
+		go func() {
+			for _, b := range data {
+				client.Write([]byte{b})
+			}
+			client.Close()
+		}()
+
+		reader := bufio.NewReader(server)
+		peeked, err := peekMagic(reader, encoding.ReflexMagic, encoding.ReflexPaddedMagic, resume.Magic)
+		if err != nil || peeked != magic {
+			t.Fatalf("expected magic %x, got %x: %v", magic, peeked, err)
+		}
+		rest, _ := io.ReadAll(reader)
+		if !bytes.Equal(rest, data) {
+			t.Fatalf("peeking consumed data: %x", rest)
+		}
+		server.Close()
+	}
+}
+
+// TestPeekHandshake tests peeking plain and padded hellos
+func TestPeekHandshake(t *testing.T) {
+	hs := &encoding.ClientHandshake{Timestamp: time.Now().Unix()}
+	padded, err := encoding.EncodePaddedClientHandshake(hs, 900)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := encoding.EncodeClientHandshake(hs)
+	defer encoding.PutClientHandshakeBuffer(plain)
+
+	for _, c := range []struct {
+		data   []byte
+		padded bool
+	}{
+		{plain, false},
+		{padded, true},
+	} {
+		reader := bufio.NewReader(bytes.NewReader(append(bytes.Clone(c.data), "frames"...)))
+		peeked, err := peekHandshake(reader, c.padded)
+		if err != nil || !bytes.Equal(peeked, c.data) {
+			t.Fatalf("padded %v: peeked %d of %d bytes: %v", c.padded, len(peeked), len(c.data), err)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package inbound
+
+import (
+	"time"
+
+	"github.com/xtls/xray-core/common/protocol"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/encoding"
+)
+
+// InForce reports whether the override applies at the local time of t.
+func (o *PolicyOverride) InForce(t time.Time) bool {
+	if o.From == o.To {
+		return true
+	}
+	minute := uint32(t.Hour()*60 + t.Minute())
+	if o.From < o.To {
+		return minute >= o.From && minute < o.To
+	}
+	return minute >= o.From || minute < o.To
+}
+
+// grantProfile decides the morphing profile of a session of user, opened at
+// now by a client asking for the profile called requested: the first
+// override in force, else the policy of the user's account, else the
+// requested profile.
+func (h *Handler) grantProfile(user *protocol.MemoryUser, requested string, now time.Time) *encoding.TrafficProfile {
+	for _, override := range h.policyOverrides {
+		if override.InForce(now) {
+			return encoding.GetProfileByName(override.Policy)
+		}
+	}
+	if account, ok := user.Account.(*reflex.MemoryAccount); ok && account.Policy != "" {
+		return encoding.GetProfileByName(account.Policy)
+	}
+	return encoding.GetProfileByName(requested)
+}
@@ -0,0 +1,73 @@
+package inbound
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/common/protocol"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/encoding"
+)
+
+// TestPolicyOverrideInForce tests time-of-day windows, including those
+// wrapping around midnight
+func TestPolicyOverrideInForce(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, 1, 1, hour, minute, 0, 0, time.Local)
+	}
+	day := &PolicyOverride{From: 8 * 60, To: 18 * 60}
+	night := &PolicyOverride{From: 22 * 60, To: 6 * 60}
+	always := &PolicyOverride{}
+
+	for _, c := range []struct {
+		override *PolicyOverride
+		time     time.Time
+		expected bool
+	}{
+		{day, at(7, 59), false},
+		{day, at(8, 0), true},
+		{day, at(17, 59), true},
+		{day, at(18, 0), false},
+		{night, at(21, 59), false},
+		{night, at(22, 0), true},
+		{night, at(0, 30), true},
+		{night, at(6, 0), false},
+		{always, at(12, 0), true},
+	} {
+		if c.override.InForce(c.time) != c.expected {
+			t.Errorf("override %d-%d at %s: expected %v", c.override.From, c.override.To, c.time.Format("15:04"), c.expected)
+		}
+	}
+}
+
+// TestGrantProfile tests that overrides take precedence over the account
+// policy, which takes precedence over the client's request
+func TestGrantProfile(t *testing.T) {
+	user := func(policy string) *protocol.MemoryUser {
+		return &protocol.MemoryUser{Account: &reflex.MemoryAccount{Policy: policy}}
+	}
+	noon := time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local)
+	midnight := time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)
+
+	h := &Handler{}
+	if p := h.grantProfile(user(""), "zoom", noon); p != encoding.ZoomProfile {
+		t.Errorf("request of a user without policy: granted %s", p.Name)
+	}
+	if p := h.grantProfile(user(""), "", noon); p != encoding.GetDefaultProfile() {
+		t.Errorf("no request: granted %s", p.Name)
+	}
+	if p := h.grantProfile(user("youtube"), "zoom", noon); p != encoding.YouTubeProfile {
+		t.Errorf("request overriding the account policy: granted %s", p.Name)
+	}
+
+	h.policyOverrides = []*PolicyOverride{
+		{Policy: "http2-api", From: 22 * 60, To: 6 * 60},
+		{Policy: "zoom"},
+	}
+	if p := h.grantProfile(user("youtube"), "youtube", midnight); p != encoding.HTTP2APIProfile {
+		t.Errorf("night override: granted %s", p.Name)
+	}
+	if p := h.grantProfile(user("youtube"), "youtube", noon); p != encoding.ZoomProfile {
+		t.Errorf("inbound override: granted %s", p.Name)
+	}
+}
@@ -0,0 +1,37 @@
+package inbound
+
+import (
+	"testing"
+
+	"github.com/xtls/xray-core/common/net"
+)
+
+// TestParseRequestHeaderSize tests that the data after a header of each
+// address type is told apart from the header
+func TestParseRequestHeaderSize(t *testing.T) {
+	for _, tc := range []struct {
+		header  []byte
+		address net.Address
+	}{
+		{[]byte{1, 0, 80, 1, 10, 0, 0, 1}, net.IPAddress([]byte{10, 0, 0, 1})},
+		{append([]byte{1, 0, 80, 3, 19}, "reverse.example.com"...), net.DomainAddress("reverse.example.com")},
+		{[]byte{1, 0, 80, 4, 0x20, 1, 0xd, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}, net.ParseAddress("2001:db8::1")},
+	} {
+		payload := append(tc.header, "data"...)
+		request, size, err := parseRequestHeader(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size != len(tc.header) || string(payload[size:]) != "data" {
+			t.Errorf("%v: header size %d, expected %d", tc.address, size, len(tc.header))
+		}
+		if request.Address.String() != tc.address.String() || request.Port != 80 {
+			t.Errorf("unexpected destination %v:%v, expected %v:80", request.Address, request.Port, tc.address)
+		}
+
+		// A header cut short is refused
+		if _, _, err := parseRequestHeader(tc.header[:len(tc.header)-1]); err == nil {
+			t.Errorf("%v: truncated header accepted", tc.address)
+		}
+	}
+}
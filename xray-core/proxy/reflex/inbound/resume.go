@@ -0,0 +1,196 @@
+package inbound
+
+import (
+	"bufio"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/xtls/xray-core/common/errors"
+	"github.com/xtls/xray-core/common/protocol"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/encoding"
+	"github.com/xtls/xray-core/proxy/reflex/resume"
+	"github.com/xtls/xray-core/transport/internet/stat"
+)
+
+// resumableEntry is a resumable session, the user it belongs to, its
+// registration among the user's sessions and the morphing profile granted to
+// it
+type resumableEntry struct {
+	session      *resume.Session
+	user         *protocol.MemoryUser
+	registration *sessionEntry
+	profile      *encoding.TrafficProfile
+}
+
+// resumableTracker keeps the resumable sessions by ID, so that clients can
+// take them over on a new connection
+type resumableTracker struct {
+	sync.Mutex
+	sessions map[[16]byte]*resumableEntry
+}
+
+func newResumableTracker() *resumableTracker {
+	return &resumableTracker{
+		sessions: make(map[[16]byte]*resumableEntry),
+	}
+}
+
+// Add registers a session of the user shaped with profile
+func (t *resumableTracker) Add(s *resume.Session, user *protocol.MemoryUser, registration *sessionEntry, profile *encoding.TrafficProfile) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.sessions[s.ID()] = &resumableEntry{session: s, user: user, registration: registration, profile: profile}
+}
+
+// Remove unregisters the session with the given ID
+func (t *resumableTracker) Remove(id [16]byte) {
+	t.Lock()
+	defer t.Unlock()
+
+	delete(t.sessions, id)
+}
+
+// Get returns the session with the given ID, or nil
+func (t *resumableTracker) Get(id [16]byte) *resumableEntry {
+	t.Lock()
+	defer t.Unlock()
+
+	return t.sessions[id]
+}
+
+// newResumable makes a resumable session of the frames exchanged with the
+// user on conn, shaped with profile, and registers it until it ends.
+func (h *Handler) newResumable(
+	user *protocol.MemoryUser,
+	registration *sessionEntry,
+	profile *encoding.TrafficProfile,
+	sessionKey []byte,
+	conn stat.Connection,
+	reader *bufio.Reader,
+	encoder *encoding.FrameEncoder,
+	decoder *encoding.FrameDecoder,
+) (*resume.Session, error) {
+	secrets, err := resume.DeriveSecrets(sessionKey)
+	if err != nil {
+		return nil, errors.New("failed to derive resumption secrets").Base(err)
+	}
+	s := resume.NewSession(secrets, resume.Config{
+		GracePeriod:       h.resumeGracePeriod,
+		KeepAliveInterval: h.keepAliveInterval,
+		Profile:           profile,
+		OnClose: func() {
+			h.resumable.Remove(secrets.ID)
+		},
+	}, resume.NewTransport(conn, reader, encoder, decoder))
+	h.resumable.Add(s, user, registration, profile)
+	return s, nil
+}
+
+// handleResume takes a detached session over on the connection of a resume
+// hello. The session itself keeps running in the goroutine of the connection
+// it was opened on; this one only lends it the new connection.
+func (h *Handler) handleResume(ctx context.Context, reader *bufio.Reader, conn stat.Connection, start time.Time) error {
+	metrics := h.metrics(ctx)
+
+	data, err := reader.Peek(resume.HelloSize)
+	if err != nil {
+		metrics.Handshake(reflex.HandshakeDecodeError)
+		return errors.New("failed to read resume hello").Base(err).AtError()
+	}
+	hello, err := resume.DecodeHello(data)
+	if err != nil {
+		metrics.Handshake(reflex.HandshakeDecodeError)
+		return errors.New("invalid resume hello").Base(err).AtError()
+	}
+
+	// Hellos for unknown sessions look like any other unknown client
+	source := sourceIP(ctx, conn)
+	entry := h.resumable.Get(hello.ID)
+	if entry == nil || !entry.session.Secrets().VerifyHello(data) {
+		metrics.Handshake(reflex.HandshakeUnknownUser)
+		h.bans.Failure(source)
+		return h.handleFallback(ctx, reader, conn)
+	}
+	if !h.skew.Valid(hello.Timestamp) {
+		if accepted, widened := h.skew.Accept(entry.user, hello.Timestamp, time.Now()); !accepted {
+			metrics.Handshake(reflex.HandshakeBadTime)
+			if !widened {
+				h.bans.Failure(source)
+			}
+			return h.handleFallback(ctx, reader, conn)
+		}
+	}
+	if !h.replay.Check(data) {
+		errors.LogWarning(ctx, "replayed resume hello of user ", entry.user.Email)
+		metrics.Handshake(reflex.HandshakeReplay)
+		h.bans.Failure(source)
+		return h.handleFallback(ctx, reader, conn)
+	}
+	h.bans.Success(source)
+
+	// Resuming is held to the current limits of the user as a new session
+	// is: expired and over-quota users look like any other unknown client,
+	// and the session moves to the new source IP only if it fits the user's
+	// limit
+	user, err := h.validator.Get(userID(entry.user))
+	if err != nil {
+		errors.LogInfo(ctx, "rejecting resumption of removed user ", entry.user.Email)
+		return h.handleFallback(ctx, reader, conn)
+	}
+	if err := h.newUserQuota(user).Check(); err != nil {
+		errors.LogInfo(ctx, "rejecting resumption of user ", entry.user.Email, ": ", err)
+		return h.handleFallback(ctx, reader, conn)
+	}
+	if !entry.registration.Move(source, maxConcurrentIPs(user)) {
+		errors.LogInfo(ctx, "rejecting resumption of user ", entry.user.Email, ": too many concurrent IPs")
+		return h.handleFallback(ctx, reader, conn)
+	}
+
+	if _, err := reader.Discard(resume.HelloSize); err != nil {
+		return errors.New("failed to read resume hello").Base(err).AtError()
+	}
+
+	key, err := entry.session.Secrets().ConnectionKey(hello.Nonce)
+	if err != nil {
+		return errors.New("failed to derive connection key").Base(err).AtError()
+	}
+	encoder, err := encoding.NewFrameEncoder(key)
+	if err != nil {
+		return errors.New("failed to create frame encoder").Base(err).AtError()
+	}
+	encoder.CountBytes(metrics.Bytes(encoding.ProfileKey(entry.profile)))
+	decoder, err := encoding.NewFrameDecoder(key)
+	if err != nil {
+		return errors.New("failed to create frame decoder").Base(err).AtError()
+	}
+
+	// Drop the old connection, if the session hasn't noticed it broke yet,
+	// so that the count of received frames no longer changes
+	received, err := entry.session.Detach()
+	if err != nil {
+		return errors.New("failed to resume session").Base(err).AtInfo()
+	}
+	if _, err := conn.Write(entry.session.Secrets().EncodeResponse(hello.Nonce, received)); err != nil {
+		return errors.New("failed to send resume response").Base(err).AtError()
+	}
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return errors.New("failed to clear read deadline").Base(err).AtError()
+	}
+
+	t := resume.NewTransport(conn, reader, encoder, decoder)
+	if err := entry.session.Attach(t, hello.Received); err != nil {
+		return errors.New("failed to resume session").Base(err).AtWarning()
+	}
+	errors.LogInfo(ctx, "resumed session of user ", entry.user.Email)
+	metrics.Handshake(reflex.HandshakeResumed)
+	metrics.HandshakeLatency(time.Since(start))
+
+	select {
+	case <-t.Done():
+	case <-ctx.Done():
+	}
+	return nil
+}
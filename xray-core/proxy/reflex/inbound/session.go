@@ -0,0 +1,139 @@
+package inbound
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/xtls/xray-core/common/protocol"
+)
+
+// sessionEntry is a handle to an active session, closed when its user is removed
+type sessionEntry struct {
+	tracker *sessionTracker
+	// user is written with the tracker locked, and may be read without
+	user  atomic.Pointer[protocol.MemoryUser]
+	ip    string
+	close func()
+}
+
+// sessionTracker keeps the active sessions of each user
+type sessionTracker struct {
+	sync.Mutex
+	sessions map[*protocol.MemoryUser]map[*sessionEntry]struct{}
+}
+
+func newSessionTracker() *sessionTracker {
+	return &sessionTracker{
+		sessions: make(map[*protocol.MemoryUser]map[*sessionEntry]struct{}),
+	}
+}
+
+// Add registers a session of the user from the given source IP. The session
+// is refused if it would exceed maxIPs distinct source IPs, 0 meaning no
+// limit. The returned entry unregisters the session when removed.
+func (t *sessionTracker) Add(user *protocol.MemoryUser, ip string, maxIPs uint32, close func()) (*sessionEntry, bool) {
+	t.Lock()
+	defer t.Unlock()
+
+	if !t.fits(user, nil, ip, maxIPs) {
+		return nil, false
+	}
+
+	entry := &sessionEntry{tracker: t, ip: ip, close: close}
+	entry.user.Store(user)
+	if t.sessions[user] == nil {
+		t.sessions[user] = make(map[*sessionEntry]struct{})
+	}
+	t.sessions[user][entry] = struct{}{}
+	return entry, true
+}
+
+// fits reports whether a session of the user from ip stays within maxIPs
+// distinct source IPs, not counting the session except. It must be called
+// with the tracker locked.
+func (t *sessionTracker) fits(user *protocol.MemoryUser, except *sessionEntry, ip string, maxIPs uint32) bool {
+	if maxIPs == 0 {
+		return true
+	}
+	ips := make(map[string]struct{})
+	for entry := range t.sessions[user] {
+		if entry != except {
+			ips[entry.ip] = struct{}{}
+		}
+	}
+	_, found := ips[ip]
+	return found || uint32(len(ips)) < maxIPs
+}
+
+// Move moves the session to a new source IP, subject to maxIPs like a new
+// session. Its old IP no longer counts for the user unless other sessions
+// come from it.
+func (e *sessionEntry) Move(ip string, maxIPs uint32) bool {
+	e.tracker.Lock()
+	defer e.tracker.Unlock()
+
+	if !e.tracker.fits(e.user.Load(), e, ip, maxIPs) {
+		return false
+	}
+	e.ip = ip
+	return true
+}
+
+// Remove unregisters the session.
+func (e *sessionEntry) Remove() {
+	e.tracker.Lock()
+	defer e.tracker.Unlock()
+
+	user := e.user.Load()
+	delete(e.tracker.sessions[user], e)
+	if len(e.tracker.sessions[user]) == 0 {
+		delete(e.tracker.sessions, user)
+	}
+}
+
+// User returns the current user of the session, which a store reload may
+// have replaced.
+func (e *sessionEntry) User() *protocol.MemoryUser {
+	return e.user.Load()
+}
+
+// CloseAll closes all active sessions of the user
+func (t *sessionTracker) CloseAll(user *protocol.MemoryUser) {
+	t.Lock()
+	entries := t.sessions[user]
+	delete(t.sessions, user)
+	t.Unlock()
+
+	for entry := range entries {
+		entry.close()
+	}
+}
+
+// Replace hands the active sessions of the user old over to user, which
+// replaces it, so that removing user closes them and the sessions are held
+// to its limits.
+func (t *sessionTracker) Replace(old, user *protocol.MemoryUser) {
+	t.Lock()
+	defer t.Unlock()
+
+	entries := t.sessions[old]
+	if entries == nil {
+		return
+	}
+	delete(t.sessions, old)
+	if t.sessions[user] == nil {
+		t.sessions[user] = make(map[*sessionEntry]struct{})
+	}
+	for entry := range entries {
+		entry.user.Store(user)
+		t.sessions[user][entry] = struct{}{}
+	}
+}
+
+// Count returns the number of active sessions of the user
+func (t *sessionTracker) Count(user *protocol.MemoryUser) int {
+	t.Lock()
+	defer t.Unlock()
+
+	return len(t.sessions[user])
+}
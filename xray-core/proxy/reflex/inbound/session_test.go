@@ -0,0 +1,80 @@
+package inbound
+
+import (
+	"testing"
+
+	"github.com/xtls/xray-core/common/protocol"
+)
+
+// TestSessionTrackerMaxIPs tests the limit of concurrent source IPs
+func TestSessionTrackerMaxIPs(t *testing.T) {
+	tracker := newSessionTracker()
+	user := &protocol.MemoryUser{Email: "test@example.com"}
+
+	first, ok := tracker.Add(user, "192.0.2.1", 2, func() {})
+	if !ok {
+		t.Fatal("first IP should be accepted")
+	}
+	if _, ok := tracker.Add(user, "192.0.2.1", 2, func() {}); !ok {
+		t.Fatal("a known IP should be accepted")
+	}
+	if _, ok := tracker.Add(user, "192.0.2.2", 2, func() {}); !ok {
+		t.Fatal("second IP should be accepted")
+	}
+	if _, ok := tracker.Add(user, "192.0.2.3", 2, func() {}); ok {
+		t.Fatal("third IP should be refused")
+	}
+
+	// The first IP still has a session left.
+	first.Remove()
+	if _, ok := tracker.Add(user, "192.0.2.3", 2, func() {}); ok {
+		t.Fatal("third IP should still be refused")
+	}
+
+	if count := tracker.Count(user); count != 2 {
+		t.Fatalf("expected 2 sessions, got %d", count)
+	}
+}
+
+// TestSessionTrackerCloseAll tests closing all sessions of a user
+func TestSessionTrackerCloseAll(t *testing.T) {
+	tracker := newSessionTracker()
+	user := &protocol.MemoryUser{Email: "test@example.com"}
+
+	closed := 0
+	for range 3 {
+		if _, ok := tracker.Add(user, "192.0.2.1", 0, func() { closed++ }); !ok {
+			t.Fatal("session should be accepted")
+		}
+	}
+	tracker.CloseAll(user)
+
+	if closed != 3 {
+		t.Fatalf("expected 3 sessions to be closed, got %d", closed)
+	}
+	if count := tracker.Count(user); count != 0 {
+		t.Fatalf("expected no sessions left, got %d", count)
+	}
+}
+
+// TestSessionTrackerMove tests moving a session to another source IP
+func TestSessionTrackerMove(t *testing.T) {
+	tracker := newSessionTracker()
+	user := &protocol.MemoryUser{Email: "test@example.com"}
+
+	first, _ := tracker.Add(user, "192.0.2.1", 2, func() {})
+	second, _ := tracker.Add(user, "192.0.2.2", 2, func() {})
+
+	if !first.Move("192.0.2.3", 2) {
+		t.Fatal("a session should be able to leave its IP for another")
+	}
+	if _, ok := tracker.Add(user, "192.0.2.1", 2, func() {}); ok {
+		t.Fatal("the old IP of a moved session should no longer be known")
+	}
+	if _, ok := tracker.Add(user, "192.0.2.3", 2, func() {}); !ok {
+		t.Fatal("the new IP of a moved session should be known")
+	}
+	if second.Move("192.0.2.4", 1) {
+		t.Fatal("a move beyond the limit should be refused")
+	}
+}
@@ -0,0 +1,85 @@
+package inbound
+
+import (
+	"sync"
+	"time"
+
+	"github.com/xtls/xray-core/common/protocol"
+	"github.com/xtls/xray-core/proxy/reflex/encoding"
+)
+
+// skewWidenPeriod is how long the window of a user stays widened after a
+// hello of theirs was rejected for its timestamp.
+const skewWidenPeriod = 10 * time.Minute
+
+// skewTracker decides whether the timestamp of a hello is acceptable. Hellos
+// within tolerance of the local clock always are. A hello of a known user
+// outside of it, but within maxSkew, is rejected the first time and widens
+// the window of the user to maxSkew for skewWidenPeriod, so that a client
+// with a wrong clock gets in on its next attempt and learns the server's
+// time from the handshake response. The replay filter must remember hellos
+// for the widest window so that recorded hellos cannot be played back in it.
+//
+// A full hello proves nothing but the user ID it carries in the clear, so
+// anyone who has seen a hello of a user can widen the user's window. This
+// grants them nothing the user ID does not: it is enough to make a hello with
+// a current timestamp, and recorded hellos are caught by the replay filter.
+// Resume hellos are authenticated with the secrets of the session, so only
+// its client can widen the window with them.
+type skewTracker struct {
+	sync.Mutex
+	tolerance int64
+	maxSkew   int64
+	// widened holds when the window of each user, by ID, narrows again, so
+	// that it stays widened across a store reload replacing the user
+	widened map[[16]byte]time.Time
+}
+
+func newSkewTracker(tolerance, maxSkew uint32) *skewTracker {
+	t := &skewTracker{
+		tolerance: int64(tolerance),
+		maxSkew:   int64(maxSkew),
+		widened:   make(map[[16]byte]time.Time),
+	}
+	if t.tolerance == 0 {
+		t.tolerance = encoding.TimestampTolerance
+	}
+	return t
+}
+
+// Window returns the widest window in seconds a timestamp may be accepted in.
+func (t *skewTracker) Window() int64 {
+	if t.maxSkew > t.tolerance {
+		return t.maxSkew
+	}
+	return t.tolerance
+}
+
+// Valid reports whether the timestamp is within tolerance of the local clock.
+func (t *skewTracker) Valid(timestamp int64) bool {
+	return encoding.ValidateTimestampWithin(timestamp, t.tolerance)
+}
+
+// Accept reports whether a timestamp outside of the tolerance is acceptable
+// in a hello of user, and whether the hello widened the user's window, in
+// which case it is rejected but is no failure of the client.
+func (t *skewTracker) Accept(user *protocol.MemoryUser, timestamp int64, now time.Time) (accepted bool, widened bool) {
+	if t.maxSkew <= t.tolerance || encoding.TimestampSkew(timestamp) > t.maxSkew {
+		return false, false
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	for id, until := range t.widened {
+		if !now.Before(until) {
+			delete(t.widened, id)
+		}
+	}
+	id := userID(user)
+	if _, found := t.widened[id]; found {
+		return true, false
+	}
+	t.widened[id] = now.Add(skewWidenPeriod)
+	return false, true
+}
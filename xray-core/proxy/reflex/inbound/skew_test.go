@@ -0,0 +1,79 @@
+package inbound
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/common/protocol"
+	"github.com/xtls/xray-core/common/serial"
+	"github.com/xtls/xray-core/proxy/reflex"
+)
+
+// skewUser returns a user with the given email and ID
+func skewUser(email, id string) *protocol.MemoryUser {
+	user, err := (&protocol.User{
+		Email:   email,
+		Account: serial.ToTypedMessage(&reflex.Account{Id: id}),
+	}).ToMemoryUser()
+	common.Must(err)
+	return user
+}
+
+// TestSkewTrackerWidens tests that a known user's first hello outside the
+// tolerance is rejected and widens the window for the following ones
+func TestSkewTrackerWidens(t *testing.T) {
+	tracker := newSkewTracker(60, 3600)
+	alice := skewUser("alice", storeID1)
+	bob := skewUser("bob", storeID2)
+	now := time.Now()
+	skewed := now.Unix() - 1800
+
+	if !tracker.Valid(now.Unix() - 30) {
+		t.Fatal("timestamp within tolerance rejected")
+	}
+	if tracker.Valid(skewed) {
+		t.Fatal("timestamp beyond tolerance accepted")
+	}
+	if accepted, widened := tracker.Accept(alice, skewed, now); accepted || !widened {
+		t.Fatal("first skewed hello accepted or did not widen the window")
+	}
+	if accepted, widened := tracker.Accept(alice, skewed, now.Add(time.Second)); !accepted || widened {
+		t.Fatal("skewed hello rejected after the window was widened")
+	}
+	if accepted, _ := tracker.Accept(bob, skewed, now.Add(time.Second)); accepted {
+		t.Fatal("window widened for another user")
+	}
+	if accepted, _ := tracker.Accept(skewUser("alice", storeID1), skewed, now.Add(time.Second)); !accepted {
+		t.Fatal("window narrowed by a reload replacing the user")
+	}
+	if accepted, widened := tracker.Accept(alice, now.Unix()-7200, now.Add(time.Second)); accepted || widened {
+		t.Fatal("hello beyond the maximum skew accepted")
+	}
+	if accepted, _ := tracker.Accept(alice, skewed, now.Add(skewWidenPeriod)); accepted {
+		t.Fatal("window still widened after the widen period")
+	}
+	if tracker.Window() != 3600 {
+		t.Fatalf("expected a window of 3600s, got %d", tracker.Window())
+	}
+}
+
+// TestSkewTrackerDefaults tests that the tolerance defaults to the protocol's
+// and that no window is widened without a maximum skew
+func TestSkewTrackerDefaults(t *testing.T) {
+	tracker := newSkewTracker(0, 0)
+	user := &protocol.MemoryUser{}
+	now := time.Now()
+
+	if !tracker.Valid(now.Unix()-120) || tracker.Valid(now.Unix()-121) {
+		t.Fatal("default tolerance is not 120s")
+	}
+	for i := 0; i < 2; i++ {
+		if accepted, widened := tracker.Accept(user, now.Unix()-300, now); accepted || widened {
+			t.Fatal("skewed hello accepted without a maximum skew")
+		}
+	}
+	if tracker.Window() != 120 {
+		t.Fatalf("expected a window of 120s, got %d", tracker.Window())
+	}
+}
@@ -0,0 +1,465 @@
+package inbound
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/xtls/xray-core/common/errors"
+	"github.com/xtls/xray-core/common/protocol"
+	"github.com/xtls/xray-core/common/serial"
+	"github.com/xtls/xray-core/common/signal/done"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/encoding"
+)
+
+// defaultStoreReloadInterval is how often the user store is checked for
+// changes unless configured otherwise
+const defaultStoreReloadInterval = 10 * time.Second
+
+// storedUser is a client of a user store, with the fields of a client of
+// the JSON config.
+type storedUser struct {
+	ID               string       `json:"id"`
+	Email            string       `json:"email"`
+	Level            uint32       `json:"level"`
+	Policy           string       `json:"policy"`
+	ExpiresAt        string       `json:"expiresAt"`
+	QuotaBytes       uint64       `json:"quotaBytes"`
+	MaxConcurrentIPs uint32       `json:"maxConcurrentIPs"`
+	Cover            *storedCover `json:"cover"`
+}
+
+// storedCover is the cover traffic of a client of a user store
+type storedCover struct {
+	Rate        uint32 `json:"rate"`
+	Size        uint32 `json:"size"`
+	Budget      uint64 `json:"budget"`
+	IdleTimeout uint32 `json:"idleTimeout"`
+}
+
+// build validates the client and converts it to a memory user.
+func (u *storedUser) build() (*protocol.MemoryUser, error) {
+	if u.ID == "" {
+		return nil, errors.New(`"id" is required`)
+	}
+	if !encoding.IsProfileName(u.Policy) {
+		return nil, errors.New(`unknown "policy" `, u.Policy, ", expected one of ", strings.Join(encoding.ProfileNames, ", "))
+	}
+	account := &reflex.Account{
+		Id:               u.ID,
+		Policy:           u.Policy,
+		QuotaBytes:       u.QuotaBytes,
+		MaxConcurrentIps: u.MaxConcurrentIPs,
+	}
+	if u.ExpiresAt != "" {
+		t, err := time.Parse(time.RFC3339, u.ExpiresAt)
+		if err != nil {
+			return nil, errors.New(`invalid "expiresAt"`).Base(err)
+		}
+		account.ExpiresAt = t.Unix()
+	}
+	if c := u.Cover; c != nil {
+		account.Cover = &reflex.Cover{
+			Rate:        c.Rate,
+			Size:        c.Size,
+			Budget:      c.Budget,
+			IdleTimeout: c.IdleTimeout,
+		}
+	}
+	user := &protocol.User{
+		Level:   u.Level,
+		Email:   u.Email,
+		Account: serial.ToTypedMessage(account),
+	}
+	memUser, err := user.ToMemoryUser()
+	if err != nil {
+		return nil, errors.New(`invalid "id" `, u.ID).Base(err)
+	}
+	return memUser, nil
+}
+
+// storeFormats are the formats of user store, by file extension
+var storeFormats = map[string]string{
+	"json":    "json",
+	"csv":     "csv",
+	"db":      "sqlite",
+	"sqlite":  "sqlite",
+	"sqlite3": "sqlite",
+}
+
+// UserStoreFormat returns the format of the user store at path, given the
+// configured one.
+func UserStoreFormat(path, format string) (string, error) {
+	if format == "" {
+		ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+		if format = storeFormats[ext]; format == "" {
+			format = ext
+		}
+	}
+	switch format {
+	case "json", "csv", "sqlite":
+		return format, nil
+	}
+	return "", errors.New("unsupported user store format ", strconv.Quote(format), ", expected json, csv or sqlite")
+}
+
+// parseJSONUsers parses a JSON user store: an array of clients, or an object
+// with a "clients" array as in the config.
+func parseJSONUsers(data []byte) ([]*storedUser, []string, error) {
+	var raw []json.RawMessage
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		var file struct {
+			Clients []json.RawMessage `json:"clients"`
+		}
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, nil, err
+		}
+		raw = file.Clients
+	} else if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, nil, err
+	}
+
+	users := make([]*storedUser, 0, len(raw))
+	names := make([]string, 0, len(raw))
+	for i, r := range raw {
+		name := fmt.Sprintf("clients[%d]", i)
+		user := new(storedUser)
+		decoder := json.NewDecoder(bytes.NewReader(r))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(user); err != nil {
+			return nil, nil, errors.New(name, ": invalid user").Base(err)
+		}
+		users = append(users, user)
+		names = append(names, name)
+	}
+	return users, names, nil
+}
+
+// storeColumns sets the field of a stored user held by each column of a CSV
+// or SQLite user store. Cover traffic cannot be set from them.
+var storeColumns = map[string]func(u *storedUser, value string) error{
+	"id":     func(u *storedUser, value string) error { u.ID = value; return nil },
+	"email":  func(u *storedUser, value string) error { u.Email = value; return nil },
+	"policy": func(u *storedUser, value string) error { u.Policy = value; return nil },
+	"level": func(u *storedUser, value string) error {
+		level, err := parseColumnUint(value, 32)
+		u.Level = uint32(level)
+		return err
+	},
+	"expiresAt": func(u *storedUser, value string) error { u.ExpiresAt = value; return nil },
+	"quotaBytes": func(u *storedUser, value string) error {
+		var err error
+		u.QuotaBytes, err = parseColumnUint(value, 64)
+		return err
+	},
+	"maxConcurrentIPs": func(u *storedUser, value string) error {
+		maxIPs, err := parseColumnUint(value, 32)
+		u.MaxConcurrentIPs = uint32(maxIPs)
+		return err
+	},
+}
+
+// parseColumnUint parses a number of a CSV or SQLite user store, an empty
+// one being 0.
+func parseColumnUint(value string, bits int) (uint64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.ParseUint(value, 10, bits)
+}
+
+// parseCSVUsers parses a CSV user store, whose first line names the columns
+// among those of storeColumns. Lines starting with # are ignored.
+func parseCSVUsers(data []byte) ([]*storedUser, []string, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, errors.New("missing header line").Base(err)
+	}
+	setters := make([]func(*storedUser, string) error, len(header))
+	for i, column := range header {
+		if setters[i] = storeColumns[column]; setters[i] == nil {
+			return nil, nil, errors.New("unknown column ", strconv.Quote(column))
+		}
+	}
+
+	var users []*storedUser
+	var names []string
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		name := fmt.Sprintf("line %d", line)
+		user := new(storedUser)
+		for i, value := range record {
+			if err := setters[i](user, value); err != nil {
+				return nil, nil, errors.New(name, `: invalid "`, header[i], `"`).Base(err)
+			}
+		}
+		users = append(users, user)
+		names = append(names, name)
+	}
+	return users, names, nil
+}
+
+// loadUserStore reads the users of the store at path, checking that they
+// are valid and do not share an ID or an email.
+func loadUserStore(path, format string) ([]*protocol.MemoryUser, error) {
+	format, err := UserStoreFormat(path, format)
+	if err != nil {
+		return nil, err
+	}
+	var stored []*storedUser
+	var names []string
+	if format == "sqlite" {
+		stored, names, err = readSQLiteUsers(path)
+	} else {
+		var data []byte
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+		if format == "json" {
+			stored, names, err = parseJSONUsers(data)
+		} else {
+			stored, names, err = parseCSVUsers(data)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]*protocol.MemoryUser, 0, len(stored))
+	ids := make(map[[16]byte]string)
+	emails := make(map[string]string)
+	for i, s := range stored {
+		user, err := s.build()
+		if err != nil {
+			return nil, errors.New(names[i]).Base(err)
+		}
+		id := userID(user)
+		if prev, found := ids[id]; found {
+			return nil, errors.New(names[i], `: duplicate "id" `, s.ID, ", already used by ", prev)
+		}
+		ids[id] = names[i]
+		if user.Email != "" {
+			email := strings.ToLower(user.Email)
+			if prev, found := emails[email]; found {
+				return nil, errors.New(names[i], `: duplicate "email" `, user.Email, ", already used by ", prev)
+			}
+			emails[email] = names[i]
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+// userID returns the ID of a Reflex user as the validator indexes it.
+func userID(user *protocol.MemoryUser) [16]byte {
+	var id [16]byte
+	copy(id[:], user.Account.(*reflex.MemoryAccount).ID.Bytes())
+	return id
+}
+
+// sameUser returns whether two users are configured the same way.
+func sameUser(a, b *protocol.MemoryUser) bool {
+	return a.Email == b.Email && a.Level == b.Level &&
+		proto.Equal(a.Account.(*reflex.MemoryAccount).ToProto(), b.Account.(*reflex.MemoryAccount).ToProto())
+}
+
+// fileStamp tells whether a file changed since it was last read
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+
+	// The write-ahead log of an SQLite store takes its changes until they
+	// are written back to the file
+	walModTime time.Time
+	walSize    int64
+}
+
+func statFile(path string) (fileStamp, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	stamp := fileStamp{modTime: info.ModTime(), size: info.Size()}
+	if wal, err := os.Stat(path + "-wal"); err == nil {
+		stamp.walModTime, stamp.walSize = wal.ModTime(), wal.Size()
+	}
+	return stamp, nil
+}
+
+// userStore keeps the clients of the inbound loaded from a file in sync with
+// it. The file is polled for changes; users it no longer lists are removed
+// and their sessions closed, and users whose settings changed are replaced,
+// their sessions running on held to the new expiry and quota. A file that
+// fails to load leaves the users of the last good one in place.
+type userStore struct {
+	path     string
+	format   string
+	interval time.Duration
+
+	access sync.Mutex
+	// users holds the users loaded from the file, by ID
+	users map[[16]byte]*protocol.MemoryUser
+	stamp fileStamp
+
+	// tag is the tag of the inbound, under which reloads are counted
+	tag  string
+	done *done.Instance
+}
+
+func newUserStore(config *UserStore, tag string) *userStore {
+	interval := time.Duration(config.ReloadInterval) * time.Second
+	if interval <= 0 {
+		interval = defaultStoreReloadInterval
+	}
+	return &userStore{
+		path:     config.Path,
+		format:   config.Format,
+		interval: interval,
+		users:    make(map[[16]byte]*protocol.MemoryUser),
+		tag:      tag,
+		done:     done.New(),
+	}
+}
+
+// Close stops watching the file.
+func (s *userStore) Close() error {
+	return s.done.Close()
+}
+
+// storeMetrics returns the metrics of the inbound.
+func (h *Handler) storeMetrics() *reflex.Metrics {
+	return reflex.NewMetrics(h.stats, "inbound", h.store.tag)
+}
+
+// loadUsers loads the user store and applies its changes to the validator.
+// On error, the users in place are left untouched.
+func (h *Handler) loadUsers() error {
+	s := h.store
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	stamp, err := statFile(s.path)
+	if err != nil {
+		return err
+	}
+	loaded, err := loadUserStore(s.path, s.format)
+	if err != nil {
+		return err
+	}
+
+	// Check the new users against those added otherwise before changing
+	// anything
+	next := make(map[[16]byte]*protocol.MemoryUser, len(loaded))
+	for _, user := range loaded {
+		id := userID(user)
+		if old := s.users[id]; old != nil && sameUser(old, user) {
+			next[id] = old
+			continue
+		}
+		if existing, err := h.validator.Get(id); err == nil && s.users[id] != existing {
+			return errors.New("user ", user.Account.(*reflex.MemoryAccount).ID, " is already configured outside of the user store")
+		}
+		if user.Email != "" {
+			if existing := h.validator.GetByEmail(user.Email); existing != nil && s.users[userID(existing)] != existing {
+				return errors.New("user ", user.Email, " is already configured outside of the user store")
+			}
+		}
+		if err := h.checkLimits(user); err != nil {
+			return errors.New("invalid limits for user ", user.Email).Base(err)
+		}
+		next[id] = user
+	}
+
+	// Users that changed are replaced under their ID, handing their
+	// sessions over to the new user, whose limits they check from then on;
+	// removed users have theirs closed
+	var added, updated, removed int
+	for id, old := range s.users {
+		if next[id] == old {
+			continue
+		}
+		if user := next[id]; user != nil {
+			h.validator.Add(user)
+			h.sessions.Replace(old, user)
+			updated++
+			continue
+		}
+		h.validator.Delete(old)
+		h.sessions.CloseAll(old)
+		removed++
+	}
+	for id, user := range next {
+		if s.users[id] == nil {
+			h.validator.Add(user)
+			added++
+		}
+	}
+	s.users = next
+	s.stamp = stamp
+
+	if added+updated+removed > 0 {
+		errors.LogInfo(context.Background(), "user store ", s.path, " loaded: ", len(next), " users, ", added, " added, ", updated, " updated, ", removed, " removed")
+	}
+	if c := h.storeMetrics().Counter("users.stored"); c != nil {
+		c.Set(int64(len(next)))
+	}
+	return nil
+}
+
+// watchUsers reloads the user store whenever the file changes, until the
+// store is closed.
+func (h *Handler) watchUsers() {
+	s := h.store
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.done.Wait():
+			return
+		}
+
+		// A file that cannot be read has a zero stamp, so its failure is
+		// reported once
+		stamp, _ := statFile(s.path)
+		s.access.Lock()
+		unchanged := stamp == s.stamp
+		s.access.Unlock()
+		if unchanged {
+			continue
+		}
+		if err := h.loadUsers(); err != nil {
+			errors.LogWarningInner(context.Background(), err, "failed to reload user store ", s.path, ", keeping the previous users")
+			h.storeMetrics().Inc("users.reload.failed")
+			// Retry once the file changes again
+			s.access.Lock()
+			s.stamp = stamp
+			s.access.Unlock()
+			continue
+		}
+		h.storeMetrics().Inc("users.reload.ok")
+	}
+}
@@ -0,0 +1,81 @@
+//go:build (darwin && (amd64 || arm64)) || (freebsd && (386 || amd64 || arm || arm64)) || (linux && (386 || amd64 || arm || arm64 || loong64 || ppc64le || riscv64 || s390x)) || (netbsd && amd64) || (openbsd && (amd64 || arm64)) || (windows && (386 || amd64 || arm64))
+
+package inbound
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/xtls/xray-core/common/errors"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteBusyTimeout is how long, in milliseconds, reading an SQLite user
+// store waits for a writer to be done
+const sqliteBusyTimeout = 5000
+
+// sqlitePath escapes a path for an SQLite URI filename
+var sqlitePath = strings.NewReplacer("%", "%25", "?", "%3f", "#", "%23")
+
+// readSQLiteUsers reads an SQLite user store, whose users table has the
+// columns of a CSV one. The database is opened read-only, so that a missing
+// file is not created.
+func readSQLiteUsers(path string) ([]*storedUser, []string, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, nil, err
+	}
+	dsn := "file:" + sqlitePath.Replace(filepath.ToSlash(path)) + "?mode=ro&_pragma=busy_timeout(" + strconv.Itoa(sqliteBusyTimeout) + ")"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT * FROM users")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, nil, err
+	}
+	setters := make([]func(*storedUser, string) error, len(columns))
+	for i, column := range columns {
+		if setters[i] = storeColumns[column]; setters[i] == nil {
+			return nil, nil, errors.New("unknown column ", strconv.Quote(column))
+		}
+	}
+
+	// NULL is read as an empty value
+	values := make([]sql.NullString, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	var users []*storedUser
+	var names []string
+	for rows.Next() {
+		name := fmt.Sprintf("row %d", len(users)+1)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, nil, errors.New(name).Base(err)
+		}
+		user := new(storedUser)
+		for i, value := range values {
+			if err := setters[i](user, value.String); err != nil {
+				return nil, nil, errors.New(name, `: invalid "`, columns[i], `"`).Base(err)
+			}
+		}
+		users = append(users, user)
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	return users, names, nil
+}
@@ -0,0 +1,12 @@
+//go:build !((darwin && (amd64 || arm64)) || (freebsd && (386 || amd64 || arm || arm64)) || (linux && (386 || amd64 || arm || arm64 || loong64 || ppc64le || riscv64 || s390x)) || (netbsd && amd64) || (openbsd && (amd64 || arm64)) || (windows && (386 || amd64 || arm64)))
+
+package inbound
+
+import (
+	"github.com/xtls/xray-core/common/errors"
+)
+
+// readSQLiteUsers fails, as the SQLite driver does not support this platform
+func readSQLiteUsers(path string) ([]*storedUser, []string, error) {
+	return nil, nil, errors.New("SQLite user store not supported on this platform")
+}
@@ -0,0 +1,151 @@
+//go:build (darwin && (amd64 || arm64)) || (freebsd && (386 || amd64 || arm || arm64)) || (linux && (386 || amd64 || arm || arm64 || loong64 || ppc64le || riscv64 || s390x)) || (netbsd && amd64) || (openbsd && (amd64 || arm64)) || (windows && (386 || amd64 || arm64))
+
+package inbound
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xtls/xray-core/proxy/reflex"
+)
+
+// execSQLite runs statements on the SQLite database at path, creating it if
+// needed
+func execSQLite(t *testing.T, path string, statements ...string) {
+	t.Helper()
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, statement := range statements {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatal(statement, ": ", err)
+		}
+	}
+}
+
+// TestLoadSQLiteUserStore tests reading users from an SQLite database
+func TestLoadSQLiteUserStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.sqlite")
+	execSQLite(t, path,
+		"CREATE TABLE users (id TEXT PRIMARY KEY, email TEXT, policy TEXT, level INTEGER, quotaBytes INTEGER)",
+		"INSERT INTO users VALUES ('"+storeID1+"', 'a@example.com', 'zoom', NULL, 1000)",
+		"INSERT INTO users (id, level) VALUES ('"+storeID2+"', 1)",
+	)
+
+	users, err := loadUserStore(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 {
+		t.Fatalf("loaded %d users", len(users))
+	}
+	byEmail := make(map[string]*reflex.MemoryAccount)
+	for _, user := range users {
+		byEmail[user.Email] = user.Account.(*reflex.MemoryAccount)
+	}
+	if a := byEmail["a@example.com"]; a == nil || a.ID.String() != storeID1 || a.Policy != "zoom" || a.QuotaBytes != 1000 {
+		t.Errorf("unexpected first user %+v", a)
+	}
+	if a := byEmail[""]; a == nil || a.ID.String() != storeID2 {
+		t.Errorf("unexpected second user %+v", a)
+	}
+}
+
+// TestLoadSQLiteUserStoreErrors tests that invalid SQLite stores are
+// rejected, and that a missing one is not created
+func TestLoadSQLiteUserStoreErrors(t *testing.T) {
+	dir := t.TempDir()
+	stores := map[string][]string{
+		"column.db": {
+			"CREATE TABLE users (id TEXT, plan TEXT)",
+		},
+		"number.db": {
+			"CREATE TABLE users (id TEXT, level TEXT)",
+			"INSERT INTO users VALUES ('" + storeID1 + "', 'high')",
+		},
+		"table.db": {
+			"CREATE TABLE clients (id TEXT)",
+		},
+	}
+	expected := map[string]string{
+		"column.db":  `unknown column "plan"`,
+		"number.db":  `row 1: invalid "level"`,
+		"table.db":   `no such table: users`,
+		"missing.db": `no such file`,
+	}
+	for name, statements := range stores {
+		execSQLite(t, filepath.Join(dir, name), statements...)
+	}
+	for name := range expected {
+		_, err := loadUserStore(filepath.Join(dir, name), "")
+		if err == nil || !strings.Contains(err.Error(), expected[name]) {
+			t.Errorf("%s: expected error containing %q, got %v", name, expected[name], err)
+		}
+	}
+	if _, err := statFile(filepath.Join(dir, "missing.db")); err == nil {
+		t.Error("loading a missing store created it")
+	}
+}
+
+// TestSQLiteUserStoreReload tests that changes to an SQLite store are seen,
+// including those still in its write-ahead log, and applied as those to a
+// file
+func TestSQLiteUserStoreReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.db")
+	execSQLite(t, path,
+		"CREATE TABLE users (id TEXT PRIMARY KEY, email TEXT, policy TEXT)",
+		"INSERT INTO users VALUES ('"+storeID1+"', 'kept@example.com', 'zoom')",
+		"INSERT INTO users VALUES ('"+storeID2+"', 'changed@example.com', 'zoom')",
+		"INSERT INTO users VALUES ('"+storeID3+"', 'removed@example.com', 'zoom')",
+	)
+
+	h := &Handler{
+		validator: reflex.NewValidator(),
+		sessions:  newSessionTracker(),
+		store:     newUserStore(&UserStore{Path: path}, ""),
+	}
+	if err := h.loadUsers(); err != nil {
+		t.Fatal(err)
+	}
+	if count := h.validator.GetCount(); count != 3 {
+		t.Fatalf("expected 3 users, got %d", count)
+	}
+	kept := h.validator.GetByEmail("kept@example.com")
+
+	// Keep a writer open so that the changes stay in the write-ahead log
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, statement := range []string{
+		"PRAGMA journal_mode=WAL",
+		"PRAGMA wal_autocheckpoint=0",
+		"UPDATE users SET policy = 'youtube' WHERE email = 'changed@example.com'",
+		"DELETE FROM users WHERE email = 'removed@example.com'",
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatal(statement, ": ", err)
+		}
+	}
+
+	if stamp, err := statFile(path); err != nil || stamp == h.store.stamp {
+		t.Fatal("change to the store went unnoticed")
+	}
+	if err := h.loadUsers(); err != nil {
+		t.Fatal(err)
+	}
+	if h.validator.GetByEmail("kept@example.com") != kept {
+		t.Error("unchanged user was replaced")
+	}
+	if changed := h.validator.GetByEmail("changed@example.com"); changed == nil || changed.Account.(*reflex.MemoryAccount).Policy != "youtube" {
+		t.Error("changed user was not replaced")
+	}
+	if h.validator.GetByEmail("removed@example.com") != nil {
+		t.Error("removed user is still there")
+	}
+}
@@ -0,0 +1,256 @@
+package inbound
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xtls/xray-core/app/stats"
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/common/protocol"
+	"github.com/xtls/xray-core/common/serial"
+	"github.com/xtls/xray-core/features/policy"
+	"github.com/xtls/xray-core/proxy/reflex"
+)
+
+const (
+	storeID1 = "27848739-7e62-4138-9fd3-098a63964b6b"
+	storeID2 = "b831381d-6324-4d53-ad4f-8cda48b30811"
+	storeID3 = "a3482e88-686a-4a58-8126-99c9df64b7bf"
+)
+
+// writeStore writes a user store file named name in dir
+func writeStore(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestLoadUserStore tests reading users from JSON and CSV files
+func TestLoadUserStore(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"array.json": `[
+			{"id": "` + storeID1 + `", "email": "a@example.com", "policy": "zoom", "quotaBytes": 1000},
+			{"id": "` + storeID2 + `", "level": 1, "cover": {"rate": 50, "size": 1200}}
+		]`,
+		"object.json": `{"clients": [
+			{"id": "` + storeID1 + `", "email": "a@example.com", "policy": "zoom", "quotaBytes": 1000},
+			{"id": "` + storeID2 + `", "level": 1, "cover": {"rate": 50, "size": 1200}}
+		]}`,
+		"users.csv": "# users of the inbound\n" +
+			"id,email,policy,level,quotaBytes\n" +
+			storeID1 + ",a@example.com,zoom,,1000\n" +
+			storeID2 + ",,,1,\n",
+	}
+	for name, content := range files {
+		users, err := loadUserStore(writeStore(t, dir, name, content), "")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(users) != 2 {
+			t.Fatalf("%s: loaded %d users", name, len(users))
+		}
+		a := users[0].Account.(*reflex.MemoryAccount)
+		if users[0].Email != "a@example.com" || a.ID.String() != storeID1 || a.Policy != "zoom" || a.QuotaBytes != 1000 {
+			t.Errorf("%s: unexpected first user %+v %+v", name, users[0], a)
+		}
+		if users[1].Level != 1 {
+			t.Errorf("%s: unexpected second user %+v", name, users[1])
+		}
+	}
+}
+
+// TestLoadUserStoreErrors tests that invalid stores are rejected with an
+// error naming the offending entry
+func TestLoadUserStoreErrors(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"dup.json":     `[{"id": "` + storeID1 + `"}, {"id": "` + strings.ToUpper(storeID1) + `"}]`,
+		"email.json":   `[{"id": "` + storeID1 + `", "email": "A@example.com"}, {"id": "` + storeID2 + `", "email": "a@example.com"}]`,
+		"policy.json":  `[{"id": "` + storeID1 + `", "policy": "netflix"}]`,
+		"unknown.json": `[{"id": "` + storeID1 + `", "polciy": "zoom"}]`,
+		"column.csv":   "id,plan\n" + storeID1 + ",zoom\n",
+		"number.csv":   "id,level\n" + storeID1 + ",high\n",
+		"users.txt":    "",
+	}
+	expected := map[string]string{
+		"dup.json":     `clients[1]: duplicate "id"`,
+		"email.json":   `clients[1]: duplicate "email"`,
+		"policy.json":  `clients[0]`,
+		"unknown.json": `clients[0]: invalid user`,
+		"column.csv":   `unknown column "plan"`,
+		"number.csv":   `line 2: invalid "level"`,
+		"users.txt":    `unsupported user store format "txt"`,
+	}
+	for name, content := range files {
+		_, err := loadUserStore(writeStore(t, dir, name, content), "")
+		if err == nil || !strings.Contains(err.Error(), expected[name]) {
+			t.Errorf("%s: expected error containing %q, got %v", name, expected[name], err)
+		}
+	}
+}
+
+// TestUserStoreReload tests that reloading the store only touches the users
+// that changed, closing the sessions of removed users only, and that a broken
+// file leaves the users in place
+func TestUserStoreReload(t *testing.T) {
+	dir := t.TempDir()
+	path := writeStore(t, dir, "users.csv", "id,email,policy\n"+
+		storeID1+",kept@example.com,zoom\n"+
+		storeID2+",changed@example.com,zoom\n"+
+		storeID3+",removed@example.com,zoom\n")
+
+	h := &Handler{
+		validator: reflex.NewValidator(),
+		sessions:  newSessionTracker(),
+		store:     newUserStore(&UserStore{Path: path}, ""),
+	}
+	if err := h.loadUsers(); err != nil {
+		t.Fatal(err)
+	}
+	if count := h.validator.GetCount(); count != 3 {
+		t.Fatalf("expected 3 users, got %d", count)
+	}
+
+	closed := make(map[string]bool)
+	for _, user := range h.validator.GetAll() {
+		email := user.Email
+		h.sessions.Add(user, "192.0.2.1", 0, func() { closed[email] = true })
+	}
+	kept := h.validator.GetByEmail("kept@example.com")
+
+	writeStore(t, dir, "users.csv", "id,email,policy\n"+
+		storeID1+",kept@example.com,zoom\n"+
+		storeID2+",changed@example.com,youtube\n")
+	if err := h.loadUsers(); err != nil {
+		t.Fatal(err)
+	}
+	if h.validator.GetByEmail("kept@example.com") != kept || closed["kept@example.com"] {
+		t.Error("unchanged user was replaced")
+	}
+	changed := h.validator.GetByEmail("changed@example.com")
+	if changed == nil || changed.Account.(*reflex.MemoryAccount).Policy != "youtube" {
+		t.Error("changed user was not replaced")
+	}
+	if closed["changed@example.com"] || h.sessions.Count(changed) != 1 {
+		t.Error("sessions of the changed user were not handed over to it")
+	}
+	if h.validator.GetByEmail("removed@example.com") != nil || !closed["removed@example.com"] {
+		t.Error("removed user is still there")
+	}
+
+	// A broken file changes nothing
+	writeStore(t, dir, "users.csv", "id,email,policy\n"+storeID1+",kept@example.com,netflix\n")
+	if err := h.loadUsers(); err == nil {
+		t.Fatal("expected an invalid store to fail to load")
+	}
+	if h.validator.GetCount() != 2 || h.validator.GetByEmail("kept@example.com") != kept {
+		t.Error("failed reload changed the users")
+	}
+
+	// Removing the changed user closes the sessions it took over
+	writeStore(t, dir, "users.csv", "id,email,policy\n"+storeID1+",kept@example.com,zoom\n")
+	if err := h.loadUsers(); err != nil {
+		t.Fatal(err)
+	}
+	if !closed["changed@example.com"] {
+		t.Error("sessions of the changed user were not closed on its removal")
+	}
+}
+
+// TestUserStoreReloadQuota tests that a reload lowering the quota of a user
+// below its usage stops the sessions already running
+func TestUserStoreReloadQuota(t *testing.T) {
+	manager, err := stats.NewManager(context.Background(), &stats.Config{})
+	common.Must(err)
+	dir := t.TempDir()
+	path := writeStore(t, dir, "users.csv", "id,email,quotaBytes\n"+storeID1+",a@example.com,1000\n")
+
+	h := &Handler{
+		validator:     reflex.NewValidator(),
+		sessions:      newSessionTracker(),
+		stats:         manager,
+		policyManager: policy.DefaultManager{},
+		store:         newUserStore(&UserStore{Path: path}, ""),
+	}
+	common.Must(h.loadUsers())
+	registration, _ := h.sessions.Add(h.validator.GetByEmail("a@example.com"), "192.0.2.1", 0, func() {})
+	quota := h.newSessionQuota(registration)
+	quota.AddUplink(300)
+	quota.AddDownlink(300)
+	if err := quota.Check(); err != nil {
+		t.Fatal("session within its quota stopped: ", err)
+	}
+
+	writeStore(t, dir, "users.csv", "id,email,quotaBytes\n"+storeID1+",a@example.com,500\n")
+	common.Must(h.loadUsers())
+	if err := quota.Check(); err == nil {
+		t.Error("session kept running past the quota lowered by the reload")
+	}
+}
+
+// TestUserStoreTag tests that the store is counted under the tag of the
+// inbound from its first load
+func TestUserStoreTag(t *testing.T) {
+	manager, err := stats.NewManager(context.Background(), &stats.Config{})
+	common.Must(err)
+	dir := t.TempDir()
+	path := writeStore(t, dir, "users.csv", "id\n"+storeID1+"\n"+storeID2+"\n")
+
+	h := &Handler{
+		validator: reflex.NewValidator(),
+		sessions:  newSessionTracker(),
+		stats:     manager,
+		store:     newUserStore(&UserStore{Path: path}, "in"),
+	}
+	common.Must(h.loadUsers())
+	if c := manager.GetCounter("inbound>>>in>>>reflex>>>users.stored"); c == nil || c.Value() != 2 {
+		t.Fatal("users of the first load are not counted")
+	}
+
+	writeStore(t, dir, "users.csv", "id\n"+storeID1+"\n")
+	common.Must(h.loadUsers())
+	if c := manager.GetCounter("inbound>>>in>>>reflex>>>users.stored"); c.Value() != 1 {
+		t.Errorf("expected 1 stored user, got %d", c.Value())
+	}
+	if manager.GetCounter("inbound>>>>>>reflex>>>users.stored") != nil {
+		t.Error("users counted without a tag")
+	}
+}
+
+// TestUserStoreConflicts tests that the store cannot take over users
+// configured otherwise
+func TestUserStoreConflicts(t *testing.T) {
+	dir := t.TempDir()
+	static, err := (&protocol.User{
+		Email:   "static@example.com",
+		Account: serial.ToTypedMessage(&reflex.Account{Id: storeID1}),
+	}).ToMemoryUser()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range map[string]string{
+		"id.csv":    "id\n" + storeID1 + "\n",
+		"email.csv": "id,email\n" + storeID2 + ",Static@example.com\n",
+	} {
+		h := &Handler{
+			validator: reflex.NewValidator(),
+			sessions:  newSessionTracker(),
+			store:     newUserStore(&UserStore{Path: writeStore(t, dir, name, content)}, ""),
+		}
+		h.validator.Add(static)
+		if err := h.loadUsers(); err == nil || !strings.Contains(err.Error(), "already configured") {
+			t.Errorf("%s: expected a conflict with the static user, got %v", name, err)
+		}
+		if h.validator.GetCount() != 1 {
+			t.Errorf("%s: conflicting store changed the users", name)
+		}
+	}
+}
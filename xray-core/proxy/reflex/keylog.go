@@ -0,0 +1,97 @@
+package reflex
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/xtls/xray-core/common/errors"
+)
+
+// keyLogLabel starts the lines of a key log. Each line is the label, the
+// client's public key from the hello and the session key, in hex:
+//
+//	REFLEX_SESSION_KEY <public key> <session key>
+//
+// The public key is sent in the clear, so it finds the key of a connection in
+// a capture. Connections resuming a session derive their keys from the key
+// of the session and need no line of their own.
+const keyLogLabel = "REFLEX_SESSION_KEY"
+
+// KeyLog appends the session keys of Reflex connections to a file, like
+// SSLKEYLOGFILE does for TLS, so that captured traffic can be decrypted with
+// "xray reflex decode". A nil KeyLog logs nothing.
+type KeyLog struct {
+	access sync.Mutex
+	file   *os.File
+}
+
+// OpenKeyLog opens the key log at path for appending. It returns nil for an
+// empty path.
+func OpenKeyLog(path string) (*KeyLog, error) {
+	if path == "" {
+		return nil, nil
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, errors.New("failed to open ", path, " as key log").Base(err)
+	}
+	errors.LogWarning(context.Background(), "Reflex key log is on: session keys are written to ", path, ", with which anyone can decrypt captured traffic. Turn it off outside of debugging.")
+	return &KeyLog{file: file}, nil
+}
+
+// Log appends the session key of the connection whose hello carried
+// clientPublicKey.
+func (l *KeyLog) Log(clientPublicKey [32]byte, sessionKey []byte) {
+	if l == nil {
+		return
+	}
+	line := keyLogLabel + " " + hex.EncodeToString(clientPublicKey[:]) + " " + hex.EncodeToString(sessionKey) + "\n"
+	l.access.Lock()
+	defer l.access.Unlock()
+	if _, err := l.file.WriteString(line); err != nil {
+		errors.LogWarningInner(context.Background(), err, "failed to write to key log")
+	}
+}
+
+// Close closes the file of the key log.
+func (l *KeyLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// ReadKeyLog reads the session keys of a key log, by client public key.
+// Blank lines, comments starting with # and lines of other labels are
+// skipped, so that a key log may be shared with TLS.
+func ReadKeyLog(r io.Reader) (map[[32]byte][]byte, error) {
+	keys := make(map[[32]byte][]byte)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] != keyLogLabel {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, errors.New("key log line ", line, ": expected a public key and a session key")
+		}
+		publicKey, err := hex.DecodeString(fields[1])
+		if err != nil || len(publicKey) != 32 {
+			return nil, errors.New("key log line ", line, ": invalid public key")
+		}
+		sessionKey, err := hex.DecodeString(fields[2])
+		if err != nil || len(sessionKey) != 32 {
+			return nil, errors.New("key log line ", line, ": invalid session key")
+		}
+		keys[[32]byte(publicKey)] = sessionKey
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
@@ -0,0 +1,66 @@
+package reflex
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestKeyLog tests that logged keys are read back by client public key
+func TestKeyLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.log")
+	keyLog, err := OpenKeyLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var publicKey1, publicKey2 [32]byte
+	publicKey1[0], publicKey2[0] = 1, 2
+	sessionKey1, sessionKey2 := bytes.Repeat([]byte{0xaa}, 32), bytes.Repeat([]byte{0xbb}, 32)
+	keyLog.Log(publicKey1, sessionKey1)
+	keyLog.Log(publicKey2, sessionKey2)
+	if err := keyLog.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A key log shared with TLS keeps its lines
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = append([]byte("# keys\nCLIENT_RANDOM 00 11\n\n"), data...)
+	keys, err := ReadKeyLog(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || !bytes.Equal(keys[publicKey1], sessionKey1) || !bytes.Equal(keys[publicKey2], sessionKey2) {
+		t.Fatalf("unexpected keys %x", keys)
+	}
+}
+
+// TestKeyLogOff tests that no key log is kept without a path
+func TestKeyLogOff(t *testing.T) {
+	keyLog, err := OpenKeyLog("")
+	if err != nil || keyLog != nil {
+		t.Fatalf("expected no key log, got %v, %v", keyLog, err)
+	}
+	// A nil key log does nothing
+	keyLog.Log([32]byte{}, make([]byte, 32))
+	if err := keyLog.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestReadKeyLogErrors tests that malformed lines are reported by number
+func TestReadKeyLogErrors(t *testing.T) {
+	for input, expected := range map[string]string{
+		"REFLEX_SESSION_KEY 00":                                  "line 1: expected",
+		"\nREFLEX_SESSION_KEY zz " + strings.Repeat("00", 32):    "line 2: invalid public key",
+		"REFLEX_SESSION_KEY " + strings.Repeat("00", 32) + " 00": "line 1: invalid session key",
+	} {
+		if _, err := ReadKeyLog(strings.NewReader(input)); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%q: expected error containing %q, got %v", input, expected, err)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package reflex
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/xtls/xray-core/features/stats"
+)
+
+// Handshake results reported by Metrics. Outbounds cannot tell why a server
+// rejected them, so they report HandshakeFailed when no response arrives.
+// HandshakeResumed counts sessions taken over by a new connection.
+const (
+	HandshakeOK          = "ok"
+	HandshakeResumed     = "resumed"
+	HandshakeBadTime     = "bad_time"
+	HandshakeUnknownUser = "unknown_user"
+	HandshakeReplay      = "replay"
+	HandshakeDecodeError = "decode_error"
+	HandshakeFailed      = "failed"
+)
+
+// latencyBuckets are the upper bounds of the handshake latency histogram.
+var latencyBuckets = []time.Duration{
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+}
+
+// Metrics reports protocol events of a Reflex handler as counters of the
+// stats manager, named "inbound>>>tag>>>reflex>>>name" or
+// "outbound>>>tag>>>reflex>>>name" so that they show up in the stats API and
+// in app/metrics next to the traffic counters of the handler. A nil Metrics
+// reports nothing.
+type Metrics struct {
+	manager stats.Manager
+	prefix  string
+}
+
+// NewMetrics returns the metrics of the handler tagged tag, where direction is
+// "inbound" or "outbound". It returns nil for untagged handlers.
+func NewMetrics(manager stats.Manager, direction, tag string) *Metrics {
+	if manager == nil || tag == "" {
+		return nil
+	}
+	return &Metrics{
+		manager: manager,
+		prefix:  direction + ">>>" + tag + ">>>reflex>>>",
+	}
+}
+
+// Counter returns the counter called name, or nil if the stats app is not
+// enabled.
+func (m *Metrics) Counter(name string) stats.Counter {
+	if m == nil {
+		return nil
+	}
+	c, _ := stats.GetOrRegisterCounter(m.manager, m.prefix+name)
+	return c
+}
+
+// Inc adds one to the counter called name.
+func (m *Metrics) Inc(name string) {
+	if c := m.Counter(name); c != nil {
+		c.Add(1)
+	}
+}
+
+// Handshake counts a handshake that ended with result.
+func (m *Metrics) Handshake(result string) {
+	m.Inc("handshake." + result)
+}
+
+// Fallback counts a connection handed to the fallback, by the kind of traffic
+// it carried: tls, http or unknown.
+func (m *Metrics) Fallback(kind string) {
+	m.Inc("fallback." + kind)
+}
+
+// FallbackDest counts a connection forwarded to the fallback destination dest.
+func (m *Metrics) FallbackDest(dest string) {
+	m.Inc("fallback.dest." + dest)
+}
+
+// HandshakeLatency records the duration of a successful handshake in a
+// cumulative histogram: handshake_latency.le_<bound> counts the handshakes
+// that took at most bound, le_inf counts all of them and sum_ms their total
+// duration.
+func (m *Metrics) HandshakeLatency(d time.Duration) {
+	if m == nil {
+		return
+	}
+	for _, bound := range latencyBuckets {
+		if d <= bound {
+			m.Inc("handshake_latency.le_" + formatBound(bound))
+		}
+	}
+	m.Inc("handshake_latency.le_inf")
+	if c := m.Counter("handshake_latency.sum_ms"); c != nil {
+		c.Add(d.Milliseconds())
+	}
+}
+
+// Bytes returns the counters of the data and padding bytes sent with the
+// morphing profile called profile.
+func (m *Metrics) Bytes(profile string) (data, padding stats.Counter) {
+	return m.Counter("bytes.data." + profile), m.Counter("bytes.padding." + profile)
+}
+
+// CoverOverhead returns the counter of the bytes sent as cover traffic that
+// carried no data.
+func (m *Metrics) CoverOverhead() stats.Counter {
+	return m.Counter("cover.overhead")
+}
+
+func formatBound(d time.Duration) string {
+	if d%time.Second == 0 {
+		return strconv.FormatInt(int64(d/time.Second), 10) + "s"
+	}
+	return strconv.FormatInt(d.Milliseconds(), 10) + "ms"
+}
@@ -0,0 +1,67 @@
+package reflex
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/app/stats"
+	"github.com/xtls/xray-core/common"
+)
+
+// TestMetrics tests counter names and the latency histogram
+func TestMetrics(t *testing.T) {
+	manager, err := stats.NewManager(context.Background(), &stats.Config{})
+	common.Must(err)
+
+	m := NewMetrics(manager, "inbound", "reflex")
+	m.Handshake(HandshakeOK)
+	m.Handshake(HandshakeOK)
+	m.Handshake(HandshakeReplay)
+	m.Fallback("http")
+	m.FallbackDest("127.0.0.1:80")
+	m.HandshakeLatency(20 * time.Millisecond)
+	m.HandshakeLatency(3 * time.Second)
+	data, padding := m.Bytes("zoom")
+	data.Add(100)
+	padding.Add(10)
+
+	for name, expected := range map[string]int64{
+		"inbound>>>reflex>>>reflex>>>handshake.ok":                2,
+		"inbound>>>reflex>>>reflex>>>handshake.replay":            1,
+		"inbound>>>reflex>>>reflex>>>fallback.http":               1,
+		"inbound>>>reflex>>>reflex>>>fallback.dest.127.0.0.1:80":  1,
+		"inbound>>>reflex>>>reflex>>>handshake_latency.le_10ms":   0,
+		"inbound>>>reflex>>>reflex>>>handshake_latency.le_25ms":   1,
+		"inbound>>>reflex>>>reflex>>>handshake_latency.le_1s":     1,
+		"inbound>>>reflex>>>reflex>>>handshake_latency.le_2500ms": 1,
+		"inbound>>>reflex>>>reflex>>>handshake_latency.le_inf":    2,
+		"inbound>>>reflex>>>reflex>>>handshake_latency.sum_ms":    3020,
+		"inbound>>>reflex>>>reflex>>>bytes.data.zoom":             100,
+		"inbound>>>reflex>>>reflex>>>bytes.padding.zoom":          10,
+	} {
+		var value int64
+		if c := manager.GetCounter(name); c != nil {
+			value = c.Value()
+		}
+		if value != expected {
+			t.Errorf("%s: expected %d, got %d", name, expected, value)
+		}
+	}
+}
+
+// TestMetricsDisabled tests that metrics of untagged handlers report nothing
+func TestMetricsDisabled(t *testing.T) {
+	manager, err := stats.NewManager(context.Background(), &stats.Config{})
+	common.Must(err)
+
+	m := NewMetrics(manager, "inbound", "")
+	if m != nil {
+		t.Fatal("expected no metrics for untagged handler")
+	}
+	m.Handshake(HandshakeOK)
+	m.HandshakeLatency(time.Second)
+	if data, padding := m.Bytes("zoom"); data != nil || padding != nil {
+		t.Fatal("expected no counters")
+	}
+}
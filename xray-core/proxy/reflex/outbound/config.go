@@ -0,0 +1,16 @@
+package outbound
+
+import (
+	"encoding/json"
+)
+
+// UnmarshalJSON implements custom JSON unmarshaling for Config
+func (c *Config) UnmarshalJSON(data []byte) error {
+	type configAlias Config
+	aux := &struct {
+		*configAlias
+	}{
+		configAlias: (*configAlias)(c),
+	}
+	return json.Unmarshal(data, &aux)
+}
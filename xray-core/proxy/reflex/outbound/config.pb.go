@@ -7,8 +7,6 @@
 package outbound
 
 import (
-	"encoding/json"
-
 	protocol "github.com/xtls/xray-core/common/protocol"
 	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
 	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
@@ -24,16 +22,154 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+// HelloSplit splits the hello into several writes, so that it does not
+// arrive as one packet of its exact size.
+type HelloSplit struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Number of writes the hello is split into, at random offsets.
+	Count uint32 `protobuf:"varint,1,opt,name=count,proto3" json:"count,omitempty"`
+	// Range in milliseconds of the random delay before each write but the
+	// first.
+	MinDelay      uint32 `protobuf:"varint,2,opt,name=min_delay,json=minDelay,proto3" json:"min_delay,omitempty"`
+	MaxDelay      uint32 `protobuf:"varint,3,opt,name=max_delay,json=maxDelay,proto3" json:"max_delay,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HelloSplit) Reset() {
+	*x = HelloSplit{}
+	mi := &file_proxy_reflex_outbound_config_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HelloSplit) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HelloSplit) ProtoMessage() {}
+
+func (x *HelloSplit) ProtoReflect() protoreflect.Message {
+	mi := &file_proxy_reflex_outbound_config_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HelloSplit.ProtoReflect.Descriptor instead.
+func (*HelloSplit) Descriptor() ([]byte, []int) {
+	return file_proxy_reflex_outbound_config_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *HelloSplit) GetCount() uint32 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+func (x *HelloSplit) GetMinDelay() uint32 {
+	if x != nil {
+		return x.MinDelay
+	}
+	return 0
+}
+
+func (x *HelloSplit) GetMaxDelay() uint32 {
+	if x != nil {
+		return x.MaxDelay
+	}
+	return 0
+}
+
+// Pool keeps connections to the server on which the handshake is done, so
+// that requests only send their request header.
+type Pool struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Number of idle connections kept. 0 disables the pool.
+	Size uint32 `protobuf:"varint,1,opt,name=size,proto3" json:"size,omitempty"`
+	// Seconds an idle connection is kept before it is replaced. 0 means 60.
+	MaxAge        uint32 `protobuf:"varint,2,opt,name=max_age,json=maxAge,proto3" json:"max_age,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Pool) Reset() {
+	*x = Pool{}
+	mi := &file_proxy_reflex_outbound_config_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Pool) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Pool) ProtoMessage() {}
+
+func (x *Pool) ProtoReflect() protoreflect.Message {
+	mi := &file_proxy_reflex_outbound_config_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Pool.ProtoReflect.Descriptor instead.
+func (*Pool) Descriptor() ([]byte, []int) {
+	return file_proxy_reflex_outbound_config_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Pool) GetSize() uint32 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *Pool) GetMaxAge() uint32 {
+	if x != nil {
+		return x.MaxAge
+	}
+	return 0
+}
+
 type Config struct {
-	state         protoimpl.MessageState     `protogen:"open.v1"`
-	Vnext         []*protocol.ServerEndpoint `protobuf:"bytes,1,rep,name=vnext,proto3" json:"vnext,omitempty"`
+	state protoimpl.MessageState     `protogen:"open.v1"`
+	Vnext []*protocol.ServerEndpoint `protobuf:"bytes,1,rep,name=vnext,proto3" json:"vnext,omitempty"`
+	// Interval in seconds between keepalive PING frames. 0 disables keepalive.
+	KeepAliveInterval uint32 `protobuf:"varint,2,opt,name=keep_alive_interval,json=keepAliveInterval,proto3" json:"keep_alive_interval,omitempty"`
+	// Ask the server to keep sessions alive across connection resets, so
+	// they can be resumed on a new connection.
+	Resume bool `protobuf:"varint,3,opt,name=resume,proto3" json:"resume,omitempty"`
+	// Pad the hello and ask the server to pad its response, to packet sizes
+	// of the account's profile. Padding only hides the sizes of the first
+	// packets: a padded hello still starts with the fixed magic "RFXP" and
+	// carries the user ID in the clear, so it does not hide the protocol
+	// from an observer that looks at the bytes.
+	PadHandshake bool        `protobuf:"varint,4,opt,name=pad_handshake,json=padHandshake,proto3" json:"pad_handshake,omitempty"`
+	HelloSplit   *HelloSplit `protobuf:"bytes,5,opt,name=hello_split,json=helloSplit,proto3" json:"hello_split,omitempty"`
+	Pool         *Pool       `protobuf:"bytes,6,opt,name=pool,proto3" json:"pool,omitempty"`
+	// Path of a file to which the session key of each connection is
+	// appended, to decrypt captures with "xray reflex decode". Anyone holding
+	// the file can read the traffic; leave empty outside of debugging.
+	KeyLog        string `protobuf:"bytes,7,opt,name=key_log,json=keyLog,proto3" json:"key_log,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *Config) Reset() {
 	*x = Config{}
-	mi := &file_proxy_reflex_outbound_config_proto_msgTypes[0]
+	mi := &file_proxy_reflex_outbound_config_proto_msgTypes[2]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -45,7 +181,7 @@ func (x *Config) String() string {
 func (*Config) ProtoMessage() {}
 
 func (x *Config) ProtoReflect() protoreflect.Message {
-	mi := &file_proxy_reflex_outbound_config_proto_msgTypes[0]
+	mi := &file_proxy_reflex_outbound_config_proto_msgTypes[2]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -58,7 +194,7 @@ func (x *Config) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Config.ProtoReflect.Descriptor instead.
 func (*Config) Descriptor() ([]byte, []int) {
-	return file_proxy_reflex_outbound_config_proto_rawDescGZIP(), []int{0}
+	return file_proxy_reflex_outbound_config_proto_rawDescGZIP(), []int{2}
 }
 
 func (x *Config) GetVnext() []*protocol.ServerEndpoint {
@@ -68,13 +204,70 @@ func (x *Config) GetVnext() []*protocol.ServerEndpoint {
 	return nil
 }
 
+func (x *Config) GetKeepAliveInterval() uint32 {
+	if x != nil {
+		return x.KeepAliveInterval
+	}
+	return 0
+}
+
+func (x *Config) GetResume() bool {
+	if x != nil {
+		return x.Resume
+	}
+	return false
+}
+
+func (x *Config) GetPadHandshake() bool {
+	if x != nil {
+		return x.PadHandshake
+	}
+	return false
+}
+
+func (x *Config) GetHelloSplit() *HelloSplit {
+	if x != nil {
+		return x.HelloSplit
+	}
+	return nil
+}
+
+func (x *Config) GetPool() *Pool {
+	if x != nil {
+		return x.Pool
+	}
+	return nil
+}
+
+func (x *Config) GetKeyLog() string {
+	if x != nil {
+		return x.KeyLog
+	}
+	return ""
+}
+
 var File_proxy_reflex_outbound_config_proto protoreflect.FileDescriptor
 
 const file_proxy_reflex_outbound_config_proto_rawDesc = "" +
 	"\n" +
-	"\"proxy/reflex/outbound/config.proto\x12\x1axray.proxy.reflex.outbound\x1a!common/protocol/server_spec.proto\"D\n" +
+	"\"proxy/reflex/outbound/config.proto\x12\x1axray.proxy.reflex.outbound\x1a!common/protocol/server_spec.proto\"\\\n" +
+	"\n" +
+	"HelloSplit\x12\x14\n" +
+	"\x05count\x18\x01 \x01(\rR\x05count\x12\x1b\n" +
+	"\tmin_delay\x18\x02 \x01(\rR\bminDelay\x12\x1b\n" +
+	"\tmax_delay\x18\x03 \x01(\rR\bmaxDelay\"3\n" +
+	"\x04Pool\x12\x12\n" +
+	"\x04size\x18\x01 \x01(\rR\x04size\x12\x17\n" +
+	"\amax_age\x18\x02 \x01(\rR\x06maxAge\"\xc9\x02\n" +
 	"\x06Config\x12:\n" +
-	"\x05vnext\x18\x01 \x03(\v2$.xray.common.protocol.ServerEndpointR\x05vnextBp\n" +
+	"\x05vnext\x18\x01 \x03(\v2$.xray.common.protocol.ServerEndpointR\x05vnext\x12.\n" +
+	"\x13keep_alive_interval\x18\x02 \x01(\rR\x11keepAliveInterval\x12\x16\n" +
+	"\x06resume\x18\x03 \x01(\bR\x06resume\x12#\n" +
+	"\rpad_handshake\x18\x04 \x01(\bR\fpadHandshake\x12G\n" +
+	"\vhello_split\x18\x05 \x01(\v2&.xray.proxy.reflex.outbound.HelloSplitR\n" +
+	"helloSplit\x124\n" +
+	"\x04pool\x18\x06 \x01(\v2 .xray.proxy.reflex.outbound.PoolR\x04pool\x12\x17\n" +
+	"\akey_log\x18\a \x01(\tR\x06keyLogBp\n" +
 	"\x1ecom.xray.proxy.reflex.outboundP\x01Z/github.com/xtls/xray-core/proxy/reflex/outbound\xaa\x02\x1aXray.Proxy.Reflex.Outboundb\x06proto3"
 
 var (
@@ -89,18 +282,22 @@ func file_proxy_reflex_outbound_config_proto_rawDescGZIP() []byte {
 	return file_proxy_reflex_outbound_config_proto_rawDescData
 }
 
-var file_proxy_reflex_outbound_config_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_proxy_reflex_outbound_config_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
 var file_proxy_reflex_outbound_config_proto_goTypes = []any{
-	(*Config)(nil),                  // 0: xray.proxy.reflex.outbound.Config
-	(*protocol.ServerEndpoint)(nil), // 1: xray.common.protocol.ServerEndpoint
+	(*HelloSplit)(nil),              // 0: xray.proxy.reflex.outbound.HelloSplit
+	(*Pool)(nil),                    // 1: xray.proxy.reflex.outbound.Pool
+	(*Config)(nil),                  // 2: xray.proxy.reflex.outbound.Config
+	(*protocol.ServerEndpoint)(nil), // 3: xray.common.protocol.ServerEndpoint
 }
 var file_proxy_reflex_outbound_config_proto_depIdxs = []int32{
-	1, // 0: xray.proxy.reflex.outbound.Config.vnext:type_name -> xray.common.protocol.ServerEndpoint
-	1, // [1:1] is the sub-list for method output_type
-	1, // [1:1] is the sub-list for method input_type
-	1, // [1:1] is the sub-list for extension type_name
-	1, // [1:1] is the sub-list for extension extendee
-	0, // [0:1] is the sub-list for field type_name
+	3, // 0: xray.proxy.reflex.outbound.Config.vnext:type_name -> xray.common.protocol.ServerEndpoint
+	0, // 1: xray.proxy.reflex.outbound.Config.hello_split:type_name -> xray.proxy.reflex.outbound.HelloSplit
+	1, // 2: xray.proxy.reflex.outbound.Config.pool:type_name -> xray.proxy.reflex.outbound.Pool
+	3, // [3:3] is the sub-list for method output_type
+	3, // [3:3] is the sub-list for method input_type
+	3, // [3:3] is the sub-list for extension type_name
+	3, // [3:3] is the sub-list for extension extendee
+	0, // [0:3] is the sub-list for field type_name
 }
 
 func init() { file_proxy_reflex_outbound_config_proto_init() }
@@ -114,7 +311,7 @@ func file_proxy_reflex_outbound_config_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proxy_reflex_outbound_config_proto_rawDesc), len(file_proxy_reflex_outbound_config_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   1,
+			NumMessages:   3,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
@@ -126,14 +323,3 @@ func file_proxy_reflex_outbound_config_proto_init() {
 	file_proxy_reflex_outbound_config_proto_goTypes = nil
 	file_proxy_reflex_outbound_config_proto_depIdxs = nil
 }
-
-// UnmarshalJSON implements custom JSON unmarshaling for Config
-func (c *Config) UnmarshalJSON(data []byte) error {
-	type configAlias Config
-	aux := &struct {
-		*configAlias
-	}{
-		configAlias: (*configAlias)(c),
-	}
-	return json.Unmarshal(data, &aux)
-}
@@ -2,22 +2,30 @@ package outbound
 
 import (
 	"context"
-	"os"
+	"crypto/rand"
+	"io"
+	mrand "math/rand"
+	"slices"
 	"time"
 
 	"github.com/xtls/xray-core/common"
 	"github.com/xtls/xray-core/common/buf"
+	xctx "github.com/xtls/xray-core/common/ctx"
 	"github.com/xtls/xray-core/common/errors"
 	"github.com/xtls/xray-core/common/net"
 	"github.com/xtls/xray-core/common/protocol"
 	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/common/signal"
 	"github.com/xtls/xray-core/common/task"
 	"github.com/xtls/xray-core/core"
 	"github.com/xtls/xray-core/features/policy"
+	"github.com/xtls/xray-core/features/stats"
 	"github.com/xtls/xray-core/proxy/reflex"
 	"github.com/xtls/xray-core/proxy/reflex/encoding"
+	"github.com/xtls/xray-core/proxy/reflex/resume"
 	"github.com/xtls/xray-core/transport"
 	"github.com/xtls/xray-core/transport/internet"
+	"github.com/xtls/xray-core/transport/internet/stat"
 )
 
 func init() {
@@ -28,52 +36,83 @@ func init() {
 
 // Handler is an outbound connection handler for Reflex protocol
 type Handler struct {
-	policyManager policy.Manager
-	config        *Config
+	policyManager     policy.Manager
+	stats             stats.Manager
+	config            *Config
+	keepAliveInterval time.Duration
+	// clock follows the server's clock, as learned from its handshake
+	// responses, so that hellos pass its timestamp check
+	clock  encoding.Clock
+	keyLog *reflex.KeyLog
+	// timeSource times cover traffic and TLS shaping, the system clock if
+	// nil
+	timeSource encoding.TimeSource
+
+	// server is the Reflex server, and user the user the client connects
+	// as, from the first entry of the vnext
+	server  net.Destination
+	user    *protocol.MemoryUser
+	account *reflex.MemoryAccount
+
+	// pool keeps handshaken connections to the server, nil if the handler
+	// does not pool connections
+	pool *connPool
 }
 
 // New creates a new Reflex outbound handler
 func New(ctx context.Context, config *Config) (*Handler, error) {
 	v := core.MustFromContext(ctx)
 
-	handler := &Handler{
-		policyManager: v.GetFeature(policy.ManagerType()).(policy.Manager),
-		config:        config,
+	server, user, account, err := parseServer(config)
+	if err != nil {
+		return nil, err
 	}
-
-	return handler, nil
-}
-
-// Process implements proxy.Outbound.Process
-func (h *Handler) Process(ctx context.Context, link *transport.Link, dialer internet.Dialer) error {
-	// Log to file
-	f, _ := os.OpenFile("reflex-outbound.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if f != nil {
-		f.WriteString("REFLEX OUTBOUND PROCESS CALLED\n")
-		f.Close()
+	// The pool dials through the outbound handler this one is created by,
+	// as requests do
+	owner := session.FullHandlerFromContext(ctx)
+	dialer, ok := owner.(internet.Dialer)
+	if config.Pool.GetSize() > 0 && !ok {
+		return nil, errors.New("connection pool requires an outbound handler to dial with")
 	}
 
-	outbounds := session.OutboundsFromContext(ctx)
-	if len(outbounds) == 0 {
-		return errors.New("no outbound").AtError()
+	keyLog, err := reflex.OpenKeyLog(config.KeyLog)
+	if err != nil {
+		return nil, err
+	}
+	handler := &Handler{
+		policyManager:     v.GetFeature(policy.ManagerType()).(policy.Manager),
+		stats:             v.GetFeature(stats.ManagerType()).(stats.Manager),
+		config:            config,
+		keepAliveInterval: time.Duration(config.KeepAliveInterval) * time.Second,
+		keyLog:            keyLog,
+		timeSource:        encoding.TimeSourceFromContext(ctx),
+		server:            server,
+		user:              user,
+		account:           account,
 	}
 
-	ob := outbounds[len(outbounds)-1]
-	if !ob.Target.IsValid() {
-		return errors.New("target not specified").AtError()
+	if size := config.Pool.GetSize(); size > 0 {
+		metrics := reflex.NewMetrics(handler.stats, "outbound", owner.Tag())
+		// Connections outlive the requests that take them
+		poolCtx := xctx.ContextWithID(context.Background(), session.NewID())
+		poolCtx = session.ContextWithOutbounds(poolCtx, []*session.Outbound{{Target: server, Tag: owner.Tag()}})
+		handler.pool = newConnPool(poolCtx, int(size), time.Duration(config.Pool.MaxAge)*time.Second, func() (*clientConn, error) {
+			return handler.handshake(poolCtx, dialer, server, account, metrics)
+		})
 	}
 
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
+	return handler, nil
+}
 
-	// Get server endpoint from vnext
-	if len(h.config.Vnext) == 0 {
-		return errors.New("no server configured").AtError()
+// parseServer returns the destination of the first server of the vnext, and
+// the user to connect as.
+func parseServer(config *Config) (net.Destination, *protocol.MemoryUser, *reflex.MemoryAccount, error) {
+	if len(config.Vnext) == 0 {
+		return net.Destination{}, nil, nil, errors.New("no server configured")
 	}
-
-	server := h.config.Vnext[0]
+	server := config.Vnext[0]
 	if server.Address == nil {
-		return errors.New("server address not specified").AtError()
+		return net.Destination{}, nil, nil, errors.New("server address not specified")
 	}
 
 	// Create a net address for the server from IPOrDomain
@@ -83,110 +122,145 @@ func (h *Handler) Process(ctx context.Context, link *transport.Link, dialer inte
 	} else if domain := server.Address.GetDomain(); domain != "" {
 		serverAddr = net.DomainAddress(domain)
 	} else {
-		return errors.New("server address is empty").AtError()
+		return net.Destination{}, nil, nil, errors.New("server address is empty")
 	}
 
-	serverPort := net.Port(server.Port)
-	serverDestination := net.Destination{
-		Network: net.Network_TCP,
-		Address: serverAddr,
-		Port:    serverPort,
+	if server.User == nil {
+		return net.Destination{}, nil, nil, errors.New("no user configured for server")
 	}
-
-	// Dial to the reflex server (not the target)
-	rawConn, err := dialer.Dial(ctx, serverDestination)
+	user, err := server.User.ToMemoryUser()
 	if err != nil {
-		return errors.New("failed to dial reflex server").Base(err).AtError()
+		return net.Destination{}, nil, nil, errors.New("failed to parse user").Base(err)
 	}
-	defer rawConn.Close()
-
-	target := ob.Target
-	request := &protocol.RequestHeader{
-		Version: 1,
-		Address: target.Address,
-		Port:    target.Port,
-		Command: protocol.RequestCommandTCP,
+	account, ok := user.Account.(*reflex.MemoryAccount)
+	if !ok {
+		return net.Destination{}, nil, nil, errors.New("invalid account type")
 	}
 
-	if target.Network == net.Network_UDP {
-		request.Command = protocol.RequestCommandUDP
-	}
+	// The stream settings of the outbound decide the actual transport
+	// (RAW, WebSocket, gRPC, XHTTP, ...); the destination is always a
+	// stream one.
+	return net.TCPDestination(serverAddr, net.Port(server.Port)), user, account, nil
+}
 
-	// Get user account from config (vnext)
-	// Use the first server's user account
-	var account *reflex.MemoryAccount
-	if server.User == nil {
-		return errors.New("no user configured for server").AtError()
+// Close implements common.Closable.Close
+func (h *Handler) Close() error {
+	var err error
+	if h.pool != nil {
+		err = h.pool.Close()
 	}
+	return errors.Combine(err, h.keyLog.Close())
+}
 
-	// Convert User to MemoryAccount
-	memUser, err := server.User.ToMemoryUser()
-	if err != nil {
-		return errors.New("failed to parse user").Base(err).AtError()
+// Process implements proxy.Outbound.Process
+func (h *Handler) Process(ctx context.Context, link *transport.Link, dialer internet.Dialer) error {
+	outbounds := session.OutboundsFromContext(ctx)
+	if len(outbounds) == 0 {
+		return errors.New("no outbound").AtError()
 	}
 
-	if memUser.Account == nil {
-		return errors.New("user has no account").AtError()
+	ob := outbounds[len(outbounds)-1]
+	if !ob.Target.IsValid() {
+		return errors.New("target not specified").AtError()
 	}
+	metrics := reflex.NewMetrics(h.stats, "outbound", ob.Tag)
 
-	if reflexAccount, ok := memUser.Account.(*reflex.MemoryAccount); ok {
-		account = reflexAccount
-	} else {
-		return errors.New("invalid account type").AtError()
-	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	// Perform handshake
-	clientPrivateKey, clientPublicKey, err := encoding.GenerateKeyPair()
-	if err != nil {
-		return errors.New("failed to generate key pair").Base(err).AtError()
+	target := ob.Target
+	request := &protocol.RequestHeader{
+		Version: 1,
+		Address: target.Address,
+		Port:    target.Port,
+		Command: protocol.RequestCommandTCP,
 	}
 
-	userIDBytes := encoding.UUIDToBytes(account.ID)
-	var nonce [16]byte
-	// TODO: Generate random nonce
-
-	clientHS := &encoding.ClientHandshake{
-		PublicKey: clientPublicKey,
-		UserID:    userIDBytes,
-		Timestamp: time.Now().Unix(),
-		Nonce:     nonce,
+	if target.Network == net.Network_UDP {
+		request.Command = protocol.RequestCommandUDP
 	}
 
-	// Send client handshake (use pooled buffer)
-	handshakeData := encoding.EncodeClientHandshake(clientHS)
-	defer encoding.PutClientHandshakeBuffer(handshakeData)
-	if _, err := rawConn.Write(handshakeData); err != nil {
-		return errors.New("failed to send handshake").Base(err).AtError()
-	}
+	serverDestination, account := h.server, h.account
 
-	// Read server handshake response (40 bytes) - use pooled buffer
-	responseData := encoding.GetServerHandshakeBuffer()
-	defer encoding.PutServerHandshakeBuffer(responseData)
-	if _, err := rawConn.Read(responseData); err != nil {
-		return errors.New("failed to read handshake response").Base(err).AtError()
+	// Take an idle connection of the pool, on which the handshake is done
+	// already, or dial the reflex server (not the target) and handshake
+	dial := func() (*clientConn, error) {
+		return h.handshake(ctx, dialer, serverDestination, account, metrics)
 	}
-
-	serverHS, err := encoding.DecodeServerHandshake(responseData)
-	if err != nil {
-		return errors.New("invalid server handshake").Base(err).AtError()
+	var c *clientConn
+	if h.pool != nil {
+		c = h.pool.Get()
 	}
-
-	// Derive session key
-	sharedKey := encoding.DeriveSharedKey(clientPrivateKey, serverHS.PublicKey)
-	sessionKey, err := encoding.DeriveSessionKey(sharedKey, []byte("reflex-session-v1"))
-	if err != nil {
-		return errors.New("failed to derive session key").Base(err).AtError()
+	pooled := c != nil
+	if !pooled {
+		var err error
+		if c, err = dial(); err != nil {
+			return err
+		}
 	}
 
-	// Create frame encoder/decoder
-	frameEncoder, err := encoding.NewFrameEncoder(sessionKey)
-	if err != nil {
-		return errors.New("failed to create frame encoder").Base(err).AtError()
+	// A pooled connection the server has just closed fails the first write
+	// or read of the session, which then moves to a fresh one. Resumable
+	// sessions run on transports of their own, so only the write of their
+	// offer is retried.
+	var frames encoding.FrameConn
+	current := func() *clientConn { return c }
+	if pooled && !h.config.Resume {
+		pooledFrames := newPooledFrames(ctx, c, dial)
+		defer pooledFrames.Close()
+		frames = pooledFrames
+		current = pooledFrames.Conn
+	} else {
+		defer func() { c.conn.Close() }()
+		frames = encoding.NewFrameConn(c.encoder, c.conn, c.decoder, c.reader)
+	}
+	profile := c.profile
+	keepAliveInterval := h.keepAliveInterval
+
+	// Offer to resume the session on a new connection if this one breaks.
+	// The session holds on to what it sends until the server answers.
+	if h.config.Resume {
+		err := frames.WriteFrame(resume.NewOfferFrame())
+		if err != nil && pooled {
+			errors.LogInfoInner(ctx, err, "pooled connection failed, retrying on a new one")
+			c.conn.Close()
+			if c, err = dial(); err != nil {
+				return err
+			}
+			frames = encoding.NewFrameConn(c.encoder, c.conn, c.decoder, c.reader)
+			profile = c.profile
+			err = frames.WriteFrame(resume.NewOfferFrame())
+		}
+		if err != nil {
+			return errors.New("failed to send resume offer").Base(err).AtError()
+		}
+		frameEncoder, frameDecoder := c.encoder, c.decoder
+		secrets, err := resume.DeriveSecrets(c.sessionKey)
+		if err != nil {
+			return errors.New("failed to derive resumption secrets").Base(err).AtError()
+		}
+		resumable := resume.NewSession(secrets, resume.Config{
+			KeepAliveInterval: h.keepAliveInterval,
+			Profile:           profile,
+			Clock:             h.timeSource,
+			Redial: func(received uint64) (*resume.Transport, uint64, error) {
+				return h.redial(ctx, dialer, serverDestination, &secrets, received, metrics, profile)
+			},
+		}, resume.NewTransport(c.conn, c.reader, frameEncoder, frameDecoder))
+		defer resumable.Close()
+		frames = resumable
+		// The session keeps each of its connections alive itself
+		keepAliveInterval = 0
 	}
 
-	frameDecoder, err := encoding.NewFrameDecoder(sessionKey)
-	if err != nil {
-		return errors.New("failed to create frame decoder").Base(err).AtError()
+	// Cover traffic sends frames of a fixed size at a fixed rate
+	coverConfig := account.CoverConfig()
+	if coverConfig != nil {
+		coverConfig.Clock = h.timeSource
+		cover := encoding.NewCover(frames, *coverConfig, profile, metrics.CoverOverhead())
+		common.Must(cover.Start())
+		defer cover.Close()
+		frames = cover
 	}
 
 	// Send request header as first frame
@@ -195,16 +269,43 @@ func (h *Handler) Process(ctx context.Context, link *transport.Link, dialer inte
 		Type:    encoding.FrameTypeData,
 		Payload: requestData,
 	}
-	if err := frameEncoder.WriteFrame(rawConn, firstFrame); err != nil {
+	if err := frames.WriteFrame(firstFrame); err != nil {
 		return errors.New("failed to send request").Base(err).AtError()
 	}
 
+	// Without cover traffic, hide at least a tunneled TLS handshake
+	if coverConfig == nil {
+		shaper := encoding.NewTLSShaper(frames, profile, h.timeSource)
+		defer shaper.Close()
+		frames = shaper
+	}
+
+	sessionPolicy := h.policyManager.ForLevel(h.user.Level)
+	timer := signal.CancelAfterInactivity(ctx, cancel, sessionPolicy.Timeouts.ConnectionIdle)
+
+	// Keepalive frames only prove that the server is reachable; they do not
+	// update the activity timer, so an idle session still times out.
+	keepAlive := encoding.NewKeepAlive(frames, profile, keepAliveInterval, func() {
+		errors.LogInfo(ctx, "server stopped answering keepalive, closing session")
+		cancel()
+	})
+	common.Must(keepAlive.Start())
+	defer keepAlive.Close()
+
 	// Transfer data
 	requestDone := func() error {
+		defer timer.SetTimeout(sessionPolicy.Timeouts.DownlinkOnly)
+
 		// Read from link and write as frames
 		for {
 			mb, err := link.Reader.ReadMultiBuffer()
 			if err != nil {
+				// The application finished its upload: half-close the session
+				// so the server can pass the end of stream on to the target
+				if errors.Cause(err) == io.EOF {
+					return frames.WriteFrame(encoding.NewCloseFrame(encoding.CloseReasonFin))
+				}
+				frames.WriteFrame(encoding.NewCloseFrame(encoding.CloseReasonRst))
 				return err
 			}
 
@@ -213,7 +314,7 @@ func (h *Handler) Process(ctx context.Context, link *transport.Link, dialer inte
 				frame.Type = encoding.FrameTypeData
 				frame.Payload = b.Bytes()
 
-				if err := frameEncoder.WriteFrame(rawConn, frame); err != nil {
+				if err := frames.WriteFrame(frame); err != nil {
 					encoding.PutFrame(frame)
 					buf.ReleaseMulti(mb)
 					return err
@@ -221,16 +322,29 @@ func (h *Handler) Process(ctx context.Context, link *transport.Link, dialer inte
 				encoding.PutFrame(frame)
 			}
 			buf.ReleaseMulti(mb)
+			timer.Update()
 		}
 	}
 
 	responseDone := func() error {
+		defer timer.SetTimeout(sessionPolicy.Timeouts.UplinkOnly)
+
 		// Read frames and write to link
+		authenticated := false
 		for {
-			frame, err := frameDecoder.ReadFrame(rawConn)
+			frame, err := frames.ReadFrame()
 			if err != nil {
 				return err
 			}
+			keepAlive.Alive()
+
+			// Only a response followed by a frame sealed with the session key
+			// comes from the server; correct later hellos by its clock then
+			if !authenticated {
+				authenticated = true
+				c := current()
+				h.learnClock(ctx, c.serverTimestamp, c.sent, c.received)
+			}
 
 			switch frame.Type {
 			case encoding.FrameTypeData:
@@ -240,10 +354,31 @@ func (h *Handler) Process(ctx context.Context, link *transport.Link, dialer inte
 					encoding.PutFrame(frame)
 					return err
 				}
+				timer.Update()
 				encoding.PutFrame(frame)
 			case encoding.FrameTypeClose:
+				reset := encoding.IsReset(frame)
 				encoding.PutFrame(frame)
+				if reset {
+					return errors.New("session reset by server")
+				}
 				return nil
+			case encoding.FrameTypePing:
+				encoding.PutFrame(frame)
+				if err := frames.WriteFrame(encoding.NewControlFrame(encoding.FrameTypePong, keepAlive.Profile())); err != nil {
+					return err
+				}
+			case encoding.FrameTypePong:
+				encoding.PutFrame(frame)
+			case encoding.FrameTypePolicy:
+				granted, err := encoding.DecodePolicyGrant(frame)
+				encoding.PutFrame(frame)
+				if err != nil {
+					return errors.New("invalid policy grant").Base(err).AtWarning()
+				}
+				profile.Update(granted)
+				current().encoder.CountBytes(metrics.Bytes(encoding.ProfileKey(profile)))
+				errors.LogDebug(ctx, "server granted profile ", encoding.ProfileKey(profile))
 			case encoding.FrameTypePadding, encoding.FrameTypeTiming:
 				// Control frames - ignore for now
 				encoding.PutFrame(frame)
@@ -256,13 +391,284 @@ func (h *Handler) Process(ctx context.Context, link *transport.Link, dialer inte
 	}
 
 	// Run both directions concurrently
-	if err := task.Run(ctx, requestDone, responseDone); err != nil {
+	responseDoneAndCloseWriter := task.OnSuccess(responseDone, task.Close(link.Writer))
+	if err := task.Run(ctx, requestDone, responseDoneAndCloseWriter); err != nil {
 		return errors.New("connection ends").Base(err).AtInfo()
 	}
 
 	return nil
 }
 
+// clientConn is a connection to the server on which the handshake is done.
+type clientConn struct {
+	conn stat.Connection
+	// reader reads what the server sends after its handshake response
+	reader     io.Reader
+	encoder    *encoding.FrameEncoder
+	decoder    *encoding.FrameDecoder
+	sessionKey []byte
+	// profile shapes the session, the server's grant updating it in place
+	profile *encoding.TrafficProfile
+
+	// serverTimestamp is the timestamp of the handshake response to the
+	// hello sent at sent, received at received
+	serverTimestamp int64
+	sent            time.Time
+	received        time.Time
+
+	// expire is when a pooled connection is no longer handed out
+	expire time.Time
+	// unwatch stops watching a pooled connection before it is handed out
+	unwatch func()
+}
+
+// handshake dials the server and performs the handshake for account, asking
+// for the profile of its policy.
+func (h *Handler) handshake(
+	ctx context.Context,
+	dialer internet.Dialer,
+	dest net.Destination,
+	account *reflex.MemoryAccount,
+	metrics *reflex.Metrics,
+) (*clientConn, error) {
+	start := time.Now()
+	conn, err := dialer.Dial(ctx, dest)
+	if err != nil {
+		return nil, errors.New("failed to dial reflex server").Base(err).AtError()
+	}
+	c, err := h.handshakeOn(conn, account, metrics, start)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// handshakeOn performs the handshake on conn, dialed at start.
+func (h *Handler) handshakeOn(conn stat.Connection, account *reflex.MemoryAccount, metrics *reflex.Metrics, start time.Time) (*clientConn, error) {
+	clientPrivateKey, clientPublicKey, err := encoding.GenerateKeyPair()
+	if err != nil {
+		return nil, errors.New("failed to generate key pair").Base(err).AtError()
+	}
+
+	userIDBytes := encoding.UUIDToBytes(account.ID)
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, errors.New("failed to generate nonce").Base(err).AtError()
+	}
+
+	clientHS := &encoding.ClientHandshake{
+		PublicKey: clientPublicKey,
+		UserID:    userIDBytes,
+		Timestamp: h.clock.Now().Unix(),
+		Nonce:     nonce,
+	}
+
+	// Send client handshake, padded to a packet size of our profile if
+	// asked to (otherwise use pooled buffer)
+	var handshakeData []byte
+	if h.config.PadHandshake {
+		padding := encoding.HandshakePadding(encoding.GetProfileByName(account.Policy), encoding.PaddedClientHandshakeHeaderSize)
+		if handshakeData, err = encoding.EncodePaddedClientHandshake(clientHS, padding); err != nil {
+			return nil, errors.New("failed to encode handshake").Base(err).AtError()
+		}
+	} else {
+		handshakeData = encoding.EncodeClientHandshake(clientHS)
+		defer encoding.PutClientHandshakeBuffer(handshakeData)
+	}
+	if err := writeSplit(conn, handshakeData, h.config.HelloSplit, encoding.OrSystemClock(h.timeSource)); err != nil {
+		return nil, errors.New("failed to send handshake").Base(err).AtError()
+	}
+	sent := time.Now()
+
+	// Read server handshake response. Message-based transports may deliver
+	// it in several reads.
+	var serverHS *encoding.ServerHandshake
+	salt := []byte("reflex-session-v1")
+	if h.config.PadHandshake {
+		var responseData []byte
+		if serverHS, responseData, err = encoding.ReadPaddedServerHandshake(conn); err != nil {
+			metrics.Handshake(reflex.HandshakeFailed)
+			return nil, errors.New("failed to read handshake response").Base(err).AtError()
+		}
+		salt = encoding.PaddedSessionSalt(handshakeData, responseData)
+	} else {
+		// Use pooled buffer
+		responseData := encoding.GetServerHandshakeBuffer()
+		defer encoding.PutServerHandshakeBuffer(responseData)
+		if _, err := io.ReadFull(conn, responseData); err != nil {
+			metrics.Handshake(reflex.HandshakeFailed)
+			return nil, errors.New("failed to read handshake response").Base(err).AtError()
+		}
+		if serverHS, err = encoding.DecodeServerHandshake(responseData); err != nil {
+			metrics.Handshake(reflex.HandshakeDecodeError)
+			return nil, errors.New("invalid server handshake").Base(err).AtError()
+		}
+	}
+	metrics.Handshake(reflex.HandshakeOK)
+	metrics.HandshakeLatency(time.Since(start))
+	received := time.Now()
+
+	// Derive session key
+	sharedKey := encoding.DeriveSharedKey(clientPrivateKey, serverHS.PublicKey)
+	sessionKey, err := encoding.DeriveSessionKey(sharedKey, salt)
+	if err != nil {
+		return nil, errors.New("failed to derive session key").Base(err).AtError()
+	}
+	h.keyLog.Log(clientPublicKey, sessionKey)
+
+	// Create frame encoder/decoder
+	frameEncoder, err := encoding.NewFrameEncoder(sessionKey)
+	if err != nil {
+		return nil, errors.New("failed to create frame encoder").Base(err).AtError()
+	}
+
+	// Shape with the profile of our policy until the server grants one. The
+	// grant updates this copy in place, so everything shaping the session
+	// follows it.
+	profile := encoding.GetProfileByName(account.Policy).Clone()
+	frameEncoder.CountBytes(metrics.Bytes(encoding.ProfileKey(profile)))
+
+	frameDecoder, err := encoding.NewFrameDecoder(sessionKey)
+	if err != nil {
+		return nil, errors.New("failed to create frame decoder").Base(err).AtError()
+	}
+
+	// Ask for the profile of our policy; the server may grant another one.
+	// The request is not held up waiting for the answer.
+	if err := frameEncoder.WriteFrame(conn, encoding.NewPolicyRequestFrame(account.Policy)); err != nil {
+		return nil, errors.New("failed to send policy request").Base(err).AtError()
+	}
+
+	return &clientConn{
+		conn:            conn,
+		reader:          conn,
+		encoder:         frameEncoder,
+		decoder:         frameDecoder,
+		sessionKey:      sessionKey,
+		profile:         profile,
+		serverTimestamp: serverHS.Timestamp,
+		sent:            sent,
+		received:        received,
+	}, nil
+}
+
+// writeSplit writes data in the number of writes of split, cut at random
+// offsets, waiting a random delay within its range on clock before each write
+// but the first. Without split, data is written at once.
+func writeSplit(w io.Writer, data []byte, split *HelloSplit, clock encoding.TimeSource) error {
+	count := min(int(split.GetCount()), len(data))
+	if count <= 1 {
+		_, err := w.Write(data)
+		return err
+	}
+
+	cuts := make([]int, 0, count+1)
+	cuts = append(cuts, 0)
+	for _, offset := range mrand.Perm(len(data) - 1)[:count-1] {
+		cuts = append(cuts, offset+1)
+	}
+	cuts = append(cuts, len(data))
+	slices.Sort(cuts)
+
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			delay := split.GetMinDelay()
+			if split.GetMaxDelay() > delay {
+				delay += uint32(mrand.Int63n(int64(split.GetMaxDelay() - delay + 1)))
+			}
+			<-clock.After(time.Duration(delay) * time.Millisecond)
+		}
+		if _, err := w.Write(data[cuts[i]:cuts[i+1]]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// learnClock corrects the timestamps of later hellos by the offset of the
+// server's clock, given the timestamp of its response to a hello sent at
+// sent and answered at received.
+func (h *Handler) learnClock(ctx context.Context, serverTimestamp int64, sent, received time.Time) {
+	h.clock.Learn(serverTimestamp, sent, received)
+	offset := h.clock.Offset()
+	if offset < 0 {
+		offset = -offset
+	}
+	if offset > encoding.TimestampTolerance*time.Second/2 {
+		errors.LogWarning(ctx, "local clock is ", offset, " off from the server's, correcting handshake timestamps")
+	}
+}
+
+// redial opens a new connection to the server and resumes the session with
+// the given secrets on it, given the number of frames received so far.
+func (h *Handler) redial(
+	ctx context.Context,
+	dialer internet.Dialer,
+	dest net.Destination,
+	secrets *resume.Secrets,
+	received uint64,
+	metrics *reflex.Metrics,
+	profile *encoding.TrafficProfile,
+) (*resume.Transport, uint64, error) {
+	start := time.Now()
+	conn, err := dialer.Dial(ctx, dest)
+	if err != nil {
+		return nil, 0, errors.New("failed to dial reflex server").Base(err)
+	}
+
+	hello := &resume.Hello{
+		ID:        secrets.ID,
+		Timestamp: h.clock.Now().Unix(),
+		Received:  received,
+	}
+	if _, err := rand.Read(hello.Nonce[:]); err != nil {
+		conn.Close()
+		return nil, 0, errors.New("failed to generate nonce").Base(err)
+	}
+	if _, err := conn.Write(hello.Encode(secrets)); err != nil {
+		conn.Close()
+		return nil, 0, errors.New("failed to send resume hello").Base(err)
+	}
+
+	// A server that no longer knows the session answers like it does to
+	// probes, so only wait for a reply as long as for a handshake
+	response := make([]byte, resume.ResponseSize)
+	conn.SetReadDeadline(time.Now().Add(h.policyManager.ForLevel(0).Timeouts.Handshake))
+	if _, err := io.ReadFull(conn, response); err != nil {
+		conn.Close()
+		metrics.Handshake(reflex.HandshakeFailed)
+		return nil, 0, errors.New("failed to read resume response").Base(err)
+	}
+	conn.SetReadDeadline(time.Time{})
+	serverReceived, err := secrets.DecodeResponse(hello.Nonce, response)
+	if err != nil {
+		conn.Close()
+		metrics.Handshake(reflex.HandshakeDecodeError)
+		return nil, 0, err
+	}
+
+	key, err := secrets.ConnectionKey(hello.Nonce)
+	if err != nil {
+		conn.Close()
+		return nil, 0, err
+	}
+	encoder, err := encoding.NewFrameEncoder(key)
+	if err != nil {
+		conn.Close()
+		return nil, 0, err
+	}
+	encoder.CountBytes(metrics.Bytes(encoding.ProfileKey(profile)))
+	decoder, err := encoding.NewFrameDecoder(key)
+	if err != nil {
+		conn.Close()
+		return nil, 0, err
+	}
+	metrics.Handshake(reflex.HandshakeResumed)
+	metrics.HandshakeLatency(time.Since(start))
+	return resume.NewTransport(conn, conn, encoder, decoder), serverReceived, nil
+}
+
 // encodeRequestHeader encodes request header to bytes
 // Format: [command(1)] + [port(2)] + [addrType(1)] + [address]
 func encodeRequestHeader(request *protocol.RequestHeader) []byte {
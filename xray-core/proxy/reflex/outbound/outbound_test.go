@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/xtls/xray-core/proxy/reflex/encoding"
 )
@@ -82,3 +83,55 @@ func TestConcurrentFrameEncodingDecoding(t *testing.T) {
 		t.Errorf("expected %d decoded frames, got %d", expectedFrames, len(decodedFrames))
 	}
 }
+
+// recordingWriter records the writes made to it
+type recordingWriter struct {
+	writes [][]byte
+	times  []time.Time
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.writes = append(w.writes, bytes.Clone(b))
+	w.times = append(w.times, time.Now())
+	return len(b), nil
+}
+
+// TestWriteSplit tests that the hello is split into the configured number of
+// non-empty writes, spaced by the configured delays
+func TestWriteSplit(t *testing.T) {
+	data := make([]byte, 200)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	for _, c := range []struct {
+		split  *HelloSplit
+		writes int
+	}{
+		{nil, 1},
+		{&HelloSplit{Count: 1}, 1},
+		{&HelloSplit{Count: 4, MinDelay: 5, MaxDelay: 10}, 4},
+		{&HelloSplit{Count: 500}, len(data)},
+	} {
+		w := &recordingWriter{}
+		if err := writeSplit(w, data, c.split, encoding.OrSystemClock(nil)); err != nil {
+			t.Fatal(err)
+		}
+		if len(w.writes) != c.writes {
+			t.Fatalf("split %v: expected %d writes, got %d", c.split, c.writes, len(w.writes))
+		}
+		if joined := bytes.Join(w.writes, nil); !bytes.Equal(joined, data) {
+			t.Fatalf("split %v: writes do not add up to the data", c.split)
+		}
+		for i, write := range w.writes {
+			if len(write) == 0 {
+				t.Fatalf("split %v: write %d is empty", c.split, i)
+			}
+			if i > 0 && c.split.GetMinDelay() > 0 {
+				if gap := w.times[i].Sub(w.times[i-1]); gap < time.Duration(c.split.GetMinDelay())*time.Millisecond {
+					t.Fatalf("split %v: write %d after %s only", c.split, i, gap)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,370 @@
+package outbound
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/xtls/xray-core/common/errors"
+	"github.com/xtls/xray-core/common/signal"
+	"github.com/xtls/xray-core/common/signal/done"
+	"github.com/xtls/xray-core/proxy/reflex/encoding"
+)
+
+const (
+	// defaultPoolMaxAge is how long an idle connection stays in the pool
+	// unless configured otherwise
+	defaultPoolMaxAge = 60 * time.Second
+
+	// Failed dials back off from poolMinBackoff, doubling up to
+	// poolMaxBackoff
+	poolMinBackoff = 200 * time.Millisecond
+	poolMaxBackoff = 30 * time.Second
+)
+
+// connPool keeps idle connections to the server on which the handshake is
+// done already, so that a request only has to send its request header.
+//
+// Each pooled connection has sent a hello of its own, which the server
+// checked for replay and timestamp when it arrived; connections are only
+// handed out for maxAge, before the server or a middlebox gives up on them.
+type connPool struct {
+	ctx    context.Context
+	size   int
+	maxAge time.Duration
+	dial   func() (*clientConn, error)
+
+	access sync.Mutex
+	// idle connections, from the oldest to the youngest
+	idle []*clientConn
+
+	wake *signal.Notifier
+	done *done.Instance
+}
+
+// newConnPool creates a pool of size connections made by dial, and starts
+// filling it.
+func newConnPool(ctx context.Context, size int, maxAge time.Duration, dial func() (*clientConn, error)) *connPool {
+	if maxAge <= 0 {
+		maxAge = defaultPoolMaxAge
+	}
+	p := &connPool{
+		ctx:    ctx,
+		size:   size,
+		maxAge: maxAge,
+		dial:   dial,
+		wake:   signal.NewNotifier(),
+		done:   done.New(),
+	}
+	go p.run()
+	return p
+}
+
+// Get takes the youngest idle connection out of the pool, or returns nil if
+// the pool is empty.
+func (p *connPool) Get() *clientConn {
+	c := p.take()
+	if c != nil {
+		c.unwatch()
+	}
+	return c
+}
+
+// take removes the youngest idle connection from the pool, or returns nil if
+// the pool is empty.
+func (p *connPool) take() *clientConn {
+	p.access.Lock()
+	defer p.access.Unlock()
+
+	p.expire(time.Now())
+	if len(p.idle) == 0 {
+		return nil
+	}
+	c := p.idle[len(p.idle)-1]
+	p.idle = p.idle[:len(p.idle)-1]
+	p.wake.Signal()
+	return c
+}
+
+// Len returns the number of idle connections.
+func (p *connPool) Len() int {
+	p.access.Lock()
+	defer p.access.Unlock()
+	return len(p.idle)
+}
+
+// Close closes the idle connections and stops filling the pool.
+func (p *connPool) Close() error {
+	p.access.Lock()
+	defer p.access.Unlock()
+
+	p.done.Close()
+	for _, c := range p.idle {
+		c.conn.Close()
+	}
+	p.idle = nil
+	return nil
+}
+
+// run keeps the pool full until it is closed.
+func (p *connPool) run() {
+	var backoff time.Duration
+	for {
+		if p.missing() > 0 {
+			c, err := p.dial()
+			if err != nil {
+				backoff = min(max(2*backoff, poolMinBackoff), poolMaxBackoff)
+				errors.LogInfoInner(p.ctx, err, "failed to fill connection pool, retrying in ", backoff)
+				select {
+				case <-time.After(backoff):
+				case <-p.done.Wait():
+					return
+				}
+				continue
+			}
+			backoff = 0
+			if !p.put(c) {
+				c.conn.Close()
+				return
+			}
+			continue
+		}
+
+		select {
+		case <-p.wake.Wait():
+		case <-time.After(p.nextExpiry()):
+		case <-p.done.Wait():
+			return
+		}
+	}
+}
+
+// missing returns the number of connections the pool lacks, after dropping
+// the expired ones.
+func (p *connPool) missing() int {
+	p.access.Lock()
+	defer p.access.Unlock()
+
+	if p.done.Done() {
+		return 0
+	}
+	p.expire(time.Now())
+	return p.size - len(p.idle)
+}
+
+// nextExpiry returns how long until the oldest idle connection expires.
+func (p *connPool) nextExpiry() time.Duration {
+	p.access.Lock()
+	defer p.access.Unlock()
+
+	if len(p.idle) == 0 {
+		return p.maxAge
+	}
+	return max(time.Until(p.idle[0].expire), 0)
+}
+
+// expire closes and drops the idle connections that are too old to be handed
+// out. It must be called with access held.
+func (p *connPool) expire(now time.Time) {
+	n := 0
+	for n < len(p.idle) && !now.Before(p.idle[n].expire) {
+		p.idle[n].conn.Close()
+		n++
+	}
+	p.idle = p.idle[n:]
+}
+
+// put adds a new connection to the pool, watching it for errors while it is
+// idle. It returns false if the pool is closed.
+func (p *connPool) put(c *clientConn) bool {
+	p.access.Lock()
+	defer p.access.Unlock()
+
+	if p.done.Done() {
+		return false
+	}
+	c.expire = time.Now().Add(p.maxAge)
+	p.watch(c)
+	p.idle = append(p.idle, c)
+	return true
+}
+
+// watch peeks at c in the background while it is idle, so that a connection
+// the server or the network closes leaves the pool at once. What the server
+// sends is kept for the session that takes the connection, up to the size of
+// the read buffer; past it, the watcher stops and lets TCP push back.
+func (p *connPool) watch(c *clientConn) {
+	reader := bufio.NewReader(c.reader)
+	c.reader = reader
+	stopped := done.New()
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		for n := 1; n <= reader.Size(); n = reader.Buffered() + 1 {
+			_, err := reader.Peek(n)
+			if err == nil {
+				continue
+			}
+			if !stopped.Done() && p.remove(c) {
+				errors.LogDebugInner(p.ctx, err, "idle pooled connection closed")
+				c.conn.Close()
+				p.wake.Signal()
+			}
+			return
+		}
+	}()
+
+	// The watcher is woken up by an expired read deadline, which the peek
+	// returns once and leaves the reader usable
+	c.unwatch = func() {
+		stopped.Close()
+		c.conn.SetReadDeadline(time.Now())
+		<-exited
+		c.conn.SetReadDeadline(time.Time{})
+	}
+}
+
+// remove drops c from the idle connections, returning false if it is not
+// one of them.
+func (p *connPool) remove(c *clientConn) bool {
+	p.access.Lock()
+	defer p.access.Unlock()
+
+	for i, idle := range p.idle {
+		if idle == c {
+			p.idle = append(p.idle[:i], p.idle[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// maxReplay bounds the frames a session on a pooled connection keeps to write
+// again on a fresh connection.
+const maxReplay = 64 * 1024
+
+// pooledFrames runs a session on a connection taken from the pool. The server
+// may close an idle connection just as the pool hands it out, which only
+// shows once the session writes to it or reads from it; until the first frame
+// from the server arrives, the frames written are kept, and if the
+// connection fails, the session moves once to a fresh connection made by dial
+// and writes them there again.
+type pooledFrames struct {
+	ctx  context.Context
+	dial func() (*clientConn, error)
+
+	access sync.Mutex
+	conn   *clientConn
+	frames encoding.FrameConn
+	// gen changes when the session moves to a fresh connection, so that a
+	// read from the old one fails without a second move
+	gen int
+	// kept holds the frames written so far, nil once the server is heard
+	// from, the frames outgrow maxReplay or the session has moved
+	kept []*encoding.Frame
+	size int
+}
+
+func newPooledFrames(ctx context.Context, c *clientConn, dial func() (*clientConn, error)) *pooledFrames {
+	return &pooledFrames{
+		ctx:    ctx,
+		dial:   dial,
+		conn:   c,
+		frames: encoding.NewFrameConn(c.encoder, c.conn, c.decoder, c.reader),
+		kept:   []*encoding.Frame{},
+	}
+}
+
+// Conn returns the connection the session currently runs on.
+func (p *pooledFrames) Conn() *clientConn {
+	p.access.Lock()
+	defer p.access.Unlock()
+	return p.conn
+}
+
+// WriteFrame writes a frame, moving to a fresh connection if it fails before
+// the server is heard from.
+func (p *pooledFrames) WriteFrame(frame *encoding.Frame) error {
+	p.access.Lock()
+	defer p.access.Unlock()
+
+	if p.kept != nil {
+		if p.size += len(frame.Payload); p.size <= maxReplay {
+			p.kept = append(p.kept, &encoding.Frame{
+				Type:    frame.Type,
+				Payload: bytes.Clone(frame.Payload),
+				Padding: frame.Padding,
+			})
+		} else {
+			p.kept = nil
+		}
+	}
+	err := p.frames.WriteFrame(frame)
+	if err != nil && p.kept != nil {
+		err = p.moveLocked(err)
+	}
+	return err
+}
+
+// ReadFrame reads a frame, moving to a fresh connection if the first read
+// fails.
+func (p *pooledFrames) ReadFrame() (*encoding.Frame, error) {
+	for {
+		p.access.Lock()
+		frames, gen := p.frames, p.gen
+		p.access.Unlock()
+
+		frame, err := frames.ReadFrame()
+
+		p.access.Lock()
+		if err == nil {
+			p.kept = nil
+			p.access.Unlock()
+			return frame, nil
+		}
+		if gen != p.gen {
+			// A write has moved the session meanwhile
+			p.access.Unlock()
+			continue
+		}
+		if p.kept != nil {
+			if err = p.moveLocked(err); err == nil {
+				p.access.Unlock()
+				continue
+			}
+		}
+		p.access.Unlock()
+		return nil, err
+	}
+}
+
+// Close closes the current connection.
+func (p *pooledFrames) Close() error {
+	return p.Conn().conn.Close()
+}
+
+// moveLocked moves the session to a fresh connection after the pooled one
+// failed with cause, writing the kept frames there again. It must be called
+// with access held.
+func (p *pooledFrames) moveLocked(cause error) error {
+	kept := p.kept
+	p.kept = nil
+	p.conn.conn.Close()
+	errors.LogInfoInner(p.ctx, cause, "pooled connection failed, retrying on a new one")
+
+	c, err := p.dial()
+	if err != nil {
+		return errors.New("failed to retry on a new connection").Base(err)
+	}
+	p.conn = c
+	p.frames = encoding.NewFrameConn(c.encoder, c.conn, c.decoder, c.reader)
+	p.gen++
+	for _, frame := range kept {
+		if err := p.frames.WriteFrame(frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package outbound
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/proxy/reflex/encoding"
+)
+
+// pipeDialer hands out one end of in-memory connections, keeping the other
+// ends as the server's
+type pipeDialer struct {
+	access  sync.Mutex
+	servers []net.Conn
+}
+
+func (d *pipeDialer) dial() (*clientConn, error) {
+	client, server := net.Pipe()
+	d.access.Lock()
+	d.servers = append(d.servers, server)
+	d.access.Unlock()
+	return &clientConn{conn: client, reader: client}, nil
+}
+
+func (d *pipeDialer) server(i int) net.Conn {
+	d.access.Lock()
+	defer d.access.Unlock()
+	return d.servers[i]
+}
+
+func (d *pipeDialer) dials() int {
+	d.access.Lock()
+	defer d.access.Unlock()
+	return len(d.servers)
+}
+
+// waitFor polls cond until it holds, failing the test after a second
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for ", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestConnPoolRefill tests that taking a connection refills the pool, and
+// that what the server sends while the connection is idle is kept for the
+// session that takes it
+func TestConnPoolRefill(t *testing.T) {
+	dialer := &pipeDialer{}
+	pool := newConnPool(context.Background(), 2, time.Minute, dialer.dial)
+	defer pool.Close()
+
+	waitFor(t, "the pool to fill", func() bool { return pool.Len() == 2 })
+
+	// The youngest connection is handed out first
+	go dialer.server(1).Write([]byte("policy grant"))
+	c := pool.Get()
+	if c == nil {
+		t.Fatal("expected an idle connection")
+	}
+	defer c.conn.Close()
+	received := make([]byte, len("policy grant"))
+	if _, err := io.ReadFull(c.reader, received); err != nil || string(received) != "policy grant" {
+		t.Fatalf("read %q from a pooled connection: %v", received, err)
+	}
+
+	waitFor(t, "the pool to refill", func() bool { return pool.Len() == 2 && dialer.dials() == 3 })
+}
+
+// TestConnPoolDropsClosed tests that a connection the server closes while
+// idle leaves the pool and is replaced
+func TestConnPoolDropsClosed(t *testing.T) {
+	dialer := &pipeDialer{}
+	pool := newConnPool(context.Background(), 1, time.Minute, dialer.dial)
+	defer pool.Close()
+
+	waitFor(t, "the pool to fill", func() bool { return pool.Len() == 1 })
+	dialer.server(0).Close()
+	waitFor(t, "the closed connection to be replaced", func() bool { return dialer.dials() == 2 && pool.Len() == 1 })
+}
+
+// TestConnPoolExpires tests that connections are not handed out past their
+// max age
+func TestConnPoolExpires(t *testing.T) {
+	dialer := &pipeDialer{}
+	pool := newConnPool(context.Background(), 1, 50*time.Millisecond, dialer.dial)
+	defer pool.Close()
+
+	waitFor(t, "the pool to fill", func() bool { return pool.Len() == 1 })
+	waitFor(t, "the expired connection to be replaced", func() bool { return dialer.dials() >= 2 })
+
+	// The expired connection is closed
+	if _, err := dialer.server(0).Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected the expired connection to be closed, got %v", err)
+	}
+}
+
+// TestConnPoolClose tests that closing the pool closes its idle connections
+// and stops filling it
+func TestConnPoolClose(t *testing.T) {
+	dialer := &pipeDialer{}
+	pool := newConnPool(context.Background(), 3, time.Minute, dialer.dial)
+	waitFor(t, "the pool to fill", func() bool { return pool.Len() == 3 })
+
+	pool.Close()
+	if c := pool.Get(); c != nil {
+		t.Fatal("expected a closed pool to hand out nothing")
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := dialer.server(i).Read(make([]byte, 1)); err != io.EOF {
+			t.Fatalf("expected idle connection %d to be closed, got %v", i, err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	if dialer.dials() != 3 {
+		t.Fatalf("closed pool dialed %d connections", dialer.dials()-3)
+	}
+}
+
+// TestConnPoolBackpressure tests that what the server sends is not read into
+// memory without bound, whether the connection is idle or taken by a slow
+// session
+func TestConnPoolBackpressure(t *testing.T) {
+	dialer := &pipeDialer{}
+	pool := newConnPool(context.Background(), 1, time.Minute, dialer.dial)
+	defer pool.Close()
+
+	waitFor(t, "the pool to fill", func() bool { return pool.Len() == 1 })
+
+	sent := make([]byte, 1<<20)
+	rand.Read(sent)
+	written := make(chan error, 1)
+	go func() {
+		_, err := dialer.server(0).Write(sent)
+		written <- err
+	}()
+
+	// Nothing reads the connection once the watcher's buffer is full
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case err := <-written:
+		t.Fatalf("idle connection accepted %d bytes: %v", len(sent), err)
+	default:
+	}
+
+	c := pool.Get()
+	if c == nil {
+		t.Fatal("expected an idle connection")
+	}
+	defer c.conn.Close()
+
+	received := make([]byte, 0, len(sent))
+	chunk := make([]byte, 64<<10)
+	for len(received) < len(sent) {
+		select {
+		case err := <-written:
+			t.Fatalf("server wrote %d bytes ahead of a session that read %d: %v", len(sent), len(received), err)
+		default:
+		}
+		n, err := c.reader.Read(chunk)
+		if err != nil {
+			t.Fatal("failed to read from a pooled connection: ", err)
+		}
+		received = append(received, chunk[:n]...)
+		time.Sleep(time.Millisecond)
+	}
+	if !bytes.Equal(received, sent) {
+		t.Fatal("pooled connection corrupted what the server sent")
+	}
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// framesDialer makes connections like pipeDialer, with frame encoders and
+// decoders on a fixed key
+func framesDialer(t *testing.T, d *pipeDialer) func() (*clientConn, error) {
+	return func() (*clientConn, error) {
+		c, _ := d.dial()
+		c.encoder, c.decoder = testFrameCodec(t)
+		return c, nil
+	}
+}
+
+func testFrameCodec(t *testing.T) (*encoding.FrameEncoder, *encoding.FrameDecoder) {
+	key := make([]byte, 32)
+	encoder, err := encoding.NewFrameEncoder(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoder, err := encoding.NewFrameDecoder(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encoder, decoder
+}
+
+// TestPooledFramesRetry tests that a session whose pooled connection fails
+// before the server answers moves to a fresh connection and sends its frames
+// again there, whether the failure shows on a write or on the first read
+func TestPooledFramesRetry(t *testing.T) {
+	for _, failOn := range []string{"write", "read"} {
+		d := &pipeDialer{}
+		dial := framesDialer(t, d)
+		c, _ := dial()
+		frames := newPooledFrames(context.Background(), c, dial)
+
+		// The server closes the pooled connection before or just after the
+		// request arrives, and answers it on the fresh one
+		if failOn == "write" {
+			d.server(0).Close()
+		} else {
+			go func() {
+				d.server(0).Read(make([]byte, 64))
+				d.server(0).Close()
+			}()
+		}
+		answered := make(chan error, 1)
+		go func() {
+			waitFor(t, "a fresh connection", func() bool { return d.dials() == 2 })
+			encoder, decoder := testFrameCodec(t)
+			request, err := decoder.ReadFrame(d.server(1))
+			if err == nil && string(request.Payload) != "request" {
+				err = io.ErrUnexpectedEOF
+			}
+			if err == nil {
+				err = encoder.WriteFrame(d.server(1), &encoding.Frame{Type: encoding.FrameTypeData, Payload: []byte("response")})
+			}
+			answered <- err
+		}()
+
+		if err := frames.WriteFrame(&encoding.Frame{Type: encoding.FrameTypeData, Payload: []byte("request")}); err != nil {
+			t.Fatalf("%s: %v", failOn, err)
+		}
+		response, err := frames.ReadFrame()
+		if err != nil || string(response.Payload) != "response" {
+			t.Fatalf("%s: unexpected response %v: %v", failOn, response, err)
+		}
+		if err := <-answered; err != nil {
+			t.Fatalf("%s: %v", failOn, err)
+		}
+		if frames.Conn() == c {
+			t.Errorf("%s: session stayed on the failed connection", failOn)
+		}
+		frames.Close()
+	}
+}
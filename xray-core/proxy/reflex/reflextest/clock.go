@@ -0,0 +1,104 @@
+package reflextest
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is a virtual clock for the cover traffic, TLS shaping, hello splitting
+// and resumption back-off of Reflex sessions: its time only moves when the
+// test advances it, so that the delays drawn from traffic profiles can be
+// asserted without sleeping.
+type Clock struct {
+	access sync.Mutex
+	now    time.Time
+	timers []*timer
+	armed  chan struct{}
+}
+
+type timer struct {
+	at time.Time
+	c  chan time.Time
+}
+
+// NewClock returns a clock set to a fixed time.
+func NewClock() *Clock {
+	return &Clock{
+		now:   time.Unix(1700000000, 0),
+		armed: make(chan struct{}),
+	}
+}
+
+// Now returns the virtual time.
+func (c *Clock) Now() time.Time {
+	c.access.Lock()
+	defer c.access.Unlock()
+	return c.now
+}
+
+// After returns a channel that receives the virtual time once it has moved
+// d past the current time.
+func (c *Clock) After(d time.Duration) <-chan time.Time {
+	c.access.Lock()
+	defer c.access.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.timers = append(c.timers, &timer{at: c.now.Add(d), c: ch})
+	close(c.armed)
+	c.armed = make(chan struct{})
+	return ch
+}
+
+// Advance moves the time forward by d, firing the timers that expire on the
+// way in order.
+func (c *Clock) Advance(d time.Duration) {
+	c.access.Lock()
+	defer c.access.Unlock()
+	c.now = c.now.Add(d)
+	sort.SliceStable(c.timers, func(i, j int) bool { return c.timers[i].at.Before(c.timers[j].at) })
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			pending = append(pending, t)
+		} else {
+			t.c <- t.at
+		}
+	}
+	c.timers = pending
+}
+
+// Pending returns the delays until each armed timer fires, shortest first.
+func (c *Clock) Pending() []time.Duration {
+	c.access.Lock()
+	defer c.access.Unlock()
+	delays := make([]time.Duration, 0, len(c.timers))
+	for _, t := range c.timers {
+		delays = append(delays, t.at.Sub(c.now))
+	}
+	sort.Slice(delays, func(i, j int) bool { return delays[i] < delays[j] })
+	return delays
+}
+
+// WaitTimers waits until at least n timers are armed, so that advancing the
+// clock is seen by them, and reports whether they were within timeout of
+// real time.
+func (c *Clock) WaitTimers(n int, timeout time.Duration) bool {
+	deadline := time.After(timeout)
+	for {
+		c.access.Lock()
+		armed, changed := len(c.timers), c.armed
+		c.access.Unlock()
+		if armed >= n {
+			return true
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			return false
+		}
+	}
+}
@@ -0,0 +1,451 @@
+package reflextest
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/common/errors"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/transport/internet"
+	"github.com/xtls/xray-core/transport/internet/stat"
+)
+
+// protocolName is the transport of the in-memory network, as named in the
+// stream settings of inbounds and outbounds.
+const protocolName = "reflextest"
+
+// maxBuffered is the number of bytes written to a connection and not read
+// yet after which writes block, as the buffers of a TCP connection fill up.
+const maxBuffered = 256 * 1024
+
+func init() {
+	common.Must(internet.RegisterProtocolConfigCreator(protocolName, func() interface{} {
+		return new(transportConfig)
+	}))
+	common.Must(internet.RegisterTransportDialer(protocolName, dialTransport))
+	common.Must(internet.RegisterTransportListener(protocolName, listenTransport))
+	internet.UseAlternativeSystemDialer(&systemDialer{&internet.DefaultSystemDialer{}})
+}
+
+// transportConfig is the settings of the transport, which has none.
+type transportConfig struct{}
+
+// StreamSettings returns the stream settings of an inbound or outbound that
+// listens or dials on the in-memory network. The transport has no security
+// layer.
+func StreamSettings() *internet.StreamConfig {
+	return &internet.StreamConfig{ProtocolName: protocolName}
+}
+
+// network is the in-memory network of all harnesses. Each harness uses hosts
+// of its own, so that tests may run in parallel.
+var network = &memoryNetwork{
+	listeners: make(map[string]*Listener),
+	nextHost:  1,
+	nextPort:  32768,
+}
+
+type memoryNetwork struct {
+	access    sync.Mutex
+	listeners map[string]*Listener
+	nextHost  uint32
+	nextPort  uint16
+}
+
+// newHost returns an address of the network no harness uses yet.
+func (n *memoryNetwork) newHost() net.IP {
+	n.access.Lock()
+	defer n.access.Unlock()
+	host := n.nextHost
+	n.nextHost++
+	return net.IPv4(10, byte(host>>16), byte(host>>8), byte(host))
+}
+
+// ephemeralPort returns the port of the next connection dialed.
+func (n *memoryNetwork) ephemeralPort() int {
+	n.access.Lock()
+	defer n.access.Unlock()
+	port := n.nextPort
+	if n.nextPort++; n.nextPort == 0 {
+		n.nextPort = 32768
+	}
+	return int(port)
+}
+
+// Listen listens on address, a host and port such as "10.0.0.1:443", of the
+// in-memory network.
+func Listen(address string) (*Listener, error) {
+	addr, err := resolve(address)
+	if err != nil {
+		return nil, err
+	}
+	network.access.Lock()
+	defer network.access.Unlock()
+	if _, found := network.listeners[addr.String()]; found {
+		return nil, &net.OpError{Op: "listen", Net: "tcp", Addr: addr, Err: syscall.EADDRINUSE}
+	}
+	l := &Listener{
+		addr:    addr,
+		backlog: make(chan *Conn, 128),
+		closed:  make(chan struct{}),
+	}
+	network.listeners[addr.String()] = l
+	return l, nil
+}
+
+// Dial connects to address on the in-memory network. The connection is
+// refused if nothing listens there.
+func Dial(ctx context.Context, address string) (*Conn, error) {
+	addr, err := resolve(address)
+	if err != nil {
+		return nil, err
+	}
+	network.access.Lock()
+	l := network.listeners[addr.String()]
+	network.access.Unlock()
+	refused := &net.OpError{Op: "dial", Net: "tcp", Addr: addr, Err: syscall.ECONNREFUSED}
+	if l == nil {
+		return nil, refused
+	}
+
+	local := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: network.ephemeralPort()}
+	toServer, toClient := newPipe(), newPipe()
+	client := newConn(toClient, toServer, local, addr)
+	server := newConn(toServer, toClient, addr, local)
+	select {
+	case l.backlog <- server:
+		return client, nil
+	case <-l.closed:
+		return nil, refused
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func resolve(address string) (*net.TCPAddr, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, errors.New("not an IP address: ", host)
+	}
+	number, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, errors.New("invalid port ", port).Base(err)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(number)}, nil
+}
+
+func dialTransport(ctx context.Context, dest xnet.Destination, _ *internet.MemoryStreamConfig) (stat.Connection, error) {
+	return Dial(ctx, dest.NetAddr())
+}
+
+func listenTransport(ctx context.Context, address xnet.Address, port xnet.Port, _ *internet.MemoryStreamConfig, handler internet.ConnHandler) (internet.Listener, error) {
+	l, err := Listen(net.JoinHostPort(address.String(), port.String()))
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			handler(conn)
+		}
+	}()
+	return l, nil
+}
+
+// systemDialer connects to the listeners of the in-memory network, such as
+// the fallbacks of Reflex servers, which are dialed with the system dialer.
+// Other destinations are dialed on the network of the host.
+type systemDialer struct {
+	*internet.DefaultSystemDialer
+}
+
+func (d *systemDialer) Dial(ctx context.Context, source xnet.Address, destination xnet.Destination, sockopt *internet.SocketConfig) (net.Conn, error) {
+	if destination.Network == xnet.Network_TCP && destination.Address.Family().IsIP() {
+		network.access.Lock()
+		l := network.listeners[destination.NetAddr()]
+		network.access.Unlock()
+		if l != nil {
+			return Dial(ctx, destination.NetAddr())
+		}
+	}
+	return d.DefaultSystemDialer.Dial(ctx, source, destination, sockopt)
+}
+
+// Listener is a listener of the in-memory network.
+type Listener struct {
+	addr    *net.TCPAddr
+	backlog chan *Conn
+	closed  chan struct{}
+	once    sync.Once
+}
+
+// Accept waits for the next connection.
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.backlog:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops listening. Connections dialed from then on are refused.
+func (l *Listener) Close() error {
+	l.once.Do(func() {
+		network.access.Lock()
+		delete(network.listeners, l.addr.String())
+		network.access.Unlock()
+		close(l.closed)
+	})
+	return nil
+}
+
+// Addr returns the address listened on.
+func (l *Listener) Addr() net.Addr {
+	return l.addr
+}
+
+// pipe carries the bytes written by one end of a connection to the other.
+type pipe struct {
+	access sync.Mutex
+	data   []byte
+	// eof is set once the writer closed its side, and broken once the
+	// reader closed the connection
+	eof     bool
+	broken  bool
+	changed chan struct{}
+}
+
+func newPipe() *pipe {
+	return &pipe{changed: make(chan struct{})}
+}
+
+// signalLocked wakes up the reader and writer waiting for a change.
+func (p *pipe) signalLocked() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+func (p *pipe) closeWrite() {
+	p.access.Lock()
+	defer p.access.Unlock()
+	p.eof = true
+	p.signalLocked()
+}
+
+func (p *pipe) closeRead() {
+	p.access.Lock()
+	defer p.access.Unlock()
+	p.broken = true
+	p.data = nil
+	p.signalLocked()
+}
+
+// Conn is a connection of the in-memory network. Its writes are buffered as
+// those of a TCP connection, and it can be half-closed.
+type Conn struct {
+	in, out       *pipe
+	local, remote *net.TCPAddr
+	readDeadline  *deadline
+	writeDeadline *deadline
+	closed        chan struct{}
+	once          sync.Once
+}
+
+func newConn(in, out *pipe, local, remote *net.TCPAddr) *Conn {
+	return &Conn{
+		in:            in,
+		out:           out,
+		local:         local,
+		remote:        remote,
+		readDeadline:  newDeadline(),
+		writeDeadline: newDeadline(),
+		closed:        make(chan struct{}),
+	}
+}
+
+func (c *Conn) isClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	p := c.in
+	for {
+		if c.isClosed() {
+			return 0, net.ErrClosed
+		}
+		expired := c.readDeadline.wait()
+		select {
+		case <-expired:
+			return 0, os.ErrDeadlineExceeded
+		default:
+		}
+
+		p.access.Lock()
+		if len(p.data) > 0 {
+			n := copy(b, p.data)
+			p.data = p.data[n:]
+			p.signalLocked()
+			p.access.Unlock()
+			return n, nil
+		}
+		if p.eof {
+			p.access.Unlock()
+			return 0, io.EOF
+		}
+		changed := p.changed
+		p.access.Unlock()
+
+		select {
+		case <-changed:
+		case <-expired:
+		case <-c.closed:
+		}
+	}
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	p := c.out
+	written := 0
+	for {
+		if c.isClosed() {
+			return written, net.ErrClosed
+		}
+		expired := c.writeDeadline.wait()
+		select {
+		case <-expired:
+			return written, os.ErrDeadlineExceeded
+		default:
+		}
+
+		p.access.Lock()
+		if p.eof {
+			p.access.Unlock()
+			return written, net.ErrClosed
+		}
+		if p.broken {
+			p.access.Unlock()
+			return written, syscall.EPIPE
+		}
+		if n := min(len(b)-written, maxBuffered-len(p.data)); n > 0 {
+			p.data = append(p.data, b[written:written+n]...)
+			written += n
+			p.signalLocked()
+		}
+		if written == len(b) {
+			p.access.Unlock()
+			return written, nil
+		}
+		changed := p.changed
+		p.access.Unlock()
+
+		select {
+		case <-changed:
+		case <-expired:
+		case <-c.closed:
+		}
+	}
+}
+
+// CloseWrite shuts down the writing side: the other end reads to EOF.
+func (c *Conn) CloseWrite() error {
+	c.out.closeWrite()
+	return nil
+}
+
+// Close closes both sides. Bytes written to the connection and not read yet
+// are dropped, and the writes of the other end fail.
+func (c *Conn) Close() error {
+	c.once.Do(func() {
+		close(c.closed)
+		c.in.closeRead()
+		c.out.closeWrite()
+	})
+	return nil
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	c.writeDeadline.set(t)
+	return nil
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.set(t)
+	return nil
+}
+
+// deadline is a deadline of a connection: its channel is closed once it
+// has passed.
+type deadline struct {
+	access  sync.Mutex
+	timer   *time.Timer
+	expired chan struct{}
+}
+
+func newDeadline() *deadline {
+	return &deadline{expired: make(chan struct{})}
+}
+
+func (d *deadline) set(t time.Time) {
+	d.access.Lock()
+	defer d.access.Unlock()
+
+	if d.timer != nil && !d.timer.Stop() {
+		// The timer fired, wait for it to close the channel
+		<-d.expired
+	}
+	d.timer = nil
+	select {
+	case <-d.expired:
+		d.expired = make(chan struct{})
+	default:
+	}
+	if t.IsZero() {
+		return
+	}
+	if wait := time.Until(t); wait > 0 {
+		expired := d.expired
+		d.timer = time.AfterFunc(wait, func() { close(expired) })
+		return
+	}
+	close(d.expired)
+}
+
+func (d *deadline) wait() chan struct{} {
+	d.access.Lock()
+	defer d.access.Unlock()
+	return d.expired
+}
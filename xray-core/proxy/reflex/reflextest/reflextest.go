@@ -0,0 +1,303 @@
+// Package reflextest runs a Reflex client and server for tests, without
+// touching the network of the host.
+//
+// A Harness starts a client and a server core.Instance in the process. The
+// client takes connections on a dokodemo-door inbound and tunnels them over
+// Reflex to the server, whose freedom outbound connects them to an echo
+// target. A web server stands behind the server as its fallback. All of them
+// talk over an in-memory network registered as the "reflextest" transport,
+// whose connections are buffered and half-closable as TCP ones are.
+//
+// The cover traffic and TLS shaping of both ends can run on a virtual Clock,
+// so that the delays of traffic profiles are asserted without sleeping.
+package reflextest
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/app/dispatcher"
+	"github.com/xtls/xray-core/app/log"
+	"github.com/xtls/xray-core/app/proxyman"
+	_ "github.com/xtls/xray-core/app/proxyman/inbound"
+	_ "github.com/xtls/xray-core/app/proxyman/outbound"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/protocol"
+	"github.com/xtls/xray-core/common/serial"
+	"github.com/xtls/xray-core/common/uuid"
+	"github.com/xtls/xray-core/core"
+	"github.com/xtls/xray-core/proxy/dokodemo"
+	"github.com/xtls/xray-core/proxy/freedom"
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/encoding"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+	"github.com/xtls/xray-core/proxy/reflex/outbound"
+)
+
+// Ports of the hosts of a harness.
+const (
+	ServerPort = 443
+	ClientPort = 1080
+	EchoPort   = 7
+	WebPort    = 80
+)
+
+// WebPage is the page the fallback web server answers every request with.
+const WebPage = "<html><body><h1>It works!</h1></body></html>\n"
+
+// Options configures a Harness. The zero value runs a server with one user
+// and a client with the default settings.
+type Options struct {
+	// Server and Client are the settings of the Reflex inbound and
+	// outbound. The user of the harness is added to both. The fallback web
+	// server is the fallback of the server unless it has fallbacks or a
+	// decoy of its own.
+	Server *inbound.Config
+	Client *outbound.Config
+	// Account is the account of the user, whose ID is set by the harness.
+	Account *reflex.Account
+	// VirtualTime runs the cover traffic, TLS shaping, hello splitting and
+	// resumption back-off of both ends on Clock.
+	VirtualTime bool
+}
+
+// Harness is a running Reflex client and server.
+type Harness struct {
+	// UserID is the ID of the user the client connects as.
+	UserID *protocol.ID
+	// Server and Client are the running instances.
+	Server, Client *core.Instance
+	// Clock is the virtual clock of the sessions with Options.VirtualTime.
+	Clock *Clock
+	// Addresses of the Reflex server, the dokodemo-door inbound of the
+	// client, the echo target and the fallback web server.
+	ServerAddr, ClientAddr, EchoAddr, WebAddr string
+
+	listeners []*Listener
+}
+
+// New starts a harness, which is closed when the test ends.
+func New(t testing.TB, options Options) *Harness {
+	t.Helper()
+	host := network.newHost().String()
+	address := func(port int) string {
+		return net.JoinHostPort(host, strconv.Itoa(port))
+	}
+	h := &Harness{
+		UserID:     protocol.NewID(uuid.New()),
+		ServerAddr: address(ServerPort),
+		ClientAddr: address(ClientPort),
+		EchoAddr:   address(EchoPort),
+		WebAddr:    address(WebPort),
+	}
+	t.Cleanup(h.close)
+
+	if options.VirtualTime {
+		h.Clock = NewClock()
+	}
+
+	echo, err := Listen(h.EchoAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.listeners = append(h.listeners, echo)
+	go serveEcho(echo)
+
+	web, err := Listen(h.WebAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.listeners = append(h.listeners, web)
+	go (&http.Server{Handler: http.HandlerFunc(serveWebPage)}).Serve(web)
+
+	account := &reflex.Account{}
+	if options.Account != nil {
+		account = options.Account
+	}
+	account.Id = h.UserID.String()
+
+	serverConfig := options.Server
+	if serverConfig == nil {
+		serverConfig = &inbound.Config{}
+	}
+	serverConfig.Clients = append(serverConfig.Clients, &protocol.User{
+		Email:   "reflextest@example.com",
+		Account: serial.ToTypedMessage(account),
+	})
+	if len(serverConfig.Fallbacks) == 0 && serverConfig.Decoy == nil {
+		serverConfig.Fallbacks = []*inbound.Fallback{{Dest: h.WebAddr}}
+	}
+	if h.Server, err = h.startInstance(&core.Config{
+		Inbound: []*core.InboundHandlerConfig{
+			{
+				Tag:              "reflex",
+				ReceiverSettings: receiverSettings(host, ServerPort),
+				ProxySettings:    serial.ToTypedMessage(serverConfig),
+			},
+		},
+		Outbound: []*core.OutboundHandlerConfig{
+			{
+				SenderSettings: senderSettings(),
+				ProxySettings:  serial.ToTypedMessage(&freedom.Config{}),
+			},
+		},
+	}); err != nil {
+		t.Fatal("failed to start server: ", err)
+	}
+
+	clientConfig := options.Client
+	if clientConfig == nil {
+		clientConfig = &outbound.Config{}
+	}
+	clientConfig.Vnext = append(clientConfig.Vnext, &protocol.ServerEndpoint{
+		Address: xnet.NewIPOrDomain(xnet.ParseAddress(host)),
+		Port:    ServerPort,
+		User: &protocol.User{
+			Account: serial.ToTypedMessage(account),
+		},
+	})
+	if h.Client, err = h.startInstance(&core.Config{
+		Inbound: []*core.InboundHandlerConfig{
+			{
+				ReceiverSettings: receiverSettings(host, ClientPort),
+				ProxySettings: serial.ToTypedMessage(&dokodemo.Config{
+					Address:  xnet.NewIPOrDomain(xnet.ParseAddress(host)),
+					Port:     EchoPort,
+					Networks: []xnet.Network{xnet.Network_TCP},
+				}),
+			},
+		},
+		Outbound: []*core.OutboundHandlerConfig{
+			{
+				Tag:            "reflex",
+				SenderSettings: senderSettings(),
+				ProxySettings:  serial.ToTypedMessage(clientConfig),
+			},
+		},
+	}); err != nil {
+		t.Fatal("failed to start client: ", err)
+	}
+	return h
+}
+
+// startInstance starts an instance with the apps of config, which logs
+// nothing. Its Reflex handlers run on the virtual clock if there is one.
+func (h *Harness) startInstance(config *core.Config) (*core.Instance, error) {
+	config.App = append(config.App,
+		serial.ToTypedMessage(&log.Config{}),
+		serial.ToTypedMessage(&dispatcher.Config{}),
+		serial.ToTypedMessage(&proxyman.InboundConfig{}),
+		serial.ToTypedMessage(&proxyman.OutboundConfig{}),
+	)
+	// The in-memory connections carry the bytes as sent, as raw TCP does
+	ctx := inbound.ContextWithRawStreams(context.Background())
+	if h.Clock != nil {
+		ctx = encoding.ContextWithTimeSource(ctx, h.Clock)
+	}
+	instance, err := core.NewWithContext(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	if err := instance.Start(); err != nil {
+		instance.Close()
+		return nil, err
+	}
+	return instance, nil
+}
+
+func receiverSettings(host string, port xnet.Port) *serial.TypedMessage {
+	return serial.ToTypedMessage(&proxyman.ReceiverConfig{
+		PortList:       &xnet.PortList{Range: []*xnet.PortRange{xnet.SinglePortRange(port)}},
+		Listen:         xnet.NewIPOrDomain(xnet.ParseAddress(host)),
+		StreamSettings: StreamSettings(),
+	})
+}
+
+func senderSettings() *serial.TypedMessage {
+	return serial.ToTypedMessage(&proxyman.SenderConfig{
+		StreamSettings: StreamSettings(),
+	})
+}
+
+func (h *Harness) close() {
+	if h.Client != nil {
+		h.Client.Close()
+	}
+	if h.Server != nil {
+		h.Server.Close()
+	}
+	for _, l := range h.listeners {
+		l.Close()
+	}
+}
+
+// Dial connects to the client, which tunnels the connection to the echo
+// target through the server.
+func (h *Harness) Dial() (*Conn, error) {
+	return Dial(context.Background(), h.ClientAddr)
+}
+
+// DialServer connects to the Reflex server directly, as a prober would.
+func (h *Harness) DialServer() (*Conn, error) {
+	return Dial(context.Background(), h.ServerAddr)
+}
+
+// Echo sends payload through the tunnel and checks that the echo target
+// sends it back within timeout.
+func (h *Harness) Echo(payload []byte, timeout time.Duration) error {
+	conn, err := h.Dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(payload)
+		errc <- err
+	}()
+	response := make([]byte, len(payload))
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return err
+	}
+	if err := <-errc; err != nil {
+		return err
+	}
+	if string(response) != string(payload) {
+		return &mismatchError{}
+	}
+	return nil
+}
+
+type mismatchError struct{}
+
+func (*mismatchError) Error() string {
+	return "echo differs from the payload sent"
+}
+
+// serveEcho writes back what each connection of l sends.
+func serveEcho(l *Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			io.Copy(conn, conn)
+			conn.(*Conn).CloseWrite()
+		}()
+	}
+}
+
+func serveWebPage(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Server", "nginx")
+	w.Header().Set("Content-Type", "text/html")
+	io.WriteString(w, WebPage)
+}
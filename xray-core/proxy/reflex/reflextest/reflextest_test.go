@@ -0,0 +1,233 @@
+package reflextest_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/proxy/reflex"
+	"github.com/xtls/xray-core/proxy/reflex/encoding"
+	"github.com/xtls/xray-core/proxy/reflex/outbound"
+	"github.com/xtls/xray-core/proxy/reflex/reflextest"
+)
+
+// clientHello looks like the first record of a TLS handshake to the shapers.
+var clientHello = []byte{0x16, 0x03, 0x01, 0x00, 0x04, 0x01, 0x00, 0x00, 0x00}
+
+func TestEcho(t *testing.T) {
+	t.Parallel()
+	h := reflextest.New(t, reflextest.Options{})
+
+	payload := make([]byte, 1<<20)
+	rand.Read(payload)
+	if err := h.Echo(payload, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := h.Echo(payload[:64*1024], 10*time.Second); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestFallback(t *testing.T) {
+	t.Parallel()
+	h := reflextest.New(t, reflextest.Options{})
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, address string) (net.Conn, error) {
+			return reflextest.Dial(ctx, address)
+		},
+	}}
+	response, err := client.Get("http://" + h.ServerAddr + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	body, _ := io.ReadAll(response.Body)
+	if response.StatusCode != http.StatusOK || string(body) != reflextest.WebPage {
+		t.Errorf("unexpected response from the fallback: %d %q", response.StatusCode, body)
+	}
+}
+
+func TestNetwork(t *testing.T) {
+	t.Parallel()
+	l, err := reflextest.Listen("10.255.0.1:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reflextest.Listen("10.255.0.1:80"); err == nil {
+		t.Error("expected the address to be in use")
+	}
+	if _, err := reflextest.Dial(context.Background(), "10.255.0.1:81"); err == nil {
+		t.Error("expected the connection to be refused")
+	}
+
+	client, err := reflextest.Dial(context.Background(), "10.255.0.1:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Half-close: the server reads to EOF and can still answer
+	client.Write([]byte("request"))
+	client.CloseWrite()
+	request, err := io.ReadAll(server)
+	if err != nil || string(request) != "request" {
+		t.Fatalf("unexpected request %q: %v", request, err)
+	}
+	server.Write([]byte("response"))
+	server.Close()
+	response, err := io.ReadAll(client)
+	if err != nil || string(response) != "response" {
+		t.Fatalf("unexpected response %q: %v", response, err)
+	}
+
+	client.SetReadDeadline(time.Now())
+	if _, err := client.Read(make([]byte, 1)); err == nil {
+		t.Error("expected the deadline to be exceeded")
+	}
+	if _, err := client.Write([]byte("late")); err == nil {
+		t.Error("expected a write to a closed peer to fail")
+	}
+
+	l.Close()
+	if _, err := reflextest.Dial(context.Background(), "10.255.0.1:80"); err == nil {
+		t.Error("expected the connection to be refused once closed")
+	}
+}
+
+// TestTLSShapingDelay tests that a tunneled TLS handshake is held for a delay
+// of the traffic profile by the client, and its answer by the server.
+func TestTLSShapingDelay(t *testing.T) {
+	h := reflextest.New(t, reflextest.Options{VirtualTime: true})
+	delays := encoding.GetDefaultProfile().Delays
+
+	conn, err := h.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write(clientHello); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, end := range []string{"client", "server"} {
+		if !h.Clock.WaitTimers(1, 5*time.Second) {
+			t.Fatalf("%s did not hold the handshake", end)
+		}
+		pending := h.Clock.Pending()
+		if len(pending) != 1 || !slices.ContainsFunc(delays, func(d encoding.DelayPattern) bool { return d.Delay == pending[0] }) {
+			t.Fatalf("%s holds the handshake for %v, expected a delay of the profile", end, pending)
+		}
+		h.Clock.Advance(pending[0])
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	response := make([]byte, len(clientHello))
+	if _, err := io.ReadFull(conn, response); err != nil || !bytes.Equal(response, clientHello) {
+		t.Fatalf("unexpected echo %x: %v", response, err)
+	}
+}
+
+// TestCoverCadence tests that cover traffic sends its cells at the rate of
+// the account, and carries the data of the session.
+func TestCoverCadence(t *testing.T) {
+	h := reflextest.New(t, reflextest.Options{
+		VirtualTime: true,
+		Account:     &reflex.Account{Cover: &reflex.Cover{Rate: 10}},
+	})
+
+	conn, err := h.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	payload := []byte("covered")
+	if _, err := conn.Write(payload); err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan []byte, 1)
+	go func() {
+		response := make([]byte, len(payload))
+		io.ReadFull(conn, response)
+		received <- response
+	}()
+
+	// Cells go out at each tick, the data of the session in the first ones
+	// after it arrives
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case response := <-received:
+			if !bytes.Equal(response, payload) {
+				t.Fatalf("unexpected echo %q", response)
+			}
+			return
+		case <-deadline:
+			t.Fatal("no echo")
+		default:
+		}
+		if !h.Clock.WaitTimers(1, 5*time.Second) {
+			t.Fatal("cover traffic stopped")
+		}
+		for _, d := range h.Clock.Pending() {
+			if d != 100*time.Millisecond {
+				t.Fatalf("next cell in %v, expected 100ms", d)
+			}
+		}
+		h.Clock.Advance(100 * time.Millisecond)
+	}
+}
+
+// TestHelloSplitDelay tests that a split hello waits the delay of the split
+// before each of its writes but the first.
+func TestHelloSplitDelay(t *testing.T) {
+	h := reflextest.New(t, reflextest.Options{
+		VirtualTime: true,
+		Client:      &outbound.Config{HelloSplit: &outbound.HelloSplit{Count: 3, MinDelay: 50, MaxDelay: 50}},
+	})
+
+	conn, err := h.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	payload := []byte("split")
+	if _, err := conn.Write(payload); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if !h.Clock.WaitTimers(1, 5*time.Second) {
+			t.Fatalf("hello sent after %d delays, expected 2", i)
+		}
+		if pending := h.Clock.Pending(); len(pending) != 1 || pending[0] != 50*time.Millisecond {
+			t.Fatalf("hello held for %v, expected 50ms", pending)
+		}
+		h.Clock.Advance(50 * time.Millisecond)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	response := make([]byte, len(payload))
+	if _, err := io.ReadFull(conn, response); err != nil || !bytes.Equal(response, payload) {
+		t.Fatalf("unexpected echo %q: %v", response, err)
+	}
+}
@@ -0,0 +1,130 @@
+package resume
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+
+	"github.com/xtls/xray-core/common/errors"
+)
+
+const (
+	// Magic starts the resume hello, in place of encoding.ReflexMagic.
+	Magic = 0x52465852 // "RFXR" in ASCII
+
+	// HelloSize is the size of the resume hello:
+	// [magic(4)] [id(16)] [timestamp(8)] [nonce(16)] [received(8)] [mac(32)]
+	HelloSize = 84
+	// ResponseSize is the size of the server's answer to a resume hello:
+	// [received(8)] [mac(32)]
+	ResponseSize = 40
+)
+
+// Secrets identify a session and authenticate its resume hellos. Both ends
+// derive them from the session key, so they never cross the wire.
+type Secrets struct {
+	ID  [16]byte
+	Key [32]byte
+}
+
+// DeriveSecrets derives the resumption secrets of the session with the given
+// key.
+func DeriveSecrets(sessionKey []byte) (Secrets, error) {
+	var s Secrets
+	if _, err := io.ReadFull(hkdf.New(sha256.New, sessionKey, nil, []byte("reflex-resume-id")), s.ID[:]); err != nil {
+		return s, err
+	}
+	if _, err := io.ReadFull(hkdf.New(sha256.New, sessionKey, nil, []byte("reflex-resume-key")), s.Key[:]); err != nil {
+		return s, err
+	}
+	return s, nil
+}
+
+// ConnectionKey derives the frame key of the connection opened by the resume
+// hello with the given nonce.
+func (s *Secrets) ConnectionKey(nonce [16]byte) ([]byte, error) {
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, s.Key[:], nonce[:], []byte("reflex-resume-v1")), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (s *Secrets) mac(data ...[]byte) []byte {
+	h := hmac.New(sha256.New, s.Key[:])
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+// Hello is sent by a client on a new connection to take over a detached
+// session.
+type Hello struct {
+	ID        [16]byte
+	Timestamp int64
+	Nonce     [16]byte
+	// Received is the number of DATA and CLOSE frames the client has
+	// received, so the server knows where to resume sending.
+	Received uint64
+}
+
+// Encode encodes the hello, authenticated with the session secrets.
+func (h *Hello) Encode(s *Secrets) []byte {
+	b := make([]byte, HelloSize)
+	binary.BigEndian.PutUint32(b[0:4], Magic)
+	copy(b[4:20], h.ID[:])
+	binary.BigEndian.PutUint64(b[20:28], uint64(h.Timestamp))
+	copy(b[28:44], h.Nonce[:])
+	binary.BigEndian.PutUint64(b[44:52], h.Received)
+	copy(b[52:84], s.mac(b[:52]))
+	return b
+}
+
+// DecodeHello decodes a resume hello without authenticating it, as the
+// secrets are only known once the session has been looked up by ID.
+func DecodeHello(data []byte) (*Hello, error) {
+	if len(data) < HelloSize {
+		return nil, errors.New("resume hello too short")
+	}
+	if binary.BigEndian.Uint32(data[0:4]) != Magic {
+		return nil, errors.New("invalid resume magic")
+	}
+	h := &Hello{
+		Timestamp: int64(binary.BigEndian.Uint64(data[20:28])),
+		Received:  binary.BigEndian.Uint64(data[44:52]),
+	}
+	copy(h.ID[:], data[4:20])
+	copy(h.Nonce[:], data[28:44])
+	return h, nil
+}
+
+// VerifyHello reports whether the encoded hello data was made with s.
+func (s *Secrets) VerifyHello(data []byte) bool {
+	return len(data) >= HelloSize && hmac.Equal(data[52:84], s.mac(data[:52]))
+}
+
+// EncodeResponse encodes the server's answer to the hello with the given
+// nonce, telling the client how many frames the server has received.
+func (s *Secrets) EncodeResponse(nonce [16]byte, received uint64) []byte {
+	b := make([]byte, ResponseSize)
+	binary.BigEndian.PutUint64(b[0:8], received)
+	copy(b[8:40], s.mac([]byte("server"), nonce[:], b[:8]))
+	return b
+}
+
+// DecodeResponse authenticates the server's answer to the hello with the
+// given nonce and returns the number of frames the server has received.
+func (s *Secrets) DecodeResponse(nonce [16]byte, data []byte) (uint64, error) {
+	if len(data) < ResponseSize {
+		return 0, errors.New("resume response too short")
+	}
+	if !hmac.Equal(data[8:40], s.mac([]byte("server"), nonce[:], data[:8])) {
+		return 0, errors.New("resume response is not authentic")
+	}
+	return binary.BigEndian.Uint64(data[0:8]), nil
+}
@@ -0,0 +1,102 @@
+package resume
+
+import (
+	"testing"
+	"time"
+)
+
+func testSecrets(t *testing.T) Secrets {
+	secrets, err := DeriveSecrets(make([]byte, 32))
+	if err != nil {
+		t.Fatalf("failed to derive secrets: %v", err)
+	}
+	return secrets
+}
+
+// TestHelloRoundTrip tests that a resume hello decodes to what was encoded
+// and is authenticated by the secrets of its session only
+func TestHelloRoundTrip(t *testing.T) {
+	secrets := testSecrets(t)
+	hello := &Hello{
+		ID:        secrets.ID,
+		Timestamp: time.Now().Unix(),
+		Nonce:     [16]byte{1, 2, 3},
+		Received:  42,
+	}
+	data := hello.Encode(&secrets)
+	if len(data) != HelloSize {
+		t.Fatalf("hello is %d bytes, expected %d", len(data), HelloSize)
+	}
+
+	decoded, err := DecodeHello(data)
+	if err != nil {
+		t.Fatalf("failed to decode hello: %v", err)
+	}
+	if *decoded != *hello {
+		t.Fatalf("decoded %+v, expected %+v", decoded, hello)
+	}
+	if !secrets.VerifyHello(data) {
+		t.Fatal("hello was not verified by its own secrets")
+	}
+
+	other, err := DeriveSecrets([]byte("another session key of 32 bytes"))
+	if err != nil {
+		t.Fatalf("failed to derive secrets: %v", err)
+	}
+	if other.VerifyHello(data) {
+		t.Fatal("hello was verified by the secrets of another session")
+	}
+
+	data[50] ^= 1
+	if secrets.VerifyHello(data) {
+		t.Fatal("tampered hello was verified")
+	}
+}
+
+// TestDecodeHelloRejectsGarbage tests that short data and data without the
+// resume magic are rejected
+func TestDecodeHelloRejectsGarbage(t *testing.T) {
+	if _, err := DecodeHello(make([]byte, HelloSize-1)); err == nil {
+		t.Fatal("short hello was decoded")
+	}
+	if _, err := DecodeHello(make([]byte, HelloSize)); err == nil {
+		t.Fatal("hello without magic was decoded")
+	}
+}
+
+// TestResponseRoundTrip tests that the server's answer is bound to the nonce
+// of the hello it answers
+func TestResponseRoundTrip(t *testing.T) {
+	secrets := testSecrets(t)
+	nonce := [16]byte{7}
+
+	data := secrets.EncodeResponse(nonce, 1234)
+	received, err := secrets.DecodeResponse(nonce, data)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if received != 1234 {
+		t.Fatalf("decoded %d received frames, expected 1234", received)
+	}
+
+	if _, err := secrets.DecodeResponse([16]byte{8}, data); err == nil {
+		t.Fatal("response was accepted for another nonce")
+	}
+}
+
+// TestConnectionKeyDependsOnNonce tests that each resumed connection gets
+// its own frame key
+func TestConnectionKeyDependsOnNonce(t *testing.T) {
+	secrets := testSecrets(t)
+	a, err := secrets.ConnectionKey([16]byte{1})
+	if err != nil {
+		t.Fatalf("failed to derive key: %v", err)
+	}
+	b, err := secrets.ConnectionKey([16]byte{2})
+	if err != nil {
+		t.Fatalf("failed to derive key: %v", err)
+	}
+	if string(a) == string(b) {
+		t.Fatal("connections with different nonces share a key")
+	}
+}
@@ -0,0 +1,547 @@
+// Package resume implements Reflex sessions that outlive the connection they
+// were opened on.
+//
+// A client asks for resumption by sending a RESUME frame before its request,
+// and the server grants it by answering with a RESUME frame carrying its grace
+// period. From then on both ends count the DATA and CLOSE frames they receive,
+// acknowledge them with ACK frames and keep the frames they sent until they
+// are acknowledged. When the connection breaks, the client opens a new one
+// with a resume hello naming the session and the number of frames it has
+// received; the server answers with its own count, and both ends send again
+// whatever the other has not received.
+package resume
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/xtls/xray-core/common/errors"
+	"github.com/xtls/xray-core/proxy/reflex/encoding"
+)
+
+const (
+	// ackEvery is the number of frames received between two ACK frames.
+	ackEvery = 16
+	// maxUnacked is the number of bytes sent but not yet acknowledged after
+	// which writes block.
+	maxUnacked = 4 * 1024 * 1024
+	// flushTimeout bounds the time Close waits for queued frames to be sent.
+	flushTimeout = 5 * time.Second
+	// maxRedialDelay bounds the delay between two reconnection attempts.
+	maxRedialDelay = 5 * time.Second
+)
+
+// NewOfferFrame returns the RESUME frame with which a client asks for a
+// resumable session.
+func NewOfferFrame() *encoding.Frame {
+	return &encoding.Frame{Type: encoding.FrameTypeResume}
+}
+
+// NewGrantFrame returns the RESUME frame with which a server answers an
+// offer. A zero grace period declines resumption.
+func NewGrantFrame(grace time.Duration) *encoding.Frame {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, uint32(grace/time.Second))
+	return &encoding.Frame{Type: encoding.FrameTypeResume, Payload: payload}
+}
+
+// Transport is one connection carrying a session.
+type Transport struct {
+	conn    net.Conn
+	reader  io.Reader
+	encoder *encoding.FrameEncoder
+	decoder *encoding.FrameDecoder
+
+	keepAlive *encoding.KeepAlive
+	done      chan struct{}
+}
+
+// NewTransport returns a transport writing frames to conn with encoder and
+// reading them from reader, which buffers conn, with decoder.
+func NewTransport(conn net.Conn, reader io.Reader, encoder *encoding.FrameEncoder, decoder *encoding.FrameDecoder) *Transport {
+	return &Transport{
+		conn:    conn,
+		reader:  reader,
+		encoder: encoder,
+		decoder: decoder,
+		done:    make(chan struct{}),
+	}
+}
+
+// Done is closed once the session stops using the transport.
+func (t *Transport) Done() <-chan struct{} {
+	return t.done
+}
+
+// Config configures a session.
+type Config struct {
+	// GracePeriod is how long a server keeps a detached session. Clients
+	// learn it from the server's grant.
+	GracePeriod time.Duration
+	// KeepAliveInterval is the interval between PING frames on each
+	// transport. A transport on which nothing arrives for a whole interval
+	// is dropped. 0 disables keepalive.
+	KeepAliveInterval time.Duration
+	// Profile sizes the PING frames.
+	Profile *encoding.TrafficProfile
+	// Redial opens a new transport for a client session and resumes the
+	// session on it, given the number of frames the client has received. It
+	// returns the number of frames the server has received. Servers leave it
+	// nil and wait for the client to come back.
+	Redial func(received uint64) (*Transport, uint64, error)
+	// OnClose is called once when the session ends.
+	OnClose func()
+	// Clock times the delays between reconnection attempts. nil is the
+	// system clock.
+	Clock encoding.TimeSource
+}
+
+type state int
+
+const (
+	// pending client sessions keep what they send until the server answers
+	// their offer.
+	statePending state = iota
+	stateResumable
+	// declined client sessions are plain sessions.
+	stateDeclined
+)
+
+// Session is a resumable Reflex session. It implements encoding.FrameConn:
+// ReadFrame and WriteFrame keep working across a change of transport.
+type Session struct {
+	secrets Secrets
+	config  Config
+
+	mu    sync.Mutex
+	cond  *sync.Cond
+	state state
+	grace time.Duration
+	err   error
+	// done is closed once the session ends.
+	done chan struct{}
+
+	transport *Transport
+	// gen changes whenever the transport is attached or detached, so that
+	// results from a replaced transport are ignored.
+	gen        uint64
+	graceTimer *time.Timer
+
+	// unacked holds the DATA and CLOSE frames sent but not acknowledged;
+	// unacked[0] is frame number acked+1 and the last one is frame number
+	// sent. next is the number of the next frame to write to the transport.
+	unacked  []*encoding.Frame
+	acked    uint64
+	sent     uint64
+	next     uint64
+	buffered int
+	control  []*encoding.Frame
+
+	received uint64
+	ackSent  uint64
+}
+
+// NewSession returns a session running on transport t. Sessions with a Redial
+// function are client sessions, which become resumable once the server grants
+// their offer; other sessions are resumable from the start.
+func NewSession(secrets Secrets, config Config, t *Transport) *Session {
+	s := &Session{
+		secrets: secrets,
+		config:  config,
+		state:   stateResumable,
+		grace:   config.GracePeriod,
+		done:    make(chan struct{}),
+		next:    1,
+	}
+	if config.Redial != nil {
+		s.state = statePending
+	}
+	s.cond = sync.NewCond(&s.mu)
+	s.attachLocked(t)
+	go s.writeLoop()
+	return s
+}
+
+// ID returns the ID of the session.
+func (s *Session) ID() [16]byte {
+	return s.secrets.ID
+}
+
+// Secrets returns the resumption secrets of the session.
+func (s *Session) Secrets() *Secrets {
+	return &s.secrets
+}
+
+func isSequenced(frame *encoding.Frame) bool {
+	return frame.Type == encoding.FrameTypeData || frame.Type == encoding.FrameTypeClose
+}
+
+// WriteFrame queues a frame. DATA and CLOSE frames are kept until the peer
+// acknowledges them and block while too much data is unacknowledged; other
+// frames are dropped while the session is detached.
+func (s *Session) WriteFrame(frame *encoding.Frame) error {
+	f := &encoding.Frame{
+		Type:    frame.Type,
+		Payload: append([]byte(nil), frame.Payload...),
+		Padding: frame.Padding,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !isSequenced(f) {
+		if s.err != nil {
+			return s.err
+		}
+		if s.transport != nil {
+			s.control = append(s.control, f)
+			s.cond.Broadcast()
+		}
+		return nil
+	}
+
+	for s.err == nil && s.state != stateDeclined && s.buffered >= maxUnacked {
+		s.cond.Wait()
+	}
+	if s.err != nil {
+		return s.err
+	}
+	s.unacked = append(s.unacked, f)
+	s.sent++
+	s.buffered += len(f.Payload)
+	s.cond.Broadcast()
+	return nil
+}
+
+// writeLoop writes queued frames to the current transport.
+func (s *Session) writeLoop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for {
+		var frame *encoding.Frame
+		for frame == nil {
+			if s.err != nil {
+				return
+			}
+			if s.transport != nil {
+				if len(s.control) > 0 {
+					frame = s.control[0]
+					s.control = s.control[1:]
+					break
+				}
+				if s.next <= s.sent {
+					frame = s.unacked[s.next-s.acked-1]
+					break
+				}
+			}
+			s.cond.Wait()
+		}
+
+		t, gen := s.transport, s.gen
+		s.mu.Unlock()
+		err := t.encoder.WriteFrame(t.conn, frame)
+		s.mu.Lock()
+
+		if err != nil {
+			s.detachLocked(gen, err)
+			continue
+		}
+		if isSequenced(frame) && gen == s.gen {
+			s.next++
+			if s.state == stateDeclined {
+				s.ackLocked(s.next - 1)
+			}
+		}
+		s.cond.Broadcast()
+	}
+}
+
+// ReadFrame reads the next frame, waiting for the session to be resumed if
+// its transport breaks. ACK and RESUME frames are handled by the session.
+func (s *Session) ReadFrame() (*encoding.Frame, error) {
+	for {
+		s.mu.Lock()
+		for s.err == nil && s.transport == nil {
+			s.cond.Wait()
+		}
+		if s.err != nil {
+			err := s.err
+			s.mu.Unlock()
+			return nil, err
+		}
+		t, gen := s.transport, s.gen
+		s.mu.Unlock()
+
+		frame, err := t.decoder.ReadFrame(t.reader)
+		if err != nil {
+			s.mu.Lock()
+			s.detachLocked(gen, err)
+			s.mu.Unlock()
+			continue
+		}
+		if t.keepAlive != nil {
+			t.keepAlive.Alive()
+		}
+
+		switch frame.Type {
+		case encoding.FrameTypeAck:
+			if len(frame.Payload) >= 8 {
+				count := binary.BigEndian.Uint64(frame.Payload)
+				s.mu.Lock()
+				if gen == s.gen && count >= s.acked && count < s.next {
+					s.ackLocked(count)
+				}
+				s.mu.Unlock()
+			}
+			encoding.PutFrame(frame)
+		case encoding.FrameTypeResume:
+			var grace time.Duration
+			if len(frame.Payload) >= 4 {
+				grace = time.Duration(binary.BigEndian.Uint32(frame.Payload)) * time.Second
+			}
+			encoding.PutFrame(frame)
+			s.grant(grace)
+		case encoding.FrameTypeData, encoding.FrameTypeClose:
+			s.mu.Lock()
+			if gen != s.gen {
+				// Read from a replaced transport: the peer sends it again
+				s.mu.Unlock()
+				encoding.PutFrame(frame)
+				continue
+			}
+			s.received++
+			if s.state == stateResumable && s.received-s.ackSent >= ackEvery {
+				s.ackSent = s.received
+				payload := make([]byte, 8)
+				binary.BigEndian.PutUint64(payload, s.received)
+				s.control = append(s.control, &encoding.Frame{Type: encoding.FrameTypeAck, Payload: payload})
+				s.cond.Broadcast()
+			}
+			s.mu.Unlock()
+			return frame, nil
+		default:
+			return frame, nil
+		}
+	}
+}
+
+// grant handles the server's answer to the offer of a client session.
+func (s *Session) grant(grace time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.state != statePending {
+		return
+	}
+	if grace <= 0 {
+		s.state = stateDeclined
+		s.ackLocked(s.next - 1)
+		return
+	}
+	s.state = stateResumable
+	s.grace = grace
+}
+
+// ackLocked drops the frames up to number count, which the peer has received.
+func (s *Session) ackLocked(count uint64) {
+	for s.acked < count {
+		s.buffered -= len(s.unacked[0].Payload)
+		s.unacked[0] = nil
+		s.unacked = s.unacked[1:]
+		s.acked++
+	}
+	s.cond.Broadcast()
+}
+
+// attachLocked makes t the transport of the session.
+func (s *Session) attachLocked(t *Transport) {
+	s.transport = t
+	s.gen++
+	gen := s.gen
+	if s.config.KeepAliveInterval > 0 {
+		t.keepAlive = encoding.NewKeepAlive(transportWriter{s, gen}, s.config.Profile, s.config.KeepAliveInterval, func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.detachLocked(gen, errors.New("peer stopped answering keepalive"))
+		})
+		t.keepAlive.Start()
+	}
+	s.cond.Broadcast()
+}
+
+// detachLocked drops transport generation gen after it failed with err. The
+// session then waits for the client to resume it, or ends if it isn't
+// resumable.
+func (s *Session) detachLocked(gen uint64, err error) {
+	if gen != s.gen || s.transport == nil || s.err != nil {
+		return
+	}
+	s.dropTransportLocked()
+	if s.state != stateResumable {
+		s.failLocked(err)
+		return
+	}
+	errors.LogInfoInner(context.Background(), err, "Reflex session detached, waiting ", s.grace, " to be resumed")
+
+	gen = s.gen
+	s.graceTimer = time.AfterFunc(s.grace, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.gen == gen {
+			s.failLocked(errors.New("session was not resumed within ", s.grace).Base(err))
+		}
+	})
+	if s.config.Redial != nil {
+		go s.redial()
+	}
+}
+
+func (s *Session) dropTransportLocked() {
+	t := s.transport
+	if t == nil {
+		return
+	}
+	s.transport = nil
+	s.gen++
+	s.control = nil
+	if t.keepAlive != nil {
+		t.keepAlive.Close()
+	}
+	t.conn.Close()
+	close(t.done)
+	s.cond.Broadcast()
+}
+
+// redial resumes a client session on new transports until one succeeds or
+// the session ends, which also cuts short the wait between two attempts.
+func (s *Session) redial() {
+	delay := 100 * time.Millisecond
+	for {
+		s.mu.Lock()
+		if s.err != nil || s.transport != nil {
+			s.mu.Unlock()
+			return
+		}
+		received := s.received
+		s.mu.Unlock()
+
+		t, peerReceived, err := s.config.Redial(received)
+		if err == nil {
+			if err = s.Attach(t, peerReceived); err == nil {
+				return
+			}
+			t.conn.Close()
+		}
+		errors.LogInfoInner(context.Background(), err, "failed to resume Reflex session")
+
+		select {
+		case <-encoding.OrSystemClock(s.config.Clock).After(delay):
+		case <-s.done:
+			return
+		}
+		delay = min(delay*2, maxRedialDelay)
+	}
+}
+
+// Detach drops the current transport, if any, and returns the number of
+// frames received, so that a server can answer a resume hello. The session
+// must be resumable.
+func (s *Session) Detach() (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return 0, s.err
+	}
+	if s.state != stateResumable {
+		return 0, errors.New("session is not resumable")
+	}
+	s.detachLocked(s.gen, errors.New("connection replaced"))
+	return s.received, nil
+}
+
+// Attach resumes the detached session on t, given the number of frames the
+// peer has received. Frames the peer has not received are sent again.
+func (s *Session) Attach(t *Transport, peerReceived uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+	if s.transport != nil {
+		return errors.New("session is not detached")
+	}
+	if peerReceived < s.acked || peerReceived > s.sent {
+		err := errors.New("peer received ", peerReceived, " frames, but ", s.acked, " to ", s.sent, " were expected")
+		s.failLocked(err)
+		return err
+	}
+	if s.graceTimer != nil {
+		s.graceTimer.Stop()
+		s.graceTimer = nil
+	}
+	s.ackLocked(peerReceived)
+	s.next = peerReceived + 1
+	s.attachLocked(t)
+	errors.LogInfo(context.Background(), "Reflex session resumed, sending ", s.sent-peerReceived, " frames again")
+	return nil
+}
+
+// Close ends the session after trying to send what is queued.
+func (s *Session) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	timer := time.AfterFunc(flushTimeout, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.failLocked(io.ErrClosedPipe)
+	})
+	defer timer.Stop()
+	for s.err == nil && s.transport != nil && (len(s.control) > 0 || s.next <= s.sent) {
+		s.cond.Wait()
+	}
+	s.failLocked(io.ErrClosedPipe)
+	return nil
+}
+
+func (s *Session) failLocked(err error) {
+	if s.err != nil {
+		return
+	}
+	s.err = err
+	close(s.done)
+	s.dropTransportLocked()
+	if s.graceTimer != nil {
+		s.graceTimer.Stop()
+	}
+	s.unacked = nil
+	s.cond.Broadcast()
+	if s.config.OnClose != nil {
+		go s.config.OnClose()
+	}
+}
+
+// transportWriter queues control frames for one transport generation.
+type transportWriter struct {
+	s   *Session
+	gen uint64
+}
+
+func (w transportWriter) WriteFrame(frame *encoding.Frame) error {
+	w.s.mu.Lock()
+	defer w.s.mu.Unlock()
+
+	if w.s.gen != w.gen || w.s.err != nil {
+		return io.ErrClosedPipe
+	}
+	w.s.control = append(w.s.control, frame)
+	w.s.cond.Broadcast()
+	return nil
+}
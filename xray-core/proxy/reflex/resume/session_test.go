@@ -0,0 +1,236 @@
+package resume
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/common/errors"
+	"github.com/xtls/xray-core/proxy/reflex/encoding"
+)
+
+// pipe returns the client and server transports of a new connection.
+func pipe(t *testing.T, key []byte) (*Transport, *Transport) {
+	c, s := net.Pipe()
+	return newTestTransport(t, c, key), newTestTransport(t, s, key)
+}
+
+func newTestTransport(t *testing.T, conn net.Conn, key []byte) *Transport {
+	encoder, err := encoding.NewFrameEncoder(key)
+	if err != nil {
+		t.Fatalf("failed to create encoder: %v", err)
+	}
+	decoder, err := encoding.NewFrameDecoder(key)
+	if err != nil {
+		t.Fatalf("failed to create decoder: %v", err)
+	}
+	return NewTransport(conn, conn, encoder, decoder)
+}
+
+func dataFrame(i uint32) *encoding.Frame {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, i)
+	return &encoding.Frame{Type: encoding.FrameTypeData, Payload: payload}
+}
+
+// readSequence reads n DATA frames and checks that they are numbered 0 to
+// n-1, calling progress with the number read so far.
+func readSequence(s *Session, n uint32, progress func(uint32)) error {
+	for i := uint32(0); i < n; i++ {
+		frame, err := s.ReadFrame()
+		if err != nil {
+			return err
+		}
+		if frame.Type != encoding.FrameTypeData {
+			i--
+			continue
+		}
+		if got := binary.BigEndian.Uint32(frame.Payload); got != i {
+			return fmt.Errorf("frame %d arrived in place of frame %d", got, i)
+		}
+		progress(i + 1)
+	}
+	return nil
+}
+
+// TestSessionSurvivesConnectionReset tests that frames sent in both
+// directions arrive in order and exactly once when the connection breaks
+// mid-stream and the client resumes the session on a new one
+func TestSessionSurvivesConnectionReset(t *testing.T) {
+	const frames = 500
+	key := make([]byte, 32)
+	secrets := testSecrets(t)
+
+	clientTransport, serverTransport := pipe(t, key)
+	server := NewSession(secrets, Config{GracePeriod: 5 * time.Second}, serverTransport)
+	defer server.Close()
+
+	var redials atomic.Int32
+	client := NewSession(secrets, Config{
+		Redial: func(received uint64) (*Transport, uint64, error) {
+			redials.Add(1)
+			c, s := pipe(t, key)
+			serverReceived, err := server.Detach()
+			if err != nil {
+				return nil, 0, err
+			}
+			if err := server.Attach(s, received); err != nil {
+				return nil, 0, err
+			}
+			return c, serverReceived, nil
+		},
+	}, clientTransport)
+	defer client.Close()
+
+	if err := server.WriteFrame(NewGrantFrame(5 * time.Second)); err != nil {
+		t.Fatalf("failed to grant resumption: %v", err)
+	}
+
+	var clientRead, serverRead atomic.Uint32
+	errs := make(chan error, 4)
+	go func() {
+		errs <- readSequence(client, frames, clientRead.Store)
+	}()
+	go func() {
+		errs <- readSequence(server, frames, serverRead.Store)
+	}()
+	write := func(s *Session) {
+		for i := uint32(0); i < frames; i++ {
+			if err := s.WriteFrame(dataFrame(i)); err != nil {
+				errs <- err
+				return
+			}
+		}
+		errs <- nil
+	}
+	go write(client)
+	go write(server)
+
+	// Break the connection once both ends are half way through
+	deadline := time.Now().Add(5 * time.Second)
+	for clientRead.Load() < frames/2 || serverRead.Load() < frames/2 {
+		if time.Now().After(deadline) {
+			t.Fatal("sessions made no progress")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	clientTransport.conn.Close()
+
+	for i := 0; i < 4; i++ {
+		select {
+		case err := <-errs:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("session was not resumed")
+		}
+	}
+	if redials.Load() == 0 {
+		t.Fatal("client did not resume the session")
+	}
+}
+
+// TestSessionEndsAfterGracePeriod tests that a detached session which is not
+// resumed in time fails its readers and reports that it ended
+func TestSessionEndsAfterGracePeriod(t *testing.T) {
+	clientTransport, serverTransport := pipe(t, make([]byte, 32))
+	closed := make(chan struct{})
+	server := NewSession(testSecrets(t), Config{
+		GracePeriod: 50 * time.Millisecond,
+		OnClose:     func() { close(closed) },
+	}, serverTransport)
+
+	clientTransport.conn.Close()
+	if _, err := server.ReadFrame(); err == nil {
+		t.Fatal("session read succeeded after the grace period")
+	}
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("OnClose was not called")
+	}
+	if err := server.WriteFrame(dataFrame(0)); err == nil {
+		t.Fatal("session write succeeded after the grace period")
+	}
+}
+
+// TestSessionDeclined tests that a client session whose offer is declined
+// behaves like a plain session and ends with its connection
+func TestSessionDeclined(t *testing.T) {
+	key := make([]byte, 32)
+	clientTransport, serverTransport := pipe(t, key)
+	server := encoding.NewFrameConn(serverTransport.encoder, serverTransport.conn, serverTransport.decoder, serverTransport.reader)
+
+	client := NewSession(testSecrets(t), Config{
+		Redial: func(uint64) (*Transport, uint64, error) {
+			t.Error("declined session was resumed")
+			return nil, 0, net.ErrClosed
+		},
+	}, clientTransport)
+	defer client.Close()
+
+	go func() {
+		server.WriteFrame(NewGrantFrame(0))
+		server.WriteFrame(dataFrame(0))
+	}()
+	frame, err := client.ReadFrame()
+	if err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+	if frame.Type != encoding.FrameTypeData {
+		t.Fatalf("expected DATA frame, got type %d", frame.Type)
+	}
+
+	serverTransport.conn.Close()
+	if _, err := client.ReadFrame(); err == nil {
+		t.Fatal("declined session survived the end of its connection")
+	}
+}
+
+// stoppedClock is a clock whose timers never fire, telling waiting of each
+// timer armed.
+type stoppedClock struct {
+	waiting chan struct{}
+}
+
+func (stoppedClock) Now() time.Time { return time.Now() }
+
+func (c stoppedClock) After(time.Duration) <-chan time.Time {
+	c.waiting <- struct{}{}
+	return nil
+}
+
+// TestRedialStopsWithSession tests that a client waiting to try resuming its
+// session again gives up as soon as the session ends
+func TestRedialStopsWithSession(t *testing.T) {
+	clientTransport, _ := pipe(t, make([]byte, 32))
+	clock := stoppedClock{waiting: make(chan struct{}, 2)}
+	client := NewSession(testSecrets(t), Config{
+		Clock: clock,
+		Redial: func(uint64) (*Transport, uint64, error) {
+			return nil, 0, errors.New("server unreachable")
+		},
+	}, clientTransport)
+	client.grant(time.Minute)
+	if _, err := client.Detach(); err != nil {
+		t.Fatal(err)
+	}
+	<-clock.waiting
+
+	returned := make(chan struct{})
+	go func() {
+		client.redial()
+		close(returned)
+	}()
+	<-clock.waiting
+	client.Close()
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Fatal("client kept waiting to resume an ended session")
+	}
+}
@@ -0,0 +1,144 @@
+package sharelink
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/xtls/xray-core/common/errors"
+)
+
+type outboundJSON struct {
+	Tag            string      `json:"tag,omitempty"`
+	Protocol       string      `json:"protocol"`
+	Settings       settings    `json:"settings"`
+	StreamSettings *streamJSON `json:"streamSettings,omitempty"`
+}
+
+type settings struct {
+	Vnext []vnextJSON `json:"vnext"`
+}
+
+type vnextJSON struct {
+	Address string   `json:"address"`
+	Port    uint32   `json:"port"`
+	User    userJSON `json:"user"`
+}
+
+type userJSON struct {
+	ID     string `json:"id"`
+	Email  string `json:"email,omitempty"`
+	Policy string `json:"policy,omitempty"`
+}
+
+type streamJSON struct {
+	Network             string        `json:"network"`
+	Security            string        `json:"security,omitempty"`
+	TLSSettings         *tlsJSON      `json:"tlsSettings,omitempty"`
+	REALITYSettings     *realityJSON  `json:"realitySettings,omitempty"`
+	WSSettings          *hostPathJSON `json:"wsSettings,omitempty"`
+	HTTPUpgradeSettings *hostPathJSON `json:"httpupgradeSettings,omitempty"`
+	XHTTPSettings       *xhttpJSON    `json:"xhttpSettings,omitempty"`
+	GRPCSettings        *grpcJSON     `json:"grpcSettings,omitempty"`
+}
+
+type tlsJSON struct {
+	ServerName    string   `json:"serverName,omitempty"`
+	ALPN          []string `json:"alpn,omitempty"`
+	Fingerprint   string   `json:"fingerprint,omitempty"`
+	ECHConfigList string   `json:"echConfigList,omitempty"`
+}
+
+type realityJSON struct {
+	ServerName  string `json:"serverName,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	PublicKey   string `json:"publicKey"`
+	ShortID     string `json:"shortId,omitempty"`
+	SpiderX     string `json:"spiderX,omitempty"`
+}
+
+type hostPathJSON struct {
+	Host string `json:"host,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+type xhttpJSON struct {
+	Host string `json:"host,omitempty"`
+	Path string `json:"path,omitempty"`
+	Mode string `json:"mode,omitempty"`
+}
+
+type grpcJSON struct {
+	ServiceName string `json:"serviceName,omitempty"`
+	MultiMode   bool   `json:"multiMode,omitempty"`
+}
+
+// OutboundJSON returns a complete Reflex outbound for the link, tagged tag.
+func (l *Link) OutboundJSON(tag string) ([]byte, error) {
+	stream := &streamJSON{
+		Network: l.Network,
+	}
+	if stream.Network == "" {
+		stream.Network = "tcp"
+	}
+	switch stream.Network {
+	case "tcp", "raw":
+	case "ws", "websocket":
+		stream.WSSettings = &hostPathJSON{Host: l.Host, Path: l.Path}
+	case "httpupgrade":
+		stream.HTTPUpgradeSettings = &hostPathJSON{Host: l.Host, Path: l.Path}
+	case "xhttp", "splithttp":
+		stream.XHTTPSettings = &xhttpJSON{Host: l.Host, Path: l.Path, Mode: l.Mode}
+	case "grpc":
+		stream.GRPCSettings = &grpcJSON{ServiceName: l.ServiceName, MultiMode: l.Mode == "multi"}
+	default:
+		return nil, errors.New("unknown transport in share link: ", l.Network)
+	}
+
+	switch l.Security {
+	case "", "none":
+	case "tls":
+		stream.Security = "tls"
+		stream.TLSSettings = &tlsJSON{
+			ServerName:    l.SNI,
+			Fingerprint:   l.Fingerprint,
+			ECHConfigList: l.ECH,
+		}
+		if l.ALPN != "" {
+			stream.TLSSettings.ALPN = strings.Split(l.ALPN, ",")
+		}
+	case "reality":
+		stream.Security = "reality"
+		stream.REALITYSettings = &realityJSON{
+			ServerName:  l.SNI,
+			Fingerprint: l.Fingerprint,
+			PublicKey:   l.PublicKey,
+			ShortID:     l.ShortID,
+			SpiderX:     l.SpiderX,
+		}
+		if stream.REALITYSettings.Fingerprint == "" {
+			stream.REALITYSettings.Fingerprint = "chrome"
+		}
+	default:
+		return nil, errors.New("unknown security in share link: ", l.Security)
+	}
+
+	out := &outboundJSON{
+		Tag:      tag,
+		Protocol: "reflex",
+		Settings: settings{
+			Vnext: []vnextJSON{
+				{
+					Address: l.Address,
+					Port:    l.Port,
+					User: userJSON{
+						ID:     l.ID,
+						Email:  l.Name,
+						Policy: l.Policy,
+					},
+				},
+			},
+		},
+		StreamSettings: stream,
+	}
+	return json.MarshalIndent(out, "", "  ")
+}
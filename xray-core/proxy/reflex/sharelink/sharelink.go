@@ -0,0 +1,142 @@
+// Package sharelink implements reflex:// share links, which carry everything a
+// client needs to connect to a Reflex inbound:
+//
+//	reflex://uuid@host:port?policy=youtube&type=grpc&serviceName=api&security=tls&sni=example.com#name
+//
+// Stream settings use the parameter names of VLESS share links: type,
+// security, sni, alpn, fp, pbk, sid, spx, ech, host, path, serviceName and
+// mode.
+package sharelink
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/xtls/xray-core/common/errors"
+	"github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/uuid"
+)
+
+// Scheme is the URI scheme of Reflex share links.
+const Scheme = "reflex"
+
+// Link is a parsed reflex:// share link.
+type Link struct {
+	ID      string
+	Address string
+	Port    uint32
+	// Name is a human readable label, usually the email of the client.
+	Name string
+
+	// Policy is the traffic profile of the account.
+	Policy string
+
+	// Network is the transport: tcp, ws, grpc, xhttp or httpupgrade.
+	Network string
+	// Security is none, tls or reality.
+	Security    string
+	SNI         string
+	ALPN        string
+	Fingerprint string
+	// PublicKey, ShortID and SpiderX configure REALITY.
+	PublicKey string
+	ShortID   string
+	SpiderX   string
+	// ECH is the base64 ECH config list for TLS.
+	ECH string
+
+	Host        string
+	Path        string
+	ServiceName string
+	// Mode is the gRPC mode (gun or multi) or the XHTTP mode.
+	Mode string
+}
+
+// params lists the query parameters in the order they are documented.
+func (l *Link) params() []struct {
+	key   string
+	value *string
+} {
+	return []struct {
+		key   string
+		value *string
+	}{
+		{"policy", &l.Policy},
+		{"type", &l.Network},
+		{"security", &l.Security},
+		{"sni", &l.SNI},
+		{"alpn", &l.ALPN},
+		{"fp", &l.Fingerprint},
+		{"pbk", &l.PublicKey},
+		{"sid", &l.ShortID},
+		{"spx", &l.SpiderX},
+		{"ech", &l.ECH},
+		{"host", &l.Host},
+		{"path", &l.Path},
+		{"serviceName", &l.ServiceName},
+		{"mode", &l.Mode},
+	}
+}
+
+// Parse parses a reflex:// share link.
+func Parse(link string) (*Link, error) {
+	u, err := url.Parse(strings.TrimSpace(link))
+	if err != nil {
+		return nil, errors.New("invalid share link").Base(err)
+	}
+	if !strings.EqualFold(u.Scheme, Scheme) {
+		return nil, errors.New("not a reflex:// link: ", u.Scheme)
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return nil, errors.New("share link has no user ID")
+	}
+	l := &Link{
+		ID:      u.User.Username(),
+		Address: u.Hostname(),
+		Name:    u.Fragment,
+	}
+	if _, err := uuid.ParseString(l.ID); err != nil {
+		return nil, errors.New("invalid user ID in share link: ", l.ID).Base(err)
+	}
+	if l.Address == "" {
+		return nil, errors.New("share link has no server address")
+	}
+	port, err := strconv.ParseUint(u.Port(), 10, 16)
+	if err != nil || port == 0 {
+		return nil, errors.New("invalid port in share link: ", u.Port())
+	}
+	l.Port = uint32(port)
+
+	query := u.Query()
+	for _, p := range l.params() {
+		*p.value = query.Get(p.key)
+	}
+	switch l.Security {
+	case "", "none", "tls", "reality":
+	default:
+		return nil, errors.New("unknown security in share link: ", l.Security)
+	}
+	if l.Security == "reality" && l.PublicKey == "" {
+		return nil, errors.New("REALITY share link has no public key")
+	}
+	return l, nil
+}
+
+// String returns the link in reflex:// form. Empty parameters are omitted.
+func (l *Link) String() string {
+	query := make([]string, 0, 4)
+	for _, p := range l.params() {
+		if *p.value != "" {
+			query = append(query, p.key+"="+url.QueryEscape(*p.value))
+		}
+	}
+	u := &url.URL{
+		Scheme:   Scheme,
+		User:     url.User(l.ID),
+		Host:     net.JoinHostPort(l.Address, strconv.FormatUint(uint64(l.Port), 10)),
+		RawQuery: strings.Join(query, "&"),
+		Fragment: l.Name,
+	}
+	return u.String()
+}
@@ -0,0 +1,97 @@
+package sharelink_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/xtls/xray-core/common"
+	. "github.com/xtls/xray-core/proxy/reflex/sharelink"
+)
+
+// TestLinkRoundTrip tests that links survive String and Parse unchanged
+func TestLinkRoundTrip(t *testing.T) {
+	links := []*Link{
+		{
+			ID:      "27848739-7e62-4138-9fd3-098a63964b6b",
+			Address: "example.com",
+			Port:    443,
+		},
+		{
+			ID:       "27848739-7e62-4138-9fd3-098a63964b6b",
+			Address:  "2001:db8::1",
+			Port:     8443,
+			Name:     "love@example.com #1",
+			Policy:   "youtube",
+			Network:  "ws",
+			Security: "tls",
+			SNI:      "cdn.example.com",
+			ALPN:     "h2,http/1.1",
+			Host:     "cdn.example.com",
+			Path:     "/reflex?ed=2048",
+			ECH:      "AEX+DQBBAAAgACA=",
+		},
+		{
+			ID:          "27848739-7e62-4138-9fd3-098a63964b6b",
+			Address:     "192.0.2.1",
+			Port:        443,
+			Policy:      "zoom",
+			Network:     "grpc",
+			ServiceName: "api",
+			Mode:        "multi",
+			Security:    "reality",
+			SNI:         "www.example.com",
+			Fingerprint: "chrome",
+			PublicKey:   "E59WjnvZcQMu7tR7_BgyhycuEdBS-CtKxfImRCdAvFM",
+			ShortID:     "0123456789abcdef",
+			SpiderX:     "/",
+		},
+	}
+	for _, link := range links {
+		parsed, err := Parse(link.String())
+		if err != nil {
+			t.Fatal(link.String(), ": ", err)
+		}
+		if !reflect.DeepEqual(parsed, link) {
+			t.Fatalf("round trip of %s: got %+v, want %+v", link.String(), parsed, link)
+		}
+	}
+}
+
+// TestParseLink tests parsing a hand-written link
+func TestParseLink(t *testing.T) {
+	link, err := Parse("reflex://27848739-7e62-4138-9fd3-098a63964b6b@example.com:443?policy=http2-api&type=xhttp&mode=stream-one&path=%2Fr&security=tls&sni=example.com#my%20server")
+	common.Must(err)
+	expected := &Link{
+		ID:       "27848739-7e62-4138-9fd3-098a63964b6b",
+		Address:  "example.com",
+		Port:     443,
+		Name:     "my server",
+		Policy:   "http2-api",
+		Network:  "xhttp",
+		Mode:     "stream-one",
+		Path:     "/r",
+		Security: "tls",
+		SNI:      "example.com",
+	}
+	if !reflect.DeepEqual(link, expected) {
+		t.Fatalf("got %+v, want %+v", link, expected)
+	}
+}
+
+// TestParseLinkErrors tests that malformed links are rejected
+func TestParseLinkErrors(t *testing.T) {
+	for _, link := range []string{
+		"vless://27848739-7e62-4138-9fd3-098a63964b6b@example.com:443",
+		"reflex://example.com:443",
+		"reflex://not-a-uuid-and-too-long-to-be-mapped-to-one@example.com:443",
+		"reflex://27848739-7e62-4138-9fd3-098a63964b6b@example.com",
+		"reflex://27848739-7e62-4138-9fd3-098a63964b6b@example.com:0",
+		"reflex://27848739-7e62-4138-9fd3-098a63964b6b@:443",
+		"reflex://27848739-7e62-4138-9fd3-098a63964b6b@example.com:443?security=xtls",
+		"reflex://27848739-7e62-4138-9fd3-098a63964b6b@example.com:443?security=reality",
+	} {
+		if _, err := Parse(link); err == nil {
+			t.Error("expected error for ", link)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package reflex
 
 import (
+	"strings"
 	"sync"
 
 	"github.com/xtls/xray-core/common/errors"
@@ -26,7 +27,10 @@ func (v *Validator) Add(u *protocol.MemoryUser) error {
 	v.Lock()
 	defer v.Unlock()
 
-	account := u.Account.(*MemoryAccount)
+	account, ok := u.Account.(*MemoryAccount)
+	if !ok {
+		return errors.New("not a Reflex account")
+	}
 	idBytes := account.ID.Bytes()
 	var idArray [16]byte
 	copy(idArray[:], idBytes)
@@ -58,13 +62,65 @@ func (v *Validator) GetByUUID(id string) (*protocol.MemoryUser, error) {
 	return v.Get(idArray)
 }
 
+// GetByEmail retrieves a user by email, or nil if there is none
+func (v *Validator) GetByEmail(email string) *protocol.MemoryUser {
+	v.RLock()
+	defer v.RUnlock()
+
+	for _, user := range v.users {
+		if strings.EqualFold(user.Email, email) {
+			return user
+		}
+	}
+	return nil
+}
+
+// GetAll returns all users
+func (v *Validator) GetAll() []*protocol.MemoryUser {
+	v.RLock()
+	defer v.RUnlock()
+
+	users := make([]*protocol.MemoryUser, 0, len(v.users))
+	for _, user := range v.users {
+		users = append(users, user)
+	}
+	return users
+}
+
+// GetCount returns the number of users
+func (v *Validator) GetCount() int64 {
+	v.RLock()
+	defer v.RUnlock()
+
+	return int64(len(v.users))
+}
+
+// Delete removes the user u, returning false if it is not the user stored
+// under its ID
+func (v *Validator) Delete(u *protocol.MemoryUser) bool {
+	v.Lock()
+	defer v.Unlock()
+
+	account, ok := u.Account.(*MemoryAccount)
+	if !ok {
+		return false
+	}
+	var idArray [16]byte
+	copy(idArray[:], account.ID.Bytes())
+	if v.users[idArray] != u {
+		return false
+	}
+	delete(v.users, idArray)
+	return true
+}
+
 // Remove removes a user from the validator
 func (v *Validator) Remove(email string) error {
 	v.Lock()
 	defer v.Unlock()
 
 	for id, user := range v.users {
-		if user.Email == email {
+		if strings.EqualFold(user.Email, email) {
 			delete(v.users, id)
 			return nil
 		}
@@ -478,3 +478,74 @@ func TestValidatorUUIDBytes(t *testing.T) {
 		t.Fatal("UUID bytes should match array")
 	}
 }
+
+// TestValidatorGetByEmail tests retrieving, listing and counting users
+func TestValidatorGetByEmail(t *testing.T) {
+	validator := NewValidator()
+
+	for _, s := range []struct {
+		id    string
+		email string
+	}{
+		{"b831381d-6324-4d53-ad4f-8cda48b30811", "alice@example.com"},
+		{"c831381d-6324-4d53-ad4f-8cda48b30812", "bob@example.com"},
+	} {
+		id, _ := uuid.ParseString(s.id)
+		if err := validator.Add(&protocol.MemoryUser{
+			Account: &MemoryAccount{ID: protocol.NewID(id)},
+			Email:   s.email,
+		}); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+
+	if user := validator.GetByEmail("Alice@Example.com"); user == nil || user.Email != "alice@example.com" {
+		t.Fatalf("expected alice, got %v", user)
+	}
+	if user := validator.GetByEmail("carol@example.com"); user != nil {
+		t.Fatalf("expected no user, got %v", user)
+	}
+	if count := validator.GetCount(); count != 2 {
+		t.Fatalf("expected 2 users, got %d", count)
+	}
+	if users := validator.GetAll(); len(users) != 2 {
+		t.Fatalf("expected 2 users, got %d", len(users))
+	}
+
+	if err := validator.Remove("bob@example.com"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if count := validator.GetCount(); count != 1 {
+		t.Fatalf("expected 1 user after removal, got %d", count)
+	}
+}
+
+// TestValidatorAddWrongAccount tests that non-Reflex accounts are rejected
+func TestValidatorAddWrongAccount(t *testing.T) {
+	validator := NewValidator()
+
+	if err := validator.Add(&protocol.MemoryUser{Email: "test@example.com"}); err == nil {
+		t.Fatal("should reject a user without a Reflex account")
+	}
+}
+
+// TestValidatorDelete tests that Delete only removes the exact user stored
+// under its ID
+func TestValidatorDelete(t *testing.T) {
+	validator := NewValidator()
+	id, _ := uuid.ParseString("b831381d-6324-4d53-ad4f-8cda48b30811")
+	old := &protocol.MemoryUser{Account: &MemoryAccount{ID: protocol.NewID(id)}}
+	replacement := &protocol.MemoryUser{Account: &MemoryAccount{ID: protocol.NewID(id)}}
+
+	validator.Add(old)
+	validator.Add(replacement)
+	if validator.Delete(old) {
+		t.Fatal("deleted a user replaced since")
+	}
+	if !validator.Delete(replacement) {
+		t.Fatal("failed to delete the stored user")
+	}
+	if count := validator.GetCount(); count != 0 {
+		t.Fatalf("expected no users, got %d", count)
+	}
+}
@@ -0,0 +1,1545 @@
+package scenarios
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/app/commander"
+	"github.com/xtls/xray-core/app/log"
+	"github.com/xtls/xray-core/app/policy"
+	"github.com/xtls/xray-core/app/proxyman"
+	"github.com/xtls/xray-core/app/proxyman/command"
+	"github.com/xtls/xray-core/app/reverse"
+	"github.com/xtls/xray-core/app/router"
+	"github.com/xtls/xray-core/app/stats"
+	statscmd "github.com/xtls/xray-core/app/stats/command"
+	"github.com/xtls/xray-core/common"
+	clog "github.com/xtls/xray-core/common/log"
+	"github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/protocol"
+	"github.com/xtls/xray-core/common/serial"
+	"github.com/xtls/xray-core/common/uuid"
+	core "github.com/xtls/xray-core/core"
+	"github.com/xtls/xray-core/proxy/dokodemo"
+	"github.com/xtls/xray-core/proxy/freedom"
+	"github.com/xtls/xray-core/proxy/reflex"
+	reflexcmd "github.com/xtls/xray-core/proxy/reflex/command"
+	"github.com/xtls/xray-core/proxy/reflex/encoding"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+	"github.com/xtls/xray-core/proxy/reflex/outbound"
+	"github.com/xtls/xray-core/testing/servers/tcp"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func reflexServerConfig(serverPort net.Port, userID *protocol.ID, config *inbound.Config, apps ...*serial.TypedMessage) *core.Config {
+	config.Clients = append(config.Clients, &protocol.User{
+		Email: "reflex@example.com",
+		Account: serial.ToTypedMessage(&reflex.Account{
+			Id: userID.String(),
+		}),
+	})
+	return &core.Config{
+		App: append([]*serial.TypedMessage{
+			serial.ToTypedMessage(&log.Config{
+				ErrorLogLevel: clog.Severity_Debug,
+				ErrorLogType:  log.LogType_Console,
+			}),
+		}, apps...),
+		Inbound: []*core.InboundHandlerConfig{
+			{
+				Tag: "reflex",
+				ReceiverSettings: serial.ToTypedMessage(&proxyman.ReceiverConfig{
+					PortList: &net.PortList{Range: []*net.PortRange{net.SinglePortRange(serverPort)}},
+					Listen:   net.NewIPOrDomain(net.LocalHostIP),
+				}),
+				ProxySettings: serial.ToTypedMessage(config),
+			},
+		},
+		Outbound: []*core.OutboundHandlerConfig{
+			{
+				ProxySettings: serial.ToTypedMessage(&freedom.Config{}),
+			},
+		},
+	}
+}
+
+func reflexClientConfig(clientPort net.Port, serverPort net.Port, dest net.Destination, userID *protocol.ID, config *outbound.Config) *core.Config {
+	config.Vnext = append(config.Vnext, &protocol.ServerEndpoint{
+		Address: net.NewIPOrDomain(net.LocalHostIP),
+		Port:    uint32(serverPort),
+		User: &protocol.User{
+			Account: serial.ToTypedMessage(&reflex.Account{
+				Id: userID.String(),
+			}),
+		},
+	})
+	return &core.Config{
+		App: []*serial.TypedMessage{
+			serial.ToTypedMessage(&log.Config{
+				ErrorLogLevel: clog.Severity_Debug,
+				ErrorLogType:  log.LogType_Console,
+			}),
+		},
+		Inbound: []*core.InboundHandlerConfig{
+			{
+				ReceiverSettings: serial.ToTypedMessage(&proxyman.ReceiverConfig{
+					PortList: &net.PortList{Range: []*net.PortRange{net.SinglePortRange(clientPort)}},
+					Listen:   net.NewIPOrDomain(net.LocalHostIP),
+				}),
+				ProxySettings: serial.ToTypedMessage(&dokodemo.Config{
+					Address:  net.NewIPOrDomain(dest.Address),
+					Port:     uint32(dest.Port),
+					Networks: []net.Network{net.Network_TCP},
+				}),
+			},
+		},
+		Outbound: []*core.OutboundHandlerConfig{
+			{
+				ProxySettings: serial.ToTypedMessage(config),
+			},
+		},
+	}
+}
+
+// withReflexAPI adds a commander serving the given services on cmdPort.
+func withReflexAPI(config *core.Config, cmdPort net.Port, dest net.Destination, services ...*serial.TypedMessage) *core.Config {
+	config.App = append(config.App,
+		serial.ToTypedMessage(&commander.Config{
+			Tag:     "api",
+			Service: services,
+		}),
+		serial.ToTypedMessage(&router.Config{
+			Rule: []*router.RoutingRule{
+				{
+					InboundTag: []string{"api"},
+					TargetTag: &router.RoutingRule_Tag{
+						Tag: "api",
+					},
+				},
+			},
+		}))
+	config.Inbound = append(config.Inbound, &core.InboundHandlerConfig{
+		Tag: "api",
+		ReceiverSettings: serial.ToTypedMessage(&proxyman.ReceiverConfig{
+			PortList: &net.PortList{Range: []*net.PortRange{net.SinglePortRange(cmdPort)}},
+			Listen:   net.NewIPOrDomain(net.LocalHostIP),
+		}),
+		ProxySettings: serial.ToTypedMessage(&dokodemo.Config{
+			Address:  net.NewIPOrDomain(dest.Address),
+			Port:     uint32(dest.Port),
+			Networks: []net.Network{net.Network_TCP},
+		}),
+	})
+	return config
+}
+
+func TestReflex(t *testing.T) {
+	tcpServer := tcp.Server{
+		MsgProcessor: xor,
+	}
+	dest, err := tcpServer.Start()
+	common.Must(err)
+	defer tcpServer.Close()
+
+	userID := protocol.NewID(uuid.New())
+	serverPort := tcp.PickPort()
+	serverConfig := reflexServerConfig(serverPort, userID, &inbound.Config{})
+
+	clientPort := tcp.PickPort()
+	clientConfig := reflexClientConfig(clientPort, serverPort, dest, userID, &outbound.Config{})
+
+	servers, err := InitializeServerConfigs(serverConfig, clientConfig)
+	common.Must(err)
+	defer CloseAllServers(servers)
+
+	var errg errgroup.Group
+	for range 3 {
+		errg.Go(testTCPConn(clientPort, 1024*1024, time.Second*30))
+	}
+	if err := errg.Wait(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReflexIdleTimeout(t *testing.T) {
+	tcpServer := tcp.Server{
+		MsgProcessor: xor,
+	}
+	dest, err := tcpServer.Start()
+	common.Must(err)
+	defer tcpServer.Close()
+
+	userID := protocol.NewID(uuid.New())
+	serverPort := tcp.PickPort()
+	serverConfig := reflexServerConfig(serverPort, userID, &inbound.Config{},
+		serial.ToTypedMessage(&policy.Config{
+			Level: map[uint32]*policy.Policy{
+				0: {
+					Timeout: &policy.Policy_Timeout{
+						ConnectionIdle: &policy.Second{Value: 2},
+					},
+				},
+			},
+		}))
+
+	clientPort := tcp.PickPort()
+	clientConfig := reflexClientConfig(clientPort, serverPort, dest, userID, &outbound.Config{})
+
+	servers, err := InitializeServerConfigs(serverConfig, clientConfig)
+	common.Must(err)
+	defer CloseAllServers(servers)
+
+	conn, err := net.DialTCP("tcp", nil, &net.TCPAddr{
+		IP:   []byte{127, 0, 0, 1},
+		Port: int(clientPort),
+	})
+	common.Must(err)
+	defer conn.Close()
+
+	if err := testTCPConn2(conn, 1024, time.Second*5)(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The idle session must be torn down by the server well before the
+	// default 300s idle timeout.
+	start := time.Now()
+	conn.SetReadDeadline(time.Now().Add(time.Second * 10))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatal("expected EOF on idle session, but got ", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second*6 {
+		t.Error("idle session closed after ", elapsed)
+	}
+}
+
+func TestReflexKeepAlive(t *testing.T) {
+	tcpServer := tcp.Server{
+		MsgProcessor: xor,
+	}
+	dest, err := tcpServer.Start()
+	common.Must(err)
+	defer tcpServer.Close()
+
+	userID := protocol.NewID(uuid.New())
+	serverPort := tcp.PickPort()
+	serverConfig := reflexServerConfig(serverPort, userID, &inbound.Config{KeepAliveInterval: 1})
+
+	clientPort := tcp.PickPort()
+	clientConfig := reflexClientConfig(clientPort, serverPort, dest, userID, &outbound.Config{KeepAliveInterval: 1})
+
+	servers, err := InitializeServerConfigs(serverConfig, clientConfig)
+	common.Must(err)
+	defer CloseAllServers(servers)
+
+	conn, err := net.DialTCP("tcp", nil, &net.TCPAddr{
+		IP:   []byte{127, 0, 0, 1},
+		Port: int(clientPort),
+	})
+	common.Must(err)
+	defer conn.Close()
+
+	if err := testTCPConn2(conn, 1024, time.Second*5)(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Both ends exchange PING/PONG frames while the session is idle; the
+	// session must survive them and keep carrying data.
+	time.Sleep(time.Second * 4)
+
+	if err := testTCPConn2(conn, 64*1024, time.Second*5)(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReflexClientHalfClose(t *testing.T) {
+	tcpServer := tcp.Server{
+		MsgProcessor: xor,
+	}
+	dest, err := tcpServer.Start()
+	common.Must(err)
+	defer tcpServer.Close()
+
+	userID := protocol.NewID(uuid.New())
+	serverPort := tcp.PickPort()
+	serverConfig := reflexServerConfig(serverPort, userID, &inbound.Config{})
+
+	clientPort := tcp.PickPort()
+	clientConfig := reflexClientConfig(clientPort, serverPort, dest, userID, &outbound.Config{})
+
+	servers, err := InitializeServerConfigs(serverConfig, clientConfig)
+	common.Must(err)
+	defer CloseAllServers(servers)
+
+	conn, err := net.DialTCP("tcp", nil, &net.TCPAddr{
+		IP:   []byte{127, 0, 0, 1},
+		Port: int(clientPort),
+	})
+	common.Must(err)
+	defer conn.Close()
+
+	// Upload everything, then half-close like `nc -N` does. The echo server
+	// only closes after it sees EOF, so the full response followed by EOF
+	// proves the FIN travelled all the way to the target and back.
+	payload := make([]byte, 512*1024)
+	common.Must2(rand.Read(payload))
+	if _, err := conn.Write(payload); err != nil {
+		t.Fatal(err)
+	}
+	common.Must(conn.CloseWrite())
+
+	conn.SetReadDeadline(time.Now().Add(time.Second * 20))
+	response, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(response, xor(payload)) {
+		t.Error("response mismatch, got ", len(response), " bytes")
+	}
+}
+
+func TestReflexServerHalfClose(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	common.Must(err)
+	defer listener.Close()
+
+	// The target sends a greeting and half-closes, then keeps reading the
+	// client's upload until EOF.
+	greeting := []byte("greeting")
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write(greeting)
+		conn.(*net.TCPConn).CloseWrite()
+		b, _ := io.ReadAll(conn)
+		received <- b
+	}()
+	dest := net.DestinationFromAddr(listener.Addr())
+
+	userID := protocol.NewID(uuid.New())
+	serverPort := tcp.PickPort()
+	serverConfig := reflexServerConfig(serverPort, userID, &inbound.Config{})
+
+	clientPort := tcp.PickPort()
+	clientConfig := reflexClientConfig(clientPort, serverPort, dest, userID, &outbound.Config{})
+
+	servers, err := InitializeServerConfigs(serverConfig, clientConfig)
+	common.Must(err)
+	defer CloseAllServers(servers)
+
+	conn, err := net.DialTCP("tcp", nil, &net.TCPAddr{
+		IP:   []byte{127, 0, 0, 1},
+		Port: int(clientPort),
+	})
+	common.Must(err)
+	defer conn.Close()
+
+	if response := readFrom(conn, time.Second*5, len(greeting)); !bytes.Equal(response, greeting) {
+		t.Fatal("unexpected greeting: ", string(response))
+	}
+
+	// The download direction is finished; the upload must still go through.
+	payload := make([]byte, 64*1024)
+	common.Must2(rand.Read(payload))
+	if _, err := conn.Write(payload); err != nil {
+		t.Fatal(err)
+	}
+	common.Must(conn.CloseWrite())
+
+	select {
+	case b := <-received:
+		if !bytes.Equal(b, payload) {
+			t.Error("target received ", len(b), " bytes, expected ", len(payload))
+		}
+	case <-time.After(time.Second * 20):
+		t.Error("target did not receive the upload")
+	}
+}
+
+func TestReflexAddRemoveUser(t *testing.T) {
+	tcpServer := tcp.Server{
+		MsgProcessor: xor,
+	}
+	dest, err := tcpServer.Start()
+	common.Must(err)
+	defer tcpServer.Close()
+
+	u1 := protocol.NewID(uuid.New())
+	u2 := protocol.NewID(uuid.New())
+
+	cmdPort := tcp.PickPort()
+	serverPort := tcp.PickPort()
+	serverConfig := withReflexAPI(reflexServerConfig(serverPort, u1, &inbound.Config{}), cmdPort, dest,
+		serial.ToTypedMessage(&command.Config{}))
+
+	clientPort := tcp.PickPort()
+	clientConfig := reflexClientConfig(clientPort, serverPort, dest, u2, &outbound.Config{})
+
+	servers, err := InitializeServerConfigs(serverConfig, clientConfig)
+	common.Must(err)
+	defer CloseAllServers(servers)
+
+	if err := testTCPConn(clientPort, 1024, time.Second*5)(); err == nil {
+		t.Fatal("expected unknown user to be rejected")
+	}
+
+	cmdConn, err := grpc.Dial(fmt.Sprintf("127.0.0.1:%d", cmdPort), grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock())
+	common.Must(err)
+	defer cmdConn.Close()
+
+	hsClient := command.NewHandlerServiceClient(cmdConn)
+	_, err = hsClient.AlterInbound(context.Background(), &command.AlterInboundRequest{
+		Tag: "reflex",
+		Operation: serial.ToTypedMessage(
+			&command.AddUserOperation{
+				User: &protocol.User{
+					Email: "test@example.com",
+					Account: serial.ToTypedMessage(&reflex.Account{
+						Id:     u2.String(),
+						Policy: "youtube",
+					}),
+				},
+			}),
+	})
+	common.Must(err)
+
+	countResp, err := hsClient.GetInboundUsersCount(context.Background(), &command.GetInboundUserRequest{Tag: "reflex"})
+	common.Must(err)
+	if countResp.Count != 2 {
+		t.Error("expected 2 users, got ", countResp.Count)
+	}
+	usersResp, err := hsClient.GetInboundUsers(context.Background(), &command.GetInboundUserRequest{Tag: "reflex", Email: "test@example.com"})
+	common.Must(err)
+	if len(usersResp.Users) != 1 {
+		t.Fatal("expected 1 user, got ", len(usersResp.Users))
+	}
+	account, err := usersResp.Users[0].Account.GetInstance()
+	common.Must(err)
+	if a := account.(*reflex.Account); a.Id != u2.String() || a.Policy != "youtube" {
+		t.Error("unexpected account: ", a)
+	}
+
+	// Adding the same email again must fail.
+	if _, err := hsClient.AlterInbound(context.Background(), &command.AlterInboundRequest{
+		Tag: "reflex",
+		Operation: serial.ToTypedMessage(
+			&command.AddUserOperation{
+				User: &protocol.User{
+					Email: "test@example.com",
+					Account: serial.ToTypedMessage(&reflex.Account{
+						Id: protocol.NewID(uuid.New()).String(),
+					}),
+				},
+			}),
+	}); err == nil {
+		t.Error("expected duplicate email to be rejected")
+	}
+
+	conn, err := net.DialTCP("tcp", nil, &net.TCPAddr{
+		IP:   []byte{127, 0, 0, 1},
+		Port: int(clientPort),
+	})
+	common.Must(err)
+	defer conn.Close()
+
+	if err := testTCPConn2(conn, 1024, time.Second*5)(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = hsClient.AlterInbound(context.Background(), &command.AlterInboundRequest{
+		Tag:       "reflex",
+		Operation: serial.ToTypedMessage(&command.RemoveUserOperation{Email: "test@example.com"}),
+	})
+	common.Must(err)
+
+	// The active session of the removed user is closed.
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatal("expected EOF on removed user's session, but got ", err)
+	}
+
+	if err := testTCPConn(clientPort, 1024, time.Second*5)(); err == nil {
+		t.Fatal("expected removed user to be rejected")
+	}
+}
+
+func TestReflexExpiredUser(t *testing.T) {
+	tcpServer := tcp.Server{
+		MsgProcessor: xor,
+	}
+	dest, err := tcpServer.Start()
+	common.Must(err)
+	defer tcpServer.Close()
+
+	// The expired user is routed to the fallback like an unknown client.
+	fallback := tcp.Server{
+		MsgProcessor: func(b []byte) []byte { return []byte("HTTP/1.1 400 Bad Request\r\n\r\n") },
+	}
+	fallbackDest, err := fallback.Start()
+	common.Must(err)
+	defer fallback.Close()
+
+	userID := protocol.NewID(uuid.New())
+	serverPort := tcp.PickPort()
+	serverConfig := reflexServerConfig(serverPort, protocol.NewID(uuid.New()), &inbound.Config{
+		Clients: []*protocol.User{
+			{
+				Email: "expired@example.com",
+				Account: serial.ToTypedMessage(&reflex.Account{
+					Id:        userID.String(),
+					ExpiresAt: time.Now().Add(-time.Hour).Unix(),
+				}),
+			},
+		},
+		Fallbacks: []*inbound.Fallback{
+			{Dest: fallbackDest.NetAddr()},
+		},
+	})
+
+	clientPort := tcp.PickPort()
+	clientConfig := reflexClientConfig(clientPort, serverPort, dest, userID, &outbound.Config{})
+
+	servers, err := InitializeServerConfigs(serverConfig, clientConfig)
+	common.Must(err)
+	defer CloseAllServers(servers)
+
+	if err := testTCPConn(clientPort, 1024, time.Second*5)(); err == nil {
+		t.Fatal("expected expired user to be rejected")
+	}
+
+	// The raw handshake of the expired user reaches the fallback untouched.
+	conn, err := net.DialTCP("tcp", nil, &net.TCPAddr{
+		IP:   []byte{127, 0, 0, 1},
+		Port: int(serverPort),
+	})
+	common.Must(err)
+	defer conn.Close()
+
+	_, publicKey, err := encoding.GenerateKeyPair()
+	common.Must(err)
+	var id [16]byte
+	copy(id[:], userID.Bytes())
+	common.Must2(conn.Write(encoding.EncodeClientHandshake(&encoding.ClientHandshake{
+		PublicKey: publicKey,
+		UserID:    id,
+		Timestamp: time.Now().Unix(),
+	})))
+	if response := readFrom(conn, time.Second*5, 12); string(response) != "HTTP/1.1 400" {
+		t.Error("expected fallback response, got ", string(response))
+	}
+}
+
+func TestReflexQuota(t *testing.T) {
+	tcpServer := tcp.Server{
+		MsgProcessor: xor,
+	}
+	dest, err := tcpServer.Start()
+	common.Must(err)
+	defer tcpServer.Close()
+
+	const quota = 256 * 1024
+
+	userID := protocol.NewID(uuid.New())
+	cmdPort := tcp.PickPort()
+	serverPort := tcp.PickPort()
+	serverConfig := withReflexAPI(reflexServerConfig(serverPort, protocol.NewID(uuid.New()), &inbound.Config{
+		Clients: []*protocol.User{
+			{
+				Email: "quota@example.com",
+				Account: serial.ToTypedMessage(&reflex.Account{
+					Id:         userID.String(),
+					QuotaBytes: quota,
+				}),
+			},
+		},
+	}, serial.ToTypedMessage(&stats.Config{})), cmdPort, dest, serial.ToTypedMessage(&statscmd.Config{}))
+
+	clientPort := tcp.PickPort()
+	clientConfig := reflexClientConfig(clientPort, serverPort, dest, userID, &outbound.Config{})
+
+	servers, err := InitializeServerConfigs(serverConfig, clientConfig)
+	common.Must(err)
+	defer CloseAllServers(servers)
+
+	cmdConn, err := grpc.Dial(fmt.Sprintf("127.0.0.1:%d", cmdPort), grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock())
+	common.Must(err)
+	defer cmdConn.Close()
+	sClient := statscmd.NewStatsServiceClient(cmdConn)
+
+	remaining := func() int64 {
+		resp, err := sClient.GetStats(context.Background(), &statscmd.GetStatsRequest{
+			Name: "user>>>quota@example.com>>>traffic>>>remaining",
+		})
+		common.Must(err)
+		return resp.Stat.Value
+	}
+	if r := remaining(); r != quota {
+		t.Fatal("expected full quota before use, got ", r)
+	}
+
+	// 64KB up and 64KB down.
+	if err := testTCPConn(clientPort, 64*1024, time.Second*5)(); err != nil {
+		t.Fatal(err)
+	}
+	if r := remaining(); r != quota-128*1024 {
+		t.Error("expected ", quota-128*1024, " bytes remaining, got ", r)
+	}
+
+	// The session is closed once it runs over the quota.
+	if err := testTCPConn(clientPort, 256*1024, time.Second*5)(); err == nil {
+		t.Fatal("expected session over quota to fail")
+	}
+	if r := remaining(); r != 0 {
+		t.Error("expected quota to be used up, got ", r)
+	}
+
+	// Later sessions are rejected at handshake.
+	if err := testTCPConn(clientPort, 1024, time.Second*5)(); err == nil {
+		t.Fatal("expected user over quota to be rejected")
+	}
+}
+
+func TestReflexBan(t *testing.T) {
+	tcpServer := tcp.Server{
+		MsgProcessor: xor,
+	}
+	dest, err := tcpServer.Start()
+	common.Must(err)
+	defer tcpServer.Close()
+
+	fallback := tcp.Server{
+		MsgProcessor: func(b []byte) []byte { return []byte("HTTP/1.1 400 Bad Request\r\n\r\n") },
+	}
+	fallbackDest, err := fallback.Start()
+	common.Must(err)
+	defer fallback.Close()
+
+	userID := protocol.NewID(uuid.New())
+	cmdPort := tcp.PickPort()
+	serverPort := tcp.PickPort()
+	serverConfig := withReflexAPI(reflexServerConfig(serverPort, userID, &inbound.Config{
+		Fallbacks: []*inbound.Fallback{
+			{Dest: fallbackDest.NetAddr()},
+		},
+		Ban: &inbound.Ban{
+			MaxFailures: 2,
+			Duration:    60,
+		},
+	}), cmdPort, dest, serial.ToTypedMessage(&reflexcmd.Config{}))
+
+	clientPort := tcp.PickPort()
+	clientConfig := reflexClientConfig(clientPort, serverPort, dest, userID, &outbound.Config{})
+
+	servers, err := InitializeServerConfigs(serverConfig, clientConfig)
+	common.Must(err)
+	defer CloseAllServers(servers)
+
+	if err := testTCPConn(clientPort, 1024, time.Second*5)(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Handshakes of unknown users count as failures.
+	for range 2 {
+		conn, err := net.DialTCP("tcp", nil, &net.TCPAddr{
+			IP:   []byte{127, 0, 0, 1},
+			Port: int(serverPort),
+		})
+		common.Must(err)
+		_, publicKey, err := encoding.GenerateKeyPair()
+		common.Must(err)
+		var id [16]byte
+		copy(id[:], protocol.NewID(uuid.New()).Bytes())
+		common.Must2(conn.Write(encoding.EncodeClientHandshake(&encoding.ClientHandshake{
+			PublicKey: publicKey,
+			UserID:    id,
+			Timestamp: time.Now().Unix(),
+		})))
+		if response := readFrom(conn, time.Second*5, 12); string(response) != "HTTP/1.1 400" {
+			t.Error("expected fallback response, got ", string(response))
+		}
+		conn.Close()
+	}
+
+	cmdConn, err := grpc.Dial(fmt.Sprintf("127.0.0.1:%d", cmdPort), grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock())
+	common.Must(err)
+	defer cmdConn.Close()
+	rClient := reflexcmd.NewReflexServiceClient(cmdConn)
+
+	bans, err := rClient.GetBans(context.Background(), &reflexcmd.GetBansRequest{Tag: "reflex"})
+	common.Must(err)
+	if len(bans.Bans) != 1 || bans.Bans[0].Source != "127.0.0.1" || bans.Bans[0].Strikes != 1 {
+		t.Fatal("unexpected bans: ", bans.Bans)
+	}
+
+	// A banned source is sent to the fallback even with a valid user.
+	if err := testTCPConn(clientPort, 1024, time.Second*5)(); err == nil {
+		t.Fatal("expected banned source to be rejected")
+	}
+
+	cleared, err := rClient.ClearBans(context.Background(), &reflexcmd.ClearBansRequest{
+		Tag:     "reflex",
+		Sources: []string{"127.0.0.1"},
+	})
+	common.Must(err)
+	if cleared.Count != 1 {
+		t.Fatal("expected 1 ban lifted, got ", cleared.Count)
+	}
+
+	if err := testTCPConn(clientPort, 1024, time.Second*5)(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReflexMetrics(t *testing.T) {
+	tcpServer := tcp.Server{
+		MsgProcessor: xor,
+	}
+	dest, err := tcpServer.Start()
+	common.Must(err)
+	defer tcpServer.Close()
+
+	fallback := tcp.Server{
+		MsgProcessor: func(b []byte) []byte { return []byte("HTTP/1.1 400 Bad Request\r\n\r\n") },
+	}
+	fallbackDest, err := fallback.Start()
+	common.Must(err)
+	defer fallback.Close()
+
+	userID := protocol.NewID(uuid.New())
+	cmdPort := tcp.PickPort()
+	serverPort := tcp.PickPort()
+	serverConfig := withReflexAPI(reflexServerConfig(serverPort, userID, &inbound.Config{
+		Fallbacks: []*inbound.Fallback{
+			{Dest: fallbackDest.NetAddr()},
+		},
+	}, serial.ToTypedMessage(&stats.Config{})), cmdPort, dest, serial.ToTypedMessage(&statscmd.Config{}))
+
+	clientPort := tcp.PickPort()
+	clientConfig := reflexClientConfig(clientPort, serverPort, dest, userID, &outbound.Config{})
+
+	servers, err := InitializeServerConfigs(serverConfig, clientConfig)
+	common.Must(err)
+	defer CloseAllServers(servers)
+
+	if err := testTCPConn(clientPort, 1024, time.Second*5)(); err != nil {
+		t.Fatal(err)
+	}
+
+	dial := func() *net.TCPConn {
+		conn, err := net.DialTCP("tcp", nil, &net.TCPAddr{
+			IP:   []byte{127, 0, 0, 1},
+			Port: int(serverPort),
+		})
+		common.Must(err)
+		return conn
+	}
+	handshake := func(id *protocol.ID, timestamp int64) []byte {
+		_, publicKey, err := encoding.GenerateKeyPair()
+		common.Must(err)
+		hs := &encoding.ClientHandshake{
+			PublicKey: publicKey,
+			UserID:    encoding.UUIDToBytes(id),
+			Timestamp: timestamp,
+		}
+		common.Must2(rand.Read(hs.Nonce[:]))
+		return bytes.Clone(encoding.EncodeClientHandshake(hs))
+	}
+
+	// A valid handshake is answered, and sent again it is a replay.
+	recorded := handshake(userID, time.Now().Unix())
+	conn := dial()
+	common.Must2(conn.Write(recorded))
+	if response := readFrom(conn, time.Second*5, 40); len(response) != 40 {
+		t.Fatal("expected handshake response, got ", len(response), " bytes")
+	}
+	conn.Close()
+
+	// A hello with a bad timestamp is turned away like the other probes
+	for _, probe := range [][]byte{
+		recorded,
+		handshake(protocol.NewID(uuid.New()), time.Now().Unix()),
+		[]byte("GET / HTTP/1.1\r\nHost: example.com\r\nUser-Agent: Mozilla/5.0 (X11; Linux x86_64)\r\n\r\n"),
+		handshake(userID, time.Now().Add(-time.Hour).Unix()),
+	} {
+		conn := dial()
+		common.Must2(conn.Write(probe))
+		if response := readFrom(conn, time.Second*5, 12); string(response) != "HTTP/1.1 400" {
+			t.Error("expected fallback response, got ", string(response))
+		}
+		conn.Close()
+	}
+
+	cmdConn, err := grpc.Dial(fmt.Sprintf("127.0.0.1:%d", cmdPort), grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock())
+	common.Must(err)
+	defer cmdConn.Close()
+	sClient := statscmd.NewStatsServiceClient(cmdConn)
+
+	resp, err := sClient.QueryStats(context.Background(), &statscmd.QueryStatsRequest{
+		Pattern: "inbound>>>reflex>>>reflex>>>",
+	})
+	common.Must(err)
+	values := make(map[string]int64)
+	for _, stat := range resp.Stat {
+		values[strings.TrimPrefix(stat.Name, "inbound>>>reflex>>>reflex>>>")] = stat.Value
+	}
+	for name, expected := range map[string]int64{
+		"handshake.ok":                            2,
+		"handshake.replay":                        1,
+		"handshake.unknown_user":                  1,
+		"handshake.bad_time":                      1,
+		"fallback.unknown":                        3,
+		"fallback.http":                           1,
+		"fallback.dest." + fallbackDest.NetAddr(): 4,
+		"handshake_latency.le_inf":                2,
+		"bytes.data.http2-api":                    1024,
+	} {
+		if values[name] != expected {
+			t.Errorf("%s: expected %d, got %d", name, expected, values[name])
+		}
+	}
+}
+
+func TestReflexCover(t *testing.T) {
+	tcpServer := tcp.Server{
+		MsgProcessor: xor,
+	}
+	dest, err := tcpServer.Start()
+	common.Must(err)
+	defer tcpServer.Close()
+
+	cover := &reflex.Cover{Rate: 200, Size: 1400, IdleTimeout: 1}
+	userID := protocol.NewID(uuid.New())
+	serverPort := tcp.PickPort()
+	serverConfig := reflexServerConfig(serverPort, userID, &inbound.Config{})
+	serverConfig.Inbound[0].ProxySettings = serial.ToTypedMessage(&inbound.Config{
+		Clients: []*protocol.User{
+			{
+				Account: serial.ToTypedMessage(&reflex.Account{
+					Id:    userID.String(),
+					Cover: cover,
+				}),
+			},
+		},
+	})
+
+	clientPort := tcp.PickPort()
+	clientConfig := reflexClientConfig(clientPort, serverPort, dest, userID, &outbound.Config{
+		Vnext: []*protocol.ServerEndpoint{
+			{
+				Address: net.NewIPOrDomain(net.LocalHostIP),
+				Port:    uint32(serverPort),
+				User: &protocol.User{
+					Account: serial.ToTypedMessage(&reflex.Account{
+						Id:    userID.String(),
+						Cover: cover,
+					}),
+				},
+			},
+		},
+	})
+
+	servers, err := InitializeServerConfigs(serverConfig, clientConfig)
+	common.Must(err)
+	defer CloseAllServers(servers)
+
+	conn, err := net.DialTCP("tcp", nil, &net.TCPAddr{
+		IP:   []byte{127, 0, 0, 1},
+		Port: int(clientPort),
+	})
+	common.Must(err)
+	defer conn.Close()
+
+	if err := testTCPConn2(conn, 64*1024, time.Second*10)(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The cover traffic stops while idle and starts again with data
+	time.Sleep(time.Second * 2)
+	if err := testTCPConn2(conn, 1024, time.Second*5)(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReflexPolicyGrant(t *testing.T) {
+	tcpServer := tcp.Server{
+		MsgProcessor: xor,
+	}
+	dest, err := tcpServer.Start()
+	common.Must(err)
+	defer tcpServer.Close()
+
+	// The server forces its profile on the client, which asks for another
+	userID := protocol.NewID(uuid.New())
+	serverCmdPort := tcp.PickPort()
+	serverPort := tcp.PickPort()
+	serverConfig := withReflexAPI(reflexServerConfig(serverPort, userID, &inbound.Config{
+		PolicyOverrides: []*inbound.PolicyOverride{
+			{Policy: "zoom"},
+		},
+	}, serial.ToTypedMessage(&stats.Config{})), serverCmdPort, dest, serial.ToTypedMessage(&statscmd.Config{}))
+
+	clientCmdPort := tcp.PickPort()
+	clientPort := tcp.PickPort()
+	clientConfig := reflexClientConfig(clientPort, serverPort, dest, userID, &outbound.Config{
+		Vnext: []*protocol.ServerEndpoint{
+			{
+				Address: net.NewIPOrDomain(net.LocalHostIP),
+				Port:    uint32(serverPort),
+				User: &protocol.User{
+					Account: serial.ToTypedMessage(&reflex.Account{
+						Id:     userID.String(),
+						Policy: "youtube",
+					}),
+				},
+			},
+		},
+	})
+	clientConfig.App = append(clientConfig.App, serial.ToTypedMessage(&stats.Config{}))
+	clientConfig.Outbound[0].Tag = "proxy"
+	clientConfig = withReflexAPI(clientConfig, clientCmdPort, dest, serial.ToTypedMessage(&statscmd.Config{}))
+
+	servers, err := InitializeServerConfigs(serverConfig, clientConfig)
+	common.Must(err)
+	defer CloseAllServers(servers)
+
+	conn, err := net.DialTCP("tcp", nil, &net.TCPAddr{
+		IP:   []byte{127, 0, 0, 1},
+		Port: int(clientPort),
+	})
+	common.Must(err)
+	defer conn.Close()
+
+	// The grant arrives before the first answer, so the client sends the
+	// second round with the granted profile
+	for i := 0; i < 2; i++ {
+		if err := testTCPConn2(conn, 1024, time.Second*5)(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	queryBytes := func(cmdPort net.Port, prefix string) map[string]int64 {
+		cmdConn, err := grpc.Dial(fmt.Sprintf("127.0.0.1:%d", cmdPort), grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock())
+		common.Must(err)
+		defer cmdConn.Close()
+		resp, err := statscmd.NewStatsServiceClient(cmdConn).QueryStats(context.Background(), &statscmd.QueryStatsRequest{
+			Pattern: prefix + "bytes.data.",
+		})
+		common.Must(err)
+		values := make(map[string]int64)
+		for _, stat := range resp.Stat {
+			values[strings.TrimPrefix(stat.Name, prefix+"bytes.data.")] = stat.Value
+		}
+		return values
+	}
+
+	if values := queryBytes(serverCmdPort, "inbound>>>reflex>>>reflex>>>"); values["zoom"] != 2048 || values["youtube"] != 0 {
+		t.Errorf("server sent data with the wrong profile: %v", values)
+	}
+	if values := queryBytes(clientCmdPort, "outbound>>>proxy>>>reflex>>>"); values["zoom"] < 1024 {
+		t.Errorf("client did not switch to the granted profile: %v", values)
+	}
+}
+
+func TestReflexClockSkew(t *testing.T) {
+	fallback := tcp.Server{
+		MsgProcessor: func(b []byte) []byte { return []byte("HTTP/1.1 400 Bad Request\r\n\r\n") },
+	}
+	fallbackDest, err := fallback.Start()
+	common.Must(err)
+	defer fallback.Close()
+
+	userID := protocol.NewID(uuid.New())
+	serverPort := tcp.PickPort()
+	serverConfig := reflexServerConfig(serverPort, userID, &inbound.Config{
+		TimestampTolerance: 60,
+		MaxClockSkew:       7200,
+		Fallbacks: []*inbound.Fallback{
+			{Dest: fallbackDest.NetAddr()},
+		},
+		// A single failure bans the source, so the hello that widens the
+		// window must not count as one
+		Ban: &inbound.Ban{
+			MaxFailures: 1,
+		},
+	})
+
+	servers, err := InitializeServerConfigs(serverConfig)
+	common.Must(err)
+	defer CloseAllServers(servers)
+
+	// hello sends a hello of the user with a clock off by skew and returns
+	// the first length bytes the server sends back, if any
+	hello := func(skew time.Duration, length int) []byte {
+		conn, err := net.DialTCP("tcp", nil, &net.TCPAddr{
+			IP:   []byte{127, 0, 0, 1},
+			Port: int(serverPort),
+		})
+		common.Must(err)
+		defer conn.Close()
+		_, publicKey, err := encoding.GenerateKeyPair()
+		common.Must(err)
+		hs := &encoding.ClientHandshake{
+			PublicKey: publicKey,
+			UserID:    encoding.UUIDToBytes(userID),
+			Timestamp: time.Now().Add(skew).Unix(),
+		}
+		common.Must2(rand.Read(hs.Nonce[:]))
+		common.Must2(conn.Write(encoding.EncodeClientHandshake(hs)))
+		response, _ := readFrom2(conn, time.Second*5, length)
+		return response
+	}
+
+	// The first hello an hour off is sent to the fallback, and widens the
+	// window for the next ones
+	if response := hello(-time.Hour, 12); string(response) != "HTTP/1.1 400" {
+		t.Fatal("expected the first skewed hello to get the fallback, got ", string(response))
+	}
+	response := hello(-time.Hour, 40)
+	if response == nil {
+		t.Fatal("expected the second skewed hello to be accepted")
+	}
+	serverHS, err := encoding.DecodeServerHandshake(response)
+	common.Must(err)
+	if !encoding.ValidateTimestampWithin(serverHS.Timestamp, 5) {
+		t.Error("server did not send its own time: ", serverHS.Timestamp)
+	}
+
+	// Clocks off by more than the maximum skew never are
+	if response := hello(-3*time.Hour, 12); string(response) != "HTTP/1.1 400" {
+		t.Error("expected a hello beyond the maximum skew to get the fallback, got ", string(response))
+	}
+}
+
+func TestReflexPaddedHandshake(t *testing.T) {
+	tcpServer := tcp.Server{
+		MsgProcessor: xor,
+	}
+	dest, err := tcpServer.Start()
+	common.Must(err)
+	defer tcpServer.Close()
+
+	userID := protocol.NewID(uuid.New())
+	serverPort := tcp.PickPort()
+	serverConfig := reflexServerConfig(serverPort, userID, &inbound.Config{})
+
+	clientPort := tcp.PickPort()
+	clientConfig := reflexClientConfig(clientPort, serverPort, dest, userID, &outbound.Config{
+		PadHandshake: true,
+		HelloSplit: &outbound.HelloSplit{
+			Count:    3,
+			MinDelay: 5,
+			MaxDelay: 20,
+		},
+	})
+
+	servers, err := InitializeServerConfigs(serverConfig, clientConfig)
+	common.Must(err)
+	defer CloseAllServers(servers)
+
+	var errg errgroup.Group
+	for range 3 {
+		errg.Go(testTCPConn(clientPort, 10240, time.Second*30))
+	}
+	if err := errg.Wait(); err != nil {
+		t.Error(err)
+	}
+
+	// The response to a padded hello is padded to a packet size of the
+	// user's profile, the default one here
+	conn, err := net.DialTCP("tcp", nil, &net.TCPAddr{
+		IP:   []byte{127, 0, 0, 1},
+		Port: int(serverPort),
+	})
+	common.Must(err)
+	defer conn.Close()
+	_, publicKey, err := encoding.GenerateKeyPair()
+	common.Must(err)
+	hs := &encoding.ClientHandshake{
+		PublicKey: publicKey,
+		UserID:    encoding.UUIDToBytes(userID),
+		Timestamp: time.Now().Unix(),
+	}
+	common.Must2(rand.Read(hs.Nonce[:]))
+	hello, err := encoding.EncodePaddedClientHandshake(hs, 300)
+	common.Must(err)
+	common.Must2(conn.Write(hello))
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	_, response, err := encoding.ReadPaddedServerHandshake(conn)
+	common.Must(err)
+	switch len(response) {
+	case 200, 500, 1000, 1500:
+	default:
+		t.Error("response of ", len(response), " bytes is not padded to a packet size of the profile")
+	}
+}
+
+func TestReflexPaddedReplay(t *testing.T) {
+	fallback := tcp.Server{
+		MsgProcessor: func(b []byte) []byte { return []byte("HTTP/1.1 400 Bad Request\r\n\r\n") },
+	}
+	fallbackDest, err := fallback.Start()
+	common.Must(err)
+	defer fallback.Close()
+
+	userID := protocol.NewID(uuid.New())
+	serverPort := tcp.PickPort()
+	serverConfig := reflexServerConfig(serverPort, userID, &inbound.Config{
+		Fallbacks: []*inbound.Fallback{
+			{Dest: fallbackDest.NetAddr()},
+		},
+	})
+
+	servers, err := InitializeServerConfigs(serverConfig)
+	common.Must(err)
+	defer CloseAllServers(servers)
+
+	send := func(hello []byte) *net.TCPConn {
+		conn, err := net.DialTCP("tcp", nil, &net.TCPAddr{
+			IP:   []byte{127, 0, 0, 1},
+			Port: int(serverPort),
+		})
+		common.Must(err)
+		common.Must2(conn.Write(hello))
+		return conn
+	}
+
+	_, publicKey, err := encoding.GenerateKeyPair()
+	common.Must(err)
+	hs := &encoding.ClientHandshake{
+		PublicKey: publicKey,
+		UserID:    encoding.UUIDToBytes(userID),
+		Timestamp: time.Now().Unix(),
+	}
+	common.Must2(rand.Read(hs.Nonce[:]))
+	recorded, err := encoding.EncodePaddedClientHandshake(hs, 300)
+	common.Must(err)
+
+	conn := send(recorded)
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if _, _, err := encoding.ReadPaddedServerHandshake(conn); err != nil {
+		t.Fatal("expected handshake response: ", err)
+	}
+	conn.Close()
+
+	// Neither the padding nor the magic are authenticated, so a recorded
+	// hello with either changed is still the same hello
+	modified := bytes.Clone(recorded)
+	modified[len(modified)-1] ^= 0xff
+	plain := bytes.Clone(encoding.EncodeClientHandshake(hs))
+	unpadded, err := encoding.EncodePaddedClientHandshake(hs, 0)
+	common.Must(err)
+
+	for name, probe := range map[string][]byte{
+		"replayed":         recorded,
+		"modified padding": modified,
+		"plain magic":      plain,
+		"no padding":       unpadded,
+	} {
+		conn := send(probe)
+		if response := readFrom(conn, time.Second*5, 12); string(response) != "HTTP/1.1 400" {
+			t.Error(name, ": expected fallback response, got ", string(response))
+		}
+		conn.Close()
+	}
+}
+
+// severableRelay forwards TCP connections to dest until Sever cuts them all.
+type severableRelay struct {
+	listener net.Listener
+	dest     string
+
+	access   sync.Mutex
+	conns    []net.Conn
+	accepted int
+}
+
+func newSeverableRelay(dest string) *severableRelay {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	common.Must(err)
+	r := &severableRelay{listener: listener, dest: dest}
+	go r.serve()
+	return r
+}
+
+func (r *severableRelay) serve() {
+	for {
+		conn, err := r.listener.Accept()
+		if err != nil {
+			return
+		}
+		upstream, err := net.Dial("tcp", r.dest)
+		if err != nil {
+			conn.Close()
+			continue
+		}
+		r.access.Lock()
+		r.conns = append(r.conns, conn, upstream)
+		r.accepted++
+		r.access.Unlock()
+		go func() {
+			io.Copy(upstream, conn)
+			upstream.Close()
+		}()
+		go func() {
+			io.Copy(conn, upstream)
+			conn.Close()
+		}()
+	}
+}
+
+func (r *severableRelay) Port() net.Port {
+	return net.Port(r.listener.Addr().(*net.TCPAddr).Port)
+}
+
+// Sever resets all relayed connections, as a middlebox injecting RSTs would.
+func (r *severableRelay) Sever() {
+	r.access.Lock()
+	defer r.access.Unlock()
+	for _, conn := range r.conns {
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			tcpConn.SetLinger(0)
+		}
+		conn.Close()
+	}
+	r.conns = nil
+}
+
+func (r *severableRelay) Accepted() int {
+	r.access.Lock()
+	defer r.access.Unlock()
+	return r.accepted
+}
+
+func (r *severableRelay) Close() {
+	r.listener.Close()
+	r.Sever()
+}
+
+func TestReflexResume(t *testing.T) {
+	tcpServer := tcp.Server{
+		MsgProcessor: xor,
+	}
+	dest, err := tcpServer.Start()
+	common.Must(err)
+	defer tcpServer.Close()
+
+	userID := protocol.NewID(uuid.New())
+	serverPort := tcp.PickPort()
+	serverConfig := reflexServerConfig(serverPort, userID, &inbound.Config{ResumeGracePeriod: 30})
+
+	relay := newSeverableRelay(fmt.Sprintf("127.0.0.1:%d", serverPort))
+	defer relay.Close()
+
+	clientPort := tcp.PickPort()
+	clientConfig := reflexClientConfig(clientPort, relay.Port(), dest, userID, &outbound.Config{Resume: true})
+
+	servers, err := InitializeServerConfigs(serverConfig, clientConfig)
+	common.Must(err)
+	defer CloseAllServers(servers)
+
+	conn, err := net.DialTCP("tcp", nil, &net.TCPAddr{
+		IP:   []byte{127, 0, 0, 1},
+		Port: int(clientPort),
+	})
+	common.Must(err)
+	defer conn.Close()
+
+	payload := make([]byte, 4*1024*1024)
+	common.Must2(rand.Read(payload))
+	go func() {
+		for sent := 0; sent < len(payload); sent += 64 * 1024 {
+			if _, err := conn.Write(payload[sent : sent+64*1024]); err != nil {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	// Reset the connection to the server once part of the echo came back;
+	// the application connection must not notice.
+	conn.SetReadDeadline(time.Now().Add(time.Second * 30))
+	response := make([]byte, len(payload))
+	if _, err := io.ReadFull(conn, response[:len(payload)/4]); err != nil {
+		t.Fatal(err)
+	}
+	relay.Sever()
+	if _, err := io.ReadFull(conn, response[len(payload)/4:]); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(response, xor(payload)) {
+		t.Error("response mismatch after resumption")
+	}
+	if relay.Accepted() < 2 {
+		t.Error("session was not resumed on a new connection")
+	}
+}
+
+func TestReflexPool(t *testing.T) {
+	tcpServer := tcp.Server{
+		MsgProcessor: xor,
+	}
+	dest, err := tcpServer.Start()
+	common.Must(err)
+	defer tcpServer.Close()
+
+	userID := protocol.NewID(uuid.New())
+	serverPort := tcp.PickPort()
+	serverConfig := reflexServerConfig(serverPort, userID, &inbound.Config{})
+
+	relay := newSeverableRelay(fmt.Sprintf("127.0.0.1:%d", serverPort))
+	defer relay.Close()
+
+	clientPort := tcp.PickPort()
+	clientConfig := reflexClientConfig(clientPort, relay.Port(), dest, userID, &outbound.Config{
+		Pool: &outbound.Pool{Size: 2},
+	})
+
+	servers, err := InitializeServerConfigs(serverConfig, clientConfig)
+	common.Must(err)
+	defer CloseAllServers(servers)
+
+	// The pool fills as the client starts, and requests take its connections
+	time.Sleep(time.Millisecond * 500)
+	for range 4 {
+		if err := testTCPConn(clientPort, 1024, time.Second*10)(); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond * 200)
+	}
+	// The two connections of the filled pool, and one refill for each request
+	if accepted := relay.Accepted(); accepted != 2+4 {
+		t.Error("expected 6 connections to the server, got ", accepted)
+	}
+
+	// Idle connections the network resets leave the pool and are replaced
+	relay.Sever()
+	time.Sleep(time.Millisecond * 500)
+	if err := testTCPConn(clientPort, 1024, time.Second*10)(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReflexUserStore(t *testing.T) {
+	tcpServer := tcp.Server{
+		MsgProcessor: xor,
+	}
+	dest, err := tcpServer.Start()
+	common.Must(err)
+	defer tcpServer.Close()
+
+	u1 := protocol.NewID(uuid.New())
+	u2 := protocol.NewID(uuid.New())
+	storePath := filepath.Join(t.TempDir(), "users.json")
+	writeUsers := func(content string) {
+		// Let the modification time move on from the last write
+		time.Sleep(time.Millisecond * 10)
+		common.Must(os.WriteFile(storePath, []byte(content), 0o600))
+	}
+	writeUsers(`[{"id": "` + u2.String() + `", "email": "stored@example.com"}]`)
+
+	serverPort := tcp.PickPort()
+	serverConfig := reflexServerConfig(serverPort, u1, &inbound.Config{
+		UserStore: &inbound.UserStore{Path: storePath, ReloadInterval: 1},
+	})
+
+	clientPort := tcp.PickPort()
+	clientConfig := reflexClientConfig(clientPort, serverPort, dest, u2, &outbound.Config{})
+
+	servers, err := InitializeServerConfigs(serverConfig, clientConfig)
+	common.Must(err)
+	defer CloseAllServers(servers)
+
+	conn, err := net.DialTCP("tcp", nil, &net.TCPAddr{
+		IP:   []byte{127, 0, 0, 1},
+		Port: int(clientPort),
+	})
+	common.Must(err)
+	defer conn.Close()
+	if err := testTCPConn2(conn, 1024, time.Second*5)(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A broken file leaves the stored user in place
+	writeUsers(`[{"id": "` + u2.String() + `", "policy": "netflix"}]`)
+	time.Sleep(time.Second * 2)
+	if err := testTCPConn2(conn, 1024, time.Second*5)(); err != nil {
+		t.Fatal("session of the stored user broken by a failed reload: ", err)
+	}
+
+	// The session of a user removed from the file is closed
+	writeUsers(`[]`)
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatal("expected EOF on removed user's session, but got ", err)
+	}
+	if err := testTCPConn(clientPort, 1024, time.Second*5)(); err == nil {
+		t.Fatal("expected removed user to be rejected")
+	}
+}
+
+func TestReflexKeyLog(t *testing.T) {
+	tcpServer := tcp.Server{
+		MsgProcessor: xor,
+	}
+	dest, err := tcpServer.Start()
+	common.Must(err)
+	defer tcpServer.Close()
+
+	dir := t.TempDir()
+	userID := protocol.NewID(uuid.New())
+	serverPort := tcp.PickPort()
+	serverConfig := reflexServerConfig(serverPort, userID, &inbound.Config{
+		KeyLog: filepath.Join(dir, "server.log"),
+	})
+
+	clientPort := tcp.PickPort()
+	clientConfig := reflexClientConfig(clientPort, serverPort, dest, userID, &outbound.Config{
+		KeyLog: filepath.Join(dir, "client.log"),
+	})
+
+	servers, err := InitializeServerConfigs(serverConfig, clientConfig)
+	common.Must(err)
+	defer CloseAllServers(servers)
+
+	for range 2 {
+		if err := testTCPConn(clientPort, 1024, time.Second*5)(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Both ends log the same key for each connection
+	read := func(name string) map[[32]byte][]byte {
+		file, err := os.Open(filepath.Join(dir, name))
+		common.Must(err)
+		defer file.Close()
+		keys, err := reflex.ReadKeyLog(file)
+		common.Must(err)
+		return keys
+	}
+	serverKeys, clientKeys := read("server.log"), read("client.log")
+	if len(serverKeys) != 2 || len(clientKeys) != 2 {
+		t.Fatalf("expected 2 keys on each end, got %d and %d", len(serverKeys), len(clientKeys))
+	}
+	for publicKey, key := range clientKeys {
+		if !bytes.Equal(serverKeys[publicKey], key) {
+			t.Errorf("server logged %x for client key %x, expected %x", serverKeys[publicKey], publicKey, key)
+		}
+	}
+}
+
+func TestReflexReverseProxy(t *testing.T) {
+	tcpServer := tcp.Server{
+		MsgProcessor: xor,
+	}
+	dest, err := tcpServer.Start()
+	common.Must(err)
+	defer tcpServer.Close()
+
+	userID := protocol.NewID(uuid.New())
+	externalPort := tcp.PickPort()
+	reversePort := tcp.PickPort()
+
+	// The portal exposes the service the bridge reaches through the Reflex
+	// connections it opens to the server
+	serverConfig := reflexServerConfig(reversePort, userID, &inbound.Config{},
+		serial.ToTypedMessage(&reverse.Config{
+			PortalConfig: []*reverse.PortalConfig{
+				{
+					Tag:    "portal",
+					Domain: "reverse.example.com",
+				},
+			},
+		}),
+		serial.ToTypedMessage(&router.Config{
+			Rule: []*router.RoutingRule{
+				{
+					Domain: []*router.Domain{
+						{Type: router.Domain_Full, Value: "reverse.example.com"},
+					},
+					TargetTag: &router.RoutingRule_Tag{
+						Tag: "portal",
+					},
+				},
+				{
+					InboundTag: []string{"external"},
+					TargetTag: &router.RoutingRule_Tag{
+						Tag: "portal",
+					},
+				},
+			},
+		}))
+	serverConfig.Inbound = append(serverConfig.Inbound, &core.InboundHandlerConfig{
+		Tag: "external",
+		ReceiverSettings: serial.ToTypedMessage(&proxyman.ReceiverConfig{
+			PortList: &net.PortList{Range: []*net.PortRange{net.SinglePortRange(externalPort)}},
+			Listen:   net.NewIPOrDomain(net.LocalHostIP),
+		}),
+		ProxySettings: serial.ToTypedMessage(&dokodemo.Config{
+			Address:  net.NewIPOrDomain(dest.Address),
+			Port:     uint32(dest.Port),
+			Networks: []net.Network{net.Network_TCP},
+		}),
+	})
+
+	// The bridge has no inbound: the service is only reachable through it
+	clientConfig := reflexClientConfig(tcp.PickPort(), reversePort, dest, userID, &outbound.Config{})
+	clientConfig.Inbound = nil
+	clientConfig.Outbound[0].Tag = "reverse"
+	clientConfig.Outbound = append(clientConfig.Outbound, &core.OutboundHandlerConfig{
+		Tag:           "freedom",
+		ProxySettings: serial.ToTypedMessage(&freedom.Config{}),
+	})
+	clientConfig.App = append(clientConfig.App,
+		serial.ToTypedMessage(&reverse.Config{
+			BridgeConfig: []*reverse.BridgeConfig{
+				{
+					Tag:    "bridge",
+					Domain: "reverse.example.com",
+				},
+			},
+		}),
+		serial.ToTypedMessage(&router.Config{
+			Rule: []*router.RoutingRule{
+				{
+					Domain: []*router.Domain{
+						{Type: router.Domain_Full, Value: "reverse.example.com"},
+					},
+					TargetTag: &router.RoutingRule_Tag{
+						Tag: "reverse",
+					},
+				},
+				{
+					InboundTag: []string{"bridge"},
+					TargetTag: &router.RoutingRule_Tag{
+						Tag: "freedom",
+					},
+				},
+			},
+		}))
+
+	servers, err := InitializeServerConfigs(serverConfig, clientConfig)
+	common.Must(err)
+	defer CloseAllServers(servers)
+
+	var errg errgroup.Group
+	for range 8 {
+		errg.Go(testTCPConn(externalPort, 1024*1024, time.Second*20))
+	}
+	if err := errg.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
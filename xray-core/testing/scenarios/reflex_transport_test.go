@@ -0,0 +1,547 @@
+package scenarios
+
+import (
+	gotls "crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/xtls/reality/hpke"
+	"github.com/xtls/xray-core/app/proxyman"
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/protocol"
+	"github.com/xtls/xray-core/common/protocol/tls/cert"
+	"github.com/xtls/xray-core/common/serial"
+	"github.com/xtls/xray-core/common/uuid"
+	core "github.com/xtls/xray-core/core"
+	"github.com/xtls/xray-core/proxy/reflex/inbound"
+	"github.com/xtls/xray-core/proxy/reflex/outbound"
+	"github.com/xtls/xray-core/testing/servers/tcp"
+	"github.com/xtls/xray-core/transport/internet"
+	"github.com/xtls/xray-core/transport/internet/grpc"
+	"github.com/xtls/xray-core/transport/internet/httpupgrade"
+	"github.com/xtls/xray-core/transport/internet/reality"
+	"github.com/xtls/xray-core/transport/internet/splithttp"
+	"github.com/xtls/xray-core/transport/internet/tls"
+	websocketConfig "github.com/xtls/xray-core/transport/internet/websocket"
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/sync/errgroup"
+)
+
+// reflexTransport is a pair of matching server and client stream settings.
+type reflexTransport struct {
+	name   string
+	server *internet.StreamConfig
+	client *internet.StreamConfig
+}
+
+func reflexTLSSettings() (server, client []*serial.TypedMessage) {
+	return []*serial.TypedMessage{
+		serial.ToTypedMessage(&tls.Config{
+			Certificate: []*tls.Certificate{tls.ParseCertificate(cert.MustGenerate(nil))},
+		}),
+	}, []*serial.TypedMessage{
+		serial.ToTypedMessage(&tls.Config{
+			AllowInsecure: true,
+		}),
+	}
+}
+
+func reflexTransportStream(name string, settings *serial.TypedMessage) (server, client *internet.StreamConfig) {
+	stream := func() *internet.StreamConfig {
+		return &internet.StreamConfig{
+			ProtocolName: name,
+			TransportSettings: []*internet.TransportConfig{
+				{
+					ProtocolName: name,
+					Settings:     settings,
+				},
+			},
+		}
+	}
+	return stream(), stream()
+}
+
+// reflexECHKeySet generates an ECH key set for publicName the way
+// "xray tls ech" does, returning the config list and the server keys.
+func reflexECHKeySet(publicName string) (configList []byte, serverKeys []byte) {
+	echConfig, priv, err := tls.GenerateECHKeySet(0, publicName, hpke.DHKEM_X25519_HKDF_SHA256)
+	common.Must(err)
+	configBytes, err := tls.MarshalBinary(echConfig)
+	common.Must(err)
+
+	var b cryptobyte.Builder
+	b.AddUint16LengthPrefixed(func(child *cryptobyte.Builder) {
+		child.AddBytes(configBytes)
+	})
+	configList, err = b.Bytes()
+	common.Must(err)
+
+	var b2 cryptobyte.Builder
+	b2.AddUint16(uint16(len(priv)))
+	b2.AddBytes(priv)
+	b2.AddUint16(uint16(len(configBytes)))
+	b2.AddBytes(configBytes)
+	serverKeys, err = b2.Bytes()
+	common.Must(err)
+	return configList, serverKeys
+}
+
+// reflexTransports returns the transports Reflex is tested over. realityDest
+// is a local TLS 1.3 server REALITY borrows its handshake from.
+func reflexTransports(realityDest string) []reflexTransport {
+	var transports []reflexTransport
+	transports = append(transports, reflexTransport{name: "raw"})
+
+	tlsServer, tlsClient := reflexTLSSettings()
+	transports = append(transports, reflexTransport{
+		name: "tls",
+		server: &internet.StreamConfig{
+			ProtocolName:     "tcp",
+			SecurityType:     serial.GetMessageType(&tls.Config{}),
+			SecuritySettings: tlsServer,
+		},
+		client: &internet.StreamConfig{
+			ProtocolName:     "tcp",
+			SecurityType:     serial.GetMessageType(&tls.Config{}),
+			SecuritySettings: tlsClient,
+		},
+	})
+
+	// With ECH the outer ClientHello only carries the public name
+	configList, serverKeys := reflexECHKeySet("cover.example.com")
+	transports = append(transports, reflexTransport{
+		name: "tls+ech",
+		server: &internet.StreamConfig{
+			ProtocolName: "tcp",
+			SecurityType: serial.GetMessageType(&tls.Config{}),
+			SecuritySettings: []*serial.TypedMessage{
+				serial.ToTypedMessage(&tls.Config{
+					Certificate:   []*tls.Certificate{tls.ParseCertificate(cert.MustGenerate(nil))},
+					EchServerKeys: serverKeys,
+				}),
+			},
+		},
+		client: &internet.StreamConfig{
+			ProtocolName: "tcp",
+			SecurityType: serial.GetMessageType(&tls.Config{}),
+			SecuritySettings: []*serial.TypedMessage{
+				serial.ToTypedMessage(&tls.Config{
+					ServerName:    "reflex.example.com",
+					AllowInsecure: true,
+					EchConfigList: base64.StdEncoding.EncodeToString(configList),
+				}),
+			},
+		},
+	})
+
+	privateKey, _ := base64.RawURLEncoding.DecodeString("aGSYystUbf59_9_6LKRxD27rmSW_-2_nyd9YG_Gwbks")
+	publicKey, _ := base64.RawURLEncoding.DecodeString("E59WjnvZcQMu7tR7_BgyhycuEdBS-CtKxfImRCdAvFM")
+	shortID := []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef}
+	transports = append(transports, reflexTransport{
+		name: "reality",
+		server: &internet.StreamConfig{
+			ProtocolName: "tcp",
+			SecurityType: serial.GetMessageType(&reality.Config{}),
+			SecuritySettings: []*serial.TypedMessage{
+				serial.ToTypedMessage(&reality.Config{
+					Dest:        realityDest,
+					ServerNames: []string{"example.com"},
+					PrivateKey:  privateKey,
+					ShortIds:    [][]byte{shortID},
+					Type:        "tcp",
+				}),
+			},
+		},
+		client: &internet.StreamConfig{
+			ProtocolName: "tcp",
+			SecurityType: serial.GetMessageType(&reality.Config{}),
+			SecuritySettings: []*serial.TypedMessage{
+				serial.ToTypedMessage(&reality.Config{
+					Fingerprint: "chrome",
+					ServerName:  "example.com",
+					PublicKey:   publicKey,
+					ShortId:     shortID,
+					SpiderX:     "/",
+				}),
+			},
+		},
+	})
+
+	for _, transport := range []struct {
+		name     string
+		settings *serial.TypedMessage
+	}{
+		{"websocket", serial.ToTypedMessage(&websocketConfig.Config{Path: "/reflex"})},
+		{"grpc", serial.ToTypedMessage(&grpc.Config{ServiceName: "reflex"})},
+		{"splithttp", serial.ToTypedMessage(&splithttp.Config{Path: "/reflex"})},
+		{"httpupgrade", serial.ToTypedMessage(&httpupgrade.Config{Path: "/reflex"})},
+	} {
+		server, client := reflexTransportStream(transport.name, transport.settings)
+		transports = append(transports, reflexTransport{
+			name:   transport.name,
+			server: server,
+			client: client,
+		})
+	}
+
+	// gRPC is the transport most often put behind TLS
+	server, client := reflexTransportStream("grpc", serial.ToTypedMessage(&grpc.Config{ServiceName: "reflex"}))
+	server.SecurityType = serial.GetMessageType(&tls.Config{})
+	client.SecurityType = serial.GetMessageType(&tls.Config{})
+	server.SecuritySettings, client.SecuritySettings = reflexTLSSettings()
+	transports = append(transports, reflexTransport{
+		name:   "grpc+tls",
+		server: server,
+		client: client,
+	})
+
+	return transports
+}
+
+// withReflexStream sets the stream settings of the first inbound of server
+// and the first outbound of client.
+func withReflexStream(server, client *core.Config, transport reflexTransport) {
+	receiver, err := server.Inbound[0].ReceiverSettings.GetInstance()
+	common.Must(err)
+	receiver.(*proxyman.ReceiverConfig).StreamSettings = transport.server
+	server.Inbound[0].ReceiverSettings = serial.ToTypedMessage(receiver)
+
+	client.Outbound[0].SenderSettings = serial.ToTypedMessage(&proxyman.SenderConfig{
+		StreamSettings: transport.client,
+	})
+}
+
+func TestReflexTransports(t *testing.T) {
+	tcpServer := tcp.Server{
+		MsgProcessor: xor,
+	}
+	dest, err := tcpServer.Start()
+	common.Must(err)
+	defer tcpServer.Close()
+
+	realityDest := httptest.NewTLSServer(http.NotFoundHandler())
+	defer realityDest.Close()
+
+	for _, transport := range reflexTransports(realityDest.Listener.Addr().String()) {
+		t.Run(transport.name, func(t *testing.T) {
+			userID := protocol.NewID(uuid.New())
+			serverPort := tcp.PickPort()
+			serverConfig := reflexServerConfig(serverPort, userID, &inbound.Config{})
+
+			clientPort := tcp.PickPort()
+			clientConfig := reflexClientConfig(clientPort, serverPort, dest, userID, &outbound.Config{})
+			withReflexStream(serverConfig, clientConfig, transport)
+
+			servers, err := InitializeServerConfigs(serverConfig, clientConfig)
+			common.Must(err)
+			defer CloseAllServers(servers)
+
+			var errg errgroup.Group
+			for range 3 {
+				errg.Go(testTCPConn(clientPort, 1024*1024, time.Second*20))
+			}
+			if err := errg.Wait(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestReflexTLSFallback(t *testing.T) {
+	named := tcp.Server{
+		MsgProcessor: func(b []byte) []byte { return []byte("HTTP/1.1 200 OK\r\n\r\n") },
+	}
+	namedDest, err := named.Start()
+	common.Must(err)
+	defer named.Close()
+
+	fallback := tcp.Server{
+		MsgProcessor: func(b []byte) []byte { return []byte("HTTP/1.1 400 Bad Request\r\n\r\n") },
+	}
+	fallbackDest, err := fallback.Start()
+	common.Must(err)
+	defer fallback.Close()
+
+	tlsServer, _ := reflexTLSSettings()
+	serverPort := tcp.PickPort()
+	serverConfig := reflexServerConfig(serverPort, protocol.NewID(uuid.New()), &inbound.Config{
+		Fallbacks: []*inbound.Fallback{
+			{Name: "decoy.example.com", Dest: namedDest.NetAddr()},
+			{Dest: fallbackDest.NetAddr()},
+		},
+	})
+	withReflexStream(serverConfig, &core.Config{Outbound: []*core.OutboundHandlerConfig{{}}}, reflexTransport{
+		server: &internet.StreamConfig{
+			ProtocolName:     "tcp",
+			SecurityType:     serial.GetMessageType(&tls.Config{}),
+			SecuritySettings: tlsServer,
+		},
+	})
+
+	servers, err := InitializeServerConfigs(serverConfig)
+	common.Must(err)
+	defer CloseAllServers(servers)
+
+	// The fallback is chosen by the SNI of the TLS layer, not by the
+	// decrypted bytes that follow it.
+	for sni, expected := range map[string]string{
+		"decoy.example.com": "HTTP/1.1 200",
+		"other.example.com": "HTTP/1.1 400",
+	} {
+		conn, err := gotls.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", serverPort), &gotls.Config{
+			ServerName:         sni,
+			InsecureSkipVerify: true,
+		})
+		common.Must(err)
+		common.Must2(conn.Write([]byte("GET / HTTP/1.1\r\nHost: " + sni + "\r\nUser-Agent: Mozilla/5.0 (X11; Linux x86_64)\r\n\r\n")))
+		if response := readFrom(conn, time.Second*10, 12); string(response) != expected {
+			t.Error("expected ", expected, " for ", sni, ", got ", string(response))
+		}
+		conn.Close()
+	}
+}
+
+func TestReflexECHFallback(t *testing.T) {
+	named := tcp.Server{
+		MsgProcessor: func(b []byte) []byte { return []byte("HTTP/1.1 200 OK\r\n\r\n") },
+	}
+	namedDest, err := named.Start()
+	common.Must(err)
+	defer named.Close()
+
+	fallback := tcp.Server{
+		MsgProcessor: func(b []byte) []byte { return []byte("HTTP/1.1 400 Bad Request\r\n\r\n") },
+	}
+	fallbackDest, err := fallback.Start()
+	common.Must(err)
+	defer fallback.Close()
+
+	configList, serverKeys := reflexECHKeySet("cover.example.com")
+	serverPort := tcp.PickPort()
+	serverConfig := reflexServerConfig(serverPort, protocol.NewID(uuid.New()), &inbound.Config{
+		Fallbacks: []*inbound.Fallback{
+			{Name: "decoy.example.com", Alpn: "h2", Dest: namedDest.NetAddr()},
+			{Dest: fallbackDest.NetAddr()},
+		},
+	})
+	withReflexStream(serverConfig, &core.Config{Outbound: []*core.OutboundHandlerConfig{{}}}, reflexTransport{
+		server: &internet.StreamConfig{
+			ProtocolName: "tcp",
+			SecurityType: serial.GetMessageType(&tls.Config{}),
+			SecuritySettings: []*serial.TypedMessage{
+				serial.ToTypedMessage(&tls.Config{
+					Certificate:   []*tls.Certificate{tls.ParseCertificate(cert.MustGenerate(nil))},
+					NextProtocol:  []string{"h2", "http/1.1"},
+					EchServerKeys: serverKeys,
+				}),
+			},
+		},
+	})
+
+	servers, err := InitializeServerConfigs(serverConfig)
+	common.Must(err)
+	defer CloseAllServers(servers)
+
+	// The outer SNI is the public name; only the inner SNI and ALPN, which
+	// an observer never sees, select the fallback.
+	for _, c := range []struct {
+		sni      string
+		alpn     string
+		expected string
+	}{
+		{"decoy.example.com", "h2", "HTTP/1.1 200"},
+		{"decoy.example.com", "http/1.1", "HTTP/1.1 400"},
+		{"other.example.com", "h2", "HTTP/1.1 400"},
+	} {
+		conn, err := gotls.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", serverPort), &gotls.Config{
+			ServerName:                     c.sni,
+			NextProtos:                     []string{c.alpn},
+			InsecureSkipVerify:             true,
+			EncryptedClientHelloConfigList: configList,
+		})
+		common.Must(err)
+		if !conn.ConnectionState().ECHAccepted {
+			t.Fatal("ECH was not accepted")
+		}
+		common.Must2(conn.Write([]byte("GET / HTTP/1.1\r\nHost: " + c.sni + "\r\nUser-Agent: Mozilla/5.0 (X11; Linux x86_64)\r\n\r\n")))
+		if response := readFrom(conn, time.Second*10, 12); string(response) != c.expected {
+			t.Error("expected ", c.expected, " for ", c.sni, " ", c.alpn, ", got ", string(response))
+		}
+		conn.Close()
+	}
+}
+
+func TestReflexWebSocketNoFallback(t *testing.T) {
+	var reached atomic.Bool
+	fallback := tcp.Server{
+		MsgProcessor: func(b []byte) []byte {
+			reached.Store(true)
+			return []byte("HTTP/1.1 400 Bad Request\r\n\r\n")
+		},
+	}
+	fallbackDest, err := fallback.Start()
+	common.Must(err)
+	defer fallback.Close()
+
+	wsServer, _ := reflexTransportStream("websocket", serial.ToTypedMessage(&websocketConfig.Config{Path: "/reflex"}))
+	serverPort := tcp.PickPort()
+	serverConfig := reflexServerConfig(serverPort, protocol.NewID(uuid.New()), &inbound.Config{
+		Fallbacks: []*inbound.Fallback{
+			{Dest: fallbackDest.NetAddr()},
+		},
+	})
+	withReflexStream(serverConfig, &core.Config{Outbound: []*core.OutboundHandlerConfig{{}}}, reflexTransport{
+		server: wsServer,
+	})
+
+	servers, err := InitializeServerConfigs(serverConfig)
+	common.Must(err)
+	defer CloseAllServers(servers)
+
+	// WebSocket has already answered the client, so a non-Reflex stream
+	// inside it is closed instead of being spliced into the fallback.
+	conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://127.0.0.1:%d/reflex", serverPort), nil)
+	common.Must(err)
+	defer conn.Close()
+	common.Must(conn.WriteMessage(websocket.BinaryMessage, []byte("GET / HTTP/1.1\r\nHost: example.com\r\nUser-Agent: Mozilla/5.0 (X11; Linux x86_64)\r\n\r\n")))
+	conn.SetReadDeadline(time.Now().Add(time.Second * 10))
+	if _, _, err := conn.ReadMessage(); err == nil {
+		t.Error("expected the stream to be closed")
+	}
+	if reached.Load() {
+		t.Error("fallback should not be used over WebSocket")
+	}
+}
+
+func TestReflexTunneledTLS(t *testing.T) {
+	target := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello over tls"))
+	}))
+	defer target.Close()
+	targetPort := target.Listener.Addr().(*net.TCPAddr).Port
+	dest := net.TCPDestination(net.LocalHostIP, net.Port(targetPort))
+
+	userID := protocol.NewID(uuid.New())
+	serverPort := tcp.PickPort()
+	serverConfig := reflexServerConfig(serverPort, userID, &inbound.Config{})
+
+	clientPort := tcp.PickPort()
+	clientConfig := reflexClientConfig(clientPort, serverPort, dest, userID, &outbound.Config{})
+
+	servers, err := InitializeServerConfigs(serverConfig, clientConfig)
+	common.Must(err)
+	defer CloseAllServers(servers)
+
+	// The handshake flights are reshaped on the way; the TLS session on top
+	// must not notice
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialTLS: func(network, addr string) (net.Conn, error) {
+				return gotls.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", clientPort), &gotls.Config{
+					InsecureSkipVerify: true,
+				})
+			},
+		},
+		Timeout: time.Second * 10,
+	}
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(target.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != "hello over tls" {
+			t.Fatal("unexpected response ", string(body))
+		}
+		client.CloseIdleConnections()
+	}
+}
+
+func TestReflexDecoy(t *testing.T) {
+	tcpServer := tcp.Server{
+		MsgProcessor: xor,
+	}
+	dest, err := tcpServer.Start()
+	common.Must(err)
+	defer tcpServer.Close()
+
+	dir := t.TempDir()
+	root := filepath.Join(dir, "www")
+	common.Must(os.Mkdir(root, 0o755))
+	common.Must(os.WriteFile(filepath.Join(root, "index.html"), []byte("<h1>It works!</h1>"), 0o644))
+	certPEM, keyPEM := cert.MustGenerate(nil, cert.DNSNames("www.example.com")).ToPEM()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	common.Must(os.WriteFile(certFile, certPEM, 0o600))
+	common.Must(os.WriteFile(keyFile, keyPEM, 0o600))
+
+	userID := protocol.NewID(uuid.New())
+	serverPort := tcp.PickPort()
+	serverConfig := reflexServerConfig(serverPort, userID, &inbound.Config{
+		// The web server of the fallback is down
+		Fallbacks: []*inbound.Fallback{
+			{Dest: fmt.Sprintf("127.0.0.1:%d", tcp.PickPort())},
+		},
+		Decoy: &inbound.Decoy{
+			Root:            root,
+			CertificateFile: certFile,
+			KeyFile:         keyFile,
+		},
+	})
+
+	clientPort := tcp.PickPort()
+	clientConfig := reflexClientConfig(clientPort, serverPort, dest, userID, &outbound.Config{})
+
+	servers, err := InitializeServerConfigs(serverConfig, clientConfig)
+	common.Must(err)
+	defer CloseAllServers(servers)
+
+	if err := testTCPConn(clientPort, 1024, time.Second*5)(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Probes over HTTP, and over TLS with h2 or HTTP/1.1, see the website
+	for _, tc := range []struct {
+		scheme string
+		h2     bool
+		proto  string
+	}{
+		{"http", false, "HTTP/1.1"},
+		{"https", true, "HTTP/2.0"},
+		{"https", false, "HTTP/1.1"},
+	} {
+		client := &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &gotls.Config{
+					ServerName:         "www.example.com",
+					InsecureSkipVerify: true,
+				},
+				ForceAttemptHTTP2: tc.h2,
+			},
+			Timeout: time.Second * 10,
+		}
+		resp, err := client.Get(fmt.Sprintf("%s://127.0.0.1:%d/", tc.scheme, serverPort))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		common.Must(err)
+		if string(body) != "<h1>It works!</h1>" || resp.Proto != tc.proto || resp.Header.Get("Server") != "nginx" {
+			t.Errorf("%s: unexpected response %s %q from %q", tc.scheme, resp.Proto, string(body), resp.Header.Get("Server"))
+		}
+	}
+}
@@ -2,11 +2,13 @@ package tests
 
 import (
 	"bytes"
+	"io"
 	"net"
 	"testing"
 	"time"
 
 	"github.com/xtls/xray-core/proxy/reflex/encoding"
+	"github.com/xtls/xray-core/proxy/reflex/reflextest"
 )
 
 // TestHandshakeKeyExchange tests X25519 ECDH key exchange
@@ -189,7 +191,6 @@ func TestReplayProtection(t *testing.T) {
 			frame.Payload = payload
 			encoder.WriteFrame(client, frame)
 			encoding.PutFrame(frame)
-			time.Sleep(time.Millisecond)
 		}
 	}()
 
@@ -204,37 +205,27 @@ func TestReplayProtection(t *testing.T) {
 
 // TestIntegrationFullConnection tests complete client-server tunnel flow
 func TestIntegrationFullConnection(t *testing.T) {
-	priv, pub, _ := encoding.GenerateKeyPair()
-	shared := encoding.DeriveSharedKey(priv, pub)
-	sessionKey, _ := encoding.DeriveSessionKey(shared, []byte("reflex-session-v1"))
-
-	encoder, _ := encoding.NewFrameEncoder(sessionKey)
-	decoder, _ := encoding.NewFrameDecoder(sessionKey)
+	h := reflextest.New(t, reflextest.Options{})
 
-	client, server := net.Pipe()
-	defer client.Close()
-	defer server.Close()
+	conn, err := h.Dial()
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
 
 	messages := []string{"hello", "world", "reflex protocol", "test complete"}
 
-	go func() {
-		for _, msg := range messages {
-			frame := encoding.GetFrame()
-			frame.Type = encoding.FrameTypeData
-			frame.Payload = []byte(msg)
-			encoder.WriteFrame(client, frame)
-			encoding.PutFrame(frame)
-		}
-	}()
-
 	for _, expected := range messages {
-		frame, err := decoder.ReadFrame(server)
-		if err != nil {
-			t.Fatalf("ReadFrame failed: %v", err)
+		if _, err := conn.Write([]byte(expected)); err != nil {
+			t.Fatalf("Write failed: %v", err)
 		}
-		if string(frame.Payload) != expected {
-			t.Errorf("expected %q, got %q", expected, frame.Payload)
+		echoed := make([]byte, len(expected))
+		if _, err := io.ReadFull(conn, echoed); err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		if string(echoed) != expected {
+			t.Errorf("expected %q, got %q", expected, echoed)
 		}
-		encoding.PutFrame(frame)
 	}
 }
@@ -182,6 +182,29 @@ func (p *pipe) WriteMultiBuffer(mb buf.MultiBuffer) error {
 	}
 }
 
+// waitDrained waits up to timeout for the reader to take all the data in the
+// pipe, or for the pipe to be interrupted. The writer must be done writing,
+// as it shares the signal.
+func (p *pipe) waitDrained(timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		p.Lock()
+		drained := p.data.IsEmpty() || p.state == errord
+		p.Unlock()
+		if drained {
+			return true
+		}
+
+		select {
+		case <-p.writeSignal.Wait():
+		case <-timer.C:
+			return false
+		}
+	}
+}
+
 func (p *pipe) Close() error {
 	p.Lock()
 	defer p.Unlock()
@@ -200,6 +223,10 @@ func (p *pipe) Interrupt() {
 	p.Lock()
 	defer p.Unlock()
 
+	// A pipe closed by its writer may be interrupted while the writer waits
+	// for it to drain, which only the write signal wakes up
+	defer p.writeSignal.Signal()
+
 	if !p.data.IsEmpty() {
 		buf.ReleaseMulti(p.data)
 		p.data = nil
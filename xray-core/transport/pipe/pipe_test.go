@@ -72,6 +72,47 @@ func TestPipeClose(t *testing.T) {
 	}
 }
 
+func TestPipeWaitDrained(t *testing.T) {
+	pReader, pWriter := New(WithSizeLimit(1024))
+	b := buf.New()
+	common.Must2(b.WriteString("abcd"))
+	common.Must(pWriter.WriteMultiBuffer(buf.MultiBuffer{b}))
+	common.Must(pWriter.Close())
+
+	if pWriter.WaitDrained(10 * time.Millisecond) {
+		t.Fatal("expected the pipe not to be drained before it is read")
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		rb, _ := pReader.ReadMultiBuffer()
+		buf.ReleaseMulti(rb)
+	}()
+	if !pWriter.WaitDrained(time.Second) {
+		t.Fatal("expected the pipe to be drained once read")
+	}
+}
+
+func TestPipeWaitDrainedInterrupted(t *testing.T) {
+	pReader, pWriter := New(WithSizeLimit(1024))
+	b := buf.New()
+	common.Must2(b.WriteString("abcd"))
+	common.Must(pWriter.WriteMultiBuffer(buf.MultiBuffer{b}))
+	common.Must(pWriter.Close())
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		pReader.Interrupt()
+	}()
+	start := time.Now()
+	if !pWriter.WaitDrained(5 * time.Second) {
+		t.Fatal("expected an interrupted pipe to count as drained")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatal("waited ", elapsed, " for an interrupted pipe")
+	}
+}
+
 func TestPipeLimitZero(t *testing.T) {
 	pReader, pWriter := New(WithSizeLimit(0))
 	bb := buf.New()
@@ -1,6 +1,8 @@
 package pipe
 
 import (
+	"time"
+
 	"github.com/xtls/xray-core/common/buf"
 )
 
@@ -23,6 +25,12 @@ func (w *Writer) Len() int32 {
 	return w.pipe.Len()
 }
 
+// WaitDrained waits up to timeout for the reader to take everything written
+// to the pipe, once the writer is done writing. It returns whether it did.
+func (w *Writer) WaitDrained(timeout time.Duration) bool {
+	return w.pipe.waitDrained(timeout)
+}
+
 // Interrupt implements common.Interruptible.
 func (w *Writer) Interrupt() {
 	w.pipe.Interrupt()